@@ -0,0 +1,218 @@
+// gtp-migrate applies, reverts and previews the versioned database migrations registered in the db package.
+//
+// Usage:
+//
+//	gtp-migrate [-connection CONNECTION_STRING] [-driver DRIVER] up
+//	gtp-migrate [-connection CONNECTION_STRING] [-driver DRIVER] down [N]
+//	gtp-migrate [-connection CONNECTION_STRING] [-driver DRIVER] status
+//	gtp-migrate [-connection CONNECTION_STRING] [-driver DRIVER] plan [up | down [N]]
+//	gtp-migrate [-connection CONNECTION_STRING] [-driver DRIVER] drift
+//
+// The connection string defaults to the DB_CONNECTION_STRING environment variable.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/EurosportDigital/global-transcoding-platform/db"
+	"github.com/jinzhu/gorm"
+)
+
+const connectionStringEnv = "DB_CONNECTION_STRING"
+
+const usage = `usage: gtp-migrate [flags] <command>
+
+commands:
+  up              apply all pending migrations
+  down [N]        revert the last N applied migrations (default 1)
+  status          list all migrations and whether they are applied
+  plan [up]       print the SQL that up would execute without applying it
+  plan down [N]   print the SQL that down N would execute without applying it
+  drift           compare the database with the gorm models, exits with 1 when they differ
+
+flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, &db.GormMigration{}))
+}
+
+// run executes the command described by args and returns the process exit code.
+func run(args []string, stdout io.Writer, stderr io.Writer, migration *db.GormMigration) int {
+	flags := flag.NewFlagSet("gtp-migrate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	connectionString := flags.String("connection", os.Getenv(connectionStringEnv), "database connection string, defaults to $"+connectionStringEnv)
+	driver := flags.String("driver", "", "database driver, defaults to postgres")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	command, commandArgs := flags.Arg(0), flags.Args()[1:]
+	if !isKnownCommand(command) {
+		fmt.Fprintf(stderr, "unknown command %q\n", command)
+		flags.Usage()
+		return 2
+	}
+
+	connection, err := db.OpenDBConnection(*connectionString, *driver)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to connect to the database: %v\n", err)
+		return 1
+	}
+	defer connection.Close()
+
+	switch command {
+	case "up":
+		return reportOutcome(stdout, stderr, connection, migration, migration.Migrate(connection))
+	case "down":
+		steps, err := parseSteps(commandArgs)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		return reportOutcome(stdout, stderr, connection, migration, migration.Rollback(connection, steps))
+	case "status":
+		if err := printStatus(stdout, connection, migration); err != nil {
+			fmt.Fprintf(stderr, "unable to read migration status: %v\n", err)
+			return 1
+		}
+		return 0
+	case "drift":
+		return drift(stdout, stderr, connection)
+	default:
+		return plan(stdout, stderr, connection, migration, commandArgs)
+	}
+}
+
+func isKnownCommand(command string) bool {
+	switch command {
+	case "up", "down", "status", "plan", "drift":
+		return true
+	}
+	return false
+}
+
+func parseSteps(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	steps, err := strconv.Atoi(args[0])
+	if err != nil || steps <= 0 {
+		return 0, fmt.Errorf("invalid number of migrations %q, expected a positive integer", args[0])
+	}
+	return steps, nil
+}
+
+func plan(stdout io.Writer, stderr io.Writer, connection *gorm.DB, migration *db.GormMigration, args []string) int {
+	var migrations []*db.VersionedMigration
+	var err error
+	down := len(args) > 0 && args[0] == "down"
+	switch {
+	case len(args) == 0 || args[0] == "up":
+		migrations, err = migration.PlanMigrate(connection)
+	case down:
+		steps, stepsErr := parseSteps(args[1:])
+		if stepsErr != nil {
+			fmt.Fprintln(stderr, stepsErr)
+			return 2
+		}
+		migrations, err = migration.PlanRollback(connection, steps)
+	default:
+		fmt.Fprintf(stderr, "unknown plan direction %q, expected up or down\n", args[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to plan migrations: %v\n", err)
+		return 1
+	}
+
+	if len(migrations) == 0 {
+		fmt.Fprintln(stdout, "Nothing to do.")
+		return 0
+	}
+	for _, m := range migrations {
+		statement := m.UpSQL
+		if down {
+			statement = m.DownSQL
+		}
+		if statement == "" {
+			statement = "-- implemented in Go, SQL not available for preview"
+		}
+		fmt.Fprintf(stdout, "-- %d %s\n%s\n\n", m.Version, m.Name, statement)
+	}
+	return 0
+}
+
+func drift(stdout io.Writer, stderr io.Writer, connection *gorm.DB) int {
+	report, err := db.DetectDrift(connection)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to detect drift: %v\n", err)
+		return 1
+	}
+	printDrift(stdout, report)
+	if report.HasDrift() {
+		return 1
+	}
+	return 0
+}
+
+func printDrift(out io.Writer, report *db.DriftReport) {
+	if !report.HasDrift() {
+		fmt.Fprintln(out, "No drift detected.")
+		return
+	}
+	for _, drift := range report.Drifts {
+		fmt.Fprintln(out, drift)
+	}
+	fmt.Fprintf(out, "%d differences between the models and the database.\n", len(report.Drifts))
+}
+
+func reportOutcome(stdout io.Writer, stderr io.Writer, connection *gorm.DB, migration *db.GormMigration, err error) int {
+	if err == nil {
+		fmt.Fprintln(stdout, "Migrations completed successfully.")
+		return printStatusOrFail(stdout, stderr, connection, migration)
+	}
+	fmt.Fprintf(stderr, "MIGRATION FAILED: %v\n", err)
+	fmt.Fprintln(stderr, "The failing migration was rolled back. Current state:")
+	printStatusOrFail(stderr, stderr, connection, migration)
+	return 1
+}
+
+func printStatusOrFail(out io.Writer, stderr io.Writer, connection *gorm.DB, migration *db.GormMigration) int {
+	if err := printStatus(out, connection, migration); err != nil {
+		fmt.Fprintf(stderr, "unable to read migration status: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printStatus(out io.Writer, connection *gorm.DB, migration *db.GormMigration) error {
+	statuses, err := migration.Status(connection)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, status := range statuses {
+		state, appliedAt := "pending", ""
+		if status.Applied {
+			state = "applied"
+			appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05 MST")
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+	}
+	return writer.Flush()
+}
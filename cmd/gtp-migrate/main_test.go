@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	stderrors "errors"
+	"testing"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/db"
+	"github.com/jinzhu/gorm"
+	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/require"
+)
+
+func mockAppliedMigrations(versions ...int64) {
+	mocket.Catcher.Reset()
+	mocket.Catcher.NewMock().WithQuery(`SELECT pg_try_advisory_xact_lock`).WithReply([]map[string]interface{}{{"acquired": true}})
+	mocket.Catcher.NewMock().WithQuery(`table_name = schema_migrations`).WithReply([]map[string]interface{}{{"count": 1}})
+	var rows []map[string]interface{}
+	for _, version := range versions {
+		rows = append(rows, map[string]interface{}{"version": version, "name": "applied", "applied_at": time.Now()})
+		mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM "schema_migrations"`).WithArgs(version).WithReply([]map[string]interface{}{{"count": 1}})
+	}
+	mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM "schema_migrations"`).WithReply([]map[string]interface{}{{"count": 0}})
+	mocket.Catcher.NewMock().WithQuery(`SELECT * FROM "schema_migrations"`).WithReply(rows)
+}
+
+func newTestMigration(calls *[]int64) *db.GormMigration {
+	failing := db.SQLMigration(3, "failing", "ALTER TABLE jobs ADD COLUMN broken", "ALTER TABLE jobs DROP COLUMN broken")
+	failing.Up = func(*gorm.DB) error { return stderrors.New("column already exists") }
+	return &db.GormMigration{Migrations: []*db.VersionedMigration{
+		db.SQLMigration(1, "create_things", "CREATE TABLE things ()", "DROP TABLE things"),
+		{
+			Version: 2,
+			Name:    "go_migration",
+			Up:      func(*gorm.DB) error { *calls = append(*calls, 2); return nil },
+			Down:    func(*gorm.DB) error { *calls = append(*calls, -2); return nil },
+		},
+		failing,
+	}}
+}
+
+func runCommand(migration *db.GormMigration, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-connection", "connection_string", "-driver", mocket.DriverName}, args...)
+	code := run(args, &stdout, &stderr, migration)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	mocket.Catcher.Register()
+	mocket.Catcher.Logging = false
+
+	t.Run("Should print the pending SQL without applying it on plan", func(t *testing.T) {
+		mockAppliedMigrations(1)
+		var calls []int64
+		code, stdout, _ := runCommand(newTestMigration(&calls), "plan")
+		require.Equal(t, 0, code)
+		require.Contains(t, stdout, "-- 2 go_migration\n-- implemented in Go")
+		require.Contains(t, stdout, "-- 3 failing\nALTER TABLE jobs ADD COLUMN broken")
+		require.NotContains(t, stdout, "CREATE TABLE things")
+		require.Empty(t, calls)
+	})
+	t.Run("Should print the rollback SQL on plan down", func(t *testing.T) {
+		mockAppliedMigrations(1, 2)
+		var calls []int64
+		code, stdout, _ := runCommand(newTestMigration(&calls), "plan", "down", "2")
+		require.Equal(t, 0, code)
+		require.Contains(t, stdout, "-- 1 create_things\nDROP TABLE things")
+		require.Empty(t, calls)
+	})
+	t.Run("Should exit non zero and report the failing migration on up", func(t *testing.T) {
+		mockAppliedMigrations(1)
+		var calls []int64
+		code, _, stderr := runCommand(newTestMigration(&calls), "up")
+		require.Equal(t, 1, code)
+		require.Equal(t, []int64{2}, calls)
+		require.Contains(t, stderr, "MIGRATION FAILED: applying migration 3 failing: column already exists")
+	})
+	t.Run("Should revert the requested number of migrations on down", func(t *testing.T) {
+		mockAppliedMigrations(1, 2)
+		mocket.Catcher.NewMock().WithQuery(`DELETE FROM "schema_migrations"`).WithRowsNum(1)
+		var calls []int64
+		code, stdout, _ := runCommand(newTestMigration(&calls), "down", "1")
+		require.Equal(t, 0, code)
+		require.Equal(t, []int64{-2}, calls)
+		require.Contains(t, stdout, "Migrations completed successfully.")
+	})
+	t.Run("Should list every migration on status", func(t *testing.T) {
+		mockAppliedMigrations(1)
+		var calls []int64
+		code, stdout, _ := runCommand(newTestMigration(&calls), "status")
+		require.Equal(t, 0, code)
+		require.Regexp(t, `1\s+create_things\s+applied`, stdout)
+		require.Regexp(t, `2\s+go_migration\s+pending`, stdout)
+	})
+	t.Run("Should reject invalid arguments", func(t *testing.T) {
+		var calls []int64
+		code, _, _ := runCommand(newTestMigration(&calls), "down", "zero")
+		require.Equal(t, 2, code)
+		code, _, stderr := runCommand(newTestMigration(&calls), "sideways")
+		require.Equal(t, 2, code)
+		require.Contains(t, stderr, `unknown command "sideways"`)
+	})
+	t.Run("Should fail when the connection string is missing", func(t *testing.T) {
+		var calls []int64
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-connection", "", "status"}, &stdout, &stderr, newTestMigration(&calls))
+		require.Equal(t, 1, code)
+		require.Contains(t, stderr.String(), "unable to connect to the database")
+	})
+	t.Run("Should report that drift detection needs Postgres", func(t *testing.T) {
+		var calls []int64
+		code, _, stderr := runCommand(newTestMigration(&calls), "drift")
+		require.Equal(t, 1, code)
+		require.Contains(t, stderr, "drift detection is not supported")
+	})
+}
+
+func TestPrintDrift(t *testing.T) {
+	var out bytes.Buffer
+	printDrift(&out, &db.DriftReport{})
+	require.Equal(t, "No drift detected.\n", out.String())
+
+	out.Reset()
+	printDrift(&out, &db.DriftReport{Drifts: []*db.Drift{
+		{Kind: db.DriftMissingTable, Table: "targets"},
+		{Kind: db.DriftMissingIndex, Table: "jobs", Name: "idx_jobs_status"},
+	}})
+	require.Equal(t, "table targets is missing\nindex idx_jobs_status on jobs is missing\n2 differences between the models and the database.\n", out.String())
+}
@@ -0,0 +1,111 @@
+// gtp-seed loads encoders, encoder configs, profiles and targets from fixture files into the database,
+// creating or updating them so that every environment shares the same catalog.
+//
+// Usage:
+//
+//	gtp-seed [-connection CONNECTION_STRING] [-driver DRIVER] [-key-file KEY_FILE] [-prune] [-dry-run] FILE...
+//
+// Files are YAML (.yaml, .yml) or JSON (.json). The connection string defaults to the DB_CONNECTION_STRING
+// environment variable. The auth keys of the targets are sealed with the base64 encoded AES-256 key of the key file,
+// or of the GTP_SECRETS_KEY environment variable.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/EurosportDigital/global-transcoding-platform/db"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/secrets"
+)
+
+const connectionStringEnv = "DB_CONNECTION_STRING"
+
+const secretsKeyEnv = "GTP_SECRETS_KEY"
+
+const usage = `usage: gtp-seed [flags] FILE...
+
+Creates or updates the encoders, encoder configs, profiles and targets described by the fixture files.
+
+flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run seeds the database as described by args and returns the process exit code.
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("gtp-seed", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	connectionString := flags.String("connection", os.Getenv(connectionStringEnv), "database connection string, defaults to $"+connectionStringEnv)
+	driver := flags.String("driver", "", "database driver, defaults to postgres")
+	keyFile := flags.String("key-file", "", "file of the key sealing the target auth keys, defaults to $"+secretsKeyEnv)
+	prune := flags.Bool("prune", false, "delete the entries that are not in the files")
+	dryRun := flags.Bool("dry-run", false, "print what would change without changing anything")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	fixtures, err := db.LoadFixtures(flags.Args()...)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to load fixtures: %v\n", err)
+		return 1
+	}
+
+	keys, err := loadKeys(*keyFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to load the secrets key: %v\n", err)
+		return 1
+	}
+
+	connection, err := db.OpenDBConnection(*connectionString, *driver)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to connect to the database: %v\n", err)
+		return 1
+	}
+	defer connection.Close()
+
+	result, err := db.Seed(connection, fixtures, &db.SeedOptions{Prune: *prune, DryRun: *dryRun, Keys: keys})
+	if err != nil {
+		fmt.Fprintf(stderr, "SEED FAILED: %v\n", err)
+		fmt.Fprintln(stderr, "Nothing was changed.")
+		return 1
+	}
+
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "KIND\tNAME\tACTION")
+	for _, change := range result.Changes {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", change.Kind, change.Name, change.Action)
+	}
+	writer.Flush()
+	summary := fmt.Sprintf("%d created, %d updated, %d restored, %d unchanged, %d pruned.",
+		result.Count(db.SeedCreated), result.Count(db.SeedUpdated), result.Count(db.SeedRestored), result.Count(db.SeedUnchanged), result.Count(db.SeedPruned))
+	if *dryRun {
+		summary += " Dry run, nothing was changed."
+	}
+	fmt.Fprintln(stdout, summary)
+	return 0
+}
+
+// loadKeys returns the provider of the key of keyFile, or else of the environment. It returns nil when there is
+// neither, seeding then fails only if a target has an auth key.
+func loadKeys(keyFile string) (secrets.KeyProvider, error) {
+	if keyFile != "" {
+		return secrets.LocalKeyProviderFromFile(keyFile)
+	}
+	if _, ok := os.LookupEnv(secretsKeyEnv); ok {
+		return secrets.LocalKeyProviderFromEnv(secretsKeyEnv)
+	}
+	return nil, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/jinzhu/gorm"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+const fixtures = `
+encoders:
+  - name: bitmovin
+encoder_configs:
+  - name: default
+    encoder: bitmovin
+profiles:
+  - name: h264-hls
+    codec: h264
+    package_format: hls
+    encoder_config: default
+`
+
+const targets = `
+targets:
+  - target_type: s3
+    path: s3://bucket/prefix
+    auth_key: key
+`
+
+func runCommand(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gtp-seed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	database := filepath.Join(dir, "gtp.db")
+	connection, err := gorm.Open("sqlite3", database)
+	require.NoError(t, err)
+	require.NoError(t, connection.AutoMigrate(gormmodel.Models()...).Error)
+	connection.Close()
+	fixturesPath := filepath.Join(dir, "catalog.yaml")
+	require.NoError(t, ioutil.WriteFile(fixturesPath, []byte(fixtures), 0600))
+
+	t.Run("Should print usage without files", func(t *testing.T) {
+		code, _, stderr := runCommand("-connection", database, "-driver", "sqlite3")
+		require.Equal(t, 2, code)
+		require.Contains(t, stderr, "usage: gtp-seed")
+	})
+	t.Run("Should fail on invalid fixtures", func(t *testing.T) {
+		code, _, stderr := runCommand("-connection", database, "-driver", "sqlite3", filepath.Join(dir, "missing.yaml"))
+		require.Equal(t, 1, code)
+		require.Contains(t, stderr, "unable to load fixtures")
+	})
+	t.Run("Should not change anything on a dry run", func(t *testing.T) {
+		code, stdout, _ := runCommand("-connection", database, "-driver", "sqlite3", "-dry-run", fixturesPath)
+		require.Equal(t, 0, code)
+		require.Contains(t, stdout, "3 created, 0 updated, 0 restored, 0 unchanged, 0 pruned. Dry run, nothing was changed.")
+	})
+	t.Run("Should seed and report every entry", func(t *testing.T) {
+		code, stdout, _ := runCommand("-connection", database, "-driver", "sqlite3", fixturesPath)
+		require.Equal(t, 0, code)
+		require.Contains(t, stdout, "profile         h264-hls  created")
+		require.Contains(t, stdout, "3 created, 0 updated, 0 restored, 0 unchanged, 0 pruned.")
+
+		code, stdout, _ = runCommand("-connection", database, "-driver", "sqlite3", fixturesPath)
+		require.Equal(t, 0, code)
+		require.Contains(t, stdout, "0 created, 0 updated, 0 restored, 3 unchanged, 0 pruned.")
+	})
+	t.Run("Should seal the auth keys with the key file", func(t *testing.T) {
+		targetsPath := filepath.Join(dir, "targets.yaml")
+		require.NoError(t, ioutil.WriteFile(targetsPath, []byte(targets), 0600))
+		code, _, stderr := runCommand("-connection", database, "-driver", "sqlite3", fixturesPath, targetsPath)
+		require.Equal(t, 1, code)
+		require.Contains(t, stderr, "No Key Provider")
+
+		keyPath := filepath.Join(dir, "key")
+		require.NoError(t, ioutil.WriteFile(keyPath, []byte(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))), 0600))
+		code, stdout, _ := runCommand("-connection", database, "-driver", "sqlite3", "-key-file", keyPath, fixturesPath, targetsPath)
+		require.Equal(t, 0, code)
+		require.Contains(t, stdout, "1 created, 0 updated, 0 restored, 3 unchanged, 0 pruned.")
+
+		code, _, stderr = runCommand("-connection", database, "-driver", "sqlite3", "-key-file", filepath.Join(dir, "missing"), fixturesPath)
+		require.Equal(t, 1, code)
+		require.Contains(t, stderr, "unable to load the secrets key")
+	})
+}
@@ -1,23 +1,158 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
 	"github.com/EurosportDigital/global-transcoding-platform/lib/logger"
 	"github.com/jinzhu/gorm"
 )
 
+const (
+	// DefaultRetryBackoff is the delay before the first connection retry when DBConfig.RetryBackoff is not set.
+	DefaultRetryBackoff = time.Second
+	// DefaultMaxRetryBackoff caps the delay between connection retries when DBConfig.MaxRetryBackoff is not set.
+	DefaultMaxRetryBackoff = 30 * time.Second
+)
+
+// DBConfig configures how a database connection is opened and pooled.
+// Zero values keep the database/sql defaults, which means unlimited connections and lifetimes.
+type DBConfig struct {
+	// ConnectionString is the driver specific data source name. Required.
+	ConnectionString string
+	// Driver is the database/sql driver name. Defaults to postgres.
+	Driver string
+
+	// MaxOpenConns limits the number of open connections to the database.
+	MaxOpenConns int
+	// MaxIdleConns limits the number of connections kept idle in the pool.
+	MaxIdleConns int
+	// ConnMaxLifetime is the maximum amount of time a connection may be reused.
+	ConnMaxLifetime time.Duration
+
+	// ConnectRetries is the number of additional attempts made when the database cannot be reached,
+	// e.g. because RDS is still starting up.
+	ConnectRetries int
+	// RetryBackoff is the delay before the first retry. It doubles after every attempt. Defaults to DefaultRetryBackoff.
+	RetryBackoff time.Duration
+	// MaxRetryBackoff caps the delay between retries. Defaults to DefaultMaxRetryBackoff.
+	MaxRetryBackoff time.Duration
+	// PingTimeout bounds each attempt to reach the database. No timeout when zero.
+	PingTimeout time.Duration
+
+	// StatementTimeout aborts any statement that runs longer than this. Only supported by the postgres driver.
+	StatementTimeout time.Duration
+
+	// Instrumentation, when set, registers the query timing callbacks described by Instrument on the connection.
+	Instrumentation *InstrumentationConfig
+}
+
+// This is stored as a variable so that it can easily be overridden in tests.
+var sleep = time.Sleep
+
 // OpenDBConnection open the connection to the RDS instance using GORM.
 func OpenDBConnection(connectionString string, connectionDriver string) (*gorm.DB, error) {
+	return OpenDBConnectionWithConfig(&DBConfig{ConnectionString: connectionString, Driver: connectionDriver})
+}
+
+// OpenDBConnectionWithConfig opens a pooled connection to the database described by config, retrying with
+// exponential backoff until the database answers a ping or the retries are exhausted.
+func OpenDBConnectionWithConfig(config *DBConfig) (*gorm.DB, error) {
+	connectionDriver := config.Driver
 	if len(connectionDriver) == 0 {
 		connectionDriver = "postgres"
 	}
-	if len(connectionString) == 0 {
+	if len(config.ConnectionString) == 0 {
 		return nil, errors.New("Missing arguments to open DB connection")
 	}
-	db, dbOpenError := gorm.Open(connectionDriver, connectionString)
+	connectionString := config.ConnectionString
+	if config.StatementTimeout > 0 {
+		if connectionDriver != "postgres" {
+			return nil, errors.Errorf("statement timeouts are not supported by the %s driver", connectionDriver)
+		}
+		connectionString = withStatementTimeout(connectionString, config.StatementTimeout)
+	}
+
+	sqlDB, dbOpenError := sql.Open(connectionDriver, connectionString)
 	if dbOpenError != nil {
 		logger.Error(dbOpenError, "Error when opening DB connection")
 		return nil, errors.Wrap(dbOpenError, "opening DB connection")
 	}
+	sqlDB.SetMaxOpenConns(config.MaxOpenConns)
+	sqlDB.SetConnMaxLifetime(config.ConnMaxLifetime)
+	if config.MaxIdleConns > 0 {
+		// SetMaxIdleConns(0) would disable idle connections rather than keep the default.
+		sqlDB.SetMaxIdleConns(config.MaxIdleConns)
+	}
+
+	if pingError := pingWithRetries(sqlDB, config); pingError != nil {
+		sqlDB.Close()
+		logger.Error(pingError, "Error when opening DB connection")
+		return nil, errors.Wrap(pingError, "opening DB connection")
+	}
+
+	db, dbOpenError := gorm.Open(connectionDriver, sqlDB)
+	if dbOpenError != nil {
+		sqlDB.Close()
+		logger.Error(dbOpenError, "Error when opening DB connection")
+		return nil, errors.Wrap(dbOpenError, "opening DB connection")
+	}
+	if config.Instrumentation != nil {
+		Instrument(db, config.Instrumentation)
+	}
 	return db, nil
 }
+
+func pingWithRetries(sqlDB *sql.DB, config *DBConfig) error {
+	backoff := config.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	maxBackoff := config.MaxRetryBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxRetryBackoff
+	}
+
+	var err error
+	for attempt := 0; attempt <= config.ConnectRetries; attempt++ {
+		if attempt > 0 {
+			logger.Warnf("Unable to reach the database (attempt %d of %d), retrying in %v: %v", attempt, config.ConnectRetries+1, backoff, err)
+			sleep(backoff)
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		if err = ping(sqlDB, config.PingTimeout); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func ping(sqlDB *sql.DB, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// withStatementTimeout adds the statement_timeout run-time parameter to a lib/pq connection string,
+// supporting both the URL and the key=value formats.
+func withStatementTimeout(connectionString string, timeout time.Duration) string {
+	setting := fmt.Sprintf("statement_timeout=%d", timeout.Milliseconds())
+	if strings.HasPrefix(connectionString, "postgres://") || strings.HasPrefix(connectionString, "postgresql://") {
+		if strings.Contains(connectionString, "?") {
+			return connectionString + "&" + setting
+		}
+		return connectionString + "?" + setting
+	}
+	return connectionString + " " + setting
+}
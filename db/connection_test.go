@@ -1,7 +1,11 @@
 package db
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	stderrors "errors"
 	"testing"
+	"time"
 
 	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
 	mocket "github.com/selvatico/go-mocket"
@@ -30,3 +34,76 @@ func TestOpenDBConnection(t *testing.T) {
 		require.EqualError(t, errors.Cause(err), "Missing arguments to open DB connection")
 	})
 }
+
+// unreachableDriver fails to connect a given number of times before delegating to go-mocket.
+type unreachableDriver struct {
+	failures int
+	attempts int
+	fake     mocket.FakeDriver
+}
+
+func (d *unreachableDriver) Open(name string) (driver.Conn, error) {
+	d.attempts++
+	if d.attempts <= d.failures {
+		return nil, stderrors.New("connection refused")
+	}
+	return d.fake.Open(name)
+}
+
+var flakyDriver = &unreachableDriver{}
+
+func init() {
+	sql.Register("flaky", flakyDriver)
+}
+
+func TestOpenDBConnectionWithConfig(t *testing.T) {
+	var sleeps []time.Duration
+	sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	defer func() { sleep = time.Sleep }()
+	mocket.Catcher.Register()
+
+	t.Run("Should retry with exponential backoff until the database answers", func(t *testing.T) {
+		sleeps = nil
+		flakyDriver.failures, flakyDriver.attempts = 3, 0
+		db, err := OpenDBConnectionWithConfig(&DBConfig{
+			ConnectionString: "CONNECTION_STRING",
+			Driver:           "flaky",
+			ConnectRetries:   5,
+			RetryBackoff:     time.Second,
+			MaxRetryBackoff:  3 * time.Second,
+			PingTimeout:      time.Second,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, db)
+		require.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, sleeps)
+	})
+	t.Run("Should fail once the retries are exhausted", func(t *testing.T) {
+		sleeps = nil
+		flakyDriver.failures, flakyDriver.attempts = 10, 0
+		db, err := OpenDBConnectionWithConfig(&DBConfig{ConnectionString: "CONNECTION_STRING", Driver: "flaky", ConnectRetries: 2})
+		require.Nil(t, db)
+		require.EqualError(t, errors.Cause(err), "connection refused")
+		require.Equal(t, 3, flakyDriver.attempts)
+		require.Equal(t, []time.Duration{DefaultRetryBackoff, 2 * DefaultRetryBackoff}, sleeps)
+	})
+	t.Run("Should apply the pool limits", func(t *testing.T) {
+		db, err := OpenDBConnectionWithConfig(&DBConfig{ConnectionString: "CONNECTION_STRING", Driver: mocket.DriverName, MaxOpenConns: 7, MaxIdleConns: 3})
+		require.NoError(t, err)
+		require.Equal(t, 7, db.DB().Stats().MaxOpenConnections)
+	})
+	t.Run("Should reject statement timeouts for drivers other than postgres", func(t *testing.T) {
+		db, err := OpenDBConnectionWithConfig(&DBConfig{ConnectionString: "CONNECTION_STRING", Driver: mocket.DriverName, StatementTimeout: time.Second})
+		require.Nil(t, db)
+		require.Error(t, err)
+	})
+}
+
+func TestWithStatementTimeout(t *testing.T) {
+	t.Run("Should append a key value parameter", func(t *testing.T) {
+		require.Equal(t, "host=db user=gtp statement_timeout=1500", withStatementTimeout("host=db user=gtp", 1500*time.Millisecond))
+	})
+	t.Run("Should append a query parameter to URLs", func(t *testing.T) {
+		require.Equal(t, "postgres://gtp@db/gtp?statement_timeout=2000", withStatementTimeout("postgres://gtp@db/gtp", 2*time.Second))
+		require.Equal(t, "postgres://gtp@db/gtp?sslmode=require&statement_timeout=2000", withStatementTimeout("postgres://gtp@db/gtp?sslmode=require", 2*time.Second))
+	})
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/jinzhu/gorm"
+)
+
+// DriftKind classifies a difference between the gorm models and the live database.
+type DriftKind string
+
+const (
+	// DriftMissingTable means the table of a model does not exist.
+	DriftMissingTable DriftKind = "missing_table"
+	// DriftMissingColumn means a model field has no column.
+	DriftMissingColumn DriftKind = "missing_column"
+	// DriftExtraColumn means a column has no model field.
+	DriftExtraColumn DriftKind = "extra_column"
+	// DriftTypeMismatch means a column does not have the type of its model field.
+	DriftTypeMismatch DriftKind = "type_mismatch"
+	// DriftMissingIndex means an index expected by a model does not exist.
+	DriftMissingIndex DriftKind = "missing_index"
+)
+
+// Drift is one difference between the gorm models and the live database.
+type Drift struct {
+	Kind  DriftKind
+	Table string
+	// Column or index name, empty for a missing table.
+	Name string
+	// Expected and Actual are the column types of a type mismatch.
+	Expected string
+	Actual   string
+}
+
+func (drift *Drift) String() string {
+	switch drift.Kind {
+	case DriftMissingTable:
+		return fmt.Sprintf("table %s is missing", drift.Table)
+	case DriftMissingColumn:
+		return fmt.Sprintf("column %s.%s is missing, expected %s", drift.Table, drift.Name, drift.Expected)
+	case DriftExtraColumn:
+		return fmt.Sprintf("column %s.%s (%s) is not in the model", drift.Table, drift.Name, drift.Actual)
+	case DriftTypeMismatch:
+		return fmt.Sprintf("column %s.%s is %s, expected %s", drift.Table, drift.Name, drift.Actual, drift.Expected)
+	default:
+		return fmt.Sprintf("index %s on %s is missing", drift.Name, drift.Table)
+	}
+}
+
+// DriftReport lists the differences found by DetectDrift, table by table.
+type DriftReport struct {
+	Drifts []*Drift
+}
+
+// HasDrift reports whether the database differs from the models.
+func (report *DriftReport) HasDrift() bool {
+	return len(report.Drifts) > 0
+}
+
+// migrationIndexes lists, by table, the indexes created by migrations rather than declared in gorm tags.
+var migrationIndexes = map[string][]string{
+	"encoder_configs": {"idx_encoder_configs_encoder_id"},
+	"profiles":        {"idx_profiles_name"},
+	"jobs": {
+		"idx_jobs_priority", "idx_jobs_status", "idx_jobs_created_at_id", "idx_jobs_priority_id", "idx_jobs_updated_at",
+		"idx_jobs_source_path",
+	},
+}
+
+type informationSchemaColumn struct {
+	ColumnName string
+	DataType   string
+}
+
+// DetectDrift compares the tables, columns and indexes the gorm models expect against the live Postgres database,
+// as described by INFORMATION_SCHEMA and pg_indexes. Every gormmodel type is checked when no models are given.
+// Indexes are expected when declared with the index or unique_index gorm tags, or listed in migrationIndexes.
+func DetectDrift(db *gorm.DB, models ...interface{}) (*DriftReport, error) {
+	if name := db.Dialect().GetName(); name != "postgres" {
+		return nil, errors.Errorf("drift detection is not supported by the %s dialect", name)
+	}
+	if len(models) == 0 {
+		models = gormmodel.Models()
+	}
+
+	report := &DriftReport{}
+	for _, model := range models {
+		scope := db.NewScope(model)
+		table := scope.TableName()
+		var columns []*informationSchemaColumn
+		err := db.Raw("SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = CURRENT_SCHEMA() AND table_name = ? ORDER BY ordinal_position", table).
+			Scan(&columns).Error
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading the columns of %s", table)
+		}
+		if len(columns) == 0 {
+			report.Drifts = append(report.Drifts, &Drift{Kind: DriftMissingTable, Table: table})
+			continue
+		}
+		report.Drifts = append(report.Drifts, columnDrifts(scope, table, columns)...)
+
+		var indexes []string
+		if err := db.Raw("SELECT indexname FROM pg_indexes WHERE schemaname = CURRENT_SCHEMA() AND tablename = ?", table).Pluck("indexname", &indexes).Error; err != nil {
+			return nil, errors.Wrapf(err, "reading the indexes of %s", table)
+		}
+		existing := map[string]bool{}
+		for _, index := range indexes {
+			existing[index] = true
+		}
+		for _, index := range expectedIndexes(scope, table) {
+			if !existing[index] {
+				report.Drifts = append(report.Drifts, &Drift{Kind: DriftMissingIndex, Table: table, Name: index})
+			}
+		}
+	}
+	return report, nil
+}
+
+func columnDrifts(scope *gorm.Scope, table string, columns []*informationSchemaColumn) []*Drift {
+	var drifts []*Drift
+	actual := map[string]string{}
+	for _, column := range columns {
+		actual[column.ColumnName] = column.DataType
+	}
+	expected := map[string]bool{}
+	for _, field := range scope.GetModelStruct().StructFields {
+		if !field.IsNormal || field.IsIgnored {
+			continue
+		}
+		expected[field.DBName] = true
+		expectedType := normalizeColumnType(scope.Dialect().DataTypeOf(field))
+		actualType, ok := actual[field.DBName]
+		switch {
+		case !ok:
+			drifts = append(drifts, &Drift{Kind: DriftMissingColumn, Table: table, Name: field.DBName, Expected: expectedType})
+		case normalizeColumnType(actualType) != expectedType:
+			drifts = append(drifts, &Drift{Kind: DriftTypeMismatch, Table: table, Name: field.DBName, Expected: expectedType, Actual: actualType})
+		}
+	}
+	for _, column := range columns {
+		if !expected[column.ColumnName] {
+			drifts = append(drifts, &Drift{Kind: DriftExtraColumn, Table: table, Name: column.ColumnName, Actual: column.DataType})
+		}
+	}
+	return drifts
+}
+
+func expectedIndexes(scope *gorm.Scope, table string) []string {
+	names := map[string]bool{}
+	for _, field := range scope.GetModelStruct().StructFields {
+		for _, tag := range []string{"INDEX", "UNIQUE_INDEX"} {
+			value, ok := field.TagSettingsGet(tag)
+			if !ok {
+				continue
+			}
+			for _, name := range strings.Split(value, ",") {
+				if name == tag || name == "" {
+					// Unnamed indexes are named the way AutoMigrate names them.
+					prefix := "idx_"
+					if tag == "UNIQUE_INDEX" {
+						prefix = "uix_"
+					}
+					name = prefix + table + "_" + field.DBName
+				}
+				names[name] = true
+			}
+		}
+	}
+	for _, name := range migrationIndexes[table] {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+var (
+	columnTypeConstraints = regexp.MustCompile(`(?i)\s+(NOT NULL|NULL|UNIQUE|PRIMARY KEY|DEFAULT)\b.*$`)
+	columnTypeSize        = regexp.MustCompile(`\(.*\)$`)
+)
+
+// columnTypeAliases maps the type names gorm declares to the names INFORMATION_SCHEMA reports.
+var columnTypeAliases = map[string]string{
+	"serial":      "integer",
+	"bigserial":   "bigint",
+	"int":         "integer",
+	"int4":        "integer",
+	"int8":        "bigint",
+	"bool":        "boolean",
+	"varchar":     "character varying",
+	"char":        "character",
+	"float4":      "real",
+	"float8":      "double precision",
+	"decimal":     "numeric",
+	"timestamptz": "timestamp with time zone",
+	"timestamp":   "timestamp without time zone",
+}
+
+func normalizeColumnType(columnType string) string {
+	columnType = strings.ToLower(strings.TrimSpace(columnType))
+	columnType = columnTypeConstraints.ReplaceAllString(columnType, "")
+	columnType = strings.TrimSpace(columnTypeSize.ReplaceAllString(columnType, ""))
+	if alias, ok := columnTypeAliases[columnType]; ok {
+		return alias
+	}
+	return columnType
+}
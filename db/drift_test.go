@@ -0,0 +1,112 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/jinzhu/gorm"
+	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/require"
+)
+
+// openMockPostgres opens a mocked connection that builds its SQL with the postgres dialect.
+func openMockPostgres(t *testing.T) *gorm.DB {
+	mocket.Catcher.Register()
+	sqlDB, err := sql.Open(mocket.DriverName, "drift")
+	require.NoError(t, err)
+	db, err := gorm.Open("postgres", sqlDB)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func mockColumns(table string, columns ...string) {
+	var rows []map[string]interface{}
+	for i := 0; i < len(columns); i += 2 {
+		rows = append(rows, map[string]interface{}{"column_name": columns[i], "data_type": columns[i+1]})
+	}
+	mocket.Catcher.NewMock().WithQuery(`FROM information_schema.columns`).WithArgs(table).WithReply(rows)
+}
+
+func mockIndexes(table string, indexes ...string) {
+	var rows []map[string]interface{}
+	for _, index := range indexes {
+		rows = append(rows, map[string]interface{}{"indexname": index})
+	}
+	mocket.Catcher.NewMock().WithQuery(`FROM pg_indexes`).WithArgs(table).WithReply(rows)
+}
+
+func TestDetectDrift(t *testing.T) {
+	db := openMockPostgres(t)
+
+	t.Run("Should report nothing when the database matches the models", func(t *testing.T) {
+		mocket.Catcher.Reset()
+		mockColumns("profiles", "id", "integer", "name", "text", "codec", "text", "package_format", "text", "encoder_config_id", "integer",
+			"revision", "integer", "deleted_at", "timestamp with time zone")
+		mockIndexes("profiles", "profiles_pkey", "idx_profiles_name", "idx_profiles_deleted_at")
+
+		report, err := DetectDrift(db, &gormmodel.ProfileRecord{})
+		require.NoError(t, err)
+		require.False(t, report.HasDrift(), "%v", report.Drifts)
+	})
+	t.Run("Should report missing, extra and mismatched columns and missing indexes", func(t *testing.T) {
+		mocket.Catcher.Reset()
+		mockColumns("jobs", "id", "integer", "priority", "bigint", "status", "json", "source_path", "text",
+			"preroll_path", "character varying", "outputs", "json", "version", "integer", "lease_owner", "text",
+			"lease_expires_at", "timestamp with time zone", "created_at", "timestamp with time zone",
+			"updated_at", "timestamp with time zone", "profile_revisions", "json", "deleted_at", "timestamp with time zone",
+			"hotfix", "boolean")
+		mockIndexes("jobs", "jobs_pkey", "idx_jobs_priority", "idx_jobs_created_at_id", "idx_jobs_priority_id", "idx_jobs_updated_at", "idx_jobs_source_path",
+			"idx_jobs_deleted_at")
+
+		report, err := DetectDrift(db, &gormmodel.JobRecord{})
+		require.NoError(t, err)
+		require.Equal(t, []*Drift{
+			{Kind: DriftTypeMismatch, Table: "jobs", Name: "priority", Expected: "integer", Actual: "bigint"},
+			{Kind: DriftTypeMismatch, Table: "jobs", Name: "preroll_path", Expected: "text", Actual: "character varying"},
+			{Kind: DriftMissingColumn, Table: "jobs", Name: "postroll_path", Expected: "text"},
+			{Kind: DriftExtraColumn, Table: "jobs", Name: "hotfix", Actual: "boolean"},
+			{Kind: DriftMissingIndex, Table: "jobs", Name: "idx_jobs_status"},
+		}, report.Drifts)
+		require.Equal(t, "column jobs.priority is bigint, expected integer", report.Drifts[0].String())
+	})
+	t.Run("Should report missing tables", func(t *testing.T) {
+		mocket.Catcher.Reset()
+
+		report, err := DetectDrift(db, &gormmodel.TargetRecord{})
+		require.NoError(t, err)
+		require.Equal(t, []*Drift{{Kind: DriftMissingTable, Table: "targets"}}, report.Drifts)
+	})
+	t.Run("Should check every model by default", func(t *testing.T) {
+		mocket.Catcher.Reset()
+
+		report, err := DetectDrift(db)
+		require.NoError(t, err)
+		require.Len(t, report.Drifts, len(gormmodel.Models()))
+	})
+	t.Run("Should only support Postgres", func(t *testing.T) {
+		db := openMockDB(t, "drift")
+		defer db.Close()
+		_, err := DetectDrift(db)
+		require.Error(t, err)
+	})
+}
+
+func TestNormalizeColumnType(t *testing.T) {
+	for declared, reported := range map[string]string{
+		"serial":                         "integer",
+		"bigserial PRIMARY KEY":          "bigint",
+		"varchar(255)":                   "character varying",
+		"text NOT NULL":                  "text",
+		"integer UNIQUE":                 "integer",
+		"numeric(10,2) DEFAULT 0":        "numeric",
+		"TIMESTAMP":                      "timestamp without time zone",
+		"timestamptz DEFAULT now()":      "timestamp with time zone",
+		"timestamp with time zone":       "timestamp with time zone",
+		"boolean DEFAULT false NOT NULL": "boolean",
+		"json":                           "json",
+	} {
+		require.Equal(t, reported, normalizeColumnType(declared), declared)
+	}
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/logger"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/monitoring"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// DefaultHealthCheckName is the name the database health check is reported under when HealthConfig.Name is not set.
+	DefaultHealthCheckName = "db.health"
+	// DefaultHealthCheckInterval is how often the database is checked when HealthConfig.Interval is not set.
+	DefaultHealthCheckInterval = 30 * time.Second
+	// DefaultWarnLatency is the ping latency above which the database is reported as Warn when HealthConfig.WarnLatency is not set.
+	DefaultWarnLatency = 500 * time.Millisecond
+	// DefaultHealthCheckTimeout bounds each ping when HealthConfig.Timeout is not set.
+	DefaultHealthCheckTimeout = 5 * time.Second
+)
+
+// HealthConfig configures a HealthMonitor.
+type HealthConfig struct {
+	// Name of the reported health check. Defaults to DefaultHealthCheckName.
+	Name string
+	// Interval between two checks when the monitor is started. Defaults to DefaultHealthCheckInterval.
+	Interval time.Duration
+	// WarnLatency is the ping latency above which the database is reported as Warn. Defaults to DefaultWarnLatency.
+	WarnLatency time.Duration
+	// Timeout bounds each ping, after which the database is reported as Critical. Defaults to DefaultHealthCheckTimeout.
+	Timeout time.Duration
+	// Tags are added to the health check and to every metric.
+	Tags []monitoring.Tag
+	// HealthChecks receives the health checks. Defaults to monitoring.HealthChecks.
+	HealthChecks monitoring.HealthCheckReporter
+	// Metrics receives the ping latency and connection pool metrics. Defaults to monitoring.Metrics.
+	Metrics monitoring.MetricsReporter
+}
+
+// HealthMonitor periodically pings the database and reports its health and connection pool statistics.
+type HealthMonitor struct {
+	db     *gorm.DB
+	config HealthConfig
+	stop   chan struct{}
+	done   sync.WaitGroup
+}
+
+// NewHealthMonitor constructs a HealthMonitor for db. A nil config uses the defaults.
+func NewHealthMonitor(db *gorm.DB, config *HealthConfig) *HealthMonitor {
+	monitor := &HealthMonitor{db: db}
+	if config != nil {
+		monitor.config = *config
+	}
+	if monitor.config.Name == "" {
+		monitor.config.Name = DefaultHealthCheckName
+	}
+	if monitor.config.Interval <= 0 {
+		monitor.config.Interval = DefaultHealthCheckInterval
+	}
+	if monitor.config.WarnLatency <= 0 {
+		monitor.config.WarnLatency = DefaultWarnLatency
+	}
+	if monitor.config.Timeout <= 0 {
+		monitor.config.Timeout = DefaultHealthCheckTimeout
+	}
+	return monitor
+}
+
+// Start checks the database immediately and then at every interval until Stop is called.
+func (monitor *HealthMonitor) Start() {
+	monitor.stop = make(chan struct{})
+	monitor.done.Add(1)
+	go func() {
+		defer monitor.done.Done()
+		ticker := time.NewTicker(monitor.config.Interval)
+		defer ticker.Stop()
+		for {
+			monitor.Check()
+			select {
+			case <-ticker.C:
+			case <-monitor.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops a started monitor and waits for the check in progress, if any, to complete.
+func (monitor *HealthMonitor) Stop() {
+	if monitor.stop == nil {
+		return
+	}
+	close(monitor.stop)
+	monitor.done.Wait()
+	monitor.stop = nil
+}
+
+// Check pings the database once, reports the result and the connection pool statistics, and returns the health check.
+func (monitor *HealthMonitor) Check() *monitoring.HealthCheck {
+	sqlDB := monitor.db.DB()
+	ctx, cancel := context.WithTimeout(context.Background(), monitor.config.Timeout)
+	defer cancel()
+	start := time.Now()
+	err := sqlDB.PingContext(ctx)
+	latency := time.Since(start)
+
+	check := &monitoring.HealthCheck{Name: monitor.config.Name, Timestamp: start, Tags: monitor.config.Tags}
+	switch {
+	case err != nil:
+		logger.Error(err, "Database health check failed")
+		check.Status = monitoring.Critical
+		check.Message = fmt.Sprintf("ping failed: %v", err)
+	case latency > monitor.config.WarnLatency:
+		logger.Warnf("Database ping took %v, above the %v threshold", latency, monitor.config.WarnLatency)
+		check.Status = monitoring.Warn
+		check.Message = fmt.Sprintf("ping took %v", latency)
+	default:
+		check.Status = monitoring.Ok
+		check.Message = fmt.Sprintf("ping took %v", latency)
+	}
+
+	if reporter := monitor.healthChecks(); reporter != nil {
+		reporter.Report(check)
+	}
+	if metrics := monitor.metrics(); metrics != nil {
+		if err == nil {
+			metrics.Time("db.ping.latency", latency, monitor.config.Tags...)
+		}
+		reportPoolStats(metrics, sqlDB.Stats(), monitor.config.Tags)
+	}
+	return check
+}
+
+func (monitor *HealthMonitor) healthChecks() monitoring.HealthCheckReporter {
+	if monitor.config.HealthChecks != nil {
+		return monitor.config.HealthChecks
+	}
+	return monitoring.HealthChecks
+}
+
+func (monitor *HealthMonitor) metrics() monitoring.MetricsReporter {
+	if monitor.config.Metrics != nil {
+		return monitor.config.Metrics
+	}
+	return monitoring.Metrics
+}
+
+func reportPoolStats(metrics monitoring.MetricsReporter, stats sql.DBStats, tags []monitoring.Tag) {
+	metrics.Gauge("db.pool.max_open_connections", float64(stats.MaxOpenConnections), tags...)
+	metrics.Gauge("db.pool.open_connections", float64(stats.OpenConnections), tags...)
+	metrics.Gauge("db.pool.in_use", float64(stats.InUse), tags...)
+	metrics.Gauge("db.pool.idle", float64(stats.Idle), tags...)
+	metrics.Gauge("db.pool.wait_count", float64(stats.WaitCount), tags...)
+	metrics.Gauge("db.pool.wait_duration_ms", float64(stats.WaitDuration.Milliseconds()), tags...)
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/monitoring"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/monitoring/mocks"
+	"github.com/jinzhu/gorm"
+	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newHealthMocks() (*mocks.HealthCheckReporter, *mocks.MetricsReporter) {
+	healthChecks := &mocks.HealthCheckReporter{}
+	healthChecks.On("Report", mock.Anything)
+	metrics := &mocks.MetricsReporter{}
+	metrics.On("Time", mock.Anything, mock.Anything, mock.Anything)
+	metrics.On("Gauge", mock.Anything, mock.Anything, mock.Anything)
+	return healthChecks, metrics
+}
+
+func TestHealthMonitor(t *testing.T) {
+	mocket.Catcher.Register()
+	tag := monitoring.Tag{Key: "service", Value: "jobsvc"}
+
+	t.Run("Should report Ok and the pool statistics when the database answers", func(t *testing.T) {
+		db, err := gorm.Open(mocket.DriverName, "health")
+		require.NoError(t, err)
+		defer db.Close()
+		db.DB().SetMaxOpenConns(5)
+		healthChecks, metrics := newHealthMocks()
+
+		check := NewHealthMonitor(db, &HealthConfig{WarnLatency: time.Minute, Tags: []monitoring.Tag{tag}, HealthChecks: healthChecks, Metrics: metrics}).Check()
+
+		require.Equal(t, monitoring.Ok, check.Status)
+		require.Equal(t, DefaultHealthCheckName, check.Name)
+		healthChecks.AssertCalled(t, "Report", check)
+		metrics.AssertCalled(t, "Time", "db.ping.latency", mock.Anything, tag)
+		metrics.AssertCalled(t, "Gauge", "db.pool.max_open_connections", float64(5), tag)
+		for _, name := range []string{"db.pool.open_connections", "db.pool.in_use", "db.pool.idle", "db.pool.wait_count", "db.pool.wait_duration_ms"} {
+			metrics.AssertCalled(t, "Gauge", name, mock.Anything, tag)
+		}
+	})
+	t.Run("Should report Warn when the ping is slow", func(t *testing.T) {
+		db, err := gorm.Open(mocket.DriverName, "health")
+		require.NoError(t, err)
+		defer db.Close()
+		healthChecks, metrics := newHealthMocks()
+
+		check := NewHealthMonitor(db, &HealthConfig{WarnLatency: time.Nanosecond, HealthChecks: healthChecks, Metrics: metrics}).Check()
+
+		require.Equal(t, monitoring.Warn, check.Status)
+	})
+	t.Run("Should report Critical when the database cannot be reached", func(t *testing.T) {
+		db, err := gorm.Open(mocket.DriverName, "health")
+		require.NoError(t, err)
+		db.Close()
+		healthChecks, metrics := newHealthMocks()
+
+		check := NewHealthMonitor(db, &HealthConfig{HealthChecks: healthChecks, Metrics: metrics}).Check()
+
+		require.Equal(t, monitoring.Critical, check.Status)
+		require.Contains(t, check.Message, "database is closed")
+		metrics.AssertNotCalled(t, "Time", "db.ping.latency", mock.Anything)
+	})
+	t.Run("Should check periodically until stopped", func(t *testing.T) {
+		db, err := gorm.Open(mocket.DriverName, "health")
+		require.NoError(t, err)
+		defer db.Close()
+		reported := make(chan struct{}, 10)
+		healthChecks := &mocks.HealthCheckReporter{}
+		healthChecks.On("Report", mock.Anything).Run(func(mock.Arguments) {
+			select {
+			case reported <- struct{}{}:
+			default:
+			}
+		})
+		_, metrics := newHealthMocks()
+
+		monitor := NewHealthMonitor(db, &HealthConfig{Interval: time.Millisecond, HealthChecks: healthChecks, Metrics: metrics})
+		monitor.Start()
+		<-reported
+		<-reported
+		monitor.Stop()
+		monitor.Stop()
+	})
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/logger"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/monitoring"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/routing"
+	"github.com/jinzhu/gorm"
+)
+
+// DefaultSlowQueryThreshold is the duration above which a query is logged when InstrumentationConfig.SlowQueryThreshold is not set.
+const DefaultSlowQueryThreshold = 200 * time.Millisecond
+
+const (
+	startedAtSetting      = "gtp:instrumentation_started_at"
+	loggingContextSetting = "gtp:logging_context"
+)
+
+// InstrumentationConfig configures the callbacks registered by Instrument.
+type InstrumentationConfig struct {
+	// SlowQueryThreshold is the duration above which a query is logged with its SQL. Defaults to DefaultSlowQueryThreshold.
+	SlowQueryThreshold time.Duration
+	// Tags are added to every metric.
+	Tags []monitoring.Tag
+	// Metrics receives the query timings. Defaults to monitoring.Metrics.
+	Metrics monitoring.MetricsReporter
+}
+
+// Instrument registers gorm callbacks on db that time every create, query, update and delete.
+// The timings are reported as db.query.duration with table and operation tags, failures are counted as
+// db.query.errors, and queries slower than the threshold are logged along with the LoggingContext attached
+// through WithLoggingContext. It must be called once per connection, before the connection is shared.
+func Instrument(db *gorm.DB, config *InstrumentationConfig) {
+	instrumentation := &instrumentation{}
+	if config != nil {
+		instrumentation.config = *config
+	}
+	if instrumentation.config.SlowQueryThreshold <= 0 {
+		instrumentation.config.SlowQueryThreshold = DefaultSlowQueryThreshold
+	}
+
+	callbacks := db.Callback()
+	callbacks.Create().Before("gorm:begin_transaction").Register("gtp:before_create", instrumentation.start)
+	callbacks.Create().After("gorm:commit_or_rollback_transaction").Register("gtp:after_create", instrumentation.finish("create"))
+	callbacks.Query().Before("gorm:query").Register("gtp:before_query", instrumentation.start)
+	callbacks.Query().After("gorm:after_query").Register("gtp:after_query", instrumentation.finish("query"))
+	callbacks.Update().Before("gorm:begin_transaction").Register("gtp:before_update", instrumentation.start)
+	callbacks.Update().After("gorm:commit_or_rollback_transaction").Register("gtp:after_update", instrumentation.finish("update"))
+	callbacks.Delete().Before("gorm:begin_transaction").Register("gtp:before_delete", instrumentation.start)
+	callbacks.Delete().After("gorm:commit_or_rollback_transaction").Register("gtp:after_delete", instrumentation.finish("delete"))
+}
+
+// WithLoggingContext returns a copy of db whose slow queries are logged with the given logging context,
+// typically the one retrieved from the request with routing.GetLoggingContext.
+func WithLoggingContext(db *gorm.DB, loggingContext routing.LoggingContext) *gorm.DB {
+	return db.Set(loggingContextSetting, loggingContext)
+}
+
+type instrumentation struct {
+	config InstrumentationConfig
+}
+
+func (instrumentation *instrumentation) start(scope *gorm.Scope) {
+	scope.Set(startedAtSetting, time.Now())
+}
+
+func (instrumentation *instrumentation) finish(operation string) func(*gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		value, ok := scope.Get(startedAtSetting)
+		if !ok {
+			return
+		}
+		duration := time.Since(value.(time.Time))
+		table := scope.TableName()
+
+		if metrics := instrumentation.metrics(); metrics != nil {
+			tags := append([]monitoring.Tag{{Key: "table", Value: table}, {Key: "operation", Value: operation}}, instrumentation.config.Tags...)
+			metrics.Time("db.query.duration", duration, tags...)
+			if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+				metrics.Increment("db.query.errors", tags...)
+			}
+		}
+
+		if duration > instrumentation.config.SlowQueryThreshold {
+			fields := map[string]interface{}{}
+			if loggingContext, ok := scope.Get(loggingContextSetting); ok {
+				for key, value := range loggingContext.(routing.LoggingContext) {
+					fields[key] = value
+				}
+			}
+			// Only the SQL is logged, never its variables, as they may hold credentials.
+			fields["sql"] = scope.SQL
+			fields["table"] = table
+			fields["operation"] = operation
+			fields["duration_ms"] = duration.Milliseconds()
+			logger.WarnWithFields("Slow query", fields)
+		}
+	}
+}
+
+func (instrumentation *instrumentation) metrics() monitoring.MetricsReporter {
+	if instrumentation.config.Metrics != nil {
+		return instrumentation.config.Metrics
+	}
+	return monitoring.Metrics
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/logger"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/monitoring"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/monitoring/mocks"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/routing"
+	"github.com/rs/zerolog"
+	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type widget struct {
+	ID   int
+	Name string
+}
+
+func newInstrumentationMetrics() *mocks.MetricsReporter {
+	metrics := &mocks.MetricsReporter{}
+	metrics.On("Time", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	metrics.On("Increment", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	return metrics
+}
+
+func TestInstrument(t *testing.T) {
+	tableTag := monitoring.Tag{Key: "table", Value: "widgets"}
+	envTag := monitoring.Tag{Key: "env", Value: "test"}
+
+	t.Run("Should time every operation with table and operation tags", func(t *testing.T) {
+		db := openMockDB(t, "instrumented")
+		defer db.Close()
+		mocket.Catcher.Reset()
+		metrics := newInstrumentationMetrics()
+		Instrument(db, &InstrumentationConfig{Metrics: metrics, Tags: []monitoring.Tag{envTag}})
+
+		require.NoError(t, db.Find(&[]widget{}).Error)
+		require.NoError(t, db.Create(&widget{Name: "a"}).Error)
+		require.NoError(t, db.Model(&widget{ID: 1}).Update("name", "b").Error)
+		require.NoError(t, db.Delete(&widget{ID: 1}).Error)
+
+		for _, operation := range []string{"query", "create", "update", "delete"} {
+			metrics.AssertCalled(t, "Time", "db.query.duration", mock.Anything, tableTag, monitoring.Tag{Key: "operation", Value: operation}, envTag)
+		}
+		metrics.AssertNotCalled(t, "Increment", "db.query.errors", mock.Anything, mock.Anything, mock.Anything)
+	})
+	t.Run("Should count failed queries", func(t *testing.T) {
+		db := openMockDB(t, "instrumented")
+		defer db.Close()
+		mocket.Catcher.Reset().NewMock().WithQuery(`INSERT INTO "widgets"`).WithError(errors.New("boom"))
+		metrics := newInstrumentationMetrics()
+		Instrument(db, &InstrumentationConfig{Metrics: metrics})
+
+		require.Error(t, db.Create(&widget{Name: "a"}).Error)
+
+		metrics.AssertCalled(t, "Increment", "db.query.errors", tableTag, monitoring.Tag{Key: "operation", Value: "create"})
+	})
+	t.Run("Should log slow queries with the logging context", func(t *testing.T) {
+		var output bytes.Buffer
+		zeroLogger := zerolog.New(&output)
+		logger.SetLogger(&zeroLogger)
+		defer logger.SetLogger(nil)
+		db := openMockDB(t, "instrumented")
+		defer db.Close()
+		mocket.Catcher.Reset()
+		Instrument(db, &InstrumentationConfig{Metrics: newInstrumentationMetrics(), SlowQueryThreshold: 1})
+
+		require.NoError(t, WithLoggingContext(db, routing.LoggingContext{"request_id": "abc"}).Where("name = ?", "secret").Find(&[]widget{}).Error)
+
+		require.Contains(t, output.String(), `"message":"Slow query"`)
+		require.Contains(t, output.String(), `"request_id":"abc"`)
+		require.Contains(t, output.String(), `"operation":"query"`)
+		require.Contains(t, output.String(), `SELECT * FROM \"widgets\"  WHERE (name = ?)`)
+		require.NotContains(t, output.String(), "secret")
+	})
+	t.Run("Should not log queries under the threshold", func(t *testing.T) {
+		var output bytes.Buffer
+		zeroLogger := zerolog.New(&output)
+		logger.SetLogger(&zeroLogger)
+		defer logger.SetLogger(nil)
+		db := openMockDB(t, "instrumented")
+		defer db.Close()
+		mocket.Catcher.Reset()
+		Instrument(db, &InstrumentationConfig{Metrics: newInstrumentationMetrics()})
+
+		require.NoError(t, db.Find(&[]widget{}).Error)
+
+		require.NotContains(t, output.String(), "Slow query")
+	})
+}
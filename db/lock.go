@@ -0,0 +1,92 @@
+package db
+
+import (
+	stderrors "errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/logger"
+	"github.com/jinzhu/gorm"
+)
+
+// DefaultLockTimeout is how long a migration waits for another instance to release the migration lock.
+const DefaultLockTimeout = 5 * time.Minute
+
+// ErrLockTimeout is returned when the migration lock could not be acquired before the timeout expired.
+var ErrLockTimeout = stderrors.New("timed out waiting for the migration lock")
+
+// migrationLockKey identifies the Postgres advisory lock shared by every instance that migrates the schema.
+// It fits in 32 bits so that it can be found in pg_locks.objid.
+var migrationLockKey = int64(crc32.ChecksumIEEE([]byte("gtp-schema-migrations")))
+
+// This is stored as a variable so that it can easily be overridden in tests.
+var lockPollInterval = time.Second
+
+type lockHolder struct {
+	Pid             int
+	ApplicationName string
+	ClientAddr      string
+}
+
+func (holder *lockHolder) String() string {
+	if holder == nil {
+		return "an unknown session"
+	}
+	return fmt.Sprintf("pid %d (application %q, client %q)", holder.Pid, holder.ApplicationName, holder.ClientAddr)
+}
+
+// acquireTransactionLock takes a transaction scoped Postgres advisory lock on tx, waiting up to timeout for another
+// session to release it. The lock is released automatically when tx commits or rolls back.
+func acquireTransactionLock(tx *gorm.DB, key int64, timeout time.Duration) error {
+	if !supportsAdvisoryLocks(tx) {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	var holder *lockHolder
+	for {
+		acquired, err := tryTransactionLock(tx, key)
+		if err != nil {
+			return errors.Wrapf(err, "acquiring migration lock %d", key)
+		}
+		if acquired {
+			if holder != nil {
+				logger.Infof("Acquired migration lock %d", key)
+			}
+			return nil
+		}
+		if holder == nil {
+			holder = findLockHolder(tx, key)
+			logger.Infof("Waiting up to %v for migration lock %d held by %s", timeout, key, holder)
+		}
+		if time.Now().After(deadline) {
+			return errors.Wrapf(ErrLockTimeout, "waited %v for migration lock %d held by %s", timeout, key, holder)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+func supportsAdvisoryLocks(db *gorm.DB) bool {
+	return db.Dialect().GetName() != "sqlite3"
+}
+
+func tryTransactionLock(tx *gorm.DB, key int64) (bool, error) {
+	var result struct {
+		Acquired bool
+	}
+	err := tx.Raw("SELECT pg_try_advisory_xact_lock(?) AS acquired", key).Scan(&result).Error
+	return result.Acquired, err
+}
+
+func findLockHolder(tx *gorm.DB, key int64) *lockHolder {
+	holder := &lockHolder{}
+	err := tx.Raw(`SELECT a.pid AS pid, a.application_name AS application_name, COALESCE(host(a.client_addr), '') AS client_addr
+		FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.granted AND l.classid = 0 AND l.objid = ?`, key).Scan(holder).Error
+	if err != nil {
+		logger.Warnf("Unable to determine the holder of migration lock %d: %v", key, err)
+		return nil
+	}
+	return holder
+}
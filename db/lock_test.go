@@ -0,0 +1,97 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/jinzhu/gorm"
+	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/suite"
+)
+
+type LockSuite struct {
+	suite.Suite
+	db *gorm.DB
+}
+
+func (suite *LockSuite) SetupTest() {
+	mocket.Catcher.Register()
+	mocket.Catcher.Logging = false
+	lockPollInterval = time.Millisecond
+
+	db, err := gorm.Open(mocket.DriverName, "connectionString")
+	if err != nil {
+		panic(err)
+	}
+	suite.db = db
+	mocket.Catcher.Reset()
+}
+
+func (suite *LockSuite) TearDownTest() {
+	lockPollInterval = time.Second
+	suite.db.Close()
+}
+
+func (suite *LockSuite) mockLockHolder() *mocket.FakeResponse {
+	return mocket.Catcher.NewMock().WithQuery(`FROM pg_locks`).WithReply([]map[string]interface{}{
+		{"pid": 4242, "application_name": "jobsvc", "client_addr": "10.0.0.12"},
+	})
+}
+
+func (suite *LockSuite) TestLockContention() {
+	suite.Run("Should wait for the lock holder and then migrate", func() {
+		mocket.Catcher.Reset()
+		busyMock := mockMigrationLock(false).OneTime()
+		mockMigrationLock(true)
+		holderMock := suite.mockLockHolder()
+		mocket.Catcher.NewMock().WithQuery(`INFORMATION_SCHEMA`).WithReply([]map[string]interface{}{{"count": 1}})
+
+		migration := &GormMigration{LockTimeout: time.Second}
+		result, err := migration.UpdateTables([]interface{}{&model.Target{}}, suite.db)
+
+		suite.Require().NoError(err)
+		suite.Require().True(busyMock.Triggered, "The lock was initially held by another instance")
+		suite.Require().True(holderMock.Triggered, "The lock holder was looked up")
+		suite.Require().Equal(ModelApplied, result.Models[0].Status)
+	})
+	suite.Run("Should give up when the lock is not released before the timeout", func() {
+		mocket.Catcher.Reset()
+		mockMigrationLock(false)
+		suite.mockLockHolder()
+		tableMock := mocket.Catcher.NewMock().WithQuery(`INFORMATION_SCHEMA`).WithReply([]map[string]interface{}{{"count": 1}})
+
+		migration := &GormMigration{LockTimeout: 5 * time.Millisecond}
+		result, err := migration.UpdateTables([]interface{}{&model.Target{}}, suite.db)
+
+		suite.Require().Error(err)
+		suite.Require().Equal(ErrLockTimeout, errors.Cause(err))
+		suite.Require().Contains(err.Error(), "pid 4242")
+		suite.Require().Empty(result.Models)
+		suite.Require().False(tableMock.Triggered, "No table was migrated without the lock")
+	})
+	suite.Run("Should skip a migration another instance applied while waiting for the lock", func() {
+		mocket.Catcher.Reset()
+		mockMigrationLock(false).OneTime()
+		mockMigrationLock(true)
+		suite.mockLockHolder()
+		mocket.Catcher.NewMock().WithQuery(`table_name = schema_migrations`).WithReply([]map[string]interface{}{{"count": 1}})
+		// The migration is still pending when planning but is found applied once the lock is held.
+		mocket.Catcher.NewMock().WithQuery(`SELECT * FROM "schema_migrations"`).WithReply([]map[string]interface{}{})
+		mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM "schema_migrations"`).WithReply([]map[string]interface{}{{"count": 1}})
+		insertMock := mocket.Catcher.NewMock().WithQuery(`INSERT INTO "schema_migrations"`)
+		var calls []string
+
+		migration := &GormMigration{Migrations: []*VersionedMigration{recordingMigration(1, &calls)}, LockTimeout: time.Second}
+		err := migration.Migrate(suite.db)
+
+		suite.Require().NoError(err)
+		suite.Require().Empty(calls)
+		suite.Require().False(insertMock.Triggered, "The migration was not recorded twice")
+	})
+}
+
+func TestLockSuite(t *testing.T) {
+	suite.Run(t, new(LockSuite))
+}
@@ -1,24 +1,359 @@
 package db
 
 import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
 	"github.com/EurosportDigital/global-transcoding-platform/lib/logger"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 )
 
+const schemaMigrationsTable = "schema_migrations"
+
 type Migration interface {
-	UpdateTables([]interface{}, *gorm.DB)
+	// UpdateTables runs gorm AutoMigrate for every model in one transaction and reports the outcome per model.
+	UpdateTables([]interface{}, *gorm.DB) (*UpdateTablesResult, error)
+
+	// Migrate applies every pending versioned migration in ascending version order.
+	Migrate(*gorm.DB) error
+
+	// Rollback reverts the given number of most recently applied versioned migrations.
+	Rollback(*gorm.DB, int) error
+
+	// Status reports every known versioned migration and whether it has been applied.
+	Status(*gorm.DB) ([]*MigrationStatus, error)
+}
+
+// VersionedMigration is a single numbered, reversible schema change.
+type VersionedMigration struct {
+	// Version orders the migrations and is recorded in the schema_migrations table once applied.
+	Version int64
+	// Name is a short human readable description of the change.
+	Name string
+	// Up applies the change.
+	Up func(*gorm.DB) error
+	// Down reverts the change.
+	Down func(*gorm.DB) error
+	// UpSQL and DownSQL hold the statements executed by Up and Down, when known, so that changes can be previewed.
+	UpSQL   string
+	DownSQL string
+}
+
+// MigrationStatus describes whether a VersionedMigration has been applied to the database.
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+type schemaMigration struct {
+	Version   int64 `gorm:"primary_key;auto_increment:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string { return schemaMigrationsTable }
+
+// SQLMigration builds a VersionedMigration from raw up and down SQL statements.
+func SQLMigration(version int64, name string, up string, down string) *VersionedMigration {
+	return &VersionedMigration{
+		Version: version,
+		Name:    name,
+		Up:      execSQL(up),
+		Down:    execSQL(down),
+		UpSQL:   up,
+		DownSQL: down,
+	}
+}
+
+func execSQL(statement string) func(*gorm.DB) error {
+	return func(db *gorm.DB) error {
+		return db.Exec(statement).Error
+	}
+}
+
+// ModelMigrationStatus is the outcome of migrating a single model in UpdateTables.
+type ModelMigrationStatus string
+
+const (
+	// ModelApplied indicates the model's table was migrated successfully.
+	ModelApplied ModelMigrationStatus = "applied"
+	// ModelFailed indicates migrating the model's table returned an error.
+	ModelFailed ModelMigrationStatus = "failed"
+	// ModelSkipped indicates the model was not migrated because an earlier model failed.
+	ModelSkipped ModelMigrationStatus = "skipped"
+)
+
+// ModelMigrationResult describes the outcome of migrating a single model.
+type ModelMigrationResult struct {
+	// Model is the Go type of the migrated model, e.g. *model.Job.
+	Model  string
+	Status ModelMigrationStatus
+	Error  error
+}
+
+// UpdateTablesResult lists the outcome of every model passed to UpdateTables, in the order they were passed.
+type UpdateTablesResult struct {
+	Models []*ModelMigrationResult
+	// RolledBack is true when a failure caused the whole transaction to be rolled back.
+	RolledBack bool
+}
+
+// Failed returns the results of the models that failed to migrate.
+func (result *UpdateTablesResult) Failed() []*ModelMigrationResult {
+	var failed []*ModelMigrationResult
+	for _, m := range result.Models {
+		if m.Status == ModelFailed {
+			failed = append(failed, m)
+		}
+	}
+	return failed
 }
 
 type GormMigration struct {
+	// Migrations overrides the versioned migrations to run. Defaults to the Migrations registered in this package.
+	Migrations []*VersionedMigration
+	// LockTimeout is how long to wait for another instance holding the migration lock. Defaults to DefaultLockTimeout.
+	LockTimeout time.Duration
 }
 
 // UpdateTables initializes the schema and tables on the postres RDS DB.
-func (instance *GormMigration) UpdateTables(models []interface{}, db *gorm.DB) {
+// All models are migrated in a single transaction which is rolled back as soon as one of them fails, in which case
+// an error is returned alongside the per-model result. The transaction holds the migration lock, so concurrent
+// instances wait for each other instead of altering the same tables at once.
+func (instance *GormMigration) UpdateTables(models []interface{}, db *gorm.DB) (*UpdateTablesResult, error) {
+	result := &UpdateTablesResult{}
 	transaction := db.Begin()
+	if transaction.Error != nil {
+		return result, errors.Wrap(transaction.Error, "starting table update transaction")
+	}
+	if err := acquireTransactionLock(transaction, migrationLockKey, instance.lockTimeout()); err != nil {
+		transaction.Rollback()
+		return result, err
+	}
+
+	var failure error
 	for _, m := range models {
+		modelResult := &ModelMigrationResult{Model: fmt.Sprintf("%T", m), Status: ModelSkipped}
+		result.Models = append(result.Models, modelResult)
+		if failure != nil {
+			continue
+		}
 		logger.Infof("Running migration for %T model...", m)
+		if err := transaction.AutoMigrate(m).Error; err != nil {
+			logger.Error(err, fmt.Sprintf("Migration for %T model failed", m))
+			modelResult.Status = ModelFailed
+			modelResult.Error = err
+			failure = errors.Wrapf(err, "migrating %T model", m)
+			continue
+		}
+		modelResult.Status = ModelApplied
+	}
+
+	if failure != nil {
+		if err := transaction.Rollback().Error; err != nil {
+			logger.Error(err, "Error when rolling back table update transaction")
+		}
+		result.RolledBack = true
+		return result, failure
+	}
+	if err := transaction.Commit().Error; err != nil {
+		result.RolledBack = true
+		return result, errors.Wrap(err, "committing table update transaction")
+	}
+	return result, nil
+}
+
+// Migrate applies every pending versioned migration, each one in its own transaction.
+func (instance *GormMigration) Migrate(db *gorm.DB) error {
+	if err := instance.createMigrationsTable(db); err != nil {
+		return err
+	}
+	pending, err := instance.PlanMigrate(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		err := instance.runLocked(db, func(tx *gorm.DB) error {
+			if applied, err := isApplied(tx, m.Version); err != nil || applied {
+				// Another instance applied it while we were waiting for the lock.
+				return err
+			}
+			logger.Infof("Applying migration %d %s...", m.Version, m.Name)
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return errors.Wrapf(err, "applying migration %d %s", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// PlanMigrate returns the migrations Migrate would apply, in the order it would apply them, without applying them.
+func (instance *GormMigration) PlanMigrate(db *gorm.DB) ([]*VersionedMigration, error) {
+	applied, err := instance.appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []*VersionedMigration
+	for _, m := range instance.sortedMigrations() {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Rollback reverts the last steps applied migrations, newest first, each one in its own transaction.
+func (instance *GormMigration) Rollback(db *gorm.DB, steps int) error {
+	reverted, err := instance.PlanRollback(db, steps)
+	if err != nil {
+		return err
+	}
+	for _, m := range reverted {
+		err := instance.runLocked(db, func(tx *gorm.DB) error {
+			if applied, err := isApplied(tx, m.Version); err != nil || !applied {
+				// Another instance rolled it back while we were waiting for the lock.
+				return err
+			}
+			logger.Infof("Rolling back migration %d %s...", m.Version, m.Name)
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{Version: m.Version}).Error
+		})
+		if err != nil {
+			return errors.Wrapf(err, "rolling back migration %d %s", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// PlanRollback returns the migrations Rollback would revert, in the order it would revert them, without reverting them.
+func (instance *GormMigration) PlanRollback(db *gorm.DB, steps int) ([]*VersionedMigration, error) {
+	if steps <= 0 {
+		return nil, errors.Errorf("invalid number of migrations to roll back: %d", steps)
+	}
+	applied, err := instance.appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	var reverted []*VersionedMigration
+	migrations := instance.sortedMigrations()
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		if _, ok := applied[migrations[i].Version]; ok {
+			reverted = append(reverted, migrations[i])
+		}
+	}
+	return reverted, nil
+}
+
+// Status lists every known migration in version order along with when it was applied.
+func (instance *GormMigration) Status(db *gorm.DB) ([]*MigrationStatus, error) {
+	applied, err := instance.appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []*MigrationStatus
+	for _, m := range instance.sortedMigrations() {
+		status := &MigrationStatus{Version: m.Version, Name: m.Name}
+		if row, ok := applied[m.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (instance *GormMigration) sortedMigrations() []*VersionedMigration {
+	source := instance.Migrations
+	if source == nil {
+		source = Migrations
+	}
+	migrations := make([]*VersionedMigration, len(source))
+	copy(migrations, source)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations
+}
+
+// createMigrationsTable creates the schema_migrations table unless it exists. Only Migrate creates it, so that
+// planning and Status stay read-only.
+func (instance *GormMigration) createMigrationsTable(db *gorm.DB) error {
+	if db.HasTable(&schemaMigration{}) {
+		return nil
+	}
+	err := instance.runLocked(db, func(tx *gorm.DB) error {
+		if tx.HasTable(&schemaMigration{}) {
+			// Another instance created it while we were waiting for the lock.
+			return nil
+		}
+		return tx.CreateTable(&schemaMigration{}).Error
+	})
+	if err != nil {
+		return errors.Wrap(err, "creating schema_migrations table")
+	}
+	return nil
+}
+
+// appliedMigrations reads the applied migrations without taking the migration lock. No migration was applied yet
+// when the schema_migrations table does not exist.
+func (instance *GormMigration) appliedMigrations(db *gorm.DB) (map[int64]*schemaMigration, error) {
+	if !db.HasTable(&schemaMigration{}) {
+		return map[int64]*schemaMigration{}, nil
+	}
+	var rows []*schemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, errors.Wrap(err, "reading applied migrations")
+	}
+	applied := make(map[int64]*schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+func (instance *GormMigration) lockTimeout() time.Duration {
+	if instance.LockTimeout > 0 {
+		return instance.LockTimeout
+	}
+	return DefaultLockTimeout
+}
+
+// runLocked runs fn in a transaction that holds the migration lock.
+func (instance *GormMigration) runLocked(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	return runInTransaction(db, func(tx *gorm.DB) error {
+		if err := acquireTransactionLock(tx, migrationLockKey, instance.lockTimeout()); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+func isApplied(tx *gorm.DB, version int64) (bool, error) {
+	count := 0
+	if err := tx.Model(&schemaMigration{}).Where("version = ?", version).Count(&count).Error; err != nil {
+		return false, errors.Wrapf(err, "checking whether migration %d is applied", version)
+	}
+	return count > 0, nil
+}
+
+func runInTransaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
 	}
-	transaction.AutoMigrate(models...)
-	transaction.Commit()
+	return tx.Commit().Error
 }
@@ -1,12 +1,17 @@
 package db
 
 import (
+	stderrors "errors"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
 	"github.com/EurosportDigital/global-transcoding-platform/model"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -33,20 +38,32 @@ func (suite *UpdateTableSuite) TearDownTest() {
 }
 
 func (suite *UpdateTableSuite) TestUpdateTable() {
+	// go-mocket cannot execute DDL, so every table and column is reported as already existing.
+	existingTable := []map[string]interface{}{{"count": 1}}
 	suite.Run("Create audio tracks table schema and tables with gorm automigration", func() {
 		mocket.Catcher.Reset()
-		createAudioTrackTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = audio_tracks`)
-		createEncoderTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = encoders`)
-		createEncoderConfigsTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = encoder_configs`)
-		createJobRequestTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = jobs`)
-		createOutputsTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = outputs`)
-		createProfileTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = profiles`)
-		createSubtitlesTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = subtitles`)
-		createTargetsTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = targets`)
+		mockMigrationLock(true)
+		mocket.Catcher.NewMock().WithQuery(`INFORMATION_SCHEMA.COLUMNS`).WithReply(existingTable)
+		createAudioTrackTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = audio_tracks`).WithReply(existingTable)
+		createEncoderTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = encoders`).WithReply(existingTable)
+		createEncoderConfigsTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = encoder_configs`).WithReply(existingTable)
+		createJobRequestTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = jobs`).WithReply(existingTable)
+		createOutputsTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = outputs`).WithReply(existingTable)
+		createProfileTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = profiles`).WithReply(existingTable)
+		createSubtitlesTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = subtitles`).WithReply(existingTable)
+		createTargetsTableMock := mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM INFORMATION_SCHEMA.TABLES WHERE table_schema =  AND table_name = targets`).WithReply(existingTable)
 		var models []interface{}
 		models = append(models, &model.AudioTrack{}, &model.Encoder{}, &model.EncoderConfig{}, &model.Job{}, &model.Output{}, &model.Profile{}, &model.Subtitle{}, &model.Target{})
 		migration := &GormMigration{}
-		migration.UpdateTables(models, suite.db)
+		result, err := migration.UpdateTables(models, suite.db)
+
+		suite.Require().NoError(err)
+		suite.Require().False(result.RolledBack)
+		suite.Require().Empty(result.Failed())
+		suite.Require().Len(result.Models, len(models))
+		for _, m := range result.Models {
+			suite.Assert().Equal(ModelApplied, m.Status, "%s was migrated", m.Model)
+		}
 
 		suite.Assert().True(createAudioTrackTableMock.Triggered, "Create audio tracks table query was called correctly")
 		suite.Assert().True(createEncoderTableMock.Triggered, "Create encoder table query was called correctly")
@@ -57,6 +74,211 @@ func (suite *UpdateTableSuite) TestUpdateTable() {
 		suite.Assert().True(createSubtitlesTableMock.Triggered, "Create subtitles table query was called correctly")
 		suite.Assert().True(createTargetsTableMock.Triggered, "Create targets table query was called correctly")
 	})
+	suite.Run("Should roll back and report every model when one of them fails", func() {
+		mocket.Catcher.Reset()
+		mockMigrationLock(true)
+		mocket.Catcher.NewMock().WithQuery(`INFORMATION_SCHEMA.COLUMNS`).WithReply(existingTable)
+		mocket.Catcher.NewMock().WithQuery(`table_name = audio_tracks`).WithReply(existingTable)
+		// The encoders table is reported missing and its CREATE TABLE statement fails.
+		createTargetsTableMock := mocket.Catcher.NewMock().WithQuery(`table_name = targets`).WithReply(existingTable)
+		models := []interface{}{&model.AudioTrack{}, &model.Encoder{}, &model.Target{}}
+		migration := &GormMigration{}
+		result, err := migration.UpdateTables(models, suite.db)
+
+		suite.Require().Error(err)
+		suite.Require().True(result.RolledBack)
+		suite.Require().Len(result.Models, 3)
+		suite.Require().Equal(ModelApplied, result.Models[0].Status)
+		suite.Require().Equal(ModelFailed, result.Models[1].Status)
+		suite.Require().Equal("*model.Encoder", result.Models[1].Model)
+		suite.Require().Error(result.Models[1].Error)
+		suite.Require().Equal(ModelSkipped, result.Models[2].Status)
+		suite.Require().Equal([]*ModelMigrationResult{result.Models[1]}, result.Failed())
+		suite.Require().False(createTargetsTableMock.Triggered, "Models after the failure were not migrated")
+	})
+}
+
+func mockMigrationLock(acquired bool) *mocket.FakeResponse {
+	return mocket.Catcher.NewMock().WithQuery(`SELECT pg_try_advisory_xact_lock`).WithReply([]map[string]interface{}{{"acquired": acquired}})
+}
+
+func (suite *UpdateTableSuite) mockSchemaMigrationsTable(appliedVersions ...int64) {
+	mockMigrationLock(true)
+	mocket.Catcher.NewMock().WithQuery(`table_name = schema_migrations`).WithReply([]map[string]interface{}{{"count": 1}})
+	var rows []map[string]interface{}
+	for _, version := range appliedVersions {
+		rows = append(rows, map[string]interface{}{"version": version, "name": "applied", "applied_at": time.Now()})
+		mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM "schema_migrations"`).WithArgs(version).WithReply([]map[string]interface{}{{"count": 1}})
+	}
+	mocket.Catcher.NewMock().WithQuery(`SELECT count(*) FROM "schema_migrations"`).WithReply([]map[string]interface{}{{"count": 0}})
+	mocket.Catcher.NewMock().WithQuery(`SELECT * FROM "schema_migrations"`).WithReply(rows)
+}
+
+func recordingMigration(version int64, calls *[]string) *VersionedMigration {
+	return &VersionedMigration{
+		Version: version,
+		Name:    fmt.Sprintf("migration_%d", version),
+		Up: func(*gorm.DB) error {
+			*calls = append(*calls, fmt.Sprintf("up %d", version))
+			return nil
+		},
+		Down: func(*gorm.DB) error {
+			*calls = append(*calls, fmt.Sprintf("down %d", version))
+			return nil
+		},
+	}
+}
+
+func (suite *UpdateTableSuite) TestMigrate() {
+	suite.Run("Should apply pending migrations in version order and record them", func() {
+		mocket.Catcher.Reset()
+		suite.mockSchemaMigrationsTable(1)
+		insertMock := mocket.Catcher.NewMock().WithQuery(`INSERT INTO "schema_migrations"`)
+		var calls []string
+		migration := &GormMigration{Migrations: []*VersionedMigration{recordingMigration(3, &calls), recordingMigration(1, &calls), recordingMigration(2, &calls)}}
+
+		err := migration.Migrate(suite.db)
+
+		suite.Require().NoError(err)
+		suite.Require().Equal([]string{"up 2", "up 3"}, calls)
+		suite.Require().True(insertMock.Triggered, "Applied migrations were recorded")
+	})
+	suite.Run("Should stop at the first failing migration", func() {
+		mocket.Catcher.Reset()
+		suite.mockSchemaMigrationsTable()
+		var calls []string
+		failing := recordingMigration(2, &calls)
+		failing.Up = func(*gorm.DB) error { return stderrors.New("my error") }
+		migration := &GormMigration{Migrations: []*VersionedMigration{recordingMigration(1, &calls), failing, recordingMigration(3, &calls)}}
+
+		err := migration.Migrate(suite.db)
+
+		suite.Require().EqualError(errors.Cause(err), "my error")
+		suite.Require().Equal([]string{"up 1"}, calls)
+	})
+	suite.Run("Should create the schema_migrations table when it is missing", func() {
+		mocket.Catcher.Reset()
+		mockMigrationLock(true)
+		mocket.Catcher.NewMock().WithQuery(`table_name = schema_migrations`).WithReply([]map[string]interface{}{{"count": 0}})
+		migration := &GormMigration{Migrations: []*VersionedMigration{}}
+
+		err := migration.Migrate(suite.db)
+
+		// go-mocket does not implement CREATE statements, so reaching it is all we can assert on.
+		suite.Require().Error(err)
+		suite.Require().Contains(err.Error(), "creating schema_migrations table")
+	})
+}
+
+func (suite *UpdateTableSuite) TestRollback() {
+	suite.Run("Should revert the most recently applied migrations", func() {
+		mocket.Catcher.Reset()
+		suite.mockSchemaMigrationsTable(1, 2, 3)
+		deleteMock := mocket.Catcher.NewMock().WithQuery(`DELETE FROM "schema_migrations"`).WithRowsNum(1)
+		var calls []string
+		migration := &GormMigration{Migrations: []*VersionedMigration{recordingMigration(1, &calls), recordingMigration(2, &calls), recordingMigration(3, &calls)}}
+
+		err := migration.Rollback(suite.db, 2)
+
+		suite.Require().NoError(err)
+		suite.Require().Equal([]string{"down 3", "down 2"}, calls)
+		suite.Require().True(deleteMock.Triggered, "Reverted migrations were removed")
+	})
+	suite.Run("Should skip migrations that were never applied", func() {
+		mocket.Catcher.Reset()
+		suite.mockSchemaMigrationsTable(1)
+		var calls []string
+		migration := &GormMigration{Migrations: []*VersionedMigration{recordingMigration(1, &calls), recordingMigration(2, &calls)}}
+
+		err := migration.Rollback(suite.db, 5)
+
+		suite.Require().NoError(err)
+		suite.Require().Equal([]string{"down 1"}, calls)
+	})
+	suite.Run("Should reject a non positive number of steps", func() {
+		migration := &GormMigration{Migrations: []*VersionedMigration{}}
+		suite.Require().Error(migration.Rollback(suite.db, 0))
+	})
+}
+
+func (suite *UpdateTableSuite) TestPlan() {
+	suite.Run("Should list pending migrations without applying them", func() {
+		mocket.Catcher.Reset()
+		suite.mockSchemaMigrationsTable(1)
+		insertMock := mocket.Catcher.NewMock().WithQuery(`INSERT INTO "schema_migrations"`)
+		var calls []string
+		migration := &GormMigration{Migrations: []*VersionedMigration{recordingMigration(2, &calls), recordingMigration(1, &calls), SQLMigration(3, "sql", "UP", "DOWN")}}
+
+		pending, err := migration.PlanMigrate(suite.db)
+
+		suite.Require().NoError(err)
+		suite.Require().Len(pending, 2)
+		suite.Require().EqualValues(2, pending[0].Version)
+		suite.Require().EqualValues(3, pending[1].Version)
+		suite.Require().Equal("UP", pending[1].UpSQL)
+		suite.Require().Empty(calls)
+		suite.Require().False(insertMock.Triggered, "Planning should not record migrations")
+	})
+	suite.Run("Should list migrations to roll back newest first without reverting them", func() {
+		mocket.Catcher.Reset()
+		suite.mockSchemaMigrationsTable(1, 2, 3)
+		var calls []string
+		migration := &GormMigration{Migrations: []*VersionedMigration{recordingMigration(1, &calls), recordingMigration(2, &calls), recordingMigration(3, &calls)}}
+
+		reverted, err := migration.PlanRollback(suite.db, 2)
+
+		suite.Require().NoError(err)
+		suite.Require().Len(reverted, 2)
+		suite.Require().EqualValues(3, reverted[0].Version)
+		suite.Require().EqualValues(2, reverted[1].Version)
+		suite.Require().Empty(calls)
+	})
+	suite.Run("Should not lock nor create the schema_migrations table when it is missing", func() {
+		mocket.Catcher.Reset()
+		lockMock := mockMigrationLock(true)
+		mocket.Catcher.NewMock().WithQuery(`table_name = schema_migrations`).WithReply([]map[string]interface{}{{"count": 0}})
+		var calls []string
+		migration := &GormMigration{Migrations: []*VersionedMigration{recordingMigration(2, &calls), recordingMigration(1, &calls)}}
+
+		pending, err := migration.PlanMigrate(suite.db)
+		suite.Require().NoError(err)
+		suite.Require().Len(pending, 2)
+		statuses, err := migration.Status(suite.db)
+		suite.Require().NoError(err)
+		suite.Require().False(statuses[0].Applied)
+		suite.Require().False(lockMock.Triggered, "Planning should not take the migration lock")
+	})
+}
+
+func (suite *UpdateTableSuite) TestStatus() {
+	suite.Run("Should report applied and pending migrations", func() {
+		mocket.Catcher.Reset()
+		suite.mockSchemaMigrationsTable(1)
+		var calls []string
+		migration := &GormMigration{Migrations: []*VersionedMigration{recordingMigration(2, &calls), recordingMigration(1, &calls)}}
+
+		statuses, err := migration.Status(suite.db)
+
+		suite.Require().NoError(err)
+		suite.Require().Len(statuses, 2)
+		suite.Require().EqualValues(1, statuses[0].Version)
+		suite.Require().True(statuses[0].Applied)
+		suite.Require().NotNil(statuses[0].AppliedAt)
+		suite.Require().EqualValues(2, statuses[1].Version)
+		suite.Require().False(statuses[1].Applied)
+		suite.Require().Nil(statuses[1].AppliedAt)
+		suite.Require().Empty(calls)
+	})
+}
+
+func TestRegisteredMigrationsAreUnique(t *testing.T) {
+	seen := make(map[int64]bool)
+	for _, m := range Migrations {
+		require.False(t, seen[m.Version], "Migration version %d is registered more than once", m.Version)
+		require.NotNil(t, m.Up)
+		require.NotNil(t, m.Down)
+		seen[m.Version] = true
+	}
 }
 
 func TestUpdateTablesSuite(t *testing.T) {
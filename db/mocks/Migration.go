@@ -2,6 +2,7 @@
 
 package mocks
 
+import db "github.com/EurosportDigital/global-transcoding-platform/db"
 import gorm "github.com/jinzhu/gorm"
 import mock "github.com/stretchr/testify/mock"
 
@@ -10,7 +11,76 @@ type Migration struct {
 	mock.Mock
 }
 
+// Migrate provides a mock function with given fields: _a0
+func (_m *Migration) Migrate(_a0 *gorm.DB) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*gorm.DB) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Rollback provides a mock function with given fields: _a0, _a1
+func (_m *Migration) Rollback(_a0 *gorm.DB, _a1 int) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*gorm.DB, int) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Status provides a mock function with given fields: _a0
+func (_m *Migration) Status(_a0 *gorm.DB) ([]*db.MigrationStatus, error) {
+	ret := _m.Called(_a0)
+
+	var r0 []*db.MigrationStatus
+	if rf, ok := ret.Get(0).(func(*gorm.DB) []*db.MigrationStatus); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*db.MigrationStatus)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*gorm.DB) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // UpdateTables provides a mock function with given fields: _a0, _a1
-func (_m *Migration) UpdateTables(_a0 []interface{}, _a1 *gorm.DB) {
-	_m.Called(_a0, _a1)
+func (_m *Migration) UpdateTables(_a0 []interface{}, _a1 *gorm.DB) (*db.UpdateTablesResult, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *db.UpdateTablesResult
+	if rf, ok := ret.Get(0).(func([]interface{}, *gorm.DB) *db.UpdateTablesResult); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*db.UpdateTablesResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]interface{}, *gorm.DB) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
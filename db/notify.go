@@ -0,0 +1,24 @@
+package db
+
+import (
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/jinzhu/gorm"
+)
+
+// ProfileCacheChannel is the Postgres channel that tells the profile caches of every instance that the profiles
+// changed, see profile.PostgresCacheInvalidations.
+const ProfileCacheChannel = "gtp_profile_cache"
+
+// NotifyProfileChange notifies ProfileCacheChannel within tx, so that every instance invalidates its profile cache
+// once tx commits, and never when it is rolled back. Postgres delivers the identical notifications of a transaction
+// once. It does nothing on SQLite, which has no notifications.
+func NotifyProfileChange(tx *gorm.DB) error {
+	if tx.Dialect().GetName() == "sqlite3" {
+		return nil
+	}
+	rows, err := tx.Raw("SELECT pg_notify(?, '')", ProfileCacheChannel).Rows()
+	if err != nil {
+		return errors.Wrap(err, "notifying the profile caches")
+	}
+	return rows.Close()
+}
@@ -0,0 +1,27 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/jinzhu/gorm"
+	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifyProfileChange(t *testing.T) {
+	t.Run("Should notify the profile cache channel on Postgres", func(t *testing.T) {
+		mocket.Catcher.Register()
+		mocket.Catcher.Reset()
+		database, err := gorm.Open(mocket.DriverName, "")
+		require.NoError(t, err)
+		defer database.Close()
+		notify := mocket.Catcher.NewMock().WithQuery(`SELECT pg_notify`).WithArgs(ProfileCacheChannel)
+
+		require.NoError(t, NotifyProfileChange(database))
+		require.True(t, notify.Triggered)
+	})
+	t.Run("Should do nothing on SQLite", func(t *testing.T) {
+		require.NoError(t, NotifyProfileChange(repositorytest.OpenSQLite(t)))
+	})
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"sync/atomic"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/jinzhu/gorm"
+)
+
+// Resolver routes repository queries between the primary database and its read replicas.
+type Resolver interface {
+	// Primary returns the connection to the primary database, used for writes and for reads that must observe them.
+	Primary() *gorm.DB
+
+	// Replica returns a connection for reads that tolerate replication lag. It is the primary when there are no replicas.
+	Replica() *gorm.DB
+}
+
+// Cluster is a Resolver over a primary connection and any number of read replicas, which are used in turn.
+type Cluster struct {
+	primary  *gorm.DB
+	replicas []*gorm.DB
+	next     uint32
+}
+
+// NewCluster constructs a Cluster from already opened connections.
+func NewCluster(primary *gorm.DB, replicas ...*gorm.DB) *Cluster {
+	return &Cluster{primary: primary, replicas: replicas}
+}
+
+// OpenCluster opens the primary and every replica described by the given configurations.
+// Connections that were already opened are closed if any of them fails.
+func OpenCluster(primary *DBConfig, replicas ...*DBConfig) (*Cluster, error) {
+	primaryDB, err := OpenDBConnectionWithConfig(primary)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening primary DB connection")
+	}
+	cluster := NewCluster(primaryDB)
+	for i, config := range replicas {
+		replicaDB, err := OpenDBConnectionWithConfig(config)
+		if err != nil {
+			cluster.Close()
+			return nil, errors.Wrapf(err, "opening replica DB connection %d", i)
+		}
+		cluster.replicas = append(cluster.replicas, replicaDB)
+	}
+	return cluster, nil
+}
+
+func (c *Cluster) Primary() *gorm.DB {
+	return c.primary
+}
+
+func (c *Cluster) Replica() *gorm.DB {
+	if len(c.replicas) == 0 {
+		return c.primary
+	}
+	next := atomic.AddUint32(&c.next, 1)
+	return c.replicas[(int(next)-1)%len(c.replicas)]
+}
+
+// Close closes the primary and every replica connection, returning the first error encountered.
+func (c *Cluster) Close() error {
+	var firstErr error
+	for _, conn := range append([]*gorm.DB{c.primary}, c.replicas...) {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type primaryOnly struct {
+	Resolver
+}
+
+// PrimaryOnly wraps a Resolver so that reads are also served by the primary, for read-after-write consistency.
+func PrimaryOnly(resolver Resolver) Resolver {
+	if _, ok := resolver.(primaryOnly); ok {
+		return resolver
+	}
+	return primaryOnly{resolver}
+}
+
+func (p primaryOnly) Replica() *gorm.DB {
+	return p.Primary()
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/require"
+)
+
+func openMockDB(t *testing.T, name string) *gorm.DB {
+	mocket.Catcher.Register()
+	db, err := gorm.Open(mocket.DriverName, name)
+	require.NoError(t, err)
+	return db
+}
+
+func TestCluster(t *testing.T) {
+	primary := openMockDB(t, "primary")
+	replicaA := openMockDB(t, "replica-a")
+	replicaB := openMockDB(t, "replica-b")
+
+	t.Run("Should fall back to the primary when there are no replicas", func(t *testing.T) {
+		cluster := NewCluster(primary)
+		require.Same(t, primary, cluster.Primary())
+		require.Same(t, primary, cluster.Replica())
+	})
+	t.Run("Should use the replicas in turn", func(t *testing.T) {
+		cluster := NewCluster(primary, replicaA, replicaB)
+		require.Same(t, replicaA, cluster.Replica())
+		require.Same(t, replicaB, cluster.Replica())
+		require.Same(t, replicaA, cluster.Replica())
+		require.Same(t, primary, cluster.Primary())
+	})
+	t.Run("Should serve reads from the primary when forced", func(t *testing.T) {
+		resolver := PrimaryOnly(NewCluster(primary, replicaA))
+		require.Same(t, primary, resolver.Replica())
+		require.Same(t, primary, resolver.Primary())
+		require.Equal(t, resolver, PrimaryOnly(resolver), "Wrapping twice is a no-op")
+	})
+}
+
+func TestOpenCluster(t *testing.T) {
+	mocket.Catcher.Register()
+	t.Run("Should open the primary and every replica", func(t *testing.T) {
+		cluster, err := OpenCluster(
+			&DBConfig{ConnectionString: "primary", Driver: mocket.DriverName},
+			&DBConfig{ConnectionString: "replica", Driver: mocket.DriverName, MaxOpenConns: 4},
+		)
+		require.NoError(t, err)
+		require.NotSame(t, cluster.Primary(), cluster.Replica())
+		require.Equal(t, 4, cluster.Replica().DB().Stats().MaxOpenConnections)
+		require.NoError(t, cluster.Close())
+	})
+	t.Run("Should fail when a replica cannot be opened", func(t *testing.T) {
+		cluster, err := OpenCluster(
+			&DBConfig{ConnectionString: "primary", Driver: mocket.DriverName},
+			&DBConfig{ConnectionString: "", Driver: mocket.DriverName},
+		)
+		require.Nil(t, cluster)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,234 @@
+package db
+
+// Migrations are the versioned schema changes applied by GormMigration.Migrate.
+// New migrations must be appended with a version greater than every existing one and must never be edited once released.
+var Migrations = []*VersionedMigration{
+	SQLMigration(1, "create_base_tables", createBaseTablesUp, createBaseTablesDown),
+	SQLMigration(2, "add_lookup_indexes", addLookupIndexesUp, addLookupIndexesDown),
+	SQLMigration(3, "add_job_versions", addJobVersionsUp, addJobVersionsDown),
+	SQLMigration(4, "create_job_status_history", createJobStatusHistoryUp, createJobStatusHistoryDown),
+	SQLMigration(5, "add_job_leases", addJobLeasesUp, addJobLeasesDown),
+	SQLMigration(6, "add_job_creation_times", addJobCreationTimesUp, addJobCreationTimesDown),
+	SQLMigration(7, "add_job_filter_indexes", addJobFilterIndexesUp, addJobFilterIndexesDown),
+	SQLMigration(8, "create_job_outputs", createJobOutputsUp, createJobOutputsDown),
+	SQLMigration(9, "add_soft_deletes", addSoftDeletesUp, addSoftDeletesDown),
+	SQLMigration(10, "create_audit_entries", createAuditEntriesUp, createAuditEntriesDown),
+	SQLMigration(11, "add_profile_revisions", addProfileRevisionsUp, addProfileRevisionsDown),
+	SQLMigration(12, "restrict_profile_names_and_revisions", restrictProfileNamesAndRevisionsUp, restrictProfileNamesAndRevisionsDown),
+}
+
+// The base tables mirror what gorm AutoMigrate produced for the gormmodel types, so databases that were created
+// through UpdateTables can adopt versioned migrations without changes.
+const createBaseTablesUp = `
+CREATE TABLE IF NOT EXISTS encoders (
+	id serial PRIMARY KEY,
+	name text,
+	api_endpoint text,
+	info_url text
+);
+CREATE TABLE IF NOT EXISTS encoder_configs (
+	id serial PRIMARY KEY,
+	name text,
+	config text,
+	encoder_id integer
+);
+CREATE TABLE IF NOT EXISTS profiles (
+	id serial PRIMARY KEY,
+	name text,
+	codec text,
+	package_format text,
+	encoder_config_id integer
+);
+CREATE TABLE IF NOT EXISTS targets (
+	id serial PRIMARY KEY,
+	target_type text,
+	path text,
+	auth_key text
+);
+CREATE TABLE IF NOT EXISTS jobs (
+	id serial PRIMARY KEY,
+	priority integer,
+	status json,
+	source_path text,
+	preroll_path text,
+	postroll_path text,
+	outputs json
+);`
+
+const createBaseTablesDown = `
+DROP TABLE IF EXISTS jobs;
+DROP TABLE IF EXISTS targets;
+DROP TABLE IF EXISTS profiles;
+DROP TABLE IF EXISTS encoder_configs;
+DROP TABLE IF EXISTS encoders;`
+
+const addLookupIndexesUp = `
+CREATE INDEX IF NOT EXISTS idx_profiles_name ON profiles (name);
+CREATE INDEX IF NOT EXISTS idx_encoder_configs_encoder_id ON encoder_configs (encoder_id);
+CREATE INDEX IF NOT EXISTS idx_jobs_priority ON jobs (priority);
+CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs ((status->>'status'));`
+
+const addLookupIndexesDown = `
+DROP INDEX IF EXISTS idx_jobs_status;
+DROP INDEX IF EXISTS idx_jobs_priority;
+DROP INDEX IF EXISTS idx_encoder_configs_encoder_id;
+DROP INDEX IF EXISTS idx_profiles_name;`
+
+// Existing jobs start at version 1, like the jobs created by the repository.
+const addJobVersionsUp = `ALTER TABLE jobs ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;`
+
+const addJobVersionsDown = `ALTER TABLE jobs DROP COLUMN IF EXISTS version;`
+
+const createJobStatusHistoryUp = `
+CREATE TABLE IF NOT EXISTS job_status_history (
+	id serial PRIMARY KEY,
+	job_id integer REFERENCES jobs (id) ON DELETE CASCADE,
+	from_status text,
+	to_status text,
+	reason text,
+	created_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS idx_job_status_history_job_id ON job_status_history (job_id);`
+
+const createJobStatusHistoryDown = `DROP TABLE IF EXISTS job_status_history;`
+
+const addJobLeasesUp = `
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS lease_owner text NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS lease_expires_at timestamp with time zone;`
+
+const addJobLeasesDown = `
+ALTER TABLE jobs DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS lease_owner;`
+
+// Existing jobs are dated from the migration, their creation time was not recorded.
+const addJobCreationTimesUp = `
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS created_at timestamp with time zone NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS idx_jobs_created_at_id ON jobs (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_jobs_priority_id ON jobs (priority, id);`
+
+const addJobCreationTimesDown = `
+DROP INDEX IF EXISTS idx_jobs_priority_id;
+DROP INDEX IF EXISTS idx_jobs_created_at_id;
+ALTER TABLE jobs DROP COLUMN IF EXISTS created_at;`
+
+// text_pattern_ops lets source path prefix searches (LIKE 'prefix%') use the index whatever the collation.
+const addJobFilterIndexesUp = `
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS updated_at timestamp with time zone NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS idx_jobs_updated_at ON jobs (updated_at);
+CREATE INDEX IF NOT EXISTS idx_jobs_source_path ON jobs (source_path text_pattern_ops);`
+
+const addJobFilterIndexesDown = `
+DROP INDEX IF EXISTS idx_jobs_source_path;
+DROP INDEX IF EXISTS idx_jobs_updated_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS updated_at;`
+
+// job_outputs indexes the outputs column of jobs, it is filled from the existing jobs.
+const createJobOutputsUp = `
+CREATE TABLE IF NOT EXISTS job_outputs (
+	id serial PRIMARY KEY,
+	job_id integer REFERENCES jobs (id) ON DELETE CASCADE,
+	profile_id integer,
+	target_id integer
+);
+CREATE INDEX IF NOT EXISTS idx_job_outputs_job_id ON job_outputs (job_id);
+CREATE INDEX IF NOT EXISTS idx_job_outputs_profile_id ON job_outputs (profile_id);
+CREATE INDEX IF NOT EXISTS idx_job_outputs_target_id ON job_outputs (target_id);
+INSERT INTO job_outputs (job_id, profile_id, target_id)
+SELECT jobs.id, (output->>'profile_id')::integer, (output->>'target_id')::integer
+FROM jobs, json_array_elements(CASE WHEN json_typeof(jobs.outputs) = 'array' THEN jobs.outputs ELSE '[]'::json END) AS output;`
+
+const createJobOutputsDown = `DROP TABLE IF EXISTS job_outputs;`
+
+const addSoftDeletesUp = `
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+ALTER TABLE targets ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+CREATE INDEX IF NOT EXISTS idx_jobs_deleted_at ON jobs (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_profiles_deleted_at ON profiles (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_targets_deleted_at ON targets (deleted_at);`
+
+const addSoftDeletesDown = `
+ALTER TABLE targets DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE profiles DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS deleted_at;`
+
+// audit_entries has no foreign keys so that the entries outlive the entities they describe, even once purged.
+const createAuditEntriesUp = `
+CREATE TABLE IF NOT EXISTS audit_entries (
+	id serial PRIMARY KEY,
+	actor text NOT NULL,
+	entity_type text NOT NULL,
+	entity_id integer NOT NULL,
+	action text NOT NULL,
+	changes json NOT NULL,
+	created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_actor ON audit_entries (actor);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_entity ON audit_entries (entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_created_at ON audit_entries (created_at);`
+
+const createAuditEntriesDown = `DROP TABLE IF EXISTS audit_entries;`
+
+// The existing profiles become revision 1, and the existing jobs are pinned to it.
+const addProfileRevisionsUp = `
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS revision integer NOT NULL DEFAULT 1;
+CREATE TABLE IF NOT EXISTS profile_revisions (
+	id serial PRIMARY KEY,
+	profile_id integer NOT NULL REFERENCES profiles (id) ON DELETE CASCADE,
+	revision integer NOT NULL,
+	snapshot json NOT NULL,
+	created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_profile_revisions_profile_id_revision ON profile_revisions (profile_id, revision);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS profile_revisions json;
+ALTER TABLE job_outputs ADD COLUMN IF NOT EXISTS profile_revision integer;
+INSERT INTO profile_revisions (profile_id, revision, snapshot)
+SELECT profiles.id, profiles.revision, json_build_object(
+	'ID', profiles.id,
+	'Name', COALESCE(profiles.name, ''),
+	'Codec', COALESCE(profiles.codec, ''),
+	'PackageFormat', COALESCE(profiles.package_format, ''),
+	'EncConfig', json_build_object(
+		'ID', COALESCE(encoder_configs.id, 0),
+		'Name', COALESCE(encoder_configs.name, ''),
+		'Config', COALESCE(encoder_configs.config, ''),
+		'Encoder', json_build_object(
+			'ID', COALESCE(encoders.id, 0),
+			'Name', COALESCE(encoders.name, ''),
+			'ApiEndpoint', COALESCE(encoders.api_endpoint, ''),
+			'InfoUrl', COALESCE(encoders.info_url, ''))))
+FROM profiles
+LEFT JOIN encoder_configs ON encoder_configs.id = profiles.encoder_config_id
+LEFT JOIN encoders ON encoders.id = encoder_configs.encoder_id
+ON CONFLICT DO NOTHING;
+UPDATE job_outputs SET profile_revision = profiles.revision FROM profiles WHERE profiles.id = job_outputs.profile_id;
+UPDATE jobs SET profile_revisions = pinned.revisions
+FROM (
+	SELECT job_id, json_object_agg(profile_id, profile_revision) AS revisions
+	FROM (SELECT DISTINCT job_id, profile_id, profile_revision FROM job_outputs WHERE profile_revision IS NOT NULL) AS outputs
+	GROUP BY job_id
+) AS pinned
+WHERE jobs.id = pinned.job_id;`
+
+const addProfileRevisionsDown = `
+ALTER TABLE job_outputs DROP COLUMN IF EXISTS profile_revision;
+ALTER TABLE jobs DROP COLUMN IF EXISTS profile_revisions;
+DROP TABLE IF EXISTS profile_revisions;
+ALTER TABLE profiles DROP COLUMN IF EXISTS revision;`
+
+// Only one profile that is not deleted may have a name, creating the index fails until the others sharing it are
+// renamed or deleted. Deleting a profile no longer deletes its revisions, which the jobs pinned to them need, see
+// profile.Repository.Purge.
+const restrictProfileNamesAndRevisionsUp = `
+DROP INDEX IF EXISTS idx_profiles_name;
+CREATE UNIQUE INDEX idx_profiles_name ON profiles (name) WHERE deleted_at IS NULL;
+ALTER TABLE profile_revisions DROP CONSTRAINT IF EXISTS profile_revisions_profile_id_fkey;
+ALTER TABLE profile_revisions ADD CONSTRAINT profile_revisions_profile_id_fkey
+	FOREIGN KEY (profile_id) REFERENCES profiles (id) ON DELETE RESTRICT;`
+
+const restrictProfileNamesAndRevisionsDown = `
+ALTER TABLE profile_revisions DROP CONSTRAINT IF EXISTS profile_revisions_profile_id_fkey;
+ALTER TABLE profile_revisions ADD CONSTRAINT profile_revisions_profile_id_fkey
+	FOREIGN KEY (profile_id) REFERENCES profiles (id) ON DELETE CASCADE;
+DROP INDEX IF EXISTS idx_profiles_name;
+CREATE INDEX IF NOT EXISTS idx_profiles_name ON profiles (name);`
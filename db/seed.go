@@ -0,0 +1,562 @@
+package db
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/logger"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/secrets"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/validation"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/jinzhu/gorm"
+	"gopkg.in/yaml.v2"
+)
+
+// Fixtures is a catalog of encoders, encoder configs, profiles and targets to seed a database with.
+// Encoders, encoder configs and profiles are identified by name, targets by path. Profiles and targets must pass
+// validation.ValidateProfile and validation.ValidateTarget.
+type Fixtures struct {
+	Encoders       []*EncoderFixture       `json:"encoders" yaml:"encoders"`
+	EncoderConfigs []*EncoderConfigFixture `json:"encoder_configs" yaml:"encoder_configs"`
+	Profiles       []*ProfileFixture       `json:"profiles" yaml:"profiles"`
+	Targets        []*TargetFixture        `json:"targets" yaml:"targets"`
+}
+
+// EncoderFixture describes a gormmodel.Encoder.
+type EncoderFixture struct {
+	Name        string `json:"name" yaml:"name"`
+	APIEndpoint string `json:"api_endpoint" yaml:"api_endpoint"`
+	InfoURL     string `json:"info_url" yaml:"info_url"`
+}
+
+// EncoderConfigFixture describes a gormmodel.EncoderConfig. Encoder is the name of its encoder.
+type EncoderConfigFixture struct {
+	Name    string `json:"name" yaml:"name"`
+	Encoder string `json:"encoder" yaml:"encoder"`
+	Config  string `json:"config" yaml:"config"`
+}
+
+// ProfileFixture describes a gormmodel.Profile. EncoderConfig is the name of its encoder config.
+type ProfileFixture struct {
+	Name          string `json:"name" yaml:"name"`
+	Codec         string `json:"codec" yaml:"codec"`
+	PackageFormat string `json:"package_format" yaml:"package_format"`
+	EncoderConfig string `json:"encoder_config" yaml:"encoder_config"`
+}
+
+// TargetFixture describes a gormmodel.Target. AuthKey is the plaintext key, it is sealed before it is stored.
+type TargetFixture struct {
+	TargetType string `json:"target_type" yaml:"target_type"`
+	Path       string `json:"path" yaml:"path"`
+	AuthKey    string `json:"auth_key" yaml:"auth_key"`
+}
+
+// LoadFixtures reads and merges the fixtures of the given YAML (.yaml, .yml) and JSON (.json) files.
+// An entry defined in more than one file is an error.
+func LoadFixtures(paths ...string) (*Fixtures, error) {
+	merged := &Fixtures{}
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading fixtures %s", path)
+		}
+		fixtures := &Fixtures{}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			err = yaml.UnmarshalStrict(content, fixtures)
+		case ".json":
+			err = json.Unmarshal(content, fixtures)
+		default:
+			return nil, errors.Errorf("unsupported fixtures file %s, expected .yaml, .yml or .json", path)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing fixtures %s", path)
+		}
+		merged.Encoders = append(merged.Encoders, fixtures.Encoders...)
+		merged.EncoderConfigs = append(merged.EncoderConfigs, fixtures.EncoderConfigs...)
+		merged.Profiles = append(merged.Profiles, fixtures.Profiles...)
+		merged.Targets = append(merged.Targets, fixtures.Targets...)
+	}
+	if err := merged.validate(); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+func (fixtures *Fixtures) validate() error {
+	var encoders, configs, profiles, targets []string
+	for _, e := range fixtures.Encoders {
+		encoders = append(encoders, e.Name)
+	}
+	for _, c := range fixtures.EncoderConfigs {
+		configs = append(configs, c.Name)
+	}
+	for _, p := range fixtures.Profiles {
+		profiles = append(profiles, p.Name)
+	}
+	for _, t := range fixtures.Targets {
+		targets = append(targets, t.Path)
+	}
+	// Checked in dependency order, so that the reported error does not depend on map iteration.
+	for _, entries := range []struct {
+		kind  string
+		names []string
+	}{{"encoder", encoders}, {"encoder config", configs}, {"profile", profiles}, {"target", targets}} {
+		seen := map[string]bool{}
+		for _, name := range entries.names {
+			if name == "" {
+				return errors.Errorf("%s without a name", entries.kind)
+			}
+			if seen[name] {
+				return errors.Errorf("%s %q is defined more than once", entries.kind, name)
+			}
+			seen[name] = true
+		}
+	}
+	for _, p := range fixtures.Profiles {
+		profile := &model.Profile{Name: p.Name, Codec: p.Codec, PackageFormat: p.PackageFormat}
+		if err := validation.ValidateProfile(profile); err != nil {
+			return errors.WithMessagef(err, "profile %q", p.Name)
+		}
+	}
+	for _, t := range fixtures.Targets {
+		if err := validation.ValidateTarget(&model.Target{TargetType: t.TargetType, Path: t.Path}); err != nil {
+			return errors.WithMessagef(err, "target %q", t.Path)
+		}
+	}
+	return nil
+}
+
+// SeedAction is what Seed did with an entry.
+type SeedAction string
+
+const (
+	// SeedCreated means the entry did not exist and was inserted.
+	SeedCreated SeedAction = "created"
+	// SeedUpdated means the entry existed with different values and was updated.
+	SeedUpdated SeedAction = "updated"
+	// SeedUnchanged means the entry already existed with the same values.
+	SeedUnchanged SeedAction = "unchanged"
+	// SeedRestored means the entry was soft deleted, and was restored with the values of the fixtures.
+	SeedRestored SeedAction = "restored"
+	// SeedPruned means the entry was not in the fixtures and was deleted, softly for profiles and targets.
+	SeedPruned SeedAction = "pruned"
+)
+
+// SeedChange records what happened to one entry.
+type SeedChange struct {
+	Kind   string
+	Name   string
+	Action SeedAction
+}
+
+// SeedResult lists what Seed did with every entry, in the order it processed them.
+type SeedResult struct {
+	Changes []*SeedChange
+}
+
+// Count returns the number of entries Seed handled with action.
+func (result *SeedResult) Count(action SeedAction) int {
+	count := 0
+	for _, change := range result.Changes {
+		if change.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+func (result *SeedResult) add(kind string, name string, action SeedAction) {
+	if action != SeedUnchanged {
+		logger.Infof("Seed %s %s %q", action, kind, name)
+	}
+	result.Changes = append(result.Changes, &SeedChange{Kind: kind, Name: name, Action: action})
+}
+
+// SeedOptions configures Seed.
+type SeedOptions struct {
+	// Prune deletes the entries that are not in the fixtures. References between fixtures must then
+	// resolve within the fixtures.
+	Prune bool
+	// DryRun rolls back every change once the result is known.
+	DryRun bool
+	// Keys seals the AuthKey of the targets, secrets.Keys when nil.
+	Keys secrets.KeyProvider
+}
+
+func (options *SeedOptions) keyProvider() secrets.KeyProvider {
+	if options.Keys != nil {
+		return options.Keys
+	}
+	return secrets.Keys
+}
+
+var errDryRun = stderrors.New("dry run")
+
+// Seed inserts or updates every entry of fixtures in a single transaction, so running it twice with the same
+// fixtures changes nothing. It holds the migration lock so that it never runs alongside a migration.
+func Seed(db *gorm.DB, fixtures *Fixtures, options *SeedOptions) (*SeedResult, error) {
+	if options == nil {
+		options = &SeedOptions{}
+	}
+	if err := fixtures.validate(); err != nil {
+		return nil, err
+	}
+	result := &SeedResult{}
+	err := runInTransaction(db, func(tx *gorm.DB) error {
+		if err := acquireTransactionLock(tx, migrationLockKey, DefaultLockTimeout); err != nil {
+			return err
+		}
+		seeder := &seeder{tx: tx, options: options, result: result}
+		if err := seeder.seed(fixtures); err != nil {
+			return err
+		}
+		if options.DryRun {
+			return errDryRun
+		}
+		if len(result.Changes) == result.Count(SeedUnchanged) {
+			return nil
+		}
+		// Profiles embed their encoder config and encoder, so any change may show in the profile caches.
+		return NotifyProfileChange(tx)
+	})
+	if err != nil && err != errDryRun {
+		return nil, errors.Wrap(err, "seeding fixtures")
+	}
+	return result, nil
+}
+
+type seeder struct {
+	tx      *gorm.DB
+	options *SeedOptions
+	result  *SeedResult
+}
+
+func (s *seeder) seed(fixtures *Fixtures) error {
+	encoderIDs, err := s.seedEncoders(fixtures.Encoders)
+	if err != nil {
+		return err
+	}
+	configIDs, err := s.seedEncoderConfigs(fixtures.EncoderConfigs, encoderIDs)
+	if err != nil {
+		return err
+	}
+	if err := s.seedProfiles(fixtures.Profiles, configIDs); err != nil {
+		return err
+	}
+	if err := s.seedTargets(fixtures.Targets); err != nil {
+		return err
+	}
+	if !s.options.Prune {
+		return nil
+	}
+	// Referencing rows go first. Encoder configs and encoders have no soft deletion, so the ones the rows left,
+	// soft deleted ones included, still reference are kept for them to be restored.
+	for _, prune := range []struct {
+		kind       string
+		model      interface{}
+		column     string
+		keep       []string
+		referenced string
+	}{
+		{"target", &gormmodel.TargetRecord{}, "path", targetPaths(fixtures.Targets), ""},
+		{"profile", &gormmodel.ProfileRecord{}, "name", profileNames(fixtures.Profiles), ""},
+		{"encoder config", &gormmodel.EncoderConfig{}, "name", encoderConfigNames(fixtures.EncoderConfigs),
+			"EXISTS (SELECT 1 FROM profiles WHERE profiles.encoder_config_id = encoder_configs.id)"},
+		{"encoder", &gormmodel.Encoder{}, "name", encoderNames(fixtures.Encoders),
+			"EXISTS (SELECT 1 FROM encoder_configs WHERE encoder_configs.encoder_id = encoders.id)"},
+	} {
+		if err := s.prune(prune.kind, prune.model, prune.column, prune.keep, prune.referenced); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedEncoders returns the ID of every encoder that encoder configs may reference by name.
+func (s *seeder) seedEncoders(fixtures []*EncoderFixture) (map[string]int, error) {
+	var existing []*gormmodel.Encoder
+	if err := s.tx.Order("id").Find(&existing).Error; err != nil {
+		return nil, errors.Wrap(err, "listing encoders")
+	}
+	byName := map[string]*gormmodel.Encoder{}
+	ids := map[string]int{}
+	for _, e := range existing {
+		if _, ok := byName[e.Name]; !ok {
+			byName[e.Name] = e
+			if !s.options.Prune {
+				ids[e.Name] = e.ID
+			}
+		}
+	}
+
+	for _, fixture := range fixtures {
+		values := map[string]interface{}{"api_endpoint": fixture.APIEndpoint, "info_url": fixture.InfoURL}
+		encoder, ok := byName[fixture.Name]
+		switch {
+		case !ok:
+			encoder = &gormmodel.Encoder{Name: fixture.Name, ApiEndpoint: fixture.APIEndpoint, InfoUrl: fixture.InfoURL}
+			if err := s.tx.Create(encoder).Error; err != nil {
+				return nil, errors.Wrapf(err, "creating encoder %q", fixture.Name)
+			}
+			s.result.add("encoder", fixture.Name, SeedCreated)
+		case encoder.ApiEndpoint != fixture.APIEndpoint || encoder.InfoUrl != fixture.InfoURL:
+			if err := s.tx.Model(encoder).Updates(values).Error; err != nil {
+				return nil, errors.Wrapf(err, "updating encoder %q", fixture.Name)
+			}
+			s.result.add("encoder", fixture.Name, SeedUpdated)
+		default:
+			s.result.add("encoder", fixture.Name, SeedUnchanged)
+		}
+		ids[fixture.Name] = encoder.ID
+	}
+	return ids, nil
+}
+
+// seedEncoderConfigs returns the ID of every encoder config that profiles may reference by name.
+func (s *seeder) seedEncoderConfigs(fixtures []*EncoderConfigFixture, encoderIDs map[string]int) (map[string]int, error) {
+	var existing []*gormmodel.EncoderConfig
+	if err := s.tx.Order("id").Find(&existing).Error; err != nil {
+		return nil, errors.Wrap(err, "listing encoder configs")
+	}
+	byName := map[string]*gormmodel.EncoderConfig{}
+	ids := map[string]int{}
+	for _, c := range existing {
+		if _, ok := byName[c.Name]; !ok {
+			byName[c.Name] = c
+			if !s.options.Prune {
+				ids[c.Name] = c.ID
+			}
+		}
+	}
+
+	for _, fixture := range fixtures {
+		encoderID, ok := encoderIDs[fixture.Encoder]
+		if !ok {
+			return nil, errors.Errorf("encoder config %q references unknown encoder %q", fixture.Name, fixture.Encoder)
+		}
+		values := map[string]interface{}{"config": fixture.Config, "encoder_id": encoderID}
+		config, ok := byName[fixture.Name]
+		switch {
+		case !ok:
+			config = &gormmodel.EncoderConfig{Name: fixture.Name, Config: fixture.Config, EncoderID: encoderID}
+			if err := s.tx.Create(config).Error; err != nil {
+				return nil, errors.Wrapf(err, "creating encoder config %q", fixture.Name)
+			}
+			s.result.add("encoder config", fixture.Name, SeedCreated)
+		case config.Config != fixture.Config || config.EncoderID != encoderID:
+			if err := s.tx.Model(config).Updates(values).Error; err != nil {
+				return nil, errors.Wrapf(err, "updating encoder config %q", fixture.Name)
+			}
+			s.result.add("encoder config", fixture.Name, SeedUpdated)
+		default:
+			s.result.add("encoder config", fixture.Name, SeedUnchanged)
+		}
+		ids[fixture.Name] = config.ID
+	}
+	return ids, nil
+}
+
+func (s *seeder) seedProfiles(fixtures []*ProfileFixture, configIDs map[string]int) error {
+	var existing []*gormmodel.ProfileRecord
+	if err := s.tx.Unscoped().Order("id").Find(&existing).Error; err != nil {
+		return errors.Wrap(err, "listing profiles")
+	}
+	byName := map[string]*gormmodel.ProfileRecord{}
+	for _, p := range existing {
+		if first, ok := byName[p.Name]; !ok || (first.DeletedAt != nil && p.DeletedAt == nil) {
+			byName[p.Name] = p
+		}
+	}
+
+	for _, fixture := range fixtures {
+		configID, ok := configIDs[fixture.EncoderConfig]
+		if !ok {
+			return errors.Errorf("profile %q references unknown encoder config %q", fixture.Name, fixture.EncoderConfig)
+		}
+		values := map[string]interface{}{"codec": fixture.Codec, "package_format": fixture.PackageFormat, "encoder_config_id": configID}
+		profile, ok := byName[fixture.Name]
+		if !ok {
+			created := &gormmodel.Profile{Name: fixture.Name, Codec: fixture.Codec, PackageFormat: fixture.PackageFormat, EncoderConfigID: configID}
+			if err := s.tx.Create(created).Error; err != nil {
+				return errors.Wrapf(err, "creating profile %q", fixture.Name)
+			}
+			if err := s.recordProfileRevision(created.ID, false); err != nil {
+				return err
+			}
+			s.result.add("profile", fixture.Name, SeedCreated)
+			continue
+		}
+		changed := profile.Codec != fixture.Codec || profile.PackageFormat != fixture.PackageFormat || profile.EncoderConfigID != configID
+		action := restoredOr(profile.DeletedAt, SeedUpdated)
+		switch {
+		case changed || action == SeedRestored:
+			values["deleted_at"] = nil
+			if err := s.tx.Unscoped().Model(profile).Updates(values).Error; err != nil {
+				return errors.Wrapf(err, "updating profile %q", fixture.Name)
+			}
+			if changed {
+				if err := s.recordProfileRevision(profile.ID, true); err != nil {
+					return err
+				}
+			}
+			s.result.add("profile", fixture.Name, action)
+		default:
+			s.result.add("profile", fixture.Name, SeedUnchanged)
+		}
+	}
+	return nil
+}
+
+// restoredOr returns SeedRestored for an entry soft deleted at deletedAt, action otherwise.
+func restoredOr(deletedAt *time.Time, action SeedAction) SeedAction {
+	if deletedAt != nil {
+		return SeedRestored
+	}
+	return action
+}
+
+// recordProfileRevision snapshots the profile as a revision, like the profile repository does on every create and
+// update. An updated profile moves to the next revision first.
+func (s *seeder) recordProfileRevision(id int, updated bool) error {
+	if updated {
+		err := s.tx.Unscoped().Model(&gormmodel.ProfileRecord{}).Where("id = ?", id).UpdateColumn("revision", gorm.Expr("revision + 1")).Error
+		if err != nil {
+			return errors.Wrapf(err, "incrementing the revision of profile %v", id)
+		}
+	}
+	var record gormmodel.ProfileRecord
+	if err := s.tx.Unscoped().Preload("EncConfig.Encoder").First(&record, id).Error; err != nil {
+		return errors.Wrapf(err, "reading profile %v", id)
+	}
+	revision := gormmodel.ToGormProfileRevision(gormmodel.ToProfile(&record.Profile), record.Revision)
+	if err := s.tx.Create(revision).Error; err != nil {
+		return errors.Wrapf(err, "recording revision %v of profile %v", record.Revision, id)
+	}
+	return nil
+}
+
+func (s *seeder) seedTargets(fixtures []*TargetFixture) error {
+	var existing []*gormmodel.TargetRecord
+	if err := s.tx.Unscoped().Order("id").Find(&existing).Error; err != nil {
+		return errors.Wrap(err, "listing targets")
+	}
+	byPath := map[string]*gormmodel.TargetRecord{}
+	for _, t := range existing {
+		if first, ok := byPath[t.Path]; !ok || (first.DeletedAt != nil && t.DeletedAt == nil) {
+			byPath[t.Path] = t
+		}
+	}
+
+	keys := s.options.keyProvider()
+	for _, fixture := range fixtures {
+		authKey, err := secrets.Seal(keys, fixture.AuthKey)
+		if err != nil {
+			return errors.WithMessagef(err, "encrypting the auth key of target %q", fixture.Path)
+		}
+		values := map[string]interface{}{"target_type": fixture.TargetType, "auth_key": authKey}
+		target, ok := byPath[fixture.Path]
+		if !ok {
+			created := &gormmodel.Target{TargetType: fixture.TargetType, Path: fixture.Path, AuthKey: authKey}
+			if err := s.tx.Create(created).Error; err != nil {
+				return errors.Wrapf(err, "creating target %q", fixture.Path)
+			}
+			s.result.add("target", fixture.Path, SeedCreated)
+			continue
+		}
+		authKeyChanged, err := s.authKeyChanged(keys, &target.Target, fixture.AuthKey)
+		if err != nil {
+			return err
+		}
+		action := restoredOr(target.DeletedAt, SeedUpdated)
+		switch {
+		case target.TargetType != fixture.TargetType || authKeyChanged || action == SeedRestored:
+			values["deleted_at"] = nil
+			if err := s.tx.Unscoped().Model(target).Updates(values).Error; err != nil {
+				return errors.Wrapf(err, "updating target %q", fixture.Path)
+			}
+			s.result.add("target", fixture.Path, action)
+		default:
+			s.result.add("target", fixture.Path, SeedUnchanged)
+		}
+	}
+	return nil
+}
+
+// authKeyChanged tells whether the stored AuthKey of target is not authKey sealed. Sealing the same key twice gives
+// different values, so the stored one is opened to compare them. A key stored before encryption counts as changed,
+// so that seeding seals it.
+func (s *seeder) authKeyChanged(keys secrets.KeyProvider, target *gormmodel.Target, authKey string) (bool, error) {
+	if target.AuthKey != "" && !secrets.IsSealed(target.AuthKey) {
+		return true, nil
+	}
+	stored, err := secrets.Open(keys, target.AuthKey)
+	if err != nil {
+		return false, errors.WithMessagef(err, "decrypting the auth key of target %q", target.Path)
+	}
+	return stored != authKey, nil
+}
+
+// prune deletes the rows of model whose column is not one of keep, unless the referenced condition, when set, holds
+// for them. The records of soft deleted entities are soft deleted, which keeps the revisions jobs are pinned to.
+func (s *seeder) prune(kind string, model interface{}, column string, keep []string, referenced string) error {
+	query := s.tx.Model(model)
+	if len(keep) > 0 {
+		query = query.Where(column+" NOT IN (?)", keep)
+	}
+	if referenced != "" {
+		query = query.Where("NOT " + referenced)
+	}
+	var names []string
+	if err := query.Pluck(column, &names).Error; err != nil {
+		return errors.Wrapf(err, "listing %ss to prune", kind)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	if err := s.tx.Where(column+" IN (?)", names).Delete(model).Error; err != nil {
+		return errors.Wrapf(err, "pruning %ss", kind)
+	}
+	for _, name := range names {
+		s.result.add(kind, name, SeedPruned)
+	}
+	return nil
+}
+
+func encoderNames(fixtures []*EncoderFixture) []string {
+	names := make([]string, len(fixtures))
+	for i, fixture := range fixtures {
+		names[i] = fixture.Name
+	}
+	return names
+}
+
+func encoderConfigNames(fixtures []*EncoderConfigFixture) []string {
+	names := make([]string, len(fixtures))
+	for i, fixture := range fixtures {
+		names[i] = fixture.Name
+	}
+	return names
+}
+
+func profileNames(fixtures []*ProfileFixture) []string {
+	names := make([]string, len(fixtures))
+	for i, fixture := range fixtures {
+		names[i] = fixture.Name
+	}
+	return names
+}
+
+func targetPaths(fixtures []*TargetFixture) []string {
+	paths := make([]string, len(fixtures))
+	for i, fixture := range fixtures {
+		paths[i] = fixture.Path
+	}
+	return paths
+}
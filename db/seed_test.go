@@ -0,0 +1,265 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/secrets"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/validation"
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/stretchr/testify/require"
+)
+
+const encodersYAML = `
+encoders:
+  - name: bitmovin
+    api_endpoint: https://api.bitmovin.com
+    info_url: https://bitmovin.com
+encoder_configs:
+  - name: default
+    encoder: bitmovin
+    config: "{}"
+`
+
+const catalogJSON = `{
+  "profiles": [
+    {"name": "h264-hls", "codec": "h264", "package_format": "hls", "encoder_config": "default"},
+    {"name": "h265-dash", "codec": "h265", "package_format": "dash", "encoder_config": "default"}
+  ],
+  "targets": [
+    {"target_type": "s3", "path": "s3://bucket/prefix", "auth_key": "key"}
+  ]
+}`
+
+func writeFixtures(t *testing.T, files map[string]string) []string {
+	dir, err := ioutil.TempDir("", "fixtures")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	var paths []string
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestLoadFixtures(t *testing.T) {
+	t.Run("Should merge YAML and JSON files", func(t *testing.T) {
+		fixtures, err := LoadFixtures(writeFixtures(t, map[string]string{"encoders.yaml": encodersYAML, "catalog.json": catalogJSON})...)
+		require.NoError(t, err)
+		require.Equal(t, []*EncoderFixture{{Name: "bitmovin", APIEndpoint: "https://api.bitmovin.com", InfoURL: "https://bitmovin.com"}}, fixtures.Encoders)
+		require.Equal(t, []*EncoderConfigFixture{{Name: "default", Encoder: "bitmovin", Config: "{}"}}, fixtures.EncoderConfigs)
+		require.Len(t, fixtures.Profiles, 2)
+		require.Equal(t, []*TargetFixture{{TargetType: "s3", Path: "s3://bucket/prefix", AuthKey: "key"}}, fixtures.Targets)
+	})
+	t.Run("Should reject entries defined twice", func(t *testing.T) {
+		_, err := LoadFixtures(writeFixtures(t, map[string]string{"a.yml": encodersYAML, "b.yml": encodersYAML})...)
+		require.EqualError(t, err, `encoder "bitmovin" is defined more than once`)
+	})
+	t.Run("Should reject invalid profiles and targets", func(t *testing.T) {
+		_, err := LoadFixtures(writeFixtures(t, map[string]string{"a.yaml": "profiles:\n  - name: a\n    codec: mpeg2\n    package_format: hls\n"})...)
+		validationError, ok := validation.As(err)
+		require.True(t, ok)
+		require.Equal(t, validation.Unsupported, validationError.Field("Codec").Reason)
+		require.Contains(t, err.Error(), `profile "a"`)
+
+		_, err = LoadFixtures(writeFixtures(t, map[string]string{"a.yaml": "targets:\n  - target_type: s3\n    path: bucket/prefix\n"})...)
+		validationError, ok = validation.As(err)
+		require.True(t, ok)
+		require.Equal(t, validation.Malformed, validationError.Field("Path").Reason)
+	})
+	t.Run("Should reject unknown fields and extensions", func(t *testing.T) {
+		_, err := LoadFixtures(writeFixtures(t, map[string]string{"a.yaml": "profiles:\n  - name: a\n    codek: h264\n"})...)
+		require.Error(t, err)
+		_, err = LoadFixtures(writeFixtures(t, map[string]string{"a.toml": ""})...)
+		require.Error(t, err)
+	})
+}
+
+func TestSeed(t *testing.T) {
+	keys, err := secrets.NewLocalKeyProvider([]byte(strings.Repeat("k", 32)))
+	require.NoError(t, err)
+	newFixtures := func(t *testing.T) *Fixtures {
+		fixtures, err := LoadFixtures(writeFixtures(t, map[string]string{"encoders.yaml": encodersYAML, "catalog.json": catalogJSON})...)
+		require.NoError(t, err)
+		return fixtures
+	}
+
+	t.Run("Should create every entry and then be idempotent", func(t *testing.T) {
+		database := repositorytest.OpenSQLite(t)
+		fixtures := newFixtures(t)
+
+		result, err := Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.NoError(t, err)
+		require.Equal(t, 5, result.Count(SeedCreated))
+
+		var profile gormmodel.Profile
+		require.NoError(t, database.Preload("EncConfig.Encoder").First(&profile, "name = ?", "h264-hls").Error)
+		require.Equal(t, "bitmovin", profile.EncConfig.Encoder.Name)
+
+		result, err = Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.NoError(t, err)
+		require.Equal(t, 5, result.Count(SeedUnchanged))
+	})
+	t.Run("Should update entries that changed", func(t *testing.T) {
+		database := repositorytest.OpenSQLite(t)
+		fixtures := newFixtures(t)
+		_, err := Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.NoError(t, err)
+
+		fixtures.Profiles[0].Codec = "av1"
+		fixtures.Targets[0].AuthKey = ""
+		result, err := Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.NoError(t, err)
+		require.Equal(t, 2, result.Count(SeedUpdated))
+
+		var target gormmodel.Target
+		require.NoError(t, database.First(&target).Error)
+		require.Equal(t, "", target.AuthKey)
+
+		var revisions []*gormmodel.ProfileRevision
+		require.NoError(t, database.Order("revision").Find(&revisions, "profile_id = ?", 1).Error)
+		require.Len(t, revisions, 2)
+		require.Equal(t, "h264", revisions[0].Snapshot.Codec)
+		require.Equal(t, "av1", revisions[1].Snapshot.Codec)
+		require.Equal(t, "bitmovin", revisions[1].Snapshot.EncConfig.Encoder.Name)
+	})
+	t.Run("Should only prune when asked", func(t *testing.T) {
+		database := repositorytest.OpenSQLite(t)
+		fixtures := newFixtures(t)
+		_, err := Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.NoError(t, err)
+
+		fixtures.Profiles = fixtures.Profiles[:1]
+		fixtures.Targets = nil
+		result, err := Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.NoError(t, err)
+		require.Zero(t, result.Count(SeedPruned))
+
+		result, err = Seed(database, fixtures, &SeedOptions{Prune: true, Keys: keys})
+		require.NoError(t, err)
+		require.Equal(t, []*SeedChange{
+			{Kind: "target", Name: "s3://bucket/prefix", Action: SeedPruned},
+			{Kind: "profile", Name: "h265-dash", Action: SeedPruned},
+		}, result.Changes[len(result.Changes)-2:])
+		count := 0
+		require.NoError(t, database.Model(&gormmodel.ProfileRecord{}).Count(&count).Error)
+		require.Equal(t, 1, count)
+		require.NoError(t, database.Unscoped().Model(&gormmodel.ProfileRecord{}).Count(&count).Error)
+		require.Equal(t, 2, count, "Pruned profiles are soft deleted")
+		require.NoError(t, database.Model(&gormmodel.ProfileRevision{}).Count(&count).Error)
+		require.Equal(t, 2, count, "The revisions of pruned profiles are kept")
+	})
+	t.Run("Should keep the encoder configs and encoders pruned profiles reference", func(t *testing.T) {
+		database := repositorytest.OpenSQLite(t)
+		fixtures := newFixtures(t)
+		_, err := Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.NoError(t, err)
+
+		result, err := Seed(database, &Fixtures{}, &SeedOptions{Prune: true, Keys: keys})
+		require.NoError(t, err)
+		require.Equal(t, 3, result.Count(SeedPruned), "Only the target and the profiles are pruned")
+		count := 0
+		require.NoError(t, database.Model(&gormmodel.EncoderConfig{}).Count(&count).Error)
+		require.Equal(t, 1, count)
+		require.NoError(t, database.Model(&gormmodel.Encoder{}).Count(&count).Error)
+		require.Equal(t, 1, count)
+
+		result, err = Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.NoError(t, err)
+		require.Equal(t, 3, result.Count(SeedRestored))
+		require.Zero(t, result.Count(SeedCreated))
+		var profile gormmodel.Profile
+		require.NoError(t, database.Preload("EncConfig.Encoder").First(&profile, "name = ?", "h265-dash").Error)
+		require.Equal(t, "default", profile.EncConfig.Name, "The restored profile finds its encoder config")
+		require.Equal(t, "bitmovin", profile.EncConfig.Encoder.Name)
+
+		require.NoError(t, database.Exec("DELETE FROM profiles").Error)
+		result, err = Seed(database, &Fixtures{Targets: fixtures.Targets}, &SeedOptions{Prune: true, Keys: keys})
+		require.NoError(t, err)
+		require.Equal(t, []*SeedChange{
+			{Kind: "target", Name: "s3://bucket/prefix", Action: SeedUnchanged},
+			{Kind: "encoder config", Name: "default", Action: SeedPruned},
+			{Kind: "encoder", Name: "bitmovin", Action: SeedPruned},
+		}, result.Changes, "Once no profile references them they are pruned")
+	})
+	t.Run("Should restore the soft deleted entries it matches", func(t *testing.T) {
+		database := repositorytest.OpenSQLite(t)
+		fixtures := newFixtures(t)
+		_, err := Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.NoError(t, err)
+		now := time.Now()
+		require.NoError(t, database.Model(&gormmodel.ProfileRecord{}).Where("name = ?", "h265-dash").Update("deleted_at", &now).Error)
+		require.NoError(t, database.Model(&gormmodel.TargetRecord{}).Update("deleted_at", &now).Error)
+
+		fixtures.Profiles[1].Codec = "av1"
+		result, err := Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.NoError(t, err)
+		require.Equal(t, 2, result.Count(SeedRestored))
+		require.Zero(t, result.Count(SeedCreated))
+		var profile gormmodel.ProfileRecord
+		require.NoError(t, database.First(&profile, "name = ?", "h265-dash").Error)
+		require.Equal(t, "av1", profile.Codec)
+		require.Equal(t, 2, profile.Revision)
+		count := 0
+		require.NoError(t, database.Model(&gormmodel.TargetRecord{}).Count(&count).Error)
+		require.Equal(t, 1, count)
+	})
+	t.Run("Should seal the auth keys", func(t *testing.T) {
+		database := repositorytest.OpenSQLite(t)
+		fixtures := newFixtures(t)
+		_, err := Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.NoError(t, err)
+
+		var target gormmodel.Target
+		require.NoError(t, database.First(&target).Error)
+		require.True(t, secrets.IsSealed(target.AuthKey))
+		authKey, err := secrets.Open(keys, target.AuthKey)
+		require.NoError(t, err)
+		require.Equal(t, "key", authKey)
+
+		fixtures.Targets[0].AuthKey = "rotated"
+		result, err := Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.NoError(t, err)
+		require.Equal(t, 1, result.Count(SeedUpdated))
+
+		require.NoError(t, database.Model(&target).Update("auth_key", "rotated").Error)
+		result, err = Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.NoError(t, err)
+		require.Equal(t, 1, result.Count(SeedUpdated), "A plaintext auth key is sealed")
+		require.NoError(t, database.First(&target).Error)
+		require.True(t, secrets.IsSealed(target.AuthKey))
+
+		_, err = Seed(repositorytest.OpenSQLite(t), fixtures, nil)
+		require.Equal(t, secrets.ErrNoKeyProvider, errors.Cause(err))
+	})
+	t.Run("Should roll back a dry run", func(t *testing.T) {
+		database := repositorytest.OpenSQLite(t)
+
+		result, err := Seed(database, newFixtures(t), &SeedOptions{DryRun: true, Keys: keys})
+		require.NoError(t, err)
+		require.Equal(t, 5, result.Count(SeedCreated))
+		count := 0
+		require.NoError(t, database.Model(&gormmodel.Profile{}).Count(&count).Error)
+		require.Zero(t, count)
+	})
+	t.Run("Should fail on unknown references", func(t *testing.T) {
+		database := repositorytest.OpenSQLite(t)
+		fixtures := newFixtures(t)
+		fixtures.Profiles[0].EncoderConfig = "missing"
+
+		_, err := Seed(database, fixtures, &SeedOptions{Keys: keys})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `profile "h264-hls" references unknown encoder config "missing"`)
+		count := 0
+		require.NoError(t, database.Model(&gormmodel.Encoder{}).Count(&count).Error)
+		require.Zero(t, count, "The whole seed is rolled back")
+	})
+}
@@ -9,7 +9,9 @@ require (
 	github.com/bitmovin/bitmovin-api-sdk-go v1.40.0-alpha.0
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/mux v1.7.4
-	github.com/jinzhu/gorm v1.9.12
+	github.com/jinzhu/gorm v1.9.16
+	github.com/lib/pq v1.1.1
+	github.com/mattn/go-sqlite3 v1.14.32
 	github.com/pkg/errors v0.9.1
 	github.com/pulumi/pulumi-aws/sdk v1.30.0
 	github.com/pulumi/pulumi-datadog/sdk v0.0.0-20200407184111-6718f9ca24ef
@@ -19,4 +21,5 @@ require (
 	github.com/selvatico/go-mocket v1.0.7
 	github.com/stretchr/testify v1.5.1
 	github.com/xeipuuv/gojsonschema v1.2.0
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -98,3 +98,42 @@ func DebugWithFields(message string, fields map[string]interface{}) {
 	log := logger().With().Fields(fields).Logger()
 	log.Debug().Msg(message)
 }
+
+// FieldLogger logs the standard messages along with a fixed set of fields, such as a routing.LoggingContext.
+type FieldLogger struct {
+	fields map[string]interface{}
+}
+
+// WithFields returns a FieldLogger adding fields to every message.
+func WithFields(fields map[string]interface{}) *FieldLogger {
+	return &FieldLogger{fields: fields}
+}
+
+func (fieldLogger *FieldLogger) logger() zerolog.Logger {
+	return logger().With().Fields(fieldLogger.fields).Logger()
+}
+
+func (fieldLogger *FieldLogger) Error(err error, message string) {
+	log := fieldLogger.logger()
+	log.Error().Msgf("%v: %+v", message, err)
+}
+
+func (fieldLogger *FieldLogger) Errorf(msgFormat string, v ...interface{}) {
+	log := fieldLogger.logger()
+	log.Error().Msgf(msgFormat, v...)
+}
+
+func (fieldLogger *FieldLogger) Infof(msgFormat string, v ...interface{}) {
+	log := fieldLogger.logger()
+	log.Info().Msgf(msgFormat, v...)
+}
+
+func (fieldLogger *FieldLogger) Warnf(msgFormat string, v ...interface{}) {
+	log := fieldLogger.logger()
+	log.Warn().Msgf(msgFormat, v...)
+}
+
+func (fieldLogger *FieldLogger) Debugf(msgFormat string, v ...interface{}) {
+	log := fieldLogger.logger()
+	log.Debug().Msgf(msgFormat, v...)
+}
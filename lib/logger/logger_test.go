@@ -1,8 +1,10 @@
 package logger
 
 import (
+	"bytes"
 	stderrors "errors"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/EurosportDigital/global-transcoding-platform/lib/logger/mocks"
@@ -94,3 +96,25 @@ func TestLoggingWithFields(t *testing.T) {
 
 	mockZerolog.AssertExpectations(t)
 }
+
+func TestFieldLogger(t *testing.T) {
+	output := &bytes.Buffer{}
+	baseLogger := zerolog.New(output)
+	SetLogger(&baseLogger)
+	defer SetLogger(nil)
+
+	fieldLogger := WithFields(map[string]interface{}{"request_id": "abc"})
+	fieldLogger.Infof("Getting job %d", 1)
+	fieldLogger.Warnf(message)
+	fieldLogger.Error(stderrors.New("some error"), message)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 log lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, `"request_id":"abc"`) {
+			t.Errorf("expected the fields in %s", line)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Package audit records who created, updated or deleted the jobs, profiles and targets, and lists those records.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/db"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/routing"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/jinzhu/gorm"
+)
+
+// The entity types of the audited repositories.
+const (
+	EntityJob     = "job"
+	EntityProfile = "profile"
+	EntityTarget  = "target"
+)
+
+// UnknownActor is recorded for the mutations made with a context that carries no actor, see routing.WithActor.
+const UnknownActor = "unknown"
+
+// redacted replaces the values of secret fields, so that the entry shows they changed but not what they hold.
+var redacted = json.RawMessage(`"[redacted]"`)
+
+// Change describes a mutation of one entity.
+type Change struct {
+	EntityType string
+	EntityID   int
+	Action     model.AuditAction
+	// Before and After are the entity before and after the mutation, nil when it did not exist.
+	Before interface{}
+	After  interface{}
+	// Secrets lists the fields whose values are redacted from the entry.
+	Secrets []string
+}
+
+// Record saves the audit entry of change, made by the actor of ctx, with tx so that the entry is committed or rolled
+// back along with the change itself.
+func Record(ctx context.Context, tx *gorm.DB, change *Change) error {
+	entry, err := entryOf(ctx, change)
+	if err != nil {
+		return err
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return errors.Wrapf(err, "recording the %s of %s %v", change.Action, change.EntityType, change.EntityID)
+	}
+	return nil
+}
+
+// RecordMany saves the audit entries of changes like Record, with one multi-row statement.
+func RecordMany(ctx context.Context, tx *gorm.DB, changes []*Change) error {
+	entries := make([]interface{}, len(changes))
+	for i, change := range changes {
+		entry, err := entryOf(ctx, change)
+		if err != nil {
+			return err
+		}
+		entries[i] = entry
+	}
+	if err := repository.InsertMany(tx, entries...); err != nil {
+		return errors.Wrapf(err, "recording %d changes", len(changes))
+	}
+	return nil
+}
+
+func entryOf(ctx context.Context, change *Change) (*gormmodel.AuditEntry, error) {
+	changes, err := Diff(change.Before, change.After, change.Secrets...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "auditing the %s of %s %v", change.Action, change.EntityType, change.EntityID)
+	}
+	actor := routing.GetActor(ctx)
+	if actor == "" {
+		actor = UnknownActor
+	}
+	return gormmodel.ToGormAuditEntry(&model.AuditEntry{
+		Actor: actor, EntityType: change.EntityType, EntityID: change.EntityID, Action: change.Action, Changes: changes,
+	}), nil
+}
+
+// Diff compares the JSON encoding of before and after field by field, and returns the fields that differ.
+// Either may be nil, then every field of the other one is returned. The values of the secrets fields are redacted.
+func Diff(before interface{}, after interface{}, secrets ...string) (map[string]model.FieldChange, error) {
+	beforeFields, err := fieldsOf(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := fieldsOf(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]model.FieldChange{}
+	for field, value := range beforeFields {
+		if !bytes.Equal(value, afterFields[field]) {
+			changes[field] = model.FieldChange{Before: value, After: afterFields[field]}
+		}
+	}
+	for field, value := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			changes[field] = model.FieldChange{After: value}
+		}
+	}
+	for _, secret := range secrets {
+		if change, ok := changes[secret]; ok {
+			changes[secret] = model.FieldChange{Before: redact(change.Before), After: redact(change.After)}
+		}
+	}
+	return changes, nil
+}
+
+func fieldsOf(entity interface{}) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if entity == nil {
+		return fields, nil
+	}
+	encoded, err := json.Marshal(entity)
+	if err != nil {
+		return nil, errors.Wrapf(err, "encoding %T", entity)
+	}
+	// A nil pointer, as in a Change of a *model.Target without Before.
+	if bytes.Equal(encoded, []byte("null")) {
+		return fields, nil
+	}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, errors.Wrapf(err, "decoding the fields of %T", entity)
+	}
+	return fields, nil
+}
+
+func redact(value json.RawMessage) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	return redacted
+}
+
+// Filter selects the entries matching every condition that is set.
+type Filter struct {
+	EntityType string
+	// EntityID is only meaningful along with EntityType.
+	EntityID *int
+	Actor    string
+	Action   model.AuditAction
+	// Since and Until bound the time of the entries, Since inclusively and Until exclusively.
+	Since *time.Time
+	Until *time.Time
+}
+
+// Repository lists the audit entries.
+type Repository interface {
+	// Query lists the entries matching filter, newest first. At most limit entries are returned when limit is positive.
+	Query(filter *Filter, limit int) ([]*model.AuditEntry, error)
+
+	// History lists the entries of one entity, oldest first.
+	History(entityType string, entityID int) ([]*model.AuditEntry, error)
+}
+
+type gormRepository struct {
+	resolver db.Resolver
+}
+
+// New constructs a new instance of the audit repository.
+func New(database *gorm.DB) Repository {
+	return NewWithResolver(db.NewCluster(database))
+}
+
+// NewWithResolver constructs an audit repository that reads from the resolver's replicas.
+func NewWithResolver(resolver db.Resolver) Repository {
+	return &gormRepository{resolver: resolver}
+}
+
+func (auditRepo *gormRepository) Query(filter *Filter, limit int) ([]*model.AuditEntry, error) {
+	query := addFilters(auditRepo.resolver.Replica(), filter).Order("created_at DESC, id DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	return find(query, "querying the audit entries")
+}
+
+func (auditRepo *gormRepository) History(entityType string, entityID int) ([]*model.AuditEntry, error) {
+	query := auditRepo.resolver.Replica().Where("entity_type = ? AND entity_id = ?", entityType, entityID).Order("created_at, id")
+	return find(query, "getting the audit history of "+entityType)
+}
+
+func find(query *gorm.DB, action string) ([]*model.AuditEntry, error) {
+	var entries []*gormmodel.AuditEntry
+	if err := query.Find(&entries).Error; err != nil {
+		return nil, errors.Wrap(err, action)
+	}
+	modelEntries := make([]*model.AuditEntry, len(entries))
+	for i := range entries {
+		modelEntries[i] = gormmodel.ToAuditEntry(entries[i])
+	}
+	return modelEntries, nil
+}
+
+func addFilters(query *gorm.DB, filter *Filter) *gorm.DB {
+	if filter == nil {
+		return query
+	}
+	if filter.EntityType != "" {
+		query = query.Where("entity_type = ?", filter.EntityType)
+	}
+	if filter.EntityID != nil {
+		query = query.Where("entity_id = ?", *filter.EntityID)
+	}
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", filter.Until.UTC())
+	}
+	return query
+}
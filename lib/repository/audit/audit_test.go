@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/routing"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	before := &model.Target{ID: 1, TargetType: "s3", Path: "s3://bucket/a", AuthKey: "old"}
+
+	t.Run("Should only return the changed fields", func(t *testing.T) {
+		after := *before
+		after.Path = "s3://bucket/b"
+		changes, err := Diff(before, &after)
+		require.NoError(t, err)
+		require.Equal(t, map[string]model.FieldChange{
+			"Path": {Before: json.RawMessage(`"s3://bucket/a"`), After: json.RawMessage(`"s3://bucket/b"`)},
+		}, changes)
+	})
+	t.Run("Should return every field of a created or deleted entity", func(t *testing.T) {
+		changes, err := Diff(nil, before)
+		require.NoError(t, err)
+		require.Len(t, changes, 4)
+		require.Equal(t, model.FieldChange{After: json.RawMessage(`1`)}, changes["ID"])
+
+		var missing *model.Target
+		changes, err = Diff(before, missing)
+		require.NoError(t, err)
+		require.Len(t, changes, 4)
+		require.Equal(t, model.FieldChange{Before: json.RawMessage(`1`)}, changes["ID"])
+	})
+	t.Run("Should redact the values of secrets", func(t *testing.T) {
+		after := *before
+		after.AuthKey = "new"
+		changes, err := Diff(before, &after, "AuthKey")
+		require.NoError(t, err)
+		require.Equal(t, map[string]model.FieldChange{"AuthKey": {Before: redacted, After: redacted}}, changes)
+
+		changes, err = Diff(nil, &after, "AuthKey")
+		require.NoError(t, err)
+		require.Equal(t, model.FieldChange{After: redacted}, changes["AuthKey"])
+	})
+}
+
+func TestAuditOnSQLite(t *testing.T) {
+	database := repositorytest.OpenSQLite(t)
+	auditRepo := New(database)
+	ctx := routing.WithActor(context.Background(), "alice@example.com")
+	profile := &model.Profile{ID: 1, Name: "h264-hls", Codec: "h264"}
+	updated := &model.Profile{ID: 1, Name: "h264-hls", Codec: "av1"}
+	require.NoError(t, Record(ctx, database, &Change{EntityType: EntityProfile, EntityID: 1, Action: model.AuditCreate, After: profile}))
+	require.NoError(t, Record(ctx, database, &Change{EntityType: EntityProfile, EntityID: 1, Action: model.AuditUpdate, Before: profile, After: updated}))
+	require.NoError(t, Record(context.Background(), database, &Change{EntityType: EntityTarget, EntityID: 1, Action: model.AuditDelete}))
+
+	t.Run("Should list the history of an entity, oldest first", func(t *testing.T) {
+		history, err := auditRepo.History(EntityProfile, 1)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		require.Equal(t, model.AuditCreate, history[0].Action)
+		require.Equal(t, "alice@example.com", history[1].Actor)
+		require.False(t, history[1].At.IsZero())
+		require.Equal(t, map[string]model.FieldChange{
+			"Codec": {Before: json.RawMessage(`"h264"`), After: json.RawMessage(`"av1"`)},
+		}, history[1].Changes)
+	})
+	t.Run("Should query the entries matching the filter, newest first", func(t *testing.T) {
+		entries, err := auditRepo.Query(&Filter{Actor: "alice@example.com"}, 0)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.Equal(t, model.AuditUpdate, entries[0].Action)
+
+		entries, err = auditRepo.Query(&Filter{Actor: UnknownActor}, 0)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, EntityTarget, entries[0].EntityType)
+
+		id := 1
+		entries, err = auditRepo.Query(&Filter{EntityType: EntityProfile, EntityID: &id, Action: model.AuditUpdate}, 0)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+
+		entries, err = auditRepo.Query(nil, 1)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+
+		since := time.Now().Add(time.Hour)
+		entries, err = auditRepo.Query(&Filter{Since: &since}, 0)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+	t.Run("Should record many changes at once", func(t *testing.T) {
+		require.NoError(t, RecordMany(ctx, database, []*Change{
+			{EntityType: EntityTarget, EntityID: 2, Action: model.AuditCreate, After: &model.Target{ID: 2, AuthKey: "secret"}, Secrets: []string{"AuthKey"}},
+			{EntityType: EntityTarget, EntityID: 3, Action: model.AuditCreate, After: &model.Target{ID: 3}},
+		}))
+		history, err := auditRepo.History(EntityTarget, 2)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		require.Equal(t, "alice@example.com", history[0].Actor)
+		require.False(t, history[0].At.IsZero())
+		require.Equal(t, json.RawMessage(`"[redacted]"`), history[0].Changes["AuthKey"].After)
+
+		history, err = auditRepo.History(EntityTarget, 3)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+	})
+}
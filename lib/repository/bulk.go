@@ -0,0 +1,321 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/jinzhu/gorm"
+)
+
+// BulkMode chooses what a bulk operation does with its other items when some of them fail.
+type BulkMode int
+
+const (
+	// AllOrNothing rolls back every item of the operation as soon as one of them fails.
+	AllOrNothing BulkMode = iota
+	// PartialSuccess commits the items that succeed and reports the ones that fail.
+	PartialSuccess
+)
+
+func (mode BulkMode) String() string {
+	if mode == PartialSuccess {
+		return "partial success"
+	}
+	return "all or nothing"
+}
+
+// BulkResult is the outcome of one item of a bulk operation.
+type BulkResult struct {
+	// ID is the ID of the item, assigned by the database when it is created. It stays 0 when a create fails.
+	ID int
+	// Err is nil when the item was stored, and ErrRolledBack when it was rolled back because others failed.
+	Err error
+}
+
+// BulkResults are the outcomes of the items of a bulk operation, in the order of the items.
+type BulkResults []BulkResult
+
+// Failed returns the indexes of the items that were not stored.
+func (results BulkResults) Failed() []int {
+	var failed []int
+	for i, result := range results {
+		if result.Err != nil {
+			failed = append(failed, i)
+		}
+	}
+	return failed
+}
+
+// BulkOperation tracks the items of one bulk operation of a repository: which ones failed, and whether the
+// operation has to stop, according to its mode.
+type BulkOperation struct {
+	mode    BulkMode
+	results BulkResults
+}
+
+// NewBulkOperation starts tracking a bulk operation of size items.
+func NewBulkOperation(mode BulkMode, size int) *BulkOperation {
+	return &BulkOperation{mode: mode, results: make(BulkResults, size)}
+}
+
+// Fail records the failure of item i. It returns the error that stops the operation, see Err.
+func (operation *BulkOperation) Fail(i int, err error) error {
+	if operation.results[i].Err == nil {
+		operation.results[i].Err = err
+	}
+	return operation.Err()
+}
+
+// Succeeded returns the indexes of the items that did not fail so far.
+func (operation *BulkOperation) Succeeded() []int {
+	var succeeded []int
+	for i, result := range operation.results {
+		if result.Err == nil {
+			succeeded = append(succeeded, i)
+		}
+	}
+	return succeeded
+}
+
+// SetID records the ID of item i.
+func (operation *BulkOperation) SetID(i int, id int) {
+	operation.results[i].ID = id
+}
+
+// FailDuplicates fails the items whose ID was already listed by an earlier item, which would otherwise be
+// changed twice.
+func (operation *BulkOperation) FailDuplicates(ids []int) {
+	listed := make(map[int]bool, len(ids))
+	for i, id := range ids {
+		if listed[id] {
+			operation.Fail(i, errors.Errorf("ID %v is listed more than once", id))
+		}
+		listed[id] = true
+	}
+}
+
+// Err returns the error that stops the operation: the first failure of an AllOrNothing operation, nil otherwise.
+func (operation *BulkOperation) Err() error {
+	if operation.mode != AllOrNothing {
+		return nil
+	}
+	for i, result := range operation.results {
+		if result.Err != nil {
+			return errors.WithMessagef(result.Err, "rolled back the %d items as item %d failed", len(operation.results), i)
+		}
+	}
+	return nil
+}
+
+// Item runs fn for item i, and records its failure. With PartialSuccess fn runs within a Savepoint, so that its
+// failure leaves the rest of the transaction usable. It returns the error that stops the operation, see Err.
+func (operation *BulkOperation) Item(tx *gorm.DB, i int, fn func(tx *gorm.DB) error) error {
+	run := fn
+	if operation.mode == PartialSuccess {
+		run = func(tx *gorm.DB) error {
+			return Savepoint(tx, "bulk_item", fn)
+		}
+	}
+	if err := run(tx); err != nil {
+		return operation.Fail(i, err)
+	}
+	return nil
+}
+
+// Insert inserts the records of items with InsertMany, records[j] being the one of item items[j].
+// With PartialSuccess, when InsertMany fails, the records are inserted one at a time instead, each as an Item,
+// so that only the failing ones fail. It returns the error that stops the operation, see Err.
+func (operation *BulkOperation) Insert(tx *gorm.DB, items []int, records []interface{}) error {
+	if len(records) == 0 {
+		return nil
+	}
+	insertAll := func(tx *gorm.DB) error {
+		return InsertMany(tx, records...)
+	}
+	if operation.mode == AllOrNothing {
+		if err := insertAll(tx); err != nil {
+			for _, i := range items {
+				operation.Fail(i, err)
+			}
+		}
+		return operation.Err()
+	}
+	if err := Savepoint(tx, "bulk_insert", insertAll); err == nil {
+		return nil
+	}
+	for j, record := range records {
+		record := record
+		operation.Item(tx, items[j], func(tx *gorm.DB) error {
+			return InsertMany(tx, record)
+		})
+	}
+	return nil
+}
+
+// Run runs fn in a transaction of db, see TransactionContext, unless the operation already has to stop, and returns
+// the results of the items. The transaction is rolled back when fn fails or the operation has to stop, and the items
+// that did not fail then fail with ErrRolledBack.
+func (operation *BulkOperation) Run(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error) (BulkResults, error) {
+	err := operation.Err()
+	if err == nil && len(operation.Succeeded()) > 0 {
+		err = TransactionContext(ctx, db, func(tx *gorm.DB) error {
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return operation.Err()
+		})
+	}
+	if err != nil {
+		for i := range operation.results {
+			if operation.results[i].Err == nil {
+				operation.results[i] = BulkResult{Err: ErrRolledBack}
+			}
+		}
+	}
+	return operation.results, err
+}
+
+// Savepoint runs fn within a savepoint of the transaction tx, rolled back to when fn fails, so that the transaction
+// can go on. Postgres refuses any statement of a transaction after a failed one otherwise.
+func Savepoint(tx *gorm.DB, name string, fn func(tx *gorm.DB) error) error {
+	if err := tx.Exec("SAVEPOINT " + name).Error; err != nil {
+		return errors.Wrapf(err, "unable to create savepoint %s", name)
+	}
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.Exec("ROLLBACK TO SAVEPOINT " + name).Error; rollbackErr != nil {
+			return errors.Wrapf(rollbackErr, "unable to roll back to savepoint %s after %v", name, err)
+		}
+		return err
+	}
+	if err := tx.Exec("RELEASE SAVEPOINT " + name).Error; err != nil {
+		return errors.Wrapf(err, "unable to release savepoint %s", name)
+	}
+	return nil
+}
+
+// InsertBatchSize is the number of rows InsertMany inserts per statement, fewer when the rows have so many columns
+// that a statement would take more than the 65535 bind parameters of Postgres.
+var InsertBatchSize = 500
+
+// maxBindParameters is the number of bind parameters a Postgres statement takes at most.
+const maxBindParameters = 65535
+
+// InsertMany inserts records, pointers to models of the same table, with multi-row statements of InsertBatchSize
+// rows, and sets their IDs. Like gorm's Create, it sets their blank CreatedAt and UpdatedAt fields, and leaves the
+// blank fields with a default value to the database, unless some record sets them.
+func InsertMany(db *gorm.DB, records ...interface{}) error {
+	if len(records) == 0 {
+		return nil
+	}
+	now := gorm.NowFunc()
+	scopes := make([]*gorm.Scope, len(records))
+	for i, record := range records {
+		scopes[i] = db.NewScope(record)
+		for _, name := range []string{"CreatedAt", "UpdatedAt"} {
+			if field, ok := scopes[i].FieldByName(name); ok && field.IsBlank {
+				if err := field.Set(now); err != nil {
+					return errors.Wrapf(err, "unable to set %s", name)
+				}
+			}
+		}
+	}
+
+	table := scopes[0].TableName()
+	var fieldNames []string
+	for _, field := range scopes[0].Fields() {
+		if field.IsIgnored || !field.IsNormal || field.IsPrimaryKey || (field.HasDefaultValue && allBlank(scopes, field.Name)) {
+			continue
+		}
+		fieldNames = append(fieldNames, field.Name)
+	}
+	// The ID may take a column too, see insertBatch.
+	size := InsertBatchSize
+	if limit := maxBindParameters / (len(fieldNames) + 1); size > limit {
+		size = limit
+	}
+	for start := 0; start < len(scopes); start += size {
+		end := start + size
+		if end > len(scopes) {
+			end = len(scopes)
+		}
+		if err := insertBatch(db, scopes[start:end], fieldNames); err != nil {
+			return errors.Wrapf(err, "unable to insert %d %s", len(records), table)
+		}
+	}
+	return nil
+}
+
+// insertBatch inserts the rows of scopes with one statement and sets their IDs. When the database has sequences,
+// the IDs are drawn before the statement and inserted along with the rows, so that every row is matched with its own
+// ID whatever the order the database inserts them in.
+func insertBatch(db *gorm.DB, scopes []*gorm.Scope, fieldNames []string) error {
+	primary := scopes[0].PrimaryField()
+	nextIDs := DialectOf(db).NextIDs(scopes[0].TableName(), primary.DBName)
+	if nextIDs != "" {
+		var ids []int
+		if err := db.Raw(nextIDs, len(scopes)).Pluck(primary.DBName, &ids).Error; err != nil {
+			return errors.Wrap(err, "unable to draw the IDs")
+		}
+		if len(ids) != len(scopes) {
+			return errors.Errorf("drew %d IDs, not %d", len(ids), len(scopes))
+		}
+		if err := setIDs(scopes, ids); err != nil {
+			return err
+		}
+		fieldNames = append([]string{primary.Name}, fieldNames...)
+	}
+
+	columns := make([]string, len(fieldNames))
+	for i, name := range fieldNames {
+		field, _ := scopes[0].FieldByName(name)
+		columns[i] = scopes[0].Quote(field.DBName)
+	}
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	rows := make([]string, len(scopes))
+	var values []interface{}
+	for i, scope := range scopes {
+		rows[i] = placeholders
+		for _, name := range fieldNames {
+			field, _ := scope.FieldByName(name)
+			values = append(values, field.Field.Interface())
+		}
+	}
+	statement := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", scopes[0].QuotedTableName(), strings.Join(columns, ", "), strings.Join(rows, ", "))
+	if err := db.Exec(statement, values...).Error; err != nil {
+		return err
+	}
+	if nextIDs != "" {
+		return nil
+	}
+
+	// Without sequences, as on SQLite, whose writers are serialized, the rows take consecutive IDs up to the last one.
+	var last int
+	if err := db.Raw("SELECT last_insert_rowid()").Row().Scan(&last); err != nil {
+		return errors.Wrap(err, "unable to read the last ID")
+	}
+	ids := make([]int, len(scopes))
+	for i := range ids {
+		ids[i] = last - len(scopes) + 1 + i
+	}
+	return setIDs(scopes, ids)
+}
+
+func setIDs(scopes []*gorm.Scope, ids []int) error {
+	for i, scope := range scopes {
+		if err := scope.PrimaryField().Set(ids[i]); err != nil {
+			return errors.Wrapf(err, "unable to set the ID of %s %v", scope.TableName(), ids[i])
+		}
+	}
+	return nil
+}
+
+func allBlank(scopes []*gorm.Scope, name string) bool {
+	for _, scope := range scopes {
+		if field, ok := scope.FieldByName(name); ok && !field.IsBlank {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/jinzhu/gorm"
+	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/require"
+)
+
+type bulkItem struct {
+	ID   int
+	Name string `gorm:"unique_index"`
+}
+
+func TestInsertMany(t *testing.T) {
+	db := repositorytest.OpenSQLite(t)
+
+	t.Run("Should insert the records and set their IDs", func(t *testing.T) {
+		first := &gormmodel.TargetRecord{Target: gormmodel.Target{TargetType: "s3", Path: "s3://bucket/a"}}
+		second := &gormmodel.TargetRecord{Target: gormmodel.Target{TargetType: "s3", Path: "s3://bucket/b"}}
+		require.NoError(t, InsertMany(db, first, second))
+		require.NotZero(t, first.ID)
+		require.Equal(t, first.ID+1, second.ID)
+
+		var records []*gormmodel.TargetRecord
+		require.NoError(t, db.Order("id").Find(&records).Error)
+		require.Equal(t, []*gormmodel.TargetRecord{first, second}, records)
+	})
+	t.Run("Should set the timestamps and leave the defaults to the database", func(t *testing.T) {
+		entry := &gormmodel.AuditEntry{Actor: "test", EntityType: "target", EntityID: 1, Action: "create"}
+		require.NoError(t, InsertMany(db, entry))
+		require.False(t, entry.CreatedAt.IsZero())
+
+		profile := &gormmodel.ProfileRecord{Profile: gormmodel.Profile{Name: "h264-hls"}}
+		require.NoError(t, InsertMany(db, profile))
+		var stored gormmodel.ProfileRecord
+		require.NoError(t, db.First(&stored, profile.ID).Error)
+		require.Equal(t, 1, stored.Revision)
+	})
+	t.Run("Should insert the records in batches", func(t *testing.T) {
+		defer func(size int) { InsertBatchSize = size }(InsertBatchSize)
+		InsertBatchSize = 2
+		var records []interface{}
+		var targets []*gormmodel.TargetRecord
+		for _, path := range []string{"s3://bucket/c", "s3://bucket/d", "s3://bucket/e", "s3://bucket/f", "s3://bucket/g"} {
+			target := &gormmodel.TargetRecord{Target: gormmodel.Target{TargetType: "s3", Path: path}}
+			records = append(records, target)
+			targets = append(targets, target)
+		}
+		require.NoError(t, InsertMany(db, records...))
+
+		for _, target := range targets {
+			var stored gormmodel.TargetRecord
+			require.NoError(t, db.First(&stored, target.ID).Error)
+			require.Equal(t, target.Path, stored.Path)
+		}
+	})
+	t.Run("Should insert the IDs drawn from the sequence on Postgres", func(t *testing.T) {
+		mocket.Catcher.Register()
+		mocket.Catcher.Reset()
+		postgres, err := gorm.Open(mocket.DriverName, "")
+		require.NoError(t, err)
+		defer postgres.Close()
+		mocket.Catcher.NewMock().WithQuery(`SELECT nextval(pg_get_serial_sequence('targets', 'id')) AS id FROM generate_series(1, 2)`).
+			WithReply([]map[string]interface{}{{"id": 7}, {"id": 8}})
+		var inserted []interface{}
+		mocket.Catcher.NewMock().WithQuery(`INSERT INTO "targets" ("id", "target_type", "path", "auth_key", "deleted_at") VALUES (?, ?, ?, ?, ?), (?, ?, ?, ?, ?)`).
+			WithCallback(func(_ string, args []driver.NamedValue) {
+				for _, arg := range args {
+					inserted = append(inserted, arg.Value)
+				}
+			})
+
+		first := &gormmodel.TargetRecord{Target: gormmodel.Target{TargetType: "s3", Path: "s3://bucket/a"}}
+		second := &gormmodel.TargetRecord{Target: gormmodel.Target{TargetType: "s3", Path: "s3://bucket/b"}}
+		require.NoError(t, InsertMany(postgres, first, second))
+		require.Equal(t, []interface{}{int64(7), "s3", "s3://bucket/a", "", nil, int64(8), "s3", "s3://bucket/b", "", nil}, inserted)
+		require.Equal(t, 7, first.ID)
+		require.Equal(t, 8, second.ID)
+	})
+}
+
+func TestBulkOperation(t *testing.T) {
+	db := repositorytest.OpenSQLite(t)
+	require.NoError(t, db.CreateTable(&bulkItem{}).Error)
+	names := func() []string {
+		var names []string
+		require.NoError(t, db.Model(&bulkItem{}).Order("id").Pluck("name", &names).Error)
+		return names
+	}
+	failure := errors.New("failed")
+	create := func(operation *BulkOperation, tx *gorm.DB, items ...string) error {
+		for i, name := range items {
+			name := name
+			err := operation.Item(tx, i, func(tx *gorm.DB) error {
+				if err := tx.Create(&bulkItem{Name: name}).Error; err != nil {
+					return err
+				}
+				if name == "invalid" {
+					return failure
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	t.Run("Should roll back every item when one fails", func(t *testing.T) {
+		operation := NewBulkOperation(AllOrNothing, 3)
+		results, err := operation.Run(context.Background(), db, func(tx *gorm.DB) error {
+			return create(operation, tx, "a", "invalid", "b")
+		})
+		require.Equal(t, failure, errors.Cause(err))
+		require.Equal(t, BulkResults{{Err: ErrRolledBack}, {Err: failure}, {Err: ErrRolledBack}}, results)
+		require.Empty(t, names())
+	})
+	t.Run("Should commit the items that succeed", func(t *testing.T) {
+		operation := NewBulkOperation(PartialSuccess, 3)
+		results, err := operation.Run(context.Background(), db, func(tx *gorm.DB) error {
+			return create(operation, tx, "a", "invalid", "b")
+		})
+		require.NoError(t, err)
+		require.Equal(t, []int{1}, results.Failed())
+		require.Equal(t, []string{"a", "b"}, names(), "The failed item is rolled back to its savepoint")
+	})
+	t.Run("Should not begin a transaction once an item failed", func(t *testing.T) {
+		operation := NewBulkOperation(AllOrNothing, 2)
+		operation.Fail(1, failure)
+		results, err := operation.Run(context.Background(), db, func(tx *gorm.DB) error {
+			require.Fail(t, "The operation should have stopped")
+			return nil
+		})
+		require.Equal(t, failure, errors.Cause(err))
+		require.Equal(t, BulkResults{{Err: ErrRolledBack}, {Err: failure}}, results)
+	})
+	t.Run("Should fail the duplicate IDs", func(t *testing.T) {
+		operation := NewBulkOperation(PartialSuccess, 3)
+		operation.FailDuplicates([]int{1, 2, 1})
+		require.Equal(t, []int{0, 1}, operation.Succeeded())
+	})
+	t.Run("Should insert one at a time when the statement fails", func(t *testing.T) {
+		operation := NewBulkOperation(PartialSuccess, 3)
+		records := []interface{}{&bulkItem{Name: "c"}, &bulkItem{Name: "a"}, &bulkItem{Name: "d"}}
+		results, err := operation.Run(context.Background(), db, func(tx *gorm.DB) error {
+			return operation.Insert(tx, []int{0, 1, 2}, records)
+		})
+		require.NoError(t, err)
+		require.Equal(t, []int{1}, results.Failed())
+		require.Equal(t, []string{"a", "b", "c", "d"}, names())
+
+		operation = NewBulkOperation(AllOrNothing, 2)
+		results, err = operation.Run(context.Background(), db, func(tx *gorm.DB) error {
+			return operation.Insert(tx, []int{0, 1}, []interface{}{&bulkItem{Name: "e"}, &bulkItem{Name: "a"}})
+		})
+		require.Error(t, err)
+		require.Equal(t, []int{0, 1}, results.Failed())
+		require.Equal(t, []string{"a", "b", "c", "d"}, names())
+	})
+}
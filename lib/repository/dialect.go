@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Dialect provides the SQL fragments that differ between the databases the repositories run on.
+type Dialect interface {
+	// JSONText returns an expression selecting the field of the JSON object stored in column, as text.
+	JSONText(column string, field string) string
+
+	// Upsert returns the insert option that updates updateColumns of the existing row instead of failing
+	// when a row with the same conflictColumns already exists.
+	Upsert(conflictColumns []string, updateColumns []string) string
+
+	// ForUpdate returns the query option that locks the selected rows until the end of the transaction,
+	// skipping rows locked by other transactions when skipLocked is set. It is empty when the database
+	// has no row level locks.
+	ForUpdate(skipLocked bool) string
+
+	// NextIDs returns the query drawing the next values of the sequence of column of table, as many as its argument,
+	// in a column named column. It is empty when the database has no sequences, see InsertMany.
+	NextIDs(table string, column string) string
+
+	// StatementTimeout returns the query that makes the following statements of the transaction fail once they
+	// run longer than timeout. It is empty when the database has no such setting.
+	StatementTimeout(timeout time.Duration) string
+}
+
+// DialectOf returns the Dialect of the database behind db. Unknown databases are treated as Postgres.
+func DialectOf(db *gorm.DB) Dialect {
+	if db.Dialect().GetName() == "sqlite3" {
+		return sqliteDialect{}
+	}
+	return postgresDialect{}
+}
+
+// Upsert returns a copy of db whose Create updates updateColumns when a row with the same conflictColumns exists.
+func Upsert(db *gorm.DB, conflictColumns []string, updateColumns ...string) *gorm.DB {
+	return db.Set("gorm:insert_option", DialectOf(db).Upsert(conflictColumns, updateColumns))
+}
+
+// ForUpdate returns a copy of db whose queries lock the selected rows, see Dialect.ForUpdate.
+func ForUpdate(db *gorm.DB, skipLocked bool) *gorm.DB {
+	if option := DialectOf(db).ForUpdate(skipLocked); option != "" {
+		return db.Set("gorm:query_option", option)
+	}
+	return db
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) JSONText(column string, field string) string {
+	return fmt.Sprintf("%s->>'%s'", column, field)
+}
+
+func (postgresDialect) Upsert(conflictColumns []string, updateColumns []string) string {
+	return onConflict(conflictColumns, updateColumns, "EXCLUDED")
+}
+
+func (postgresDialect) ForUpdate(skipLocked bool) string {
+	if skipLocked {
+		return "FOR UPDATE SKIP LOCKED"
+	}
+	return "FOR UPDATE"
+}
+
+func (postgresDialect) NextIDs(table string, column string) string {
+	return fmt.Sprintf("SELECT nextval(pg_get_serial_sequence('%s', '%s')) AS %s FROM generate_series(1, ?)", table, column, column)
+}
+
+// set_config with is_local is SET LOCAL as a query. A timeout of 0 disables it, so the shortest one is a millisecond.
+func (postgresDialect) StatementTimeout(timeout time.Duration) string {
+	if timeout < time.Millisecond {
+		timeout = time.Millisecond
+	}
+	return fmt.Sprintf("SELECT set_config('statement_timeout', '%d', true)", timeout.Milliseconds())
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) JSONText(column string, field string) string {
+	return fmt.Sprintf("json_extract(%s, '$.%s')", column, field)
+}
+
+func (sqliteDialect) Upsert(conflictColumns []string, updateColumns []string) string {
+	return onConflict(conflictColumns, updateColumns, "excluded")
+}
+
+// SQLite serializes writers on the whole database, so there are no rows to lock.
+func (sqliteDialect) ForUpdate(skipLocked bool) string {
+	return ""
+}
+
+// SQLite has no sequences, the rowid of a new row follows the largest one.
+func (sqliteDialect) NextIDs(table string, column string) string {
+	return ""
+}
+
+func (sqliteDialect) StatementTimeout(timeout time.Duration) string {
+	return ""
+}
+
+func onConflict(conflictColumns []string, updateColumns []string, excluded string) string {
+	target := strings.Join(conflictColumns, ", ")
+	if len(updateColumns) == 0 {
+		return fmt.Sprintf("ON CONFLICT (%s) DO NOTHING", target)
+	}
+	assignments := make([]string, len(updateColumns))
+	for i, column := range updateColumns {
+		assignments[i] = fmt.Sprintf("%s = %s.%s", column, excluded, column)
+	}
+	return fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", target, strings.Join(assignments, ", "))
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/jinzhu/gorm"
+	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/require"
+)
+
+type setting struct {
+	Key   string `gorm:"primary_key"`
+	Value string
+}
+
+func TestDialect(t *testing.T) {
+	mocket.Catcher.Register()
+	postgres, err := gorm.Open(mocket.DriverName, "")
+	require.NoError(t, err)
+	defer postgres.Close()
+	sqlite := repositorytest.OpenSQLite(t)
+
+	t.Run("Should default to Postgres", func(t *testing.T) {
+		dialect := DialectOf(postgres)
+		require.Equal(t, "status->>'status'", dialect.JSONText("status", "status"))
+		require.Equal(t, "FOR UPDATE SKIP LOCKED", dialect.ForUpdate(true))
+		require.Equal(t, "FOR UPDATE", dialect.ForUpdate(false))
+		require.Equal(t, "ON CONFLICT (name) DO UPDATE SET codec = EXCLUDED.codec, package_format = EXCLUDED.package_format",
+			dialect.Upsert([]string{"name"}, []string{"codec", "package_format"}))
+		require.Equal(t, "ON CONFLICT (name) DO NOTHING", dialect.Upsert([]string{"name"}, nil))
+		require.Equal(t, "SELECT nextval(pg_get_serial_sequence('targets', 'id')) AS id FROM generate_series(1, ?)", dialect.NextIDs("targets", "id"))
+		require.Equal(t, "SELECT set_config('statement_timeout', '1500', true)", dialect.StatementTimeout(1500*time.Millisecond))
+		require.Equal(t, "SELECT set_config('statement_timeout', '1', true)", dialect.StatementTimeout(-time.Second), "A timeout of 0 would disable it")
+
+		option, ok := ForUpdate(postgres, true).Get("gorm:query_option")
+		require.True(t, ok)
+		require.Equal(t, "FOR UPDATE SKIP LOCKED", option)
+	})
+	t.Run("Should extract JSON fields on SQLite", func(t *testing.T) {
+		var values []string
+		require.NoError(t, sqlite.Raw(`SELECT `+DialectOf(sqlite).JSONText("?", "status"), `{"status":"ready"}`).Pluck("value", &values).Error)
+		require.Equal(t, []string{"ready"}, values)
+	})
+	t.Run("Should upsert on SQLite", func(t *testing.T) {
+		require.NoError(t, sqlite.CreateTable(&setting{}).Error)
+		require.NoError(t, Upsert(sqlite, []string{"key"}, "value").Create(&setting{Key: "a", Value: "1"}).Error)
+		require.NoError(t, Upsert(sqlite, []string{"key"}, "value").Create(&setting{Key: "a", Value: "2"}).Error)
+
+		var settings []setting
+		require.NoError(t, sqlite.Find(&settings).Error)
+		require.Equal(t, []setting{{Key: "a", Value: "2"}}, settings)
+	})
+	t.Run("Should not lock rows on SQLite", func(t *testing.T) {
+		_, ok := ForUpdate(sqlite, true).Get("gorm:query_option")
+		require.False(t, ok)
+		require.Empty(t, DialectOf(sqlite).NextIDs("targets", "id"))
+		require.Empty(t, DialectOf(sqlite).StatementTimeout(time.Second))
+	})
+}
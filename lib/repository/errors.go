@@ -10,6 +10,17 @@ import (
 var (
 	// ErrEntityNotFound is returned when the specified record is not found.
 	ErrEntityNotFound = stderrors.New("Entity Not Found")
+
+	// ErrConflict is returned when the record was modified by another writer since it was read.
+	// Callers should read it again and retry.
+	ErrConflict = stderrors.New("Entity Modified Concurrently")
+
+	// ErrDuplicate is returned when the record would take a unique value, such as a name, of another record.
+	ErrDuplicate = stderrors.New("Entity Already Exists")
+
+	// ErrRolledBack is the error of the items of a bulk operation that were rolled back along with the others,
+	// although they did not fail themselves, see BulkMode.
+	ErrRolledBack = stderrors.New("Rolled Back")
 )
 
 // EvaluateError determines if the error should be recognized as an ErrEntityNotFound.
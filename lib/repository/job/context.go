@@ -0,0 +1,68 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+)
+
+func (instance *gormJobRepository) GetContext(ctx context.Context, id int) (*model.Job, error) {
+	return instance.WithContext(ctx).Get(id)
+}
+
+func (instance *gormJobRepository) GetVersionedContext(ctx context.Context, id int) (*VersionedJob, error) {
+	return instance.WithContext(ctx).GetVersioned(id)
+}
+
+func (instance *gormJobRepository) CreateContext(ctx context.Context, job *model.Job) error {
+	return instance.WithContext(ctx).Create(job)
+}
+
+func (instance *gormJobRepository) UpdateContext(ctx context.Context, job *model.Job) error {
+	return instance.WithContext(ctx).Update(job)
+}
+
+func (instance *gormJobRepository) UpdateVersionedContext(ctx context.Context, job *VersionedJob) error {
+	return instance.WithContext(ctx).UpdateVersioned(job)
+}
+
+func (instance *gormJobRepository) DeleteContext(ctx context.Context, id int) error {
+	return instance.WithContext(ctx).Delete(id)
+}
+
+func (instance *gormJobRepository) RestoreContext(ctx context.Context, id int) error {
+	return instance.WithContext(ctx).Restore(id)
+}
+
+func (instance *gormJobRepository) PurgeContext(ctx context.Context, olderThan time.Duration) (int, error) {
+	return instance.WithContext(ctx).Purge(olderThan)
+}
+
+func (instance *gormJobRepository) AllContext(ctx context.Context, filters *JobFilter, pagination *JobPagination) (*JobPaginationResult, error) {
+	return instance.WithContext(ctx).All(filters, pagination)
+}
+
+func (instance *gormJobRepository) AllVersionedContext(ctx context.Context, filters *JobFilter, pagination *JobPagination) (*VersionedJobPaginationResult, error) {
+	return instance.WithContext(ctx).AllVersioned(filters, pagination)
+}
+
+func (instance *gormJobRepository) HistoryContext(ctx context.Context, jobID int) ([]*model.JobStatusChange, error) {
+	return instance.WithContext(ctx).History(jobID)
+}
+
+func (instance *gormJobRepository) ClaimNextContext(ctx context.Context, workerID string, leaseDuration time.Duration, filter *JobFilter) (*VersionedJob, error) {
+	return instance.WithContext(ctx).ClaimNext(workerID, leaseDuration, filter)
+}
+
+func (instance *gormJobRepository) RenewLeaseContext(ctx context.Context, jobID int, workerID string, leaseDuration time.Duration) error {
+	return instance.WithContext(ctx).RenewLease(jobID, workerID, leaseDuration)
+}
+
+func (instance *gormJobRepository) ReleaseLeaseContext(ctx context.Context, jobID int, workerID string) error {
+	return instance.WithContext(ctx).ReleaseLease(jobID, workerID)
+}
+
+func (instance *gormJobRepository) AllByCursorContext(ctx context.Context, filters *JobFilter, pagination *JobCursorPagination) (*JobCursorResult, error) {
+	return instance.WithContext(ctx).AllByCursor(filters, pagination)
+}
@@ -0,0 +1,59 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/audit"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobRepositoryContextOnSQLite(t *testing.T) {
+	database := repositorytest.OpenSQLite(t)
+	repository := New(database)
+
+	t.Run("Should run the queries of a context with a deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(routing.WithActor(context.Background(), "scheduler"), time.Minute)
+		defer cancel()
+		job := newMockJob(0)
+		require.NoError(t, repository.CreateContext(ctx, job))
+
+		read, err := repository.GetContext(ctx, job.ID)
+		require.NoError(t, err)
+		require.Equal(t, job, read)
+		history, err := repository.HistoryContext(ctx, job.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		entries, err := audit.New(database).History(audit.EntityJob, job.ID)
+		require.NoError(t, err)
+		require.Equal(t, "scheduler", entries[0].Actor)
+	})
+	t.Run("Should fail with the error of a done context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := repository.GetContext(ctx, 1)
+		require.Equal(t, context.Canceled, errors.Cause(err))
+		err = repository.CreateContext(ctx, newMockJob(0))
+		require.Equal(t, context.Canceled, errors.Cause(err))
+		_, err = repository.ClaimNextContext(ctx, "worker", time.Minute, nil)
+		require.Equal(t, context.Canceled, errors.Cause(err))
+
+		result, err := repository.All(nil, &JobPagination{Size: 10, Page: 1})
+		require.NoError(t, err)
+		require.Equal(t, 1, result.Total, "The job was not created")
+	})
+	t.Run("Should fail with the error of a context past its deadline", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+
+		_, err := repository.AllContext(ctx, nil, &JobPagination{Size: 10, Page: 1})
+		require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+		err = repository.RenewLeaseContext(ctx, 1, "worker", time.Minute)
+		require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	})
+}
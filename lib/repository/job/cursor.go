@@ -0,0 +1,179 @@
+package job
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/jinzhu/gorm"
+)
+
+// ErrInvalidCursor is returned when a cursor was not produced by AllByCursor for the same sort order.
+var ErrInvalidCursor = stderrors.New("Invalid Cursor")
+
+// JobSortField is a column jobs can be listed by. Jobs with the same value are listed by id.
+type JobSortField string
+
+const (
+	SortByID        JobSortField = "id"
+	SortByCreatedAt JobSortField = "created_at"
+	SortByPriority  JobSortField = "priority"
+)
+
+// JobSort is the order jobs are listed in.
+type JobSort struct {
+	Field      JobSortField
+	Descending bool
+}
+
+// JobCursorPagination selects a page of AllByCursor.
+type JobCursorPagination struct {
+	Size int
+	// Cursor is the NextCursor of the previous page, empty for the first page.
+	Cursor string
+	// Sort orders the jobs, by ascending id when empty. It must not change from one page to the next.
+	Sort JobSort
+	// WithTotal counts every job matching the filters, which reads all of them.
+	WithTotal bool
+}
+
+// JobCursorResult is a page of AllByCursor.
+type JobCursorResult struct {
+	Results []*VersionedJob
+	// NextCursor selects the following page, it is empty on the last page.
+	NextCursor string
+	// Total is only set when WithTotal was requested.
+	Total *int
+}
+
+func (sort JobSort) field() (JobSortField, error) {
+	switch sort.Field {
+	case "":
+		return SortByID, nil
+	case SortByID, SortByCreatedAt, SortByPriority:
+		return sort.Field, nil
+	}
+	return "", errors.Errorf("jobs cannot be sorted by %q", sort.Field)
+}
+
+func (sort JobSort) direction() (string, string) {
+	if sort.Descending {
+		return "DESC", "<"
+	}
+	return "ASC", ">"
+}
+
+func (sort JobSort) orderBy() (string, error) {
+	field, err := sort.field()
+	if err != nil {
+		return "", err
+	}
+	direction, _ := sort.direction()
+	if field == SortByID {
+		return "id " + direction, nil
+	}
+	return fmt.Sprintf("%s %s, id %s", field, direction, direction), nil
+}
+
+// jobCursor is the position after the last job of a page, encoded as base64 JSON.
+type jobCursor struct {
+	Field      JobSortField `json:"f"`
+	Descending bool         `json:"d"`
+	CreatedAt  time.Time    `json:"c"`
+	Priority   int          `json:"p"`
+	ID         int          `json:"i"`
+}
+
+func newJobCursor(field JobSortField, sort JobSort, job *gormmodel.JobRecord) string {
+	cursor := jobCursor{Field: field, Descending: sort.Descending, CreatedAt: job.CreatedAt, Priority: job.Priority, ID: job.ID}
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeJobCursor(value string, field JobSortField, sort JobSort) (*jobCursor, error) {
+	cursor := &jobCursor{}
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(decoded, cursor)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidCursor, "decoding cursor %q: %v", value, err)
+	}
+	if cursor.Field != field || cursor.Descending != sort.Descending {
+		return nil, errors.Wrapf(ErrInvalidCursor, "cursor %q does not continue the listing by %s", value, field)
+	}
+	return cursor, nil
+}
+
+// condition selects the jobs after the cursor, with row values so that the (field, id) indexes are used.
+func (cursor *jobCursor) condition(sort JobSort) (string, []interface{}) {
+	_, operator := sort.direction()
+	switch cursor.Field {
+	case SortByCreatedAt:
+		return fmt.Sprintf("(created_at, id) %s (?, ?)", operator), []interface{}{cursor.CreatedAt, cursor.ID}
+	case SortByPriority:
+		return fmt.Sprintf("(priority, id) %s (?, ?)", operator), []interface{}{cursor.Priority, cursor.ID}
+	}
+	return fmt.Sprintf("id %s ?", operator), []interface{}{cursor.ID}
+}
+
+func (instance *gormJobRepository) AllByCursor(filters *JobFilter, pagination *JobCursorPagination) (*JobCursorResult, error) {
+	instance.log().Infof("Listing jobs after cursor %q", pagination.Cursor)
+	if pagination.Size <= 0 {
+		return nil, errors.Errorf("invalid page size %v", pagination.Size)
+	}
+	field, err := pagination.Sort.field()
+	if err != nil {
+		return nil, err
+	}
+	orderBy, _ := pagination.Sort.orderBy()
+	var cursorCondition string
+	var cursorValues []interface{}
+	if pagination.Cursor != "" {
+		cursor, err := decodeJobCursor(pagination.Cursor, field, pagination.Sort)
+		if err != nil {
+			return nil, err
+		}
+		cursorCondition, cursorValues = cursor.condition(pagination.Sort)
+	}
+
+	result := &JobCursorResult{}
+	// One more job than requested tells whether there is a next page.
+	jobs := []*gormmodel.JobRecord{}
+	err = repository.Run(instance.ctx, instance.replica(), func(db *gorm.DB) error {
+		dbInstance := addFilters(db, filters)
+		if pagination.WithTotal {
+			total := 0
+			if err := dbInstance.Model(&gormmodel.JobRecord{}).Count(&total).Error; err != nil {
+				instance.log().Errorf("An error occurred while trying to count jobs %v", err)
+				return errors.Wrap(err, "unable to count jobs")
+			}
+			result.Total = &total
+		}
+		if cursorCondition != "" {
+			dbInstance = dbInstance.Where(cursorCondition, cursorValues...)
+		}
+		if err := dbInstance.Order(orderBy).Limit(pagination.Size + 1).Find(&jobs).Error; err != nil {
+			instance.log().Errorf("An error occurred while trying to list jobs %v", err)
+			return errors.Wrapf(err, "unable to list jobs after cursor %q", pagination.Cursor)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) > pagination.Size {
+		jobs = jobs[:pagination.Size]
+		result.NextCursor = newJobCursor(field, pagination.Sort, jobs[len(jobs)-1])
+	}
+	result.Results = make([]*VersionedJob, len(jobs))
+	for i := range jobs {
+		result.Results[i] = toVersionedJob(jobs[i])
+	}
+	return result, nil
+}
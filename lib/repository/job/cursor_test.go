@@ -0,0 +1,117 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/jinzhu/gorm"
+	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/require"
+)
+
+// listAllPages follows the cursors of AllByCursor and returns the ids of every page.
+func listAllPages(t *testing.T, repository JobRepository, filters *JobFilter, pagination JobCursorPagination) [][]int {
+	var pages [][]int
+	for {
+		result, err := repository.AllByCursor(filters, &pagination)
+		require.NoError(t, err)
+		var ids []int
+		for _, job := range result.Results {
+			ids = append(ids, job.ID)
+		}
+		pages = append(pages, ids)
+		if result.NextCursor == "" {
+			return pages
+		}
+		pagination.Cursor = result.NextCursor
+	}
+}
+
+func TestAllByCursor(t *testing.T) {
+	mocket.Catcher.Register()
+	database, err := gorm.Open(mocket.DriverName, "connection_string")
+	require.NoError(t, err)
+	defer database.Close()
+	repository := New(database)
+
+	t.Run("Should continue after the cursor without counting", func(t *testing.T) {
+		mocket.Catcher.Reset()
+		first := mocket.Catcher.NewMock().WithQuery(`SELECT * FROM "jobs"  WHERE "jobs"."deleted_at" IS NULL ORDER BY priority DESC, id DESC LIMIT 2`).
+			WithReply([]map[string]interface{}{buildJobPayload(7, 5, model.StatusReady), buildJobPayload(3, 5, model.StatusReady)})
+		count := mocket.Catcher.NewMock().WithQuery(`SELECT count(*)`)
+		pagination := &JobCursorPagination{Size: 1, Sort: JobSort{Field: SortByPriority, Descending: true}}
+		result, err := repository.AllByCursor(nil, pagination)
+		require.NoError(t, err)
+		require.True(t, first.Triggered)
+		require.False(t, count.Triggered)
+		require.Nil(t, result.Total)
+		require.Len(t, result.Results, 1)
+
+		next := mocket.Catcher.NewMock().WithQuery(`AND (((priority, id) < (5, 7))) ORDER BY priority DESC, id DESC LIMIT 2`)
+		pagination.Cursor = result.NextCursor
+		_, err = repository.AllByCursor(nil, pagination)
+		require.NoError(t, err)
+		require.True(t, next.Triggered)
+	})
+	t.Run("Should return errors if any", func(t *testing.T) {
+		mocket.Catcher.Reset()
+		mocket.Catcher.NewMock().WithQuery(`SELECT * FROM "jobs"`).WithError(mockError)
+		_, err := repository.AllByCursor(nil, &JobCursorPagination{Size: 1})
+		require.EqualError(t, errors.Cause(err), mockError.Error())
+	})
+}
+
+func TestAllByCursorOnSQLite(t *testing.T) {
+	repository := New(repositorytest.OpenSQLite(t))
+	var ids []int
+	for _, priority := range []int{2, 9, 2, 5, 9} {
+		job := newMockJob(0)
+		job.Priority = priority
+		require.NoError(t, repository.Create(job))
+		ids = append(ids, job.ID)
+	}
+
+	t.Run("Should list every job once, by ascending id by default", func(t *testing.T) {
+		pages := listAllPages(t, repository, nil, JobCursorPagination{Size: 2})
+		require.Equal(t, [][]int{{ids[0], ids[1]}, {ids[2], ids[3]}, {ids[4]}}, pages)
+	})
+	t.Run("Should sort by priority, then by id", func(t *testing.T) {
+		pages := listAllPages(t, repository, nil, JobCursorPagination{Size: 2, Sort: JobSort{Field: SortByPriority, Descending: true}})
+		require.Equal(t, [][]int{{ids[4], ids[1]}, {ids[3], ids[2]}, {ids[0]}}, pages)
+	})
+	t.Run("Should sort by creation time", func(t *testing.T) {
+		pages := listAllPages(t, repository, nil, JobCursorPagination{Size: 3, Sort: JobSort{Field: SortByCreatedAt}})
+		require.Equal(t, [][]int{{ids[0], ids[1], ids[2]}, {ids[3], ids[4]}}, pages)
+	})
+	t.Run("Should filter and count the jobs on request", func(t *testing.T) {
+		priority := 2
+		result, err := repository.AllByCursor(&JobFilter{Priority: &priority}, &JobCursorPagination{Size: 1, WithTotal: true})
+		require.NoError(t, err)
+		require.Equal(t, 2, *result.Total)
+		require.Equal(t, ids[0], result.Results[0].ID)
+		require.NotEmpty(t, result.NextCursor)
+	})
+	t.Run("Should reject cursors of another listing", func(t *testing.T) {
+		result, err := repository.AllByCursor(nil, &JobCursorPagination{Size: 1})
+		require.NoError(t, err)
+
+		_, err = repository.AllByCursor(nil, &JobCursorPagination{Size: 1, Cursor: result.NextCursor, Sort: JobSort{Field: SortByPriority}})
+		require.True(t, errors.Is(err, ErrInvalidCursor))
+		_, err = repository.AllByCursor(nil, &JobCursorPagination{Size: 1, Cursor: "not a cursor"})
+		require.True(t, errors.Is(err, ErrInvalidCursor))
+	})
+	t.Run("Should reject unknown sort fields", func(t *testing.T) {
+		_, err := repository.AllByCursor(nil, &JobCursorPagination{Size: 1, Sort: JobSort{Field: "source_path"}})
+		require.Error(t, err)
+		_, err = repository.All(nil, &JobPagination{Size: 1, Page: 1, Sort: JobSort{Field: "source_path"}})
+		require.Error(t, err)
+	})
+	t.Run("Should sort offset pages too", func(t *testing.T) {
+		result, err := repository.All(nil, &JobPagination{Size: 2, Page: 2, Sort: JobSort{Field: SortByPriority}})
+		require.NoError(t, err)
+		require.Equal(t, ids[3], result.Results[0].ID)
+		require.Equal(t, ids[1], result.Results[1].ID)
+	})
+}
@@ -1,8 +1,13 @@
 package job
 
 import (
+	"context"
+	stderrors "errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/EurosportDigital/global-transcoding-platform/db"
 	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
 	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
 	"github.com/jinzhu/gorm"
@@ -10,25 +15,128 @@ import (
 	// Required by GORM for postgres requests
 	"github.com/EurosportDigital/global-transcoding-platform/lib/logger"
 	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/audit"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/routing"
 	"github.com/EurosportDigital/global-transcoding-platform/model"
 )
 
 type JobRepository interface {
 	Get(id int) (*model.Job, error)
+	// GetVersioned is Get along with the version the job is read at, its lease and its pinned profile revisions.
+	GetVersioned(id int) (*VersionedJob, error)
+	// Create saves a new job at version 1.
 	Create(job *model.Job) error
+	// Update saves the job at whatever version it is when the update runs, and increments its version. As a
+	// model.Job carries no version, Update cannot tell whether the job changed since the caller read it, and
+	// overwrites such changes.
+	//
+	// Deprecated: use UpdateVersioned, which returns repository.ErrConflict rather than overwriting concurrent
+	// updates.
 	Update(job *model.Job) error
+	// UpdateVersioned saves the job if it was not modified since it was read, that is if its Version is still
+	// current: the update compares and swaps the version, and sets the incremented Version. It returns
+	// repository.ErrConflict otherwise, and callers should read the job again and retry.
+	// A status change must be allowed by model.StatusTransitions, or ErrInvalidTransition is returned. It is
+	// recorded in the job's history with the status message as the reason. The status of a job leased to a worker
+	// can only change through the owner of its Lease until the lease expires or is released, ErrLeaseNotHeld is
+	// returned otherwise. The lease ends when the job leaves processing.
+	UpdateVersioned(job *VersionedJob) error
+	// Delete soft deletes the job: reads exclude it until it is restored, and it can no longer be updated or claimed.
 	Delete(id int) error
+	// Restore undoes the soft deletion of the job. It returns repository.ErrEntityNotFound if the job is not soft deleted.
+	Restore(id int) error
+	// Purge permanently removes the jobs soft deleted more than olderThan ago, along with their history and outputs,
+	// and returns their number.
+	Purge(olderThan time.Duration) (int, error)
 	All(filters *JobFilter, pagination *JobPagination) (*JobPaginationResult, error)
+	// AllVersioned is All along with the version each job is read at, its lease and its pinned profile revisions.
+	AllVersioned(filters *JobFilter, pagination *JobPagination) (*VersionedJobPaginationResult, error)
+	// History lists the statuses the job went through, oldest first, starting with the one it was created with.
+	History(jobID int) ([]*model.JobStatusChange, error)
+	// ClaimNext moves the highest priority ready job matching filter to processing, leased to workerID for
+	// leaseDuration, and returns it. Processing jobs whose lease expired are claimed again. Jobs locked by
+	// concurrent claims are skipped. It returns repository.ErrEntityNotFound when there is no job to claim.
+	ClaimNext(workerID string, leaseDuration time.Duration, filter *JobFilter) (*VersionedJob, error)
+	// RenewLease extends the unexpired lease workerID holds on the job to leaseDuration from now.
+	// It returns ErrLeaseNotHeld otherwise. Leases do not change the job version.
+	RenewLease(jobID int, workerID string, leaseDuration time.Duration) error
+	// ReleaseLease ends the lease workerID holds on the job, so that it can be claimed again if it is still
+	// processing. It returns ErrLeaseNotHeld if the worker does not hold the lease.
+	ReleaseLease(jobID int, workerID string) error
+	// AllByCursor lists the jobs matching filters a page at a time, continuing after pagination.Cursor.
+	// Unlike All it neither skips over nor counts the previous pages, see JobCursorPagination.
+	AllByCursor(filters *JobFilter, pagination *JobCursorPagination) (*JobCursorResult, error)
+	// UsePrimary returns a view of the repository whose reads go to the primary database, for read-after-write consistency.
+	UsePrimary() JobRepository
+	// IncludeDeleted returns a view of the repository whose reads include soft deleted jobs.
+	IncludeDeleted() JobRepository
+	// WithContext returns a view of the repository bound to ctx. Its queries are cancelled when ctx is done, failing
+	// with the error of ctx, and its log lines carry the logging context of ctx, see routing.WithLoggingContext. Its
+	// creates, updates and deletes are recorded in the audit trail as made by the actor of ctx, see routing.WithActor.
+	// Claims and leases are recorded in the job history instead.
+	WithContext(ctx context.Context) JobRepository
+
+	// The Context variants are the methods of WithContext(ctx).
+	GetContext(ctx context.Context, id int) (*model.Job, error)
+	GetVersionedContext(ctx context.Context, id int) (*VersionedJob, error)
+	CreateContext(ctx context.Context, job *model.Job) error
+	// Deprecated: use UpdateVersionedContext, see Update.
+	UpdateContext(ctx context.Context, job *model.Job) error
+	UpdateVersionedContext(ctx context.Context, job *VersionedJob) error
+	DeleteContext(ctx context.Context, id int) error
+	RestoreContext(ctx context.Context, id int) error
+	PurgeContext(ctx context.Context, olderThan time.Duration) (int, error)
+	AllContext(ctx context.Context, filters *JobFilter, pagination *JobPagination) (*JobPaginationResult, error)
+	AllVersionedContext(ctx context.Context, filters *JobFilter, pagination *JobPagination) (*VersionedJobPaginationResult, error)
+	HistoryContext(ctx context.Context, jobID int) ([]*model.JobStatusChange, error)
+	ClaimNextContext(ctx context.Context, workerID string, leaseDuration time.Duration, filter *JobFilter) (*VersionedJob, error)
+	RenewLeaseContext(ctx context.Context, jobID int, workerID string, leaseDuration time.Duration) error
+	ReleaseLeaseContext(ctx context.Context, jobID int, workerID string) error
+	AllByCursorContext(ctx context.Context, filters *JobFilter, pagination *JobCursorPagination) (*JobCursorResult, error)
+}
+
+// ErrInvalidTransition is returned when a job is updated to a status it cannot move to from its current status.
+var ErrInvalidTransition = stderrors.New("Invalid Job Status Transition")
+
+// VersionedJob is a job along with the version it was read at and the lease a worker holds on it, if any.
+type VersionedJob struct {
+	*model.Job
+	Version int
+	Lease   *Lease
+	// ProfileRevisions maps the IDs of the profiles of the outputs to the revision the job is built with, see
+	// profile.Repository.GetRevisionByID. A profile keeps the revision it was at when the job first got an output using it.
+	ProfileRevisions map[int]int
 }
 
+// JobFilter selects the jobs matching every condition that is set.
 type JobFilter struct {
 	Status   *model.Status
 	Priority *int
+	// Statuses matches the jobs in any of the statuses.
+	Statuses []model.Status
+	// MinPriority and MaxPriority bound the priority, inclusively.
+	MinPriority *int
+	MaxPriority *int
+	Created     TimeRange
+	Updated     TimeRange
+	// SourcePathPrefix matches the jobs whose source path starts with it, such as "s3://bucket/".
+	SourcePathPrefix string
+	// ProfileID and TargetID match the jobs with an output using the profile or the target.
+	ProfileID *int
+	TargetID  *int
+}
+
+// TimeRange matches the times from From, inclusive, until To, exclusive. A nil bound leaves the range open.
+type TimeRange struct {
+	From *time.Time
+	To   *time.Time
 }
 
 type JobPagination struct {
-	Size  int
-	Page  int
+	Size int
+	Page int
+	// Sort orders the jobs, by ascending id when empty.
+	Sort  JobSort
 	total int
 }
 
@@ -38,113 +146,443 @@ type JobPaginationResult struct {
 	Page    int
 }
 
+type VersionedJobPaginationResult struct {
+	Results []*VersionedJob
+	Total   int
+	Page    int
+}
+
 type gormJobRepository struct {
-	db *gorm.DB
+	resolver       db.Resolver
+	includeDeleted bool
+	ctx            context.Context
+}
+
+func New(database *gorm.DB) JobRepository {
+	return NewWithResolver(db.NewCluster(database))
 }
 
-func New(db *gorm.DB) JobRepository {
+// NewWithResolver constructs a job repository that reads from the resolver's replicas and writes to its primary.
+func NewWithResolver(resolver db.Resolver) JobRepository {
 	return &gormJobRepository{
-		db: db,
+		resolver: resolver,
+		ctx:      context.Background(),
 	}
 }
 
+func (instance *gormJobRepository) UsePrimary() JobRepository {
+	view := *instance
+	view.resolver = db.PrimaryOnly(instance.resolver)
+	return &view
+}
+
+func (instance *gormJobRepository) IncludeDeleted() JobRepository {
+	view := *instance
+	view.includeDeleted = true
+	return &view
+}
+
+func (instance *gormJobRepository) WithContext(ctx context.Context) JobRepository {
+	view := *instance
+	view.ctx = ctx
+	return &view
+}
+
+// log returns the logger of the repository, adding the logging context of its context to every message.
+func (instance *gormJobRepository) log() *logger.FieldLogger {
+	return logger.WithFields(routing.GetLoggingContext(instance.ctx))
+}
+
+// replica returns the connection reads go to, unscoped when soft deleted jobs are included.
+func (instance *gormJobRepository) replica() *gorm.DB {
+	if instance.includeDeleted {
+		return instance.resolver.Replica().Unscoped()
+	}
+	return instance.resolver.Replica()
+}
+
 func (instance *gormJobRepository) Get(id int) (*model.Job, error) {
-	logger.Infof("Getting Job with Id: %d", id)
-	job := &gormmodel.Job{}
-	err := instance.db.First(job, id).Error
+	job, err := instance.GetVersioned(id)
+	if err != nil {
+		return nil, err
+	}
+	return job.Job, nil
+}
+
+func (instance *gormJobRepository) GetVersioned(id int) (*VersionedJob, error) {
+	instance.log().Infof("Getting Job with Id: %d", id)
+	job := &gormmodel.JobRecord{}
+	err := repository.Run(instance.ctx, instance.replica(), func(db *gorm.DB) error {
+		return db.First(job, id).Error
+	})
 	if gorm.IsRecordNotFoundError(err) {
-		logger.Warnf("Job with id %d not found", id)
+		instance.log().Warnf("Job with id %d not found", id)
 		return nil, errors.Wrapf(repository.ErrEntityNotFound, "job id %v not found", id)
 	}
 	if err != nil {
-		logger.Errorf("An  error ocurred while trying to get job %v", err)
+		instance.log().Errorf("An  error ocurred while trying to get job %v", err)
 		return nil, errors.Wrapf(err, "unable to get job %v", id)
 	}
-	return gormmodel.ToJob(job), nil
+	return toVersionedJob(job), nil
 }
 
 func (instance *gormJobRepository) All(filters *JobFilter, pagination *JobPagination) (*JobPaginationResult, error) {
-	logger.Infof("Listing all jobs")
-	jobs := []*gormmodel.Job{}
+	versioned, err := instance.AllVersioned(filters, pagination)
+	if err != nil {
+		return nil, err
+	}
+	modelJobs := make([]*model.Job, len(versioned.Results))
+	for i := range modelJobs {
+		modelJobs[i] = versioned.Results[i].Job
+	}
+	return &JobPaginationResult{Results: modelJobs, Total: versioned.Total, Page: versioned.Page}, nil
+}
 
-	dbInstance := instance.addFilters(filters)
+func (instance *gormJobRepository) AllVersioned(filters *JobFilter, pagination *JobPagination) (*VersionedJobPaginationResult, error) {
+	instance.log().Infof("Listing all jobs")
+	jobs := []*gormmodel.JobRecord{}
 
 	limit := pagination.Size
 	pagination.total = 0
 	offset := (pagination.Page - 1) * limit
 
-	listErr := dbInstance.Model(&jobs).Count(&pagination.total).Limit(limit).Offset(offset).Find(&jobs).Error
+	orderBy, err := pagination.Sort.orderBy()
+	if err != nil {
+		return nil, err
+	}
+	listErr := repository.Run(instance.ctx, instance.replica(), func(db *gorm.DB) error {
+		return addFilters(db, filters).Model(&jobs).Count(&pagination.total).Order(orderBy).Limit(limit).Offset(offset).Find(&jobs).Error
+	})
 
 	if listErr != nil {
-		logger.Errorf("An error occurred while trying to list jobs %v", listErr)
+		instance.log().Errorf("An error occurred while trying to list jobs %v", listErr)
 		return nil, errors.Wrapf(listErr, "unable to list all jobs on page %v with size %v", pagination.Page, limit)
 	}
-	modelJobs := make([]*model.Job, len(jobs))
+	modelJobs := make([]*VersionedJob, len(jobs))
 	for i := range modelJobs {
-		modelJobs[i] = gormmodel.ToJob(jobs[i])
+		modelJobs[i] = toVersionedJob(jobs[i])
 	}
-	return &JobPaginationResult{Results: modelJobs, Total: pagination.total, Page: pagination.Page}, nil
+	return &VersionedJobPaginationResult{Results: modelJobs, Total: pagination.total, Page: pagination.Page}, nil
 }
 
 func (instance *gormJobRepository) Update(job *model.Job) error {
-	logger.Infof("Updating job: %+v", job)
-	gormJob := gormmodel.ToGormJob(job)
-	result := instance.db.Model(&gormJob).Updates(gormJob)
-	if result.Error != nil {
-		logger.Error(result.Error, "Error found when trying to update record")
-		return errors.Wrapf(result.Error, "updating job %v", safeGetJobID(job))
+	_, err := instance.update(job, nil, "")
+	return err
+}
+
+func (instance *gormJobRepository) UpdateVersioned(job *VersionedJob) error {
+	var workerID string
+	if job.Lease != nil {
+		workerID = job.Lease.Owner
 	}
-	if result.RowsAffected == 0 {
-		logger.Warnf("Could not find record to be updated")
-		return errors.Wrapf(repository.ErrEntityNotFound, "job %v not found", safeGetJobID(job))
+	updated, err := instance.update(job.Job, &job.Version, workerID)
+	if err != nil {
+		return err
 	}
+	job.Version, job.Lease = updated.Version, updated.Lease
 	return nil
 }
 
-func (instance *gormJobRepository) Create(job *model.Job) error {
-	logger.Infof("Creating Job %+v", job)
-	gormJob := gormmodel.ToGormJob(job)
-	result := instance.db.Create(gormJob)
-	logger.Infof("Affected rows: %d", result.RowsAffected)
-	if result.Error != nil {
-		logger.Error(result.Error, "Error found when trying create job")
-		return errors.Wrapf(result.Error, "creating job %v", safeGetJobID(job))
-	}
-	*job = *gormmodel.ToJob(gormJob)
+// update saves job, checking that it is still at version unless version is nil, on behalf of workerID, empty when
+// the update is not made by a worker. It returns the new version and lease of the job.
+func (instance *gormJobRepository) update(job *model.Job, version *int, workerID string) (*VersionedJob, error) {
+	instance.log().Infof("Updating job: %+v", job)
+	gormJob := &gormmodel.JobRecord{Job: *gormmodel.ToGormJob(job)}
+	err := repository.TransactionContext(instance.ctx, instance.resolver.Primary(), func(tx *gorm.DB) error {
+		current := &gormmodel.JobRecord{}
+		err := repository.ForUpdate(tx, false).First(current, job.ID).Error
+		if gorm.IsRecordNotFoundError(err) {
+			instance.log().Warnf("Could not find record to be updated")
+			return errors.Wrapf(repository.ErrEntityNotFound, "job %v not found", safeGetJobID(job))
+		}
+		if err != nil {
+			instance.log().Error(err, "Error found when trying to read the record to update")
+			return errors.Wrapf(err, "updating job %v", safeGetJobID(job))
+		}
+		expected := current.Version
+		if version != nil && current.Version != *version {
+			instance.log().Warnf("Job %v was modified since version %d was read", safeGetJobID(job), *version)
+			return errors.Wrapf(repository.ErrConflict, "job %v is at version %d, not %d", safeGetJobID(job), current.Version, *version)
+		}
+		gormJob.Version = expected + 1
+		from, to := current.Status.Status, gormJob.Status.Status
+		if from != to && !from.CanTransitionTo(to) {
+			instance.log().Warnf("Rejected status transition of job %v from %s to %s", safeGetJobID(job), from, to)
+			return errors.Wrapf(ErrInvalidTransition, "job %v cannot move from %s to %s", safeGetJobID(job), from, to)
+		}
+		leased := current.LeaseOwner != "" && current.LeaseExpiresAt != nil && current.LeaseExpiresAt.After(time.Now().UTC())
+		if from != to && leased && current.LeaseOwner != workerID {
+			instance.log().Warnf("Rejected status change of job %v leased to worker %s", safeGetJobID(job), current.LeaseOwner)
+			return errors.Wrapf(ErrLeaseNotHeld, "job %v is leased to worker %s until %s", safeGetJobID(job), current.LeaseOwner, current.LeaseExpiresAt)
+		}
+		if len(gormJob.Outputs) > 0 {
+			revisions, err := instance.pinProfileRevisions(tx, gormJob.Outputs, current.ProfileRevisions)
+			if err != nil {
+				return err
+			}
+			gormJob.ProfileRevisions = revisions
+		}
+
+		result := tx.Model(gormJob).Where("version = ?", expected).Updates(gormJob)
+		if result.Error != nil {
+			instance.log().Error(result.Error, "Error found when trying to update record")
+			return errors.Wrapf(result.Error, "updating job %v", safeGetJobID(job))
+		}
+		if result.RowsAffected == 0 {
+			return errors.Wrapf(repository.ErrConflict, "job %v is no longer at version %d", safeGetJobID(job), expected)
+		}
+		gormJob.LeaseOwner, gormJob.LeaseExpiresAt = current.LeaseOwner, current.LeaseExpiresAt
+		if to != model.StatusProcessing && (current.LeaseOwner != "" || current.LeaseExpiresAt != nil) {
+			gormJob.LeaseOwner, gormJob.LeaseExpiresAt = "", nil
+			err := tx.Model(gormJob).UpdateColumns(map[string]interface{}{"lease_owner": "", "lease_expires_at": nil}).Error
+			if err != nil {
+				instance.log().Error(err, "Error found when trying to end the lease of the job")
+				return errors.Wrapf(err, "ending the lease on job %v", safeGetJobID(job))
+			}
+		}
+		// Like the outputs column, the outputs are left untouched when none are given.
+		if len(gormJob.Outputs) > 0 {
+			if err := instance.saveOutputs(tx, job.ID, gormJob.Outputs, gormJob.ProfileRevisions); err != nil {
+				return err
+			}
+		}
+		if from != to {
+			if err := instance.recordStatusChange(tx, job.ID, from, gormJob.Status); err != nil {
+				return err
+			}
+		}
+		updated := &gormmodel.JobRecord{}
+		if err := tx.First(updated, job.ID).Error; err != nil {
+			instance.log().Error(err, "Error found when trying to read the updated record")
+			return errors.Wrapf(err, "updating job %v", safeGetJobID(job))
+		}
+		return instance.audit(tx, model.AuditUpdate, job.ID, gormmodel.ToJob(&current.Job), gormmodel.ToJob(&updated.Job))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toVersionedJob(gormJob), nil
+}
+
+func (instance *gormJobRepository) History(jobID int) ([]*model.JobStatusChange, error) {
+	instance.log().Infof("Getting status history of job %d", jobID)
+	var history []*gormmodel.JobStatusHistory
+	err := repository.Run(instance.ctx, instance.replica(), func(db *gorm.DB) error {
+		return db.Where("job_id = ?", jobID).Order("created_at, id").Find(&history).Error
+	})
+	if err != nil {
+		instance.log().Errorf("An error occurred while trying to get the history of job %d %v", jobID, err)
+		return nil, errors.Wrapf(err, "unable to get the history of job %v", jobID)
+	}
+	changes := make([]*model.JobStatusChange, len(history))
+	for i := range history {
+		changes[i] = gormmodel.ToJobStatusChange(history[i])
+	}
+	return changes, nil
+}
+
+// pinProfileRevisions returns the revisions the profiles of outputs are pinned to: the one in pinned if any, the
+// latest revision of the profile otherwise. The profiles that do not exist are left out, and nil is returned when
+// none exist.
+func (instance *gormJobRepository) pinProfileRevisions(tx *gorm.DB, outputs gormmodel.Outputs, pinned gormmodel.ProfileRevisions) (gormmodel.ProfileRevisions, error) {
+	revisions := gormmodel.ProfileRevisions{}
+	var unpinned []int
+	for _, output := range outputs {
+		if revision, ok := pinned[output.ProfileID]; ok {
+			revisions[output.ProfileID] = revision
+		} else {
+			unpinned = append(unpinned, output.ProfileID)
+		}
+	}
+	if len(unpinned) > 0 {
+		var profiles []*gormmodel.ProfileRecord
+		if err := tx.Unscoped().Select("id, revision").Where("id IN (?)", unpinned).Find(&profiles).Error; err != nil {
+			instance.log().Error(err, "Error found when trying to read the revisions of the job profiles")
+			return nil, errors.Wrap(err, "reading the revisions of the job profiles")
+		}
+		for _, profile := range profiles {
+			revisions[profile.ID] = profile.Revision
+		}
+	}
+	if len(revisions) == 0 {
+		return nil, nil
+	}
+	return revisions, nil
+}
+
+// saveOutputs replaces the job_outputs rows of the job, which index the outputs column for JobFilter, along with the
+// revision of their profile.
+func (instance *gormJobRepository) saveOutputs(tx *gorm.DB, jobID int, outputs gormmodel.Outputs, revisions gormmodel.ProfileRevisions) error {
+	if err := tx.Where("job_id = ?", jobID).Delete(&gormmodel.JobOutput{}).Error; err != nil {
+		instance.log().Error(err, "Error found when trying to delete job outputs")
+		return errors.Wrapf(err, "deleting the outputs of job %v", jobID)
+	}
+	for _, output := range outputs {
+		row := &gormmodel.JobOutput{JobID: jobID, ProfileID: output.ProfileID, TargetID: output.TargetID, ProfileRevision: revisions[output.ProfileID]}
+		if err := tx.Create(row).Error; err != nil {
+			instance.log().Error(err, "Error found when trying to save job outputs")
+			return errors.Wrapf(err, "saving the outputs of job %v", jobID)
+		}
+	}
 	return nil
 }
 
-func (instance *gormJobRepository) Delete(id int) error {
-	logger.Infof("Deleting job with Id: %d", id)
-	result := instance.db.Delete(&gormmodel.Job{ID: id})
-	if result.Error != nil {
-		logger.Error(result.Error, "Error found when deleting job")
-		return errors.Wrapf(result.Error, "deleting job %v", id)
+func (instance *gormJobRepository) recordStatusChange(tx *gorm.DB, jobID int, from model.Status, to gormmodel.JobStatus) error {
+	change := &gormmodel.JobStatusHistory{JobID: jobID, FromStatus: from, ToStatus: to.Status, Reason: to.Message}
+	if err := tx.Create(change).Error; err != nil {
+		instance.log().Error(err, "Error found when trying to record a status change")
+		return errors.Wrapf(err, "recording the status change of job %v from %s to %s", jobID, from, to.Status)
 	}
-	if result.RowsAffected == 0 {
-		logger.Warnf("Attempting to delete record that was not found")
-		return errors.Wrapf(repository.ErrEntityNotFound, "deleting job %v", id)
+	return nil
+}
+
+func (instance *gormJobRepository) Create(job *model.Job) error {
+	instance.log().Infof("Creating Job %+v", job)
+	gormJob := &gormmodel.JobRecord{Job: *gormmodel.ToGormJob(job), Version: 1}
+	err := repository.TransactionContext(instance.ctx, instance.resolver.Primary(), func(tx *gorm.DB) error {
+		revisions, err := instance.pinProfileRevisions(tx, gormJob.Outputs, nil)
+		if err != nil {
+			return err
+		}
+		gormJob.ProfileRevisions = revisions
+		result := tx.Create(gormJob)
+		instance.log().Infof("Affected rows: %d", result.RowsAffected)
+		if result.Error != nil {
+			instance.log().Error(result.Error, "Error found when trying create job")
+			return errors.Wrapf(result.Error, "creating job %v", safeGetJobID(job))
+		}
+		if err := instance.saveOutputs(tx, gormJob.ID, gormJob.Outputs, gormJob.ProfileRevisions); err != nil {
+			return err
+		}
+		if err := instance.recordStatusChange(tx, gormJob.ID, "", gormJob.Status); err != nil {
+			return err
+		}
+		return instance.audit(tx, model.AuditCreate, gormJob.ID, nil, gormmodel.ToJob(&gormJob.Job))
+	})
+	if err != nil {
+		return err
 	}
+	*job = *gormmodel.ToJob(&gormJob.Job)
 	return nil
 }
 
-func (instance *gormJobRepository) addFilters(filters *JobFilter) *gorm.DB {
-	var dbInstance *gorm.DB
+func (instance *gormJobRepository) Delete(id int) error {
+	instance.log().Infof("Deleting job with Id: %d", id)
+	return repository.TransactionContext(instance.ctx, instance.resolver.Primary(), func(tx *gorm.DB) error {
+		current := &gormmodel.JobRecord{}
+		err := repository.ForUpdate(tx, false).First(current, id).Error
+		if gorm.IsRecordNotFoundError(err) {
+			instance.log().Warnf("Attempting to delete record that was not found")
+			return errors.Wrapf(repository.ErrEntityNotFound, "deleting job %v", id)
+		}
+		if err != nil {
+			instance.log().Error(err, "Error found when trying to read the record to delete")
+			return errors.Wrapf(err, "deleting job %v", id)
+		}
+		result := tx.Delete(&gormmodel.JobRecord{Job: gormmodel.Job{ID: id}})
+		if result.Error != nil {
+			instance.log().Error(result.Error, "Error found when deleting job")
+			return errors.Wrapf(result.Error, "deleting job %v", id)
+		}
+		if result.RowsAffected == 0 {
+			instance.log().Warnf("Attempting to delete record that was not found")
+			return errors.Wrapf(repository.ErrEntityNotFound, "deleting job %v", id)
+		}
+		return instance.audit(tx, model.AuditDelete, id, gormmodel.ToJob(&current.Job), nil)
+	})
+}
 
-	dbInstance = instance.db
+// audit records the change of the job in the audit trail, within the transaction making the change.
+func (instance *gormJobRepository) audit(tx *gorm.DB, action model.AuditAction, id int, before *model.Job, after *model.Job) error {
+	err := audit.Record(instance.ctx, tx, &audit.Change{EntityType: audit.EntityJob, EntityID: id, Action: action, Before: before, After: after})
+	if err != nil {
+		instance.log().Error(err, "Error found when trying to audit a job change")
+	}
+	return err
+}
+
+func (instance *gormJobRepository) Restore(id int) error {
+	instance.log().Infof("Restoring job with Id: %d", id)
+	return repository.TransactionContext(instance.ctx, instance.resolver.Primary(), func(tx *gorm.DB) error {
+		if err := repository.Restore(tx, &gormmodel.JobRecord{}, id); err != nil {
+			return err
+		}
+		restored := &gormmodel.JobRecord{}
+		if err := tx.First(restored, id).Error; err != nil {
+			instance.log().Error(err, "Error found when trying to read the restored record")
+			return errors.Wrapf(err, "restoring job %v", id)
+		}
+		return instance.audit(tx, model.AuditRestore, id, nil, gormmodel.ToJob(&restored.Job))
+	})
+}
 
+func (instance *gormJobRepository) Purge(olderThan time.Duration) (int, error) {
+	instance.log().Infof("Purging jobs deleted more than %v ago", olderThan)
+	purged, err := repository.Purge(instance.ctx, instance.resolver.Primary(), &gormmodel.JobRecord{}, time.Now().Add(-olderThan))
+	if err != nil {
+		instance.log().Error(err, "Error found when purging jobs")
+	}
+	return purged, err
+}
+
+func addFilters(dbInstance *gorm.DB, filters *JobFilter) *gorm.DB {
 	if filters != nil {
 		if filters.Priority != nil {
 			dbInstance = dbInstance.Where("priority = ?", filters.Priority)
 		}
 
 		if filters.Status != nil {
-			dbInstance = dbInstance.Where("status->>'status' = ?", filters.Status)
+			dbInstance = dbInstance.Where(repository.DialectOf(dbInstance).JSONText("status", "status")+" = ?", filters.Status)
+		}
+		if len(filters.Statuses) > 0 {
+			dbInstance = dbInstance.Where(repository.DialectOf(dbInstance).JSONText("status", "status")+" IN (?)", filters.Statuses)
+		}
+		if filters.MinPriority != nil {
+			dbInstance = dbInstance.Where("priority >= ?", *filters.MinPriority)
+		}
+		if filters.MaxPriority != nil {
+			dbInstance = dbInstance.Where("priority <= ?", *filters.MaxPriority)
 		}
+		dbInstance = addTimeRange(dbInstance, "created_at", filters.Created)
+		dbInstance = addTimeRange(dbInstance, "updated_at", filters.Updated)
+		if filters.SourcePathPrefix != "" {
+			dbInstance = dbInstance.Where(`source_path LIKE ? ESCAPE '\'`, escapeLike(filters.SourcePathPrefix)+"%")
+		}
+		if filters.ProfileID != nil {
+			dbInstance = dbInstance.Where("id IN (SELECT job_id FROM job_outputs WHERE profile_id = ?)", *filters.ProfileID)
+		}
+		if filters.TargetID != nil {
+			dbInstance = dbInstance.Where("id IN (SELECT job_id FROM job_outputs WHERE target_id = ?)", *filters.TargetID)
+		}
+	}
+	return dbInstance
+}
+
+func addTimeRange(dbInstance *gorm.DB, column string, timeRange TimeRange) *gorm.DB {
+	if timeRange.From != nil {
+		dbInstance = dbInstance.Where(column+" >= ?", timeRange.From.UTC())
+	}
+	if timeRange.To != nil {
+		dbInstance = dbInstance.Where(column+" < ?", timeRange.To.UTC())
 	}
 	return dbInstance
 }
 
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike escapes the LIKE wildcards of value, so that it only matches itself.
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+func toVersionedJob(job *gormmodel.JobRecord) *VersionedJob {
+	versioned := &VersionedJob{Job: gormmodel.ToJob(&job.Job), Version: job.Version, ProfileRevisions: job.ProfileRevisions}
+	if job.LeaseOwner != "" && job.LeaseExpiresAt != nil {
+		versioned.Lease = &Lease{Owner: job.LeaseOwner, ExpiresAt: *job.LeaseExpiresAt}
+	}
+	return versioned
+}
+
 func safeGetJobID(job *model.Job) string {
 	if job != nil {
 		return fmt.Sprintf("%v", job.ID)
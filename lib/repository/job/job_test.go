@@ -1,18 +1,24 @@
 package job
 
 import (
+	"context"
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
 	"github.com/EurosportDigital/global-transcoding-platform/lib/logger"
 	repositories "github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/audit"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/routing"
 	"github.com/EurosportDigital/global-transcoding-platform/model"
 	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
 	"github.com/jinzhu/gorm"
 	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -42,6 +48,23 @@ func (suite *JobTestSuite) SetupTest() {
 	suite.tableName = "jobs"
 }
 
+// recordingResolver counts which connection the repository asked for.
+type recordingResolver struct {
+	database *gorm.DB
+	primary  int
+	replica  int
+}
+
+func (r *recordingResolver) Primary() *gorm.DB {
+	r.primary++
+	return r.database
+}
+
+func (r *recordingResolver) Replica() *gorm.DB {
+	r.replica++
+	return r.database
+}
+
 func (suite *JobTestSuite) TearDownTest() {
 	suite.database.Close()
 }
@@ -57,6 +80,7 @@ func buildJobPayload(id int, priority int, status model.Status) map[string]inter
 		"preroll_path":  "s3://mock-bucket/mockpreroll",
 		"postroll_path": "s3://mock-bucket/mockpostroll",
 		"outputs":       outputs,
+		"version":       1,
 	}
 }
 
@@ -78,7 +102,7 @@ func newMockJob(id int) *model.Job {
 }
 
 func (suite *JobTestSuite) TestGet() {
-	query := fmt.Sprintf(`SELECT * FROM "%[1]s"  WHERE ("%[1]s"."id" = 1) ORDER BY "%[1]s"."id" ASC LIMIT 1`, suite.tableName)
+	query := fmt.Sprintf(`SELECT * FROM "%[1]s"  WHERE "%[1]s"."deleted_at" IS NULL AND (("%[1]s"."id" = 1)) ORDER BY "%[1]s"."id" ASC LIMIT 1`, suite.tableName)
 	suite.Run("Should return get", func() {
 		mocket.Catcher.Reset()
 		logger.Infof("Query  %s", query)
@@ -126,9 +150,9 @@ func (suite *JobTestSuite) TestGet() {
 	})
 }
 func (suite *JobTestSuite) TestList() {
-	countQuery := fmt.Sprintf(`SELECT count(*) FROM "%s"`, suite.tableName)
+	countQuery := fmt.Sprintf(`SELECT count(*) FROM "%[1]s"  WHERE "%[1]s"."deleted_at" IS NULL`, suite.tableName)
 	countQueryWithFilter := fmt.Sprintf(`SELECT count(*) FROM "%s"  WHERE`, suite.tableName)
-	listQuery := fmt.Sprintf(`SELECT * FROM "%s"   LIMIT 3 OFFSET 0`, suite.tableName)
+	listQuery := fmt.Sprintf(`SELECT * FROM "%[1]s"  WHERE "%[1]s"."deleted_at" IS NULL ORDER BY id ASC LIMIT 3 OFFSET 0`, suite.tableName)
 	listQueryWithFilter := fmt.Sprintf(`SELECT * FROM "%s"  WHERE`, suite.tableName)
 
 	suite.Run("Should return list with pagination", func() {
@@ -178,6 +202,30 @@ func (suite *JobTestSuite) TestList() {
 		suite.Require().Equal(3, len(result.Results), "Calling get should return a result")
 	})
 
+	suite.Run("Should translate every filter into SQL", func() {
+		mocket.Catcher.Reset()
+		low, high, profile, target := 1, 5, 10, 20
+		from, to := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+		mocket.Catcher.NewMock().WithQuery(`SELECT count(*)`).WithReply([]map[string]interface{}{buildCountPayload(0)})
+		list := mocket.Catcher.NewMock().WithQuery(`SELECT * FROM "jobs"  WHERE "jobs"."deleted_at" IS NULL AND ((status->>'status' IN (ready,failed)) AND (priority >= 1) AND (priority <= 5) ` +
+			`AND (created_at >= 2020-01-01 00:00:00 +0000 UTC) AND (created_at < 2020-02-01 00:00:00 +0000 UTC) ` +
+			// go-mocket formats the arguments into the query, which garbles those after the LIKE pattern.
+			`AND (updated_at >= 2020-01-01 00:00:00 +0000 UTC) AND (source_path LIKE s3://bucket\_a/%`)
+		filter := &JobFilter{
+			Statuses:         []model.Status{model.StatusReady, model.StatusFailed},
+			MinPriority:      &low,
+			MaxPriority:      &high,
+			Created:          TimeRange{From: &from, To: &to},
+			Updated:          TimeRange{From: &from},
+			SourcePathPrefix: "s3://bucket_a/",
+			ProfileID:        &profile,
+			TargetID:         &target,
+		}
+		_, err := suite.repository.All(filter, &JobPagination{Size: 3, Page: 1})
+		suite.Require().NoError(err)
+		suite.Require().True(list.Triggered)
+	})
+
 	suite.Run("Should return empty list", func() {
 		mocket.Catcher.Reset()
 		paginationMock := &JobPagination{Size: 3, Page: 1}
@@ -215,10 +263,12 @@ func (suite *JobTestSuite) TestList() {
 }
 
 func (suite *JobTestSuite) TestDelete() {
-	query := fmt.Sprintf(`DELETE FROM "%[1]s"  WHERE "%[1]s"."id" = ?`, suite.tableName)
+	selectQuery := fmt.Sprintf(`SELECT * FROM "%[1]s"  WHERE "%[1]s"."deleted_at" IS NULL AND (("%[1]s"."id" = 1))`, suite.tableName)
+	query := fmt.Sprintf(`UPDATE "%[1]s" SET "deleted_at"=?  WHERE "%[1]s"."deleted_at" IS NULL AND "%[1]s"."id" = ?`, suite.tableName)
 	logger.Infof("Query  %s", query)
 	suite.Run("Should return delete", func() {
 		mocket.Catcher.Reset()
+		mocket.Catcher.NewMock().WithQuery(selectQuery).WithReply([]map[string]interface{}{buildJobPayload(1, 3, model.StatusFailed)})
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
 				Pattern:      query,
@@ -236,6 +286,7 @@ func (suite *JobTestSuite) TestDelete() {
 	})
 	suite.Run("Should return errors if any", func() {
 		mocket.Catcher.Reset()
+		mocket.Catcher.NewMock().WithQuery(selectQuery).WithReply([]map[string]interface{}{buildJobPayload(1, 3, model.StatusFailed)})
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
 				Pattern: query,
@@ -249,54 +300,118 @@ func (suite *JobTestSuite) TestDelete() {
 }
 
 func (suite *JobTestSuite) TestUpdate() {
-	job := &model.Job{
-		ID:           2,
-		Priority:     3,
-		Status:       model.JobStatus{Status: model.StatusFailed},
-		SourcePath:   "s3://mock-bucket/mock",
-		PrerollPath:  "s3://mock-bucket/mockpreroll",
-		PostrollPath: "s3://mock-bucket/mockpostroll",
-	}
+	job := &VersionedJob{Job: newMockJob(2), Version: 1}
+	selectQuery := fmt.Sprintf(`SELECT * FROM "%[1]s"  WHERE "%[1]s"."deleted_at" IS NULL AND (("%[1]s"."id" = 2))`, suite.tableName)
 	query := fmt.Sprintf(`UPDATE "%[1]s"`, suite.tableName)
+	historyQuery := `INSERT INTO "job_status_history"`
 	logger.Infof("Query  %s", query)
 	suite.Run("Should return update", func() {
 		mocket.Catcher.Reset()
-		mocket.Catcher.Attach([]*mocket.FakeResponse{
-			{
-				Pattern:      query,
-				RowsAffected: 1,
-				Once:         true,
-			},
-		})
-		err := suite.repository.Update(job)
+		mocket.Catcher.NewMock().WithQuery(selectQuery).WithReply([]map[string]interface{}{buildJobPayload(2, 3, model.StatusFailed)})
+		update := mocket.Catcher.NewMock().WithQuery(query).WithRowsNum(1)
+		history := mocket.Catcher.NewMock().WithQuery(historyQuery)
+		updated := *job
+		err := suite.repository.UpdateVersioned(&updated)
 		suite.Require().NoError(err, "Invoking method should not produce an error")
+		suite.Require().True(update.Triggered)
+		suite.Require().False(history.Triggered, "Nothing is recorded when the status does not change")
+		suite.Require().Equal(2, updated.Version)
 	})
-	suite.Run("Should fail if no records are updated", func() {
+	suite.Run("Should record a status change", func() {
 		mocket.Catcher.Reset()
-		mocket.Catcher.Attach([]*mocket.FakeResponse{
-			{
-				Pattern:      query,
-				RowsAffected: 0,
-				Once:         true,
-			},
+		mocket.Catcher.NewMock().WithQuery(selectQuery).WithReply([]map[string]interface{}{buildJobPayload(2, 3, model.StatusProcessing)})
+		mocket.Catcher.NewMock().WithQuery(query).WithRowsNum(1)
+		var recorded []interface{}
+		mocket.Catcher.NewMock().WithQuery(historyQuery).WithCallback(func(_ string, args []driver.NamedValue) {
+			for _, arg := range args[:4] {
+				recorded = append(recorded, arg.Value)
+			}
 		})
-		err := suite.repository.Update(job)
+		failed := &VersionedJob{Job: newMockJob(2), Version: 1}
+		failed.Status.Message = "Encoder unreachable"
+		err := suite.repository.UpdateVersioned(failed)
+		suite.Require().NoError(err, "Invoking method should not produce an error")
+		suite.Require().Equal([]interface{}{int64(2), "processing", "failed", "Encoder unreachable"}, recorded)
+	})
+	suite.Run("Should reject a status change the state machine does not allow", func() {
+		mocket.Catcher.Reset()
+		mocket.Catcher.NewMock().WithQuery(selectQuery).WithReply([]map[string]interface{}{buildJobPayload(2, 3, model.StatusCompleted)})
+		update := mocket.Catcher.NewMock().WithQuery(query).WithRowsNum(1)
+		err := suite.repository.UpdateVersioned(job)
+		suite.Require().EqualError(errors.Cause(err), ErrInvalidTransition.Error())
+		suite.Require().False(update.Triggered)
+	})
+	suite.Run("Should fail if the job does not exist", func() {
+		mocket.Catcher.Reset()
+		err := suite.repository.UpdateVersioned(job)
 		suite.Require().EqualError(errors.Cause(err), repositories.ErrEntityNotFound.Error(), "Error shouldn't be different than expected")
 	})
-	suite.Run("Should return errors if any", func() {
+	suite.Run("Should fail with a conflict if the job was modified since it was read", func() {
 		mocket.Catcher.Reset()
-		mocket.Catcher.Attach([]*mocket.FakeResponse{
-			{
-				Pattern: query,
-				Once:    true,
-				Error:   mockError,
-			},
+		current := buildJobPayload(2, 3, model.StatusFailed)
+		current["version"] = 5
+		mocket.Catcher.NewMock().WithQuery(selectQuery).WithReply([]map[string]interface{}{current})
+		update := mocket.Catcher.NewMock().WithQuery(query).WithRowsNum(1)
+		stale := &VersionedJob{Job: newMockJob(2), Version: 4}
+		err := suite.repository.UpdateVersioned(stale)
+		suite.Require().False(update.Triggered)
+		suite.Require().EqualError(errors.Cause(err), repositories.ErrConflict.Error())
+		suite.Require().Equal(4, stale.Version, "The version is left untouched on failure")
+	})
+	suite.Run("Should overwrite the job whatever its version through the deprecated Update", func() {
+		mocket.Catcher.Reset()
+		current := buildJobPayload(2, 3, model.StatusFailed)
+		current["version"] = 5
+		mocket.Catcher.NewMock().WithQuery(selectQuery).WithReply([]map[string]interface{}{current})
+		var version interface{}
+		mocket.Catcher.NewMock().WithQuery(`((version = ?))`).WithRowsNum(1).WithCallback(func(_ string, args []driver.NamedValue) {
+			version = args[len(args)-1].Value
 		})
-		err := suite.repository.Update(job)
+		err := suite.repository.Update(newMockJob(2))
+		suite.Require().NoError(err)
+		suite.Require().EqualValues(5, version, "The job is updated from its current version")
+	})
+	suite.Run("Should fail with a conflict if the job is modified while updating", func() {
+		mocket.Catcher.Reset()
+		mocket.Catcher.NewMock().WithQuery(selectQuery).WithReply([]map[string]interface{}{buildJobPayload(2, 3, model.StatusFailed)})
+		update := mocket.Catcher.NewMock().WithQuery(`"jobs"."deleted_at" IS NULL AND "jobs"."id" = ? AND ((version = ?))`).WithRowsNum(0)
+		err := suite.repository.UpdateVersioned(job)
+		suite.Require().True(update.Triggered)
+		suite.Require().EqualError(errors.Cause(err), repositories.ErrConflict.Error())
+	})
+	suite.Run("Should return errors if any", func() {
+		mocket.Catcher.Reset()
+		mocket.Catcher.NewMock().WithQuery(selectQuery).WithReply([]map[string]interface{}{buildJobPayload(2, 3, model.StatusFailed)})
+		mocket.Catcher.NewMock().WithQuery(query).WithError(mockError)
+		err := suite.repository.UpdateVersioned(job)
 		suite.Require().EqualError(errors.Cause(err), mockError.Error(), "Invoking method should not produce an error")
 	})
 }
 
+func (suite *JobTestSuite) TestHistory() {
+	query := `SELECT * FROM "job_status_history"  WHERE (job_id = 1) ORDER BY created_at, id`
+	suite.Run("Should return the status changes of the job", func() {
+		mocket.Catcher.Reset()
+		mocket.Catcher.NewMock().WithQuery(query).WithReply([]map[string]interface{}{
+			{"id": 1, "job_id": 1, "from_status": "", "to_status": "ready", "reason": ""},
+			{"id": 2, "job_id": 1, "from_status": "ready", "to_status": "processing", "reason": "Picked by worker"},
+		})
+		history, err := suite.repository.History(1)
+		suite.Require().NoError(err, "Invoking method should not produce an error")
+		suite.Require().Equal([]*model.JobStatusChange{
+			{JobID: 1, To: model.StatusReady},
+			{JobID: 1, From: model.StatusReady, To: model.StatusProcessing, Reason: "Picked by worker"},
+		}, history)
+	})
+	suite.Run("Should return errors if any", func() {
+		mocket.Catcher.Reset()
+		mocket.Catcher.NewMock().WithQuery(query).WithError(mockError)
+		history, err := suite.repository.History(1)
+		suite.Require().Nil(history)
+		suite.Require().EqualError(errors.Cause(err), mockError.Error())
+	})
+}
+
 func (suite *JobTestSuite) TestInsert() {
 	job := newMockJob(2)
 	query := fmt.Sprintf(`INSERT INTO "%s"`, suite.tableName)
@@ -337,8 +452,288 @@ func (suite *JobTestSuite) TestInsert() {
 	})
 }
 
+func (suite *JobTestSuite) TestReadReplicaRouting() {
+	suite.Run("Should read from a replica and write to the primary", func() {
+		mocket.Catcher.Reset()
+		resolver := &recordingResolver{database: suite.database}
+		repository := NewWithResolver(resolver)
+
+		_, _ = repository.Get(1)
+		_, _ = repository.All(nil, &JobPagination{Size: 1, Page: 1})
+		suite.Require().Equal(2, resolver.replica)
+		suite.Require().Equal(0, resolver.primary)
+
+		_ = repository.Create(newMockJob(0))
+		_ = repository.UpdateVersioned(&VersionedJob{Job: newMockJob(1)})
+		_ = repository.Delete(1)
+		suite.Require().Equal(2, resolver.replica)
+		suite.Require().Equal(3, resolver.primary)
+	})
+	suite.Run("Should read from the primary when forced", func() {
+		mocket.Catcher.Reset()
+		resolver := &recordingResolver{database: suite.database}
+
+		_, _ = NewWithResolver(resolver).UsePrimary().Get(1)
+		suite.Require().Equal(0, resolver.replica)
+		suite.Require().Equal(1, resolver.primary)
+	})
+}
+
 // In order for 'go test' to run this suite, we need to create
 // a normal test function and pass our suite to suite.Run
 func TestJobTestSuite(t *testing.T) {
 	suite.Run(t, new(JobTestSuite))
 }
+
+func TestJobRepositoryOnSQLite(t *testing.T) {
+	database := repositorytest.OpenSQLite(t)
+	repository := New(database)
+
+	ready := newMockJob(0)
+	ready.Status.Status = model.StatusReady
+	ready.Outputs = []*model.Output{{ID: 1, ProfileID: 2, TargetID: 3}}
+	require.NoError(t, repository.Create(ready))
+	require.NotZero(t, ready.ID)
+	failed := newMockJob(0)
+	require.NoError(t, repository.Create(failed))
+
+	t.Run("Should get a created job", func(t *testing.T) {
+		job, err := repository.Get(ready.ID)
+		require.NoError(t, err)
+		require.Equal(t, ready, job)
+		versioned, err := repository.GetVersioned(ready.ID)
+		require.NoError(t, err)
+		require.Equal(t, &VersionedJob{Job: ready, Version: 1}, versioned)
+	})
+	t.Run("Should filter jobs on their status", func(t *testing.T) {
+		status := model.StatusFailed
+		result, err := repository.All(&JobFilter{Status: &status}, &JobPagination{Size: 10, Page: 1})
+		require.NoError(t, err)
+		require.Equal(t, 1, result.Total)
+		require.Equal(t, []*model.Job{failed}, result.Results)
+		versioned, err := repository.AllVersioned(&JobFilter{Status: &status}, &JobPagination{Size: 10, Page: 1})
+		require.NoError(t, err)
+		require.Equal(t, []*VersionedJob{{Job: failed, Version: 1}}, versioned.Results)
+	})
+	t.Run("Should update a job", func(t *testing.T) {
+		job := &VersionedJob{Job: failed, Version: 1}
+		job.Status = model.JobStatus{Status: model.StatusReady, Message: "retrying"}
+		require.NoError(t, repository.UpdateVersioned(job))
+		require.Equal(t, 2, job.Version)
+
+		status := model.StatusReady
+		result, err := repository.All(&JobFilter{Status: &status}, &JobPagination{Size: 10, Page: 1})
+		require.NoError(t, err)
+		require.Equal(t, 2, result.Total)
+	})
+	t.Run("Should reject an update based on a stale version", func(t *testing.T) {
+		first, err := repository.GetVersioned(failed.ID)
+		require.NoError(t, err)
+		second, err := repository.GetVersioned(failed.ID)
+		require.NoError(t, err)
+
+		first.Status.Message = "first writer"
+		require.NoError(t, repository.UpdateVersioned(first))
+		second.Status.Message = "second writer"
+		require.True(t, errors.Is(repository.UpdateVersioned(second), repositories.ErrConflict))
+
+		second, err = repository.GetVersioned(failed.ID)
+		require.NoError(t, err)
+		require.Equal(t, "first writer", second.Status.Message)
+		second.Status.Message = "second writer"
+		require.NoError(t, repository.UpdateVersioned(second), "A writer that re-read the job succeeds")
+		require.Equal(t, first.Version+1, second.Version)
+	})
+	t.Run("Should reject a status change the state machine does not allow", func(t *testing.T) {
+		job, err := repository.GetVersioned(ready.ID)
+		require.NoError(t, err)
+		job.Status.Status = model.StatusCompleted
+		require.True(t, errors.Is(repository.UpdateVersioned(job), ErrInvalidTransition))
+
+		job, err = repository.GetVersioned(ready.ID)
+		require.NoError(t, err)
+		require.Equal(t, model.StatusReady, job.Status.Status)
+		require.Equal(t, 1, job.Version)
+	})
+	t.Run("Should record every status change", func(t *testing.T) {
+		job, err := repository.GetVersioned(failed.ID)
+		require.NoError(t, err)
+		job.Status = model.JobStatus{Status: model.StatusProcessing, Message: "picked by worker"}
+		require.NoError(t, repository.UpdateVersioned(job))
+
+		history, err := repository.History(failed.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+		for i, expected := range []model.JobStatusChange{
+			{JobID: failed.ID, To: model.StatusFailed},
+			{JobID: failed.ID, From: model.StatusFailed, To: model.StatusReady, Reason: "retrying"},
+			{JobID: failed.ID, From: model.StatusReady, To: model.StatusProcessing, Reason: "picked by worker"},
+		} {
+			require.False(t, history[i].At.IsZero())
+			expected.At = history[i].At
+			require.Equal(t, expected, *history[i])
+		}
+	})
+	t.Run("Should delete a job", func(t *testing.T) {
+		require.NoError(t, repository.Delete(ready.ID))
+
+		_, err := repository.Get(ready.ID)
+		require.True(t, errors.Is(err, repositories.ErrEntityNotFound))
+		require.True(t, errors.Is(repository.Delete(ready.ID), repositories.ErrEntityNotFound))
+	})
+	t.Run("Should keep deleted jobs out of listings and updates until restored", func(t *testing.T) {
+		result, err := repository.All(nil, &JobPagination{Size: 10, Page: 1})
+		require.NoError(t, err)
+		require.Equal(t, 1, result.Total)
+		result, err = repository.IncludeDeleted().All(nil, &JobPagination{Size: 10, Page: 1})
+		require.NoError(t, err)
+		require.Equal(t, 2, result.Total)
+
+		job, err := repository.IncludeDeleted().GetVersioned(ready.ID)
+		require.NoError(t, err)
+		job.Status.Message = "edited while deleted"
+		require.True(t, errors.Is(repository.UpdateVersioned(job), repositories.ErrEntityNotFound))
+
+		require.NoError(t, repository.Restore(ready.ID))
+		require.NoError(t, repository.UpdateVersioned(job))
+		require.True(t, errors.Is(repository.Restore(ready.ID), repositories.ErrEntityNotFound))
+	})
+	t.Run("Should purge jobs deleted long enough ago", func(t *testing.T) {
+		require.NoError(t, repository.Delete(ready.ID))
+
+		purged, err := repository.Purge(time.Hour)
+		require.NoError(t, err)
+		require.Zero(t, purged)
+		purged, err = repository.Purge(-time.Second)
+		require.NoError(t, err)
+		require.Equal(t, 1, purged)
+
+		_, err = repository.IncludeDeleted().Get(ready.ID)
+		require.True(t, errors.Is(err, repositories.ErrEntityNotFound))
+	})
+	t.Run("Should audit the changes of a job along with their actor", func(t *testing.T) {
+		job, err := repository.GetVersioned(failed.ID)
+		require.NoError(t, err)
+		job.Priority = 9
+		ctx := routing.WithActor(context.Background(), "scheduler")
+		require.NoError(t, repository.WithContext(ctx).UpdateVersioned(job))
+
+		history, err := audit.New(database).History(audit.EntityJob, failed.ID)
+		require.NoError(t, err)
+		last := history[len(history)-1]
+		require.Equal(t, "scheduler", last.Actor)
+		require.Equal(t, map[string]model.FieldChange{"Priority": {Before: json.RawMessage(`3`), After: json.RawMessage(`9`)}}, last.Changes)
+
+		entries, err := audit.New(database).Query(&audit.Filter{EntityType: audit.EntityJob, Action: model.AuditDelete}, 0)
+		require.NoError(t, err)
+		require.Len(t, entries, 2, "The job was deleted, restored and deleted again")
+	})
+}
+
+func TestJobFilterOnSQLite(t *testing.T) {
+	repository := New(repositorytest.OpenSQLite(t))
+	create := func(status model.Status, priority int, sourcePath string, profileID int, targetID int) *model.Job {
+		job := newMockJob(0)
+		job.Status.Status = status
+		job.Priority = priority
+		job.SourcePath = sourcePath
+		job.Outputs = []*model.Output{{ID: 1, ProfileID: profileID, TargetID: targetID}}
+		require.NoError(t, repository.Create(job))
+		return job
+	}
+	listIDs := func(filter *JobFilter) []int {
+		result, err := repository.All(filter, &JobPagination{Size: 10, Page: 1})
+		require.NoError(t, err)
+		ids := []int{}
+		for _, job := range result.Results {
+			ids = append(ids, job.ID)
+		}
+		return ids
+	}
+
+	first := create(model.StatusReady, 1, "s3://bucket_a/first.mp4", 10, 20)
+	created := time.Now()
+	second := create(model.StatusFailed, 5, "s3://bucket-b/second.mp4", 11, 20)
+	third := create(model.StatusProcessing, 9, "s3://bucketXa/third.mp4", 12, 21)
+
+	t.Run("Should match any of the statuses", func(t *testing.T) {
+		require.Equal(t, []int{first.ID, second.ID}, listIDs(&JobFilter{Statuses: []model.Status{model.StatusReady, model.StatusFailed}}))
+	})
+	t.Run("Should match a priority range", func(t *testing.T) {
+		low, high := 2, 9
+		require.Equal(t, []int{second.ID, third.ID}, listIDs(&JobFilter{MinPriority: &low}))
+		require.Equal(t, []int{second.ID}, listIDs(&JobFilter{MinPriority: &low, MaxPriority: &high, Statuses: []model.Status{model.StatusFailed}}))
+	})
+	t.Run("Should match creation and update times", func(t *testing.T) {
+		require.Equal(t, []int{first.ID}, listIDs(&JobFilter{Created: TimeRange{To: &created}}))
+		require.Equal(t, []int{second.ID, third.ID}, listIDs(&JobFilter{Created: TimeRange{From: &created}}))
+
+		updated := time.Now()
+		job, err := repository.GetVersioned(first.ID)
+		require.NoError(t, err)
+		job.Status.Status = model.StatusProcessing
+		require.NoError(t, repository.UpdateVersioned(job))
+		require.Equal(t, []int{first.ID}, listIDs(&JobFilter{Updated: TimeRange{From: &updated}}))
+	})
+	t.Run("Should match a source path prefix literally", func(t *testing.T) {
+		require.Equal(t, []int{first.ID}, listIDs(&JobFilter{SourcePathPrefix: "s3://bucket_a/"}))
+		require.Equal(t, []int{}, listIDs(&JobFilter{SourcePathPrefix: "s3://bucket%"}))
+	})
+	t.Run("Should match the profiles and targets of the outputs", func(t *testing.T) {
+		profile, target := 10, 20
+		require.Equal(t, []int{first.ID}, listIDs(&JobFilter{ProfileID: &profile}))
+		require.Equal(t, []int{first.ID, second.ID}, listIDs(&JobFilter{TargetID: &target}))
+
+		job, err := repository.GetVersioned(third.ID)
+		require.NoError(t, err)
+		job.Outputs = []*model.Output{{ID: 1, ProfileID: 10, TargetID: 22}}
+		require.NoError(t, repository.UpdateVersioned(job))
+		require.Equal(t, []int{first.ID, third.ID}, listIDs(&JobFilter{ProfileID: &profile}))
+		require.Equal(t, []int{first.ID, second.ID}, listIDs(&JobFilter{TargetID: &target}))
+	})
+}
+
+func TestJobProfileRevisionsOnSQLite(t *testing.T) {
+	database := repositorytest.OpenSQLite(t)
+	repository := New(database)
+	createProfile := func(name string, revision int) int {
+		profile := &gormmodel.ProfileRecord{Profile: gormmodel.Profile{Name: name}, Revision: revision}
+		require.NoError(t, database.Create(profile).Error)
+		return profile.ID
+	}
+	outputRevisions := func(jobID int) map[int]int {
+		var outputs []*gormmodel.JobOutput
+		require.NoError(t, database.Find(&outputs, "job_id = ?", jobID).Error)
+		revisions := map[int]int{}
+		for _, output := range outputs {
+			revisions[output.ProfileID] = output.ProfileRevision
+		}
+		return revisions
+	}
+	hls := createProfile("h264-hls", 1)
+
+	job := newMockJob(0)
+	job.Status.Status = model.StatusReady
+	job.Outputs = []*model.Output{{ID: 1, ProfileID: hls, TargetID: 1}, {ID: 2, ProfileID: hls + 100, TargetID: 1}}
+	require.NoError(t, repository.Create(job))
+
+	t.Run("Should pin the job to the latest revision of its profiles", func(t *testing.T) {
+		read, err := repository.GetVersioned(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, map[int]int{hls: 1}, read.ProfileRevisions, "Missing profiles are not pinned")
+		require.Equal(t, map[int]int{hls: 1, hls + 100: 0}, outputRevisions(job.ID))
+	})
+	t.Run("Should keep the pinned revisions when the outputs change", func(t *testing.T) {
+		require.NoError(t, database.Model(&gormmodel.ProfileRecord{}).Where("id = ?", hls).UpdateColumn("revision", 2).Error)
+		dash := createProfile("h265-dash", 4)
+		read, err := repository.GetVersioned(job.ID)
+		require.NoError(t, err)
+		read.Outputs = append(read.Outputs, &model.Output{ID: 3, ProfileID: dash, TargetID: 1})
+		require.NoError(t, repository.UpdateVersioned(read))
+
+		versioned, err := repository.GetVersioned(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, map[int]int{hls: 1, dash: 4}, versioned.ProfileRevisions)
+		require.Equal(t, map[int]int{hls: 1, hls + 100: 0, dash: 4}, outputRevisions(job.ID))
+	})
+}
@@ -0,0 +1,109 @@
+package job
+
+import (
+	stderrors "errors"
+	"fmt"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/jinzhu/gorm"
+)
+
+// ErrLeaseNotHeld is returned when a worker renews or releases a lease it does not hold, or no longer holds.
+var ErrLeaseNotHeld = stderrors.New("Job Lease Not Held")
+
+// Lease is the claim of a worker on a job, see JobRepository.ClaimNext.
+type Lease struct {
+	Owner     string
+	ExpiresAt time.Time
+}
+
+func (instance *gormJobRepository) ClaimNext(workerID string, leaseDuration time.Duration, filter *JobFilter) (*VersionedJob, error) {
+	instance.log().Infof("Claiming the next job for worker %s", workerID)
+	now := time.Now().UTC()
+	expiresAt := now.Add(leaseDuration)
+	claimed := &gormmodel.JobRecord{}
+	err := repository.TransactionContext(instance.ctx, instance.resolver.Primary(), func(tx *gorm.DB) error {
+		status := repository.DialectOf(tx).JSONText("status", "status")
+		err := repository.ForUpdate(addFilters(tx, filter), true).
+			Where(fmt.Sprintf("%[1]s = ? OR (%[1]s = ? AND lease_expires_at < ?)", status), model.StatusReady, model.StatusProcessing, now).
+			Order("priority DESC, id").
+			Take(claimed).Error
+		if gorm.IsRecordNotFoundError(err) {
+			return errors.Wrap(repository.ErrEntityNotFound, "no job to claim")
+		}
+		if err != nil {
+			instance.log().Error(err, "Error found when trying to select a job to claim")
+			return errors.Wrap(err, "selecting a job to claim")
+		}
+
+		from := claimed.Status.Status
+		claimed.Status = gormmodel.JobStatus{Status: model.StatusProcessing, Message: fmt.Sprintf("Claimed by %s", workerID)}
+		result := tx.Model(&gormmodel.JobRecord{}).Where("id = ? AND version = ?", claimed.ID, claimed.Version).Updates(map[string]interface{}{
+			"status":           claimed.Status,
+			"version":          claimed.Version + 1,
+			"lease_owner":      workerID,
+			"lease_expires_at": expiresAt,
+		})
+		if result.Error != nil {
+			instance.log().Error(result.Error, "Error found when trying to claim a job")
+			return errors.Wrapf(result.Error, "claiming job %v", claimed.ID)
+		}
+		if result.RowsAffected == 0 {
+			return errors.Wrapf(repository.ErrConflict, "job %v was claimed concurrently", claimed.ID)
+		}
+		claimed.Version++
+		claimed.LeaseOwner, claimed.LeaseExpiresAt = workerID, &expiresAt
+		if from != model.StatusProcessing {
+			return instance.recordStatusChange(tx, claimed.ID, from, claimed.Status)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	instance.log().Infof("Worker %s claimed job %d until %s", workerID, claimed.ID, expiresAt)
+	return toVersionedJob(claimed), nil
+}
+
+func (instance *gormJobRepository) RenewLease(jobID int, workerID string, leaseDuration time.Duration) error {
+	instance.log().Infof("Renewing the lease of worker %s on job %d", workerID, jobID)
+	now := time.Now().UTC()
+	return instance.updateLease(jobID, workerID, "renewing", func(db *gorm.DB) *gorm.DB {
+		return db.Model(&gormmodel.JobRecord{}).
+			Where("id = ? AND lease_owner = ? AND lease_expires_at >= ?", jobID, workerID, now).
+			Updates(map[string]interface{}{"lease_expires_at": now.Add(leaseDuration)})
+	})
+}
+
+func (instance *gormJobRepository) ReleaseLease(jobID int, workerID string) error {
+	instance.log().Infof("Releasing the lease of worker %s on job %d", workerID, jobID)
+	// An expired lease, rather than none, lets ClaimNext pick the job again if it is still processing.
+	return instance.updateLease(jobID, workerID, "releasing", func(db *gorm.DB) *gorm.DB {
+		return db.Model(&gormmodel.JobRecord{}).
+			Where("id = ? AND lease_owner = ?", jobID, workerID).
+			Updates(map[string]interface{}{"lease_owner": "", "lease_expires_at": time.Now().UTC()})
+	})
+}
+
+// updateLease runs the update of the lease workerID holds on the job, and checks that it updated the job.
+func (instance *gormJobRepository) updateLease(jobID int, workerID string, action string, update func(db *gorm.DB) *gorm.DB) error {
+	var rowsAffected int64
+	err := repository.Run(instance.ctx, instance.resolver.Primary(), func(db *gorm.DB) error {
+		result := update(db)
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		instance.log().Error(err, "Error found when trying to update a job lease")
+		return errors.Wrapf(err, "%s the lease of worker %s on job %v", action, workerID, jobID)
+	}
+	if rowsAffected == 0 {
+		instance.log().Warnf("Worker %s does not hold the lease on job %d", workerID, jobID)
+		return errors.Wrapf(ErrLeaseNotHeld, "%s the lease of worker %s on job %v", action, workerID, jobID)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	repositories "github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/jinzhu/gorm"
+	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimNext(t *testing.T) {
+	mocket.Catcher.Register()
+	database, err := gorm.Open(mocket.DriverName, "connection_string")
+	require.NoError(t, err)
+	defer database.Close()
+	repository := New(database)
+
+	t.Run("Should lock the highest priority claimable job and lease it", func(t *testing.T) {
+		mocket.Catcher.Reset()
+		mocket.Catcher.NewMock().WithQuery(`ORDER BY priority DESC, id LIMIT 1 FOR UPDATE SKIP LOCKED`).
+			WithReply([]map[string]interface{}{buildJobPayload(4, 9, model.StatusReady)})
+		update := mocket.Catcher.NewMock().WithQuery(`UPDATE "jobs" SET "lease_expires_at" = ?, "lease_owner" = ?, "status" = ?, "updated_at" = ?, "version" = ?  WHERE "jobs"."deleted_at" IS NULL AND ((id = ? AND version = ?))`).WithRowsNum(1)
+		history := mocket.Catcher.NewMock().WithQuery(`INSERT INTO "job_status_history"`)
+
+		job, err := repository.ClaimNext("worker-1", time.Minute, nil)
+		require.NoError(t, err)
+		require.True(t, update.Triggered)
+		require.True(t, history.Triggered)
+		require.Equal(t, 4, job.ID)
+		require.Equal(t, 2, job.Version)
+		require.Equal(t, model.StatusProcessing, job.Status.Status)
+		require.Equal(t, "worker-1", job.Lease.Owner)
+	})
+	t.Run("Should return not found when there is no job to claim", func(t *testing.T) {
+		mocket.Catcher.Reset()
+		_, err := repository.ClaimNext("worker-1", time.Minute, nil)
+		require.EqualError(t, errors.Cause(err), repositories.ErrEntityNotFound.Error())
+	})
+	t.Run("Should fail with a conflict if the job is claimed concurrently", func(t *testing.T) {
+		mocket.Catcher.Reset()
+		mocket.Catcher.NewMock().WithQuery(`FOR UPDATE SKIP LOCKED`).
+			WithReply([]map[string]interface{}{buildJobPayload(4, 9, model.StatusReady)})
+		mocket.Catcher.NewMock().WithQuery(`UPDATE "jobs"`).WithRowsNum(0)
+		_, err := repository.ClaimNext("worker-1", time.Minute, nil)
+		require.EqualError(t, errors.Cause(err), repositories.ErrConflict.Error())
+	})
+	t.Run("Should only renew or release leases held by the worker", func(t *testing.T) {
+		mocket.Catcher.Reset()
+		mocket.Catcher.NewMock().WithQuery(`UPDATE "jobs"`).WithRowsNum(0)
+		require.EqualError(t, errors.Cause(repository.RenewLease(4, "worker-2", time.Minute)), ErrLeaseNotHeld.Error())
+		require.EqualError(t, errors.Cause(repository.ReleaseLease(4, "worker-2")), ErrLeaseNotHeld.Error())
+	})
+}
+
+func TestLeasesOnSQLite(t *testing.T) {
+	repository := New(repositorytest.OpenSQLite(t))
+	var ids []int
+	for _, priority := range []int{1, 5, 5} {
+		job := newMockJob(0)
+		job.Priority = priority
+		job.Status.Status = model.StatusReady
+		require.NoError(t, repository.Create(job))
+		ids = append(ids, job.ID)
+	}
+	require.NoError(t, repository.Create(newMockJob(0)), "Failed jobs are never claimed")
+
+	t.Run("Should claim jobs by priority, then by age", func(t *testing.T) {
+		first, err := repository.ClaimNext("worker-1", time.Minute, nil)
+		require.NoError(t, err)
+		require.Equal(t, ids[1], first.ID)
+		require.Equal(t, model.StatusProcessing, first.Status.Status)
+		require.Equal(t, "worker-1", first.Lease.Owner)
+
+		stored, err := repository.GetVersioned(first.ID)
+		require.NoError(t, err)
+		require.Equal(t, first, stored)
+
+		second, err := repository.ClaimNext("worker-2", time.Minute, nil)
+		require.NoError(t, err)
+		require.Equal(t, ids[2], second.ID)
+	})
+	t.Run("Should only claim jobs matching the filter", func(t *testing.T) {
+		priority := 5
+		_, err := repository.ClaimNext("worker-3", time.Minute, &JobFilter{Priority: &priority})
+		require.True(t, errors.Is(err, repositories.ErrEntityNotFound))
+	})
+	t.Run("Should record the claim in the job history", func(t *testing.T) {
+		history, err := repository.History(ids[1])
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		require.Equal(t, model.StatusProcessing, history[1].To)
+		require.Equal(t, "Claimed by worker-1", history[1].Reason)
+	})
+	t.Run("Should renew a lease without changing the job version", func(t *testing.T) {
+		before, err := repository.GetVersioned(ids[1])
+		require.NoError(t, err)
+		require.NoError(t, repository.RenewLease(ids[1], "worker-1", time.Hour))
+		require.True(t, errors.Is(repository.RenewLease(ids[1], "worker-2", time.Hour), ErrLeaseNotHeld))
+
+		after, err := repository.GetVersioned(ids[1])
+		require.NoError(t, err)
+		require.Equal(t, before.Version, after.Version)
+		require.True(t, after.Lease.ExpiresAt.After(before.Lease.ExpiresAt))
+	})
+	t.Run("Should claim processing jobs again once their lease expired", func(t *testing.T) {
+		expired, err := repository.ClaimNext("worker-4", -time.Minute, nil)
+		require.NoError(t, err)
+		require.Equal(t, ids[0], expired.ID)
+
+		reclaimed, err := repository.ClaimNext("worker-5", time.Minute, nil)
+		require.NoError(t, err)
+		require.Equal(t, ids[0], reclaimed.ID)
+		require.Equal(t, "worker-5", reclaimed.Lease.Owner)
+		require.True(t, errors.Is(repository.RenewLease(ids[0], "worker-4", time.Minute), ErrLeaseNotHeld))
+	})
+	t.Run("Should make a released job claimable again", func(t *testing.T) {
+		require.NoError(t, repository.ReleaseLease(ids[2], "worker-2"))
+		require.True(t, errors.Is(repository.ReleaseLease(ids[2], "worker-2"), ErrLeaseNotHeld))
+
+		released, err := repository.GetVersioned(ids[2])
+		require.NoError(t, err)
+		require.Nil(t, released.Lease)
+
+		claimed, err := repository.ClaimNext("worker-6", time.Minute, nil)
+		require.NoError(t, err)
+		require.Equal(t, ids[2], claimed.ID)
+	})
+	t.Run("Should only let the lease owner change the status of a leased job", func(t *testing.T) {
+		job, err := repository.GetVersioned(ids[2])
+		require.NoError(t, err)
+		job.Status = model.JobStatus{Status: model.StatusCancelled, Message: "cancelled"}
+		require.True(t, errors.Is(repository.Update(job.Job), ErrLeaseNotHeld))
+		stolen := *job
+		stolen.Lease = &Lease{Owner: "worker-7"}
+		require.True(t, errors.Is(repository.UpdateVersioned(&stolen), ErrLeaseNotHeld))
+
+		job.Status = model.JobStatus{Status: model.StatusCompleted, Message: "done"}
+		require.NoError(t, repository.UpdateVersioned(job))
+		require.Nil(t, job.Lease, "The lease ends when the job leaves processing")
+		stored, err := repository.GetVersioned(ids[2])
+		require.NoError(t, err)
+		require.Equal(t, job, stored)
+		require.True(t, errors.Is(repository.RenewLease(ids[2], "worker-6", time.Minute), ErrLeaseNotHeld))
+	})
+}
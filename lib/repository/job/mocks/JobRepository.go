@@ -3,10 +3,12 @@
 package mocks
 
 import (
-	job "github.com/EurosportDigital/global-transcoding-platform/lib/repository/job"
-	mock "github.com/stretchr/testify/mock"
+	context "context"
+	time "time"
 
+	job "github.com/EurosportDigital/global-transcoding-platform/lib/repository/job"
 	model "github.com/EurosportDigital/global-transcoding-platform/model"
+	mock "github.com/stretchr/testify/mock"
 )
 
 // JobRepository is an autogenerated mock type for the JobRepository type
@@ -37,6 +39,167 @@ func (_m *JobRepository) All(filters *job.JobFilter, pagination *job.JobPaginati
 	return r0, r1
 }
 
+// AllByCursor provides a mock function with given fields: filters, pagination
+func (_m *JobRepository) AllByCursor(filters *job.JobFilter, pagination *job.JobCursorPagination) (*job.JobCursorResult, error) {
+	ret := _m.Called(filters, pagination)
+
+	var r0 *job.JobCursorResult
+	if rf, ok := ret.Get(0).(func(*job.JobFilter, *job.JobCursorPagination) *job.JobCursorResult); ok {
+		r0 = rf(filters, pagination)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*job.JobCursorResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*job.JobFilter, *job.JobCursorPagination) error); ok {
+		r1 = rf(filters, pagination)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AllByCursorContext provides a mock function with given fields: ctx, filters, pagination
+func (_m *JobRepository) AllByCursorContext(ctx context.Context, filters *job.JobFilter, pagination *job.JobCursorPagination) (*job.JobCursorResult, error) {
+	ret := _m.Called(ctx, filters, pagination)
+
+	var r0 *job.JobCursorResult
+	if rf, ok := ret.Get(0).(func(context.Context, *job.JobFilter, *job.JobCursorPagination) *job.JobCursorResult); ok {
+		r0 = rf(ctx, filters, pagination)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*job.JobCursorResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *job.JobFilter, *job.JobCursorPagination) error); ok {
+		r1 = rf(ctx, filters, pagination)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AllContext provides a mock function with given fields: ctx, filters, pagination
+func (_m *JobRepository) AllContext(ctx context.Context, filters *job.JobFilter, pagination *job.JobPagination) (*job.JobPaginationResult, error) {
+	ret := _m.Called(ctx, filters, pagination)
+
+	var r0 *job.JobPaginationResult
+	if rf, ok := ret.Get(0).(func(context.Context, *job.JobFilter, *job.JobPagination) *job.JobPaginationResult); ok {
+		r0 = rf(ctx, filters, pagination)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*job.JobPaginationResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *job.JobFilter, *job.JobPagination) error); ok {
+		r1 = rf(ctx, filters, pagination)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AllVersioned provides a mock function with given fields: filters, pagination
+func (_m *JobRepository) AllVersioned(filters *job.JobFilter, pagination *job.JobPagination) (*job.VersionedJobPaginationResult, error) {
+	ret := _m.Called(filters, pagination)
+
+	var r0 *job.VersionedJobPaginationResult
+	if rf, ok := ret.Get(0).(func(*job.JobFilter, *job.JobPagination) *job.VersionedJobPaginationResult); ok {
+		r0 = rf(filters, pagination)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*job.VersionedJobPaginationResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*job.JobFilter, *job.JobPagination) error); ok {
+		r1 = rf(filters, pagination)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AllVersionedContext provides a mock function with given fields: ctx, filters, pagination
+func (_m *JobRepository) AllVersionedContext(ctx context.Context, filters *job.JobFilter, pagination *job.JobPagination) (*job.VersionedJobPaginationResult, error) {
+	ret := _m.Called(ctx, filters, pagination)
+
+	var r0 *job.VersionedJobPaginationResult
+	if rf, ok := ret.Get(0).(func(context.Context, *job.JobFilter, *job.JobPagination) *job.VersionedJobPaginationResult); ok {
+		r0 = rf(ctx, filters, pagination)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*job.VersionedJobPaginationResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *job.JobFilter, *job.JobPagination) error); ok {
+		r1 = rf(ctx, filters, pagination)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ClaimNext provides a mock function with given fields: workerID, leaseDuration, filter
+func (_m *JobRepository) ClaimNext(workerID string, leaseDuration time.Duration, filter *job.JobFilter) (*job.VersionedJob, error) {
+	ret := _m.Called(workerID, leaseDuration, filter)
+
+	var r0 *job.VersionedJob
+	if rf, ok := ret.Get(0).(func(string, time.Duration, *job.JobFilter) *job.VersionedJob); ok {
+		r0 = rf(workerID, leaseDuration, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*job.VersionedJob)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, time.Duration, *job.JobFilter) error); ok {
+		r1 = rf(workerID, leaseDuration, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ClaimNextContext provides a mock function with given fields: ctx, workerID, leaseDuration, filter
+func (_m *JobRepository) ClaimNextContext(ctx context.Context, workerID string, leaseDuration time.Duration, filter *job.JobFilter) (*job.VersionedJob, error) {
+	ret := _m.Called(ctx, workerID, leaseDuration, filter)
+
+	var r0 *job.VersionedJob
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Duration, *job.JobFilter) *job.VersionedJob); ok {
+		r0 = rf(ctx, workerID, leaseDuration, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*job.VersionedJob)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Duration, *job.JobFilter) error); ok {
+		r1 = rf(ctx, workerID, leaseDuration, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Create provides a mock function with given fields: _a0
 func (_m *JobRepository) Create(_a0 *model.Job) error {
 	ret := _m.Called(_a0)
@@ -51,6 +214,20 @@ func (_m *JobRepository) Create(_a0 *model.Job) error {
 	return r0
 }
 
+// CreateContext provides a mock function with given fields: ctx, _a1
+func (_m *JobRepository) CreateContext(ctx context.Context, _a1 *model.Job) error {
+	ret := _m.Called(ctx, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Job) error); ok {
+		r0 = rf(ctx, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Delete provides a mock function with given fields: id
 func (_m *JobRepository) Delete(id int) error {
 	ret := _m.Called(id)
@@ -65,6 +242,20 @@ func (_m *JobRepository) Delete(id int) error {
 	return r0
 }
 
+// DeleteContext provides a mock function with given fields: ctx, id
+func (_m *JobRepository) DeleteContext(ctx context.Context, id int) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Get provides a mock function with given fields: id
 func (_m *JobRepository) Get(id int) (*model.Job, error) {
 	ret := _m.Called(id)
@@ -88,6 +279,263 @@ func (_m *JobRepository) Get(id int) (*model.Job, error) {
 	return r0, r1
 }
 
+// GetContext provides a mock function with given fields: ctx, id
+func (_m *JobRepository) GetContext(ctx context.Context, id int) (*model.Job, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *model.Job
+	if rf, ok := ret.Get(0).(func(context.Context, int) *model.Job); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Job)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetVersioned provides a mock function with given fields: id
+func (_m *JobRepository) GetVersioned(id int) (*job.VersionedJob, error) {
+	ret := _m.Called(id)
+
+	var r0 *job.VersionedJob
+	if rf, ok := ret.Get(0).(func(int) *job.VersionedJob); ok {
+		r0 = rf(id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*job.VersionedJob)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(int) error); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetVersionedContext provides a mock function with given fields: ctx, id
+func (_m *JobRepository) GetVersionedContext(ctx context.Context, id int) (*job.VersionedJob, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *job.VersionedJob
+	if rf, ok := ret.Get(0).(func(context.Context, int) *job.VersionedJob); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*job.VersionedJob)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// History provides a mock function with given fields: jobID
+func (_m *JobRepository) History(jobID int) ([]*model.JobStatusChange, error) {
+	ret := _m.Called(jobID)
+
+	var r0 []*model.JobStatusChange
+	if rf, ok := ret.Get(0).(func(int) []*model.JobStatusChange); ok {
+		r0 = rf(jobID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.JobStatusChange)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(int) error); ok {
+		r1 = rf(jobID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// HistoryContext provides a mock function with given fields: ctx, jobID
+func (_m *JobRepository) HistoryContext(ctx context.Context, jobID int) ([]*model.JobStatusChange, error) {
+	ret := _m.Called(ctx, jobID)
+
+	var r0 []*model.JobStatusChange
+	if rf, ok := ret.Get(0).(func(context.Context, int) []*model.JobStatusChange); ok {
+		r0 = rf(ctx, jobID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.JobStatusChange)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, jobID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IncludeDeleted provides a mock function with given fields:
+func (_m *JobRepository) IncludeDeleted() job.JobRepository {
+	ret := _m.Called()
+
+	var r0 job.JobRepository
+	if rf, ok := ret.Get(0).(func() job.JobRepository); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(job.JobRepository)
+		}
+	}
+
+	return r0
+}
+
+// Purge provides a mock function with given fields: olderThan
+func (_m *JobRepository) Purge(olderThan time.Duration) (int, error) {
+	ret := _m.Called(olderThan)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(time.Duration) int); ok {
+		r0 = rf(olderThan)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(time.Duration) error); ok {
+		r1 = rf(olderThan)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PurgeContext provides a mock function with given fields: ctx, olderThan
+func (_m *JobRepository) PurgeContext(ctx context.Context, olderThan time.Duration) (int, error) {
+	ret := _m.Called(ctx, olderThan)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration) int); ok {
+		r0 = rf(ctx, olderThan)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Duration) error); ok {
+		r1 = rf(ctx, olderThan)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReleaseLease provides a mock function with given fields: jobID, workerID
+func (_m *JobRepository) ReleaseLease(jobID int, workerID string) error {
+	ret := _m.Called(jobID, workerID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int, string) error); ok {
+		r0 = rf(jobID, workerID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ReleaseLeaseContext provides a mock function with given fields: ctx, jobID, workerID
+func (_m *JobRepository) ReleaseLeaseContext(ctx context.Context, jobID int, workerID string) error {
+	ret := _m.Called(ctx, jobID, workerID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, string) error); ok {
+		r0 = rf(ctx, jobID, workerID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RenewLease provides a mock function with given fields: jobID, workerID, leaseDuration
+func (_m *JobRepository) RenewLease(jobID int, workerID string, leaseDuration time.Duration) error {
+	ret := _m.Called(jobID, workerID, leaseDuration)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int, string, time.Duration) error); ok {
+		r0 = rf(jobID, workerID, leaseDuration)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RenewLeaseContext provides a mock function with given fields: ctx, jobID, workerID, leaseDuration
+func (_m *JobRepository) RenewLeaseContext(ctx context.Context, jobID int, workerID string, leaseDuration time.Duration) error {
+	ret := _m.Called(ctx, jobID, workerID, leaseDuration)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, string, time.Duration) error); ok {
+		r0 = rf(ctx, jobID, workerID, leaseDuration)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Restore provides a mock function with given fields: id
+func (_m *JobRepository) Restore(id int) error {
+	ret := _m.Called(id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int) error); ok {
+		r0 = rf(id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RestoreContext provides a mock function with given fields: ctx, id
+func (_m *JobRepository) RestoreContext(ctx context.Context, id int) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Update provides a mock function with given fields: _a0
 func (_m *JobRepository) Update(_a0 *model.Job) error {
 	ret := _m.Called(_a0)
@@ -101,3 +549,77 @@ func (_m *JobRepository) Update(_a0 *model.Job) error {
 
 	return r0
 }
+
+// UpdateContext provides a mock function with given fields: ctx, _a1
+func (_m *JobRepository) UpdateContext(ctx context.Context, _a1 *model.Job) error {
+	ret := _m.Called(ctx, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Job) error); ok {
+		r0 = rf(ctx, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateVersioned provides a mock function with given fields: _a0
+func (_m *JobRepository) UpdateVersioned(_a0 *job.VersionedJob) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*job.VersionedJob) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateVersionedContext provides a mock function with given fields: ctx, _a1
+func (_m *JobRepository) UpdateVersionedContext(ctx context.Context, _a1 *job.VersionedJob) error {
+	ret := _m.Called(ctx, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *job.VersionedJob) error); ok {
+		r0 = rf(ctx, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UsePrimary provides a mock function with given fields:
+func (_m *JobRepository) UsePrimary() job.JobRepository {
+	ret := _m.Called()
+
+	var r0 job.JobRepository
+	if rf, ok := ret.Get(0).(func() job.JobRepository); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(job.JobRepository)
+		}
+	}
+
+	return r0
+}
+
+// WithContext provides a mock function with given fields: ctx
+func (_m *JobRepository) WithContext(ctx context.Context) job.JobRepository {
+	ret := _m.Called(ctx)
+
+	var r0 job.JobRepository
+	if rf, ok := ret.Get(0).(func(context.Context) job.JobRepository); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(job.JobRepository)
+		}
+	}
+
+	return r0
+}
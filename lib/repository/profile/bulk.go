@@ -0,0 +1,350 @@
+package profile
+
+import (
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/audit"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/validation"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+func (profileRepo *gormRepository) CreateMany(profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error) {
+	operation := repository.NewBulkOperation(mode, len(profiles))
+	for i, profile := range profiles {
+		if err := validation.ValidateProfile(profile); err != nil {
+			operation.Fail(i, errors.WithMessage(err, "unable to create profile"))
+		}
+	}
+
+	names := make([]string, len(profiles))
+	for i, profile := range profiles {
+		names[i] = profile.Name
+	}
+
+	created := make(map[int]*gormmodel.ProfileRecord, len(profiles))
+	results, err := operation.Run(profileRepo.ctx, profileRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		if err := failTakenNames(tx, operation, make([]int, len(profiles)), names); err != nil {
+			return err
+		}
+		records := make([]*gormmodel.ProfileRecord, len(profiles))
+		configs := make(map[int]*gormmodel.EncoderConfig)
+		for _, i := range operation.Succeeded() {
+			gormProfile := gormmodel.ToGormProfile(profiles[i])
+			if config := gormProfile.EncConfig; config.ID == 0 {
+				// Like Create, the encoder config is only added when it does not refer to an existing one.
+				configs[i] = config
+			}
+			gormProfile.ID, gormProfile.EncConfig = 0, nil
+			records[i] = &gormmodel.ProfileRecord{Profile: *gormProfile, Revision: 1}
+		}
+		encoders, err := insertEncoderConfigs(tx, operation, configs)
+		if err != nil {
+			return err
+		}
+		for i, config := range configs {
+			records[i].EncoderConfigID = config.ID
+		}
+
+		items := operation.Succeeded()
+		inserted := make([]interface{}, len(items))
+		for j, i := range items {
+			inserted[j] = records[i]
+		}
+		if err := operation.Insert(tx, items, inserted); err != nil {
+			return err
+		}
+		if err := deleteEncoderConfigs(tx, operation, configs, encoders); err != nil {
+			return err
+		}
+		ids := make([]int, 0, len(items))
+		for _, i := range operation.Succeeded() {
+			ids = append(ids, records[i].ID)
+		}
+		found, err := getProfiles(tx, ids)
+		if err != nil {
+			return err
+		}
+		var changes []*audit.Change
+		for _, i := range operation.Succeeded() {
+			operation.SetID(i, records[i].ID)
+			created[i] = found[records[i].ID]
+			changes = append(changes, change(model.AuditCreate, records[i].ID, nil, gormmodel.ToProfile(&created[i].Profile)))
+		}
+		if err := recordRevisions(tx, found); err != nil {
+			return err
+		}
+		return profileRepo.auditMany(tx, changes)
+	})
+	if err != nil {
+		return results, err
+	}
+	for i, record := range created {
+		*profiles[i] = *gormmodel.ToProfile(&record.Profile)
+	}
+	return results, nil
+}
+
+func (profileRepo *gormRepository) UpdateMany(profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error) {
+	operation := repository.NewBulkOperation(mode, len(profiles))
+	ids := make([]int, len(profiles))
+	for i, profile := range profiles {
+		ids[i] = profile.ID
+	}
+	operation.FailDuplicates(ids)
+
+	return operation.Run(profileRepo.ctx, profileRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		before, err := lockProfiles(tx, operation, ids)
+		if err != nil {
+			return err
+		}
+		// The update only sets the non-empty fields, so the profiles are validated as merged with their locked rows
+		// before any of them is written.
+		names := make([]string, len(profiles))
+		for _, i := range operation.Succeeded() {
+			updated := mergeProfile(gormmodel.ToProfile(&before[ids[i]].Profile), profiles[i])
+			if err := validation.ValidateProfile(updated); err != nil {
+				if err := operation.Fail(i, errors.WithMessagef(err, "unable to update profile %v", ids[i])); err != nil {
+					return err
+				}
+			}
+			names[i] = updated.Name
+		}
+		if err := failTakenNames(tx, operation, ids, names); err != nil {
+			return err
+		}
+		// Every profile sets its own non-empty fields, so each one takes an UPDATE of its own rather than sharing one
+		// UPDATE ... FROM (VALUES ...) statement, which would have to set every column of every row.
+		for _, i := range operation.Succeeded() {
+			err := operation.Item(tx, i, func(tx *gorm.DB) error {
+				gormProfile := gormmodel.ToGormProfile(profiles[i])
+				if err := updateProfile(tx, gormProfile); err != nil {
+					return errors.Wrapf(err, "unable to update profile %v", profiles[i])
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		items := operation.Succeeded()
+		if len(items) == 0 {
+			return nil
+		}
+		updated := make([]int, len(items))
+		for j, i := range items {
+			updated[j] = ids[i]
+		}
+		err = tx.Model(&gormmodel.ProfileRecord{}).Where("id IN (?)", updated).UpdateColumn("revision", gorm.Expr("revision + 1")).Error
+		if err != nil {
+			return errors.Wrapf(err, "unable to increment the revision of profiles %v", updated)
+		}
+		after, err := getProfiles(tx, updated)
+		if err != nil {
+			return err
+		}
+		if err := recordRevisions(tx, after); err != nil {
+			return err
+		}
+		changes := make([]*audit.Change, len(items))
+		for j, i := range items {
+			operation.SetID(i, ids[i])
+			changes[j] = change(model.AuditUpdate, ids[i], gormmodel.ToProfile(&before[ids[i]].Profile), gormmodel.ToProfile(&after[ids[i]].Profile))
+		}
+		return profileRepo.auditMany(tx, changes)
+	})
+}
+
+func (profileRepo *gormRepository) DeleteMany(ids []int, mode repository.BulkMode) (repository.BulkResults, error) {
+	operation := repository.NewBulkOperation(mode, len(ids))
+	operation.FailDuplicates(ids)
+
+	return operation.Run(profileRepo.ctx, profileRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		before, err := lockProfiles(tx, operation, ids)
+		if err != nil {
+			return err
+		}
+		items := operation.Succeeded()
+		if len(items) == 0 {
+			return nil
+		}
+		deleted := make([]int, len(items))
+		for j, i := range items {
+			deleted[j] = ids[i]
+		}
+		result := tx.Where("id IN (?)", deleted).Delete(&gormmodel.ProfileRecord{})
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "unable to delete profiles %v", deleted)
+		}
+		if result.RowsAffected != int64(len(deleted)) {
+			return errors.Wrapf(repository.ErrEntityNotFound, "deleted %d of profiles %v", result.RowsAffected, deleted)
+		}
+		changes := make([]*audit.Change, len(items))
+		for j, i := range items {
+			operation.SetID(i, ids[i])
+			changes[j] = change(model.AuditDelete, ids[i], gormmodel.ToProfile(&before[ids[i]].Profile), nil)
+		}
+		return profileRepo.auditMany(tx, changes)
+	})
+}
+
+// getProfiles reads the profiles with the specified IDs, along with their encoder config and encoder, with one
+// statement.
+func getProfiles(tx *gorm.DB, ids []int) (map[int]*gormmodel.ProfileRecord, error) {
+	profiles := make(map[int]*gormmodel.ProfileRecord, len(ids))
+	if len(ids) == 0 {
+		return profiles, nil
+	}
+	var records []*gormmodel.ProfileRecord
+	if err := tx.Preload("EncConfig.Encoder").Find(&records, "id IN (?)", ids).Error; err != nil {
+		return nil, errors.Wrapf(err, "could not find profiles %v", ids)
+	}
+	for _, record := range records {
+		profiles[record.ID] = record
+	}
+	return profiles, nil
+}
+
+// insertEncoderConfigs adds the encoder configs of the items of operation that did not fail yet, configs[i] being the
+// one of item i, along with their encoders that do not refer to existing ones, with multi-row statements. It returns
+// the encoders it added, by item, and the error that stops the operation.
+func insertEncoderConfigs(tx *gorm.DB, operation *repository.BulkOperation, configs map[int]*gormmodel.EncoderConfig) (map[int]*gormmodel.Encoder, error) {
+	var items []int
+	var encoders []interface{}
+	for _, i := range operation.Succeeded() {
+		if config, ok := configs[i]; ok && config.Encoder != nil && config.Encoder.ID == 0 {
+			items = append(items, i)
+			encoders = append(encoders, config.Encoder)
+		}
+	}
+	if err := operation.Insert(tx, items, encoders); err != nil {
+		return nil, err
+	}
+	added := make(map[int]*gormmodel.Encoder, len(items))
+	for _, i := range items {
+		added[i] = configs[i].Encoder
+	}
+
+	items = nil
+	var inserted []interface{}
+	for _, i := range operation.Succeeded() {
+		if config, ok := configs[i]; ok {
+			if config.Encoder != nil {
+				config.EncoderID = config.Encoder.ID
+			}
+			items = append(items, i)
+			inserted = append(inserted, config)
+		}
+	}
+	return added, operation.Insert(tx, items, inserted)
+}
+
+// deleteEncoderConfigs deletes the encoder configs of the items of operation that failed, configs[i] being the one of
+// item i, along with the encoders added for them, encoders[i] being the one of item i. A PartialSuccess operation
+// commits the items that did not fail, so the rows of the failed ones would otherwise be left behind. The rows that
+// were not inserted in the end are not found, and are skipped.
+func deleteEncoderConfigs(tx *gorm.DB, operation *repository.BulkOperation, configs map[int]*gormmodel.EncoderConfig, encoders map[int]*gormmodel.Encoder) error {
+	succeeded := make(map[int]bool, len(configs))
+	for _, i := range operation.Succeeded() {
+		succeeded[i] = true
+	}
+	var configIDs, encoderIDs []int
+	for i, config := range configs {
+		if succeeded[i] {
+			continue
+		}
+		if config.ID != 0 {
+			configIDs = append(configIDs, config.ID)
+		}
+		if encoder, ok := encoders[i]; ok && encoder.ID != 0 {
+			encoderIDs = append(encoderIDs, encoder.ID)
+		}
+	}
+	if len(configIDs) > 0 {
+		if err := tx.Where("id IN (?)", configIDs).Delete(&gormmodel.EncoderConfig{}).Error; err != nil {
+			return errors.Wrapf(err, "unable to delete the encoder configs %v of the failed profiles", configIDs)
+		}
+	}
+	if len(encoderIDs) > 0 {
+		if err := tx.Where("id IN (?)", encoderIDs).Delete(&gormmodel.Encoder{}).Error; err != nil {
+			return errors.Wrapf(err, "unable to delete the encoders %v of the failed profiles", encoderIDs)
+		}
+	}
+	return nil
+}
+
+// failTakenNames fails the items of operation that did not fail yet whose name, names[i] being the one of item i,
+// another profile that is not deleted or an earlier item has, with repository.ErrDuplicate. ids[i] is the ID of the
+// profile item i updates, zero when it creates one. It returns the error that stops the operation.
+func failTakenNames(tx *gorm.DB, operation *repository.BulkOperation, ids []int, names []string) error {
+	items := operation.Succeeded()
+	wanted := make([]string, len(items))
+	for j, i := range items {
+		wanted[j] = names[i]
+	}
+	var existing []*gormmodel.ProfileRecord
+	if err := tx.Select("id, name").Where("name IN (?)", wanted).Find(&existing).Error; err != nil {
+		return errors.Wrapf(err, "unable to check the names of profiles %q", wanted)
+	}
+	taken := make(map[string]int, len(existing))
+	for _, record := range existing {
+		taken[record.Name] = record.ID
+	}
+	listed := make(map[string]bool, len(items))
+	for _, i := range items {
+		var err error
+		if id, ok := taken[names[i]]; ok && id != ids[i] {
+			err = errors.Wrapf(repository.ErrDuplicate, "profile %q already exists", names[i])
+		} else if listed[names[i]] {
+			err = errors.Wrapf(repository.ErrDuplicate, "profile %q is listed more than once", names[i])
+		}
+		if err != nil {
+			if err := operation.Fail(i, err); err != nil {
+				return err
+			}
+			continue
+		}
+		listed[names[i]] = true
+	}
+	return nil
+}
+
+// mergeProfile returns profile as updated with the non-empty fields of update.
+func mergeProfile(profile *model.Profile, update *model.Profile) *model.Profile {
+	merged := *profile
+	if update.Name != "" {
+		merged.Name = update.Name
+	}
+	if update.Codec != "" {
+		merged.Codec = update.Codec
+	}
+	if update.PackageFormat != "" {
+		merged.PackageFormat = update.PackageFormat
+	}
+	return &merged
+}
+
+// lockProfiles reads and locks the profiles of the items of operation that did not fail yet, ids[i] being the ID of
+// item i. The items whose profile does not exist fail with repository.ErrEntityNotFound. It returns the error that
+// stops the operation.
+func lockProfiles(tx *gorm.DB, operation *repository.BulkOperation, ids []int) (map[int]*gormmodel.ProfileRecord, error) {
+	items := operation.Succeeded()
+	wanted := make([]int, len(items))
+	for j, i := range items {
+		wanted[j] = ids[i]
+	}
+	found, err := getProfiles(repository.ForUpdate(tx, false), wanted)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range items {
+		if _, ok := found[ids[i]]; !ok {
+			if err := operation.Fail(i, errors.Wrapf(repository.ErrEntityNotFound, "did not find profile %v", ids[i])); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,182 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/audit"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/validation"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileBulkOnSQLite(t *testing.T) {
+	database := repositorytest.OpenSQLite(t)
+	profileRepo := New(database)
+	all := func() []*model.Profile {
+		profiles, err := profileRepo.All()
+		require.NoError(t, err)
+		ids := make([]int, len(profiles))
+		for i, profile := range profiles {
+			ids[i] = profile.ID
+		}
+		profiles, err = profileRepo.GetMany(ids)
+		require.NoError(t, err)
+		return profiles
+	}
+
+	first := &model.Profile{Name: "h264-hls", Codec: "h264", PackageFormat: "hls", EncConfig: model.EncoderConfig{
+		Name: "default", Config: "{}", Encoder: model.Encoder{Name: "bitmovin"},
+	}}
+	second := &model.Profile{Name: "h265-dash", Codec: "h265", PackageFormat: "dash"}
+	invalid := &model.Profile{Name: "invalid", Codec: "mpeg2", PackageFormat: "hls"}
+
+	t.Run("Should create none of the profiles when one is invalid", func(t *testing.T) {
+		results, err := profileRepo.CreateMany([]*model.Profile{first, invalid}, repository.AllOrNothing)
+		validationError, ok := validation.As(err)
+		require.True(t, ok)
+		require.NotNil(t, validationError.Field("Codec"))
+		require.Equal(t, repository.ErrRolledBack, results[0].Err)
+		require.Zero(t, first.ID)
+		require.Empty(t, all())
+	})
+	t.Run("Should create the valid profiles along with their encoder config", func(t *testing.T) {
+		results, err := profileRepo.CreateMany([]*model.Profile{first, invalid}, repository.PartialSuccess)
+		require.NoError(t, err)
+		require.Equal(t, []int{1}, results.Failed())
+		require.Equal(t, repository.BulkResult{ID: first.ID}, results[0])
+		require.NotZero(t, first.EncConfig.ID)
+		require.NotZero(t, first.EncConfig.Encoder.ID)
+
+		second.EncConfig = first.EncConfig
+		results, err = profileRepo.CreateMany([]*model.Profile{second}, repository.AllOrNothing)
+		require.NoError(t, err)
+		require.Empty(t, results.Failed())
+		require.Equal(t, []*model.Profile{first, second}, all())
+
+		revision, err := profileRepo.GetRevision(second.Name, 1)
+		require.NoError(t, err)
+		require.Equal(t, *second, revision.Profile)
+	})
+	t.Run("Should not create the profiles whose name is taken", func(t *testing.T) {
+		taken := &model.Profile{Name: first.Name, Codec: "vp9", PackageFormat: "hls", EncConfig: first.EncConfig}
+		third := &model.Profile{Name: "vp9-dash", Codec: "vp9", PackageFormat: "dash", EncConfig: first.EncConfig}
+		again := &model.Profile{Name: third.Name, Codec: "av1", PackageFormat: "dash", EncConfig: first.EncConfig}
+		results, err := profileRepo.CreateMany([]*model.Profile{taken, third, again}, repository.PartialSuccess)
+		require.NoError(t, err)
+		require.Equal(t, []int{0, 2}, results.Failed())
+		require.Equal(t, repository.ErrDuplicate, errors.Cause(results[0].Err))
+		require.Equal(t, repository.ErrDuplicate, errors.Cause(results[2].Err))
+
+		require.NoError(t, profileRepo.Delete(third.ID))
+	})
+	t.Run("Should create the encoder configs and encoders of the profiles", func(t *testing.T) {
+		shared := &model.Profile{Name: "vp9-hls", Codec: "vp9", PackageFormat: "hls", EncConfig: model.EncoderConfig{
+			Name: "vp9", Config: "{}", Encoder: first.EncConfig.Encoder,
+		}}
+		added := &model.Profile{Name: "av1-dash", Codec: "av1", PackageFormat: "dash", EncConfig: model.EncoderConfig{
+			Name: "av1", Config: "{}", Encoder: model.Encoder{Name: "aws"},
+		}}
+		results, err := profileRepo.CreateMany([]*model.Profile{shared, added}, repository.AllOrNothing)
+		require.NoError(t, err)
+		require.Empty(t, results.Failed())
+
+		profiles, err := profileRepo.GetMany([]int{shared.ID, added.ID})
+		require.NoError(t, err)
+		require.Equal(t, []*model.Profile{shared, added}, profiles)
+		require.Equal(t, first.EncConfig.Encoder, shared.EncConfig.Encoder, "The existing encoder is shared")
+		require.NotZero(t, added.EncConfig.Encoder.ID)
+		require.NotEqual(t, shared.EncConfig.ID, added.EncConfig.ID)
+
+		_, err = profileRepo.DeleteMany([]int{shared.ID, added.ID}, repository.AllOrNothing)
+		require.NoError(t, err)
+	})
+	t.Run("Should not leave the encoder configs of the profiles that fail to be inserted", func(t *testing.T) {
+		count := func(table string) int {
+			var rows int
+			require.NoError(t, database.Table(table).Count(&rows).Error)
+			return rows
+		}
+		configs, encoders := count("encoder_configs"), count("encoders")
+		require.NoError(t, database.Exec(`CREATE TRIGGER refuse_profile BEFORE INSERT ON profiles WHEN NEW.name = 'refused'
+			BEGIN SELECT RAISE(ABORT, 'refused'); END`).Error)
+		defer func() { require.NoError(t, database.Exec("DROP TRIGGER refuse_profile").Error) }()
+
+		refused := &model.Profile{Name: "refused", Codec: "vp9", PackageFormat: "hls", EncConfig: model.EncoderConfig{
+			Name: "refused", Config: "{}", Encoder: model.Encoder{Name: "refused"},
+		}}
+		kept := &model.Profile{Name: "kept", Codec: "vp9", PackageFormat: "dash", EncConfig: model.EncoderConfig{
+			Name: "kept", Config: "{}", Encoder: first.EncConfig.Encoder,
+		}}
+		results, err := profileRepo.CreateMany([]*model.Profile{refused, kept}, repository.PartialSuccess)
+		require.NoError(t, err)
+		require.Equal(t, []int{0}, results.Failed())
+		require.Equal(t, configs+1, count("encoder_configs"), "Only the encoder config of the created profile is kept")
+		require.Equal(t, encoders, count("encoders"), "So is the encoder added for the failed profile deleted")
+
+		require.NoError(t, profileRepo.Delete(kept.ID))
+	})
+	t.Run("Should update the profiles as their next revision", func(t *testing.T) {
+		first.Codec = "av1"
+		second.PackageFormat = "hls"
+		results, err := profileRepo.UpdateMany([]*model.Profile{first, {ID: second.ID + 1, Codec: "h264"}, second}, repository.PartialSuccess)
+		require.NoError(t, err)
+		require.Equal(t, []int{1}, results.Failed())
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(results[1].Err))
+		require.Equal(t, []*model.Profile{first, second}, all())
+
+		revision, err := profileRepo.GetRevision(first.Name, 2)
+		require.NoError(t, err)
+		require.Equal(t, "av1", revision.Codec)
+	})
+	t.Run("Should roll back the updates when one is invalid", func(t *testing.T) {
+		updates := []*model.Profile{
+			{ID: first.ID, Codec: "vp9", EncConfig: first.EncConfig},
+			{ID: second.ID, PackageFormat: "smooth", EncConfig: second.EncConfig},
+		}
+		results, err := profileRepo.UpdateMany(updates, repository.AllOrNothing)
+		_, ok := validation.As(err)
+		require.True(t, ok)
+		require.Equal(t, repository.ErrRolledBack, results[0].Err)
+		require.Equal(t, []*model.Profile{first, second}, all())
+		_, err = profileRepo.GetRevision(first.Name, 3)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err))
+
+		results, err = profileRepo.UpdateMany(updates, repository.PartialSuccess)
+		require.NoError(t, err)
+		require.Equal(t, []int{1}, results.Failed())
+		first.Codec = "vp9"
+		require.Equal(t, []*model.Profile{first, second}, all(), "The invalid update is rolled back alone")
+	})
+	t.Run("Should not update the profiles onto a taken name", func(t *testing.T) {
+		updates := []*model.Profile{{ID: first.ID, Name: second.Name}, {ID: second.ID, Name: second.Name, Codec: "h264"}}
+		results, err := profileRepo.UpdateMany(updates, repository.PartialSuccess)
+		require.NoError(t, err)
+		require.Equal(t, []int{0}, results.Failed())
+		require.Equal(t, repository.ErrDuplicate, errors.Cause(results[0].Err))
+		second.Codec = "h264"
+		require.Equal(t, []*model.Profile{first, second}, all(), "A profile keeps its own name")
+	})
+	t.Run("Should delete the profiles", func(t *testing.T) {
+		results, err := profileRepo.DeleteMany([]int{first.ID, second.ID + 1}, repository.AllOrNothing)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err))
+		require.Equal(t, repository.ErrRolledBack, results[0].Err)
+		require.Len(t, all(), 2)
+
+		results, err = profileRepo.DeleteMany([]int{first.ID, second.ID}, repository.PartialSuccess)
+		require.NoError(t, err)
+		require.Empty(t, results.Failed())
+		require.Empty(t, all())
+	})
+	t.Run("Should audit every change", func(t *testing.T) {
+		history, err := audit.New(database).History(audit.EntityProfile, first.ID)
+		require.NoError(t, err)
+		actions := make([]model.AuditAction, len(history))
+		for i, entry := range history {
+			actions[i] = entry.Action
+		}
+		require.Equal(t, []model.AuditAction{model.AuditCreate, model.AuditUpdate, model.AuditUpdate, model.AuditDelete}, actions)
+	})
+}
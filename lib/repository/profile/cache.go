@@ -0,0 +1,465 @@
+package profile
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/logger"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/monitoring"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+)
+
+const (
+	// DefaultCacheTTL is how long a profile stays cached when CacheConfig.TTL is not set.
+	DefaultCacheTTL = 5 * time.Minute
+	// DefaultCacheSize is the number of entries kept when CacheConfig.MaxEntries is not set.
+	DefaultCacheSize = 1000
+)
+
+// CacheConfig configures NewCachedRepository.
+type CacheConfig struct {
+	// TTL is how long a profile is served from the cache before it is read again. Defaults to DefaultCacheTTL.
+	TTL time.Duration
+	// MaxEntries bounds the cache, the least recently used entries are evicted beyond it. A profile takes an entry
+	// per ID and per name it was read by. Defaults to DefaultCacheSize.
+	MaxEntries int
+	// Metrics receives the profile.cache.hits, profile.cache.misses, profile.cache.evictions and
+	// profile.cache.invalidations counts. Defaults to monitoring.Metrics.
+	Metrics monitoring.MetricsReporter
+	// Tags are added to every metric.
+	Tags []monitoring.Tag
+	// Invalidations, when set, spreads the invalidations of the cache to the caches of the other instances.
+	Invalidations CacheInvalidations
+}
+
+// CacheInvalidations broadcasts the invalidations of the profile caches of every instance, see
+// NewPostgresCacheInvalidations.
+type CacheInvalidations interface {
+	// Publish tells the other instances that the profiles changed.
+	Publish() error
+
+	// Subscribe calls invalidate whenever another instance publishes that the profiles changed.
+	Subscribe(invalidate func())
+}
+
+// cachedRepository serves the profile reads by ID and name from a cache shared by its views, and invalidates the
+// whole cache on every write: profiles change rarely, and a write may change the name of a profile.
+type cachedRepository struct {
+	inner Repository
+	cache *profileCache
+	// bypass is set on the views whose reads must not be cached, see UsePrimary and IncludeDeleted.
+	bypass bool
+}
+
+// NewCachedRepository wraps inner with a read-through cache of Get, GetMany, GetByName and GetManyByName.
+// The writes made through the returned repository, or published through config.Invalidations by other instances,
+// invalidate the cache. The profile repositories and db.Seed notify CacheInvalidationChannel within their write
+// transactions, so with PostgresCacheInvalidations the writes made around the cache, such as by a unit of work, also
+// invalidate it once committed. Without, they show once the TTL expires.
+// Cache hits return copies, so that callers may change them.
+func NewCachedRepository(inner Repository, config *CacheConfig) *cachedRepository {
+	cache := newProfileCache(config)
+	if cache.config.Invalidations != nil {
+		cache.config.Invalidations.Subscribe(cache.invalidate)
+	}
+	return &cachedRepository{inner: inner, cache: cache}
+}
+
+// Invalidate empties the cache, so that the next reads go to the database.
+func (repo *cachedRepository) Invalidate() {
+	repo.cache.invalidate()
+}
+
+// UsePrimary returns a view reading from the primary, bypassing the cache for read-after-write consistency.
+func (repo *cachedRepository) UsePrimary() Repository {
+	return &cachedRepository{inner: repo.inner.UsePrimary(), cache: repo.cache, bypass: true}
+}
+
+// IncludeDeleted returns a view bypassing the cache, which only holds the profiles that are not deleted.
+func (repo *cachedRepository) IncludeDeleted() Repository {
+	return &cachedRepository{inner: repo.inner.IncludeDeleted(), cache: repo.cache, bypass: true}
+}
+
+func (repo *cachedRepository) WithContext(ctx context.Context) Repository {
+	return &cachedRepository{inner: repo.inner.WithContext(ctx), cache: repo.cache, bypass: repo.bypass}
+}
+
+func (repo *cachedRepository) Get(id int) (*model.Profile, error) {
+	profiles, err := repo.getOne([]string{idKey(id)}, func() ([]*model.Profile, error) {
+		profile, err := repo.inner.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		return []*model.Profile{profile}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return profiles[0], nil
+}
+
+func (repo *cachedRepository) GetMany(ids []int) ([]*model.Profile, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = idKey(id)
+	}
+	return repo.getManyMissing(keys, func(missing []int) ([]*model.Profile, error) {
+		missingIDs := make([]int, len(missing))
+		for i, index := range missing {
+			missingIDs[i] = ids[index]
+		}
+		return repo.inner.GetMany(missingIDs)
+	})
+}
+
+func (repo *cachedRepository) GetByName(name string) (*model.Profile, error) {
+	profiles, err := repo.getOne([]string{nameKey(name)}, func() ([]*model.Profile, error) {
+		profile, err := repo.inner.GetByName(name)
+		if err != nil {
+			return nil, err
+		}
+		return []*model.Profile{profile}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return profiles[0], nil
+}
+
+func (repo *cachedRepository) GetManyByName(names []string) ([]*model.Profile, error) {
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = nameKey(name)
+	}
+	return repo.getManyMissing(keys, func(missing []int) ([]*model.Profile, error) {
+		missingNames := make([]string, len(missing))
+		for i, index := range missing {
+			missingNames[i] = names[index]
+		}
+		return repo.inner.GetManyByName(missingNames)
+	})
+}
+
+// getOne returns the profile of the single key, from the cache or else from fetch.
+func (repo *cachedRepository) getOne(keys []string, fetch func() ([]*model.Profile, error)) ([]*model.Profile, error) {
+	return repo.getManyMissing(keys, func([]int) ([]*model.Profile, error) {
+		return fetch()
+	})
+}
+
+// getManyMissing returns the profiles of keys, in their order and once each. The ones that are not cached are read
+// with fetch, given the indexes of their keys, and cached. Keys without a profile are left out, like the
+// repository does.
+func (repo *cachedRepository) getManyMissing(keys []string, fetch func(missing []int) ([]*model.Profile, error)) ([]*model.Profile, error) {
+	if repo.bypass {
+		return fetch(allIndexes(keys))
+	}
+	// Read before fetching, so that the profiles read before a concurrent invalidation are not cached.
+	generation := repo.cache.currentGeneration()
+	found := map[string]*model.Profile{}
+	var missing []int
+	for i, key := range keys {
+		if _, seen := found[key]; seen {
+			continue
+		}
+		profile, ok := repo.cache.get(key)
+		found[key] = profile
+		if !ok {
+			missing = append(missing, i)
+		}
+	}
+	repo.cache.count(len(found)-len(missing), len(missing))
+
+	if len(missing) > 0 {
+		fetched, err := fetch(missing)
+		if err != nil {
+			return nil, err
+		}
+		repo.cache.put(generation, fetched)
+		for _, profile := range fetched {
+			for _, key := range []string{idKey(profile.ID), nameKey(profile.Name)} {
+				if _, requested := found[key]; requested {
+					found[key] = copyProfile(profile)
+				}
+			}
+		}
+	}
+
+	profiles := make([]*model.Profile, 0, len(found))
+	emitted := map[int]bool{}
+	for _, key := range keys {
+		if profile := found[key]; profile != nil && !emitted[profile.ID] {
+			emitted[profile.ID] = true
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles, nil
+}
+
+func allIndexes(keys []string) []int {
+	indexes := make([]int, len(keys))
+	for i := range keys {
+		indexes[i] = i
+	}
+	return indexes
+}
+
+func (repo *cachedRepository) GetRevision(name string, revision int) (*model.ProfileRevision, error) {
+	return repo.inner.GetRevision(name, revision)
+}
+
+func (repo *cachedRepository) GetRevisionByID(profileID int, revision int) (*model.ProfileRevision, error) {
+	return repo.inner.GetRevisionByID(profileID, revision)
+}
+
+func (repo *cachedRepository) All() ([]*model.Profile, error) {
+	return repo.inner.All()
+}
+
+func (repo *cachedRepository) Create(profile *model.Profile) error {
+	return repo.invalidateAfter(repo.inner.Create(profile))
+}
+
+func (repo *cachedRepository) Update(profile *model.Profile) error {
+	return repo.invalidateAfter(repo.inner.Update(profile))
+}
+
+func (repo *cachedRepository) Delete(id int) error {
+	return repo.invalidateAfter(repo.inner.Delete(id))
+}
+
+func (repo *cachedRepository) Restore(id int) error {
+	return repo.invalidateAfter(repo.inner.Restore(id))
+}
+
+func (repo *cachedRepository) CreateMany(profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error) {
+	results, err := repo.inner.CreateMany(profiles, mode)
+	return results, repo.invalidateAfter(err)
+}
+
+func (repo *cachedRepository) UpdateMany(profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error) {
+	results, err := repo.inner.UpdateMany(profiles, mode)
+	return results, repo.invalidateAfter(err)
+}
+
+func (repo *cachedRepository) DeleteMany(ids []int, mode repository.BulkMode) (repository.BulkResults, error) {
+	results, err := repo.inner.DeleteMany(ids, mode)
+	return results, repo.invalidateAfter(err)
+}
+
+// Purge leaves the cache, it only removes profiles that were deleted, and so invalidated, before.
+func (repo *cachedRepository) Purge(olderThan time.Duration) (int, error) {
+	return repo.inner.Purge(olderThan)
+}
+
+// invalidateAfter invalidates the cache of every instance once a write succeeded, and returns its error.
+func (repo *cachedRepository) invalidateAfter(err error) error {
+	if err != nil {
+		return err
+	}
+	repo.cache.invalidate()
+	if invalidations := repo.cache.config.Invalidations; invalidations != nil {
+		if err := invalidations.Publish(); err != nil {
+			// The other instances read the change once their TTL expires.
+			logger.Error(err, "Error found when trying to publish a profile cache invalidation")
+		}
+	}
+	return nil
+}
+
+func (repo *cachedRepository) GetContext(ctx context.Context, id int) (*model.Profile, error) {
+	return repo.WithContext(ctx).Get(id)
+}
+
+func (repo *cachedRepository) GetManyContext(ctx context.Context, ids []int) ([]*model.Profile, error) {
+	return repo.WithContext(ctx).GetMany(ids)
+}
+
+func (repo *cachedRepository) GetByNameContext(ctx context.Context, name string) (*model.Profile, error) {
+	return repo.WithContext(ctx).GetByName(name)
+}
+
+func (repo *cachedRepository) GetRevisionContext(ctx context.Context, name string, revision int) (*model.ProfileRevision, error) {
+	return repo.WithContext(ctx).GetRevision(name, revision)
+}
+
+func (repo *cachedRepository) GetRevisionByIDContext(ctx context.Context, profileID int, revision int) (*model.ProfileRevision, error) {
+	return repo.WithContext(ctx).GetRevisionByID(profileID, revision)
+}
+
+func (repo *cachedRepository) GetManyByNameContext(ctx context.Context, names []string) ([]*model.Profile, error) {
+	return repo.WithContext(ctx).GetManyByName(names)
+}
+
+func (repo *cachedRepository) CreateContext(ctx context.Context, profile *model.Profile) error {
+	return repo.WithContext(ctx).Create(profile)
+}
+
+func (repo *cachedRepository) UpdateContext(ctx context.Context, profile *model.Profile) error {
+	return repo.WithContext(ctx).Update(profile)
+}
+
+func (repo *cachedRepository) DeleteContext(ctx context.Context, id int) error {
+	return repo.WithContext(ctx).Delete(id)
+}
+
+func (repo *cachedRepository) RestoreContext(ctx context.Context, id int) error {
+	return repo.WithContext(ctx).Restore(id)
+}
+
+func (repo *cachedRepository) CreateManyContext(ctx context.Context, profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error) {
+	return repo.WithContext(ctx).CreateMany(profiles, mode)
+}
+
+func (repo *cachedRepository) UpdateManyContext(ctx context.Context, profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error) {
+	return repo.WithContext(ctx).UpdateMany(profiles, mode)
+}
+
+func (repo *cachedRepository) DeleteManyContext(ctx context.Context, ids []int, mode repository.BulkMode) (repository.BulkResults, error) {
+	return repo.WithContext(ctx).DeleteMany(ids, mode)
+}
+
+func (repo *cachedRepository) PurgeContext(ctx context.Context, olderThan time.Duration) (int, error) {
+	return repo.WithContext(ctx).Purge(olderThan)
+}
+
+func (repo *cachedRepository) AllContext(ctx context.Context) ([]*model.Profile, error) {
+	return repo.WithContext(ctx).All()
+}
+
+func idKey(id int) string {
+	return "id:" + strconv.Itoa(id)
+}
+
+func nameKey(name string) string {
+	return "name:" + name
+}
+
+// copyProfile returns a copy of profile, which holds no pointers.
+func copyProfile(profile *model.Profile) *model.Profile {
+	copied := *profile
+	return &copied
+}
+
+// profileCache is a least recently used cache of profiles by key, whose entries expire after the TTL.
+type profileCache struct {
+	mutex   sync.Mutex
+	config  CacheConfig
+	entries map[string]*list.Element
+	// order holds the cacheEntry values, the most recently used first.
+	order *list.List
+	// generation changes on every invalidation.
+	generation uint64
+	now        func() time.Time
+}
+
+type cacheEntry struct {
+	key       string
+	profile   *model.Profile
+	expiresAt time.Time
+}
+
+func newProfileCache(config *CacheConfig) *profileCache {
+	cache := &profileCache{entries: map[string]*list.Element{}, order: list.New(), now: time.Now}
+	if config != nil {
+		cache.config = *config
+	}
+	if cache.config.TTL <= 0 {
+		cache.config.TTL = DefaultCacheTTL
+	}
+	if cache.config.MaxEntries <= 0 {
+		cache.config.MaxEntries = DefaultCacheSize
+	}
+	return cache
+}
+
+// get returns a copy of the profile cached under key, if it has not expired.
+func (cache *profileCache) get(key string) (*model.Profile, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !cache.now().Before(entry.expiresAt) {
+		cache.remove(element)
+		return nil, false
+	}
+	cache.order.MoveToFront(element)
+	return copyProfile(entry.profile), true
+}
+
+func (cache *profileCache) currentGeneration() uint64 {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.generation
+}
+
+// put caches the profiles by ID and name, unless the cache was invalidated since generation.
+func (cache *profileCache) put(generation uint64, profiles []*model.Profile) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if generation != cache.generation {
+		return
+	}
+	expiresAt := cache.now().Add(cache.config.TTL)
+	evictions := 0
+	for _, profile := range profiles {
+		copied := copyProfile(profile)
+		for _, key := range []string{idKey(profile.ID), nameKey(profile.Name)} {
+			if element, ok := cache.entries[key]; ok {
+				cache.remove(element)
+			}
+			cache.entries[key] = cache.order.PushFront(&cacheEntry{key: key, profile: copied, expiresAt: expiresAt})
+			for cache.order.Len() > cache.config.MaxEntries {
+				cache.remove(cache.order.Back())
+				evictions++
+			}
+		}
+	}
+	if evictions > 0 {
+		cache.report("profile.cache.evictions", evictions)
+	}
+}
+
+func (cache *profileCache) invalidate() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.generation++
+	cache.entries = map[string]*list.Element{}
+	cache.order.Init()
+	cache.report("profile.cache.invalidations", 1)
+}
+
+func (cache *profileCache) remove(element *list.Element) {
+	cache.order.Remove(element)
+	delete(cache.entries, element.Value.(*cacheEntry).key)
+}
+
+// count reports the hits and misses of a lookup.
+func (cache *profileCache) count(hits int, misses int) {
+	if hits > 0 {
+		cache.report("profile.cache.hits", hits)
+	}
+	if misses > 0 {
+		cache.report("profile.cache.misses", misses)
+	}
+}
+
+func (cache *profileCache) report(name string, value int) {
+	if metrics := cache.metrics(); metrics != nil {
+		metrics.Count(name, int64(value), cache.config.Tags...)
+	}
+}
+
+func (cache *profileCache) metrics() monitoring.MetricsReporter {
+	if cache.config.Metrics != nil {
+		return cache.config.Metrics
+	}
+	return monitoring.Metrics
+}
@@ -0,0 +1,179 @@
+package profile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/monitoring/mocks"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newCacheMetrics() *mocks.MetricsReporter {
+	metrics := &mocks.MetricsReporter{}
+	metrics.On("Count", mock.Anything, mock.Anything)
+	return metrics
+}
+
+// fakeInvalidations delivers the invalidations published by one cache to the others, like Postgres NOTIFY.
+type fakeInvalidations struct {
+	subscribers []func()
+}
+
+func (broker *fakeInvalidations) Publish() error {
+	for _, invalidate := range broker.subscribers {
+		invalidate()
+	}
+	return nil
+}
+
+func (broker *fakeInvalidations) Subscribe(invalidate func()) {
+	broker.subscribers = append(broker.subscribers, invalidate)
+}
+
+func TestCachedRepository(t *testing.T) {
+	database := repositorytest.OpenSQLite(t)
+	resolver := &recordingResolver{database: database}
+	newProfile := func(name string, codec string) *model.Profile {
+		return &model.Profile{Name: name, Codec: codec, PackageFormat: "hls", EncConfig: model.EncoderConfig{
+			Name: "default", Config: "{}", Encoder: model.Encoder{Name: "bitmovin"},
+		}}
+	}
+	first, second := newProfile("h264-hls", "h264"), newProfile("h265-hls", "h265")
+	require.NoError(t, New(database).Create(first))
+	second.EncConfig = first.EncConfig
+	require.NoError(t, New(database).Create(second))
+	second, err := New(database).Get(second.ID)
+	require.NoError(t, err)
+
+	t.Run("Should read a profile once until it expires", func(t *testing.T) {
+		metrics := newCacheMetrics()
+		cached := NewCachedRepository(NewWithResolver(resolver), &CacheConfig{TTL: time.Minute, Metrics: metrics})
+		now := time.Now()
+		cached.cache.now = func() time.Time { return now }
+		resolver.replica = 0
+
+		for i := 0; i < 3; i++ {
+			profile, err := cached.GetByName("h264-hls")
+			require.NoError(t, err)
+			require.Equal(t, first, profile)
+		}
+		profile, err := cached.Get(first.ID)
+		require.NoError(t, err)
+		require.Equal(t, first, profile, "The profile is cached by ID too")
+		require.Equal(t, 1, resolver.replica)
+		metrics.AssertCalled(t, "Count", "profile.cache.misses", int64(1))
+		metrics.AssertNumberOfCalls(t, "Count", 4)
+
+		now = now.Add(time.Minute)
+		_, err = cached.GetByName("h264-hls")
+		require.NoError(t, err)
+		require.Equal(t, 2, resolver.replica)
+	})
+	t.Run("Should only read the missing profiles", func(t *testing.T) {
+		metrics := newCacheMetrics()
+		cached := NewCachedRepository(NewWithResolver(resolver), &CacheConfig{Metrics: metrics})
+		_, err := cached.GetByName("h265-hls")
+		require.NoError(t, err)
+		resolver.replica = 0
+
+		profiles, err := cached.GetManyByName([]string{"h265-hls", "unknown", "h264-hls", "h265-hls"})
+		require.NoError(t, err)
+		require.Equal(t, []*model.Profile{second, first}, profiles, "In the order of the names, once each")
+		require.Equal(t, 1, resolver.replica)
+		metrics.AssertCalled(t, "Count", "profile.cache.hits", int64(1))
+		metrics.AssertCalled(t, "Count", "profile.cache.misses", int64(2))
+
+		profiles, err = cached.GetMany([]int{first.ID, second.ID})
+		require.NoError(t, err)
+		require.Equal(t, []*model.Profile{first, second}, profiles)
+		require.Equal(t, 1, resolver.replica)
+	})
+	t.Run("Should return copies", func(t *testing.T) {
+		cached := NewCachedRepository(NewWithResolver(resolver), &CacheConfig{Metrics: newCacheMetrics()})
+		profile, err := cached.Get(first.ID)
+		require.NoError(t, err)
+		profile.EncConfig.Encoder.Name = "changed"
+
+		profile, err = cached.Get(first.ID)
+		require.NoError(t, err)
+		require.Equal(t, first, profile)
+	})
+	t.Run("Should evict the least recently used profiles", func(t *testing.T) {
+		metrics := newCacheMetrics()
+		cached := NewCachedRepository(NewWithResolver(resolver), &CacheConfig{MaxEntries: 2, Metrics: metrics})
+		_, err := cached.GetByName("h264-hls")
+		require.NoError(t, err)
+		_, err = cached.GetByName("h265-hls")
+		require.NoError(t, err)
+		metrics.AssertCalled(t, "Count", "profile.cache.evictions", int64(2))
+		resolver.replica = 0
+
+		_, err = cached.GetByName("h265-hls")
+		require.NoError(t, err)
+		_, err = cached.GetByName("h264-hls")
+		require.NoError(t, err)
+		require.Equal(t, 1, resolver.replica)
+	})
+	t.Run("Should invalidate every instance on writes", func(t *testing.T) {
+		broker := &fakeInvalidations{}
+		cached := NewCachedRepository(NewWithResolver(resolver), &CacheConfig{Metrics: newCacheMetrics(), Invalidations: broker})
+		other := NewCachedRepository(NewWithResolver(resolver), &CacheConfig{Metrics: newCacheMetrics(), Invalidations: broker})
+		for _, repo := range []Repository{cached, other} {
+			_, err := repo.GetByName("h264-hls")
+			require.NoError(t, err)
+		}
+
+		first.Codec = "av1"
+		require.NoError(t, cached.WithContext(context.Background()).Update(first))
+		for _, repo := range []Repository{cached, other} {
+			profile, err := repo.GetByName("h264-hls")
+			require.NoError(t, err)
+			require.Equal(t, "av1", profile.Codec)
+		}
+
+		require.NoError(t, cached.Delete(first.ID))
+		_, err := other.GetByName("h264-hls")
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err))
+		profile, err := other.IncludeDeleted().GetByName("h264-hls")
+		require.NoError(t, err)
+		require.Equal(t, first.ID, profile.ID)
+		require.NoError(t, cached.Restore(first.ID))
+	})
+	t.Run("Should not cache the profiles read before an invalidation", func(t *testing.T) {
+		cache := newProfileCache(&CacheConfig{Metrics: newCacheMetrics()})
+		generation := cache.currentGeneration()
+		cache.invalidate()
+		cache.put(generation, []*model.Profile{first})
+		_, ok := cache.get(idKey(first.ID))
+		require.False(t, ok)
+	})
+	t.Run("Should bypass the cache to read from the primary", func(t *testing.T) {
+		cached := NewCachedRepository(NewWithResolver(resolver), &CacheConfig{Metrics: newCacheMetrics()})
+		resolver.primary = 0
+		for i := 0; i < 2; i++ {
+			_, err := cached.UsePrimary().Get(first.ID)
+			require.NoError(t, err)
+		}
+		require.Equal(t, 2, resolver.primary)
+	})
+	t.Run("Should invalidate on bulk writes", func(t *testing.T) {
+		broker := &fakeInvalidations{}
+		cached := NewCachedRepository(NewWithResolver(resolver), &CacheConfig{Metrics: newCacheMetrics(), Invalidations: broker})
+		other := NewCachedRepository(NewWithResolver(resolver), &CacheConfig{Metrics: newCacheMetrics(), Invalidations: broker})
+		_, err := other.GetByName("h264-hls")
+		require.NoError(t, err)
+
+		first.Codec = "vp9"
+		_, err = cached.UpdateMany([]*model.Profile{first}, repository.AllOrNothing)
+		require.NoError(t, err)
+		profile, err := other.GetByName("h264-hls")
+		require.NoError(t, err)
+		require.Equal(t, "vp9", profile.Codec)
+	})
+}
@@ -0,0 +1,69 @@
+package profile
+
+import (
+	"context"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+)
+
+func (profileRepo *gormRepository) GetContext(ctx context.Context, id int) (*model.Profile, error) {
+	return profileRepo.WithContext(ctx).Get(id)
+}
+
+func (profileRepo *gormRepository) GetManyContext(ctx context.Context, ids []int) ([]*model.Profile, error) {
+	return profileRepo.WithContext(ctx).GetMany(ids)
+}
+
+func (profileRepo *gormRepository) GetByNameContext(ctx context.Context, name string) (*model.Profile, error) {
+	return profileRepo.WithContext(ctx).GetByName(name)
+}
+
+func (profileRepo *gormRepository) GetManyByNameContext(ctx context.Context, names []string) ([]*model.Profile, error) {
+	return profileRepo.WithContext(ctx).GetManyByName(names)
+}
+
+func (profileRepo *gormRepository) CreateContext(ctx context.Context, profile *model.Profile) error {
+	return profileRepo.WithContext(ctx).Create(profile)
+}
+
+func (profileRepo *gormRepository) UpdateContext(ctx context.Context, profile *model.Profile) error {
+	return profileRepo.WithContext(ctx).Update(profile)
+}
+
+func (profileRepo *gormRepository) DeleteContext(ctx context.Context, id int) error {
+	return profileRepo.WithContext(ctx).Delete(id)
+}
+
+func (profileRepo *gormRepository) RestoreContext(ctx context.Context, id int) error {
+	return profileRepo.WithContext(ctx).Restore(id)
+}
+
+func (profileRepo *gormRepository) CreateManyContext(ctx context.Context, profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error) {
+	return profileRepo.WithContext(ctx).CreateMany(profiles, mode)
+}
+
+func (profileRepo *gormRepository) UpdateManyContext(ctx context.Context, profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error) {
+	return profileRepo.WithContext(ctx).UpdateMany(profiles, mode)
+}
+
+func (profileRepo *gormRepository) DeleteManyContext(ctx context.Context, ids []int, mode repository.BulkMode) (repository.BulkResults, error) {
+	return profileRepo.WithContext(ctx).DeleteMany(ids, mode)
+}
+
+func (profileRepo *gormRepository) PurgeContext(ctx context.Context, olderThan time.Duration) (int, error) {
+	return profileRepo.WithContext(ctx).Purge(olderThan)
+}
+
+func (profileRepo *gormRepository) AllContext(ctx context.Context) ([]*model.Profile, error) {
+	return profileRepo.WithContext(ctx).All()
+}
+
+func (profileRepo *gormRepository) GetRevisionContext(ctx context.Context, name string, revision int) (*model.ProfileRevision, error) {
+	return profileRepo.WithContext(ctx).GetRevision(name, revision)
+}
+
+func (profileRepo *gormRepository) GetRevisionByIDContext(ctx context.Context, profileID int, revision int) (*model.ProfileRevision, error) {
+	return profileRepo.WithContext(ctx).GetRevisionByID(profileID, revision)
+}
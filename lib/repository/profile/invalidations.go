@@ -0,0 +1,104 @@
+package profile
+
+import (
+	"sync"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/db"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/logger"
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// CacheInvalidationChannel is the Postgres channel PostgresCacheInvalidations notifies and listens on. The profile
+// repositories and db.Seed notify it within their write transactions too, see db.NotifyProfileChange.
+const CacheInvalidationChannel = db.ProfileCacheChannel
+
+// listenerPingInterval is how often the listener checks its connection when there are no notifications.
+const listenerPingInterval = 90 * time.Second
+
+// PostgresCacheInvalidations spreads the profile cache invalidations between instances with Postgres NOTIFY.
+// Notifications are not queued for instances that are disconnected, so every reconnection invalidates the cache.
+type PostgresCacheInvalidations struct {
+	database *gorm.DB
+	listener *pq.Listener
+	// instance identifies the notifications of this instance, whose cache is already invalidated.
+	instance    string
+	mutex       sync.Mutex
+	subscribers []func()
+	done        chan struct{}
+}
+
+// NewPostgresCacheInvalidations notifies through database, and listens with a dedicated connection opened with
+// connectionString, until Close.
+func NewPostgresCacheInvalidations(database *gorm.DB, connectionString string) (*PostgresCacheInvalidations, error) {
+	listener := pq.NewListener(connectionString, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Error(err, "Error found on the profile cache invalidation listener")
+		}
+	})
+	if err := listener.Listen(CacheInvalidationChannel); err != nil {
+		listener.Close()
+		return nil, errors.Wrapf(err, "unable to listen on %s", CacheInvalidationChannel)
+	}
+	invalidations := newPostgresCacheInvalidations(database)
+	invalidations.listener = listener
+	go invalidations.listen()
+	return invalidations, nil
+}
+
+func newPostgresCacheInvalidations(database *gorm.DB) *PostgresCacheInvalidations {
+	return &PostgresCacheInvalidations{database: database, instance: uuid.New().String(), done: make(chan struct{})}
+}
+
+func (invalidations *PostgresCacheInvalidations) Publish() error {
+	rows, err := invalidations.database.Raw("SELECT pg_notify(?, ?)", CacheInvalidationChannel, invalidations.instance).Rows()
+	if err != nil {
+		return errors.Wrap(err, "unable to publish a profile cache invalidation")
+	}
+	return rows.Close()
+}
+
+func (invalidations *PostgresCacheInvalidations) Subscribe(invalidate func()) {
+	invalidations.mutex.Lock()
+	defer invalidations.mutex.Unlock()
+	invalidations.subscribers = append(invalidations.subscribers, invalidate)
+}
+
+// Close stops listening.
+func (invalidations *PostgresCacheInvalidations) Close() error {
+	close(invalidations.done)
+	return invalidations.listener.Close()
+}
+
+func (invalidations *PostgresCacheInvalidations) listen() {
+	for {
+		select {
+		case notification, ok := <-invalidations.listener.Notify:
+			if !ok {
+				return
+			}
+			invalidations.dispatch(notification)
+		case <-time.After(listenerPingInterval):
+			go invalidations.listener.Ping()
+		case <-invalidations.done:
+			return
+		}
+	}
+}
+
+// dispatch invalidates the caches on the notifications of the other instances, on the ones made within write
+// transactions, which have no instance, and on reconnections, which pq reports with a nil notification.
+func (invalidations *PostgresCacheInvalidations) dispatch(notification *pq.Notification) {
+	if notification != nil && notification.Extra == invalidations.instance {
+		return
+	}
+	invalidations.mutex.Lock()
+	subscribers := append([]func(){}, invalidations.subscribers...)
+	invalidations.mutex.Unlock()
+	for _, invalidate := range subscribers {
+		invalidate()
+	}
+}
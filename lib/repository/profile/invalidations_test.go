@@ -0,0 +1,40 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresCacheInvalidations(t *testing.T) {
+	mocket.Catcher.Register()
+	database, err := gorm.Open(mocket.DriverName, "")
+	require.NoError(t, err)
+	defer database.Close()
+
+	t.Run("Should notify with the instance", func(t *testing.T) {
+		mocket.Catcher.Reset()
+		invalidations := newPostgresCacheInvalidations(database)
+		notify := mocket.Catcher.NewMock().WithQuery(`SELECT pg_notify`).WithArgs(CacheInvalidationChannel, invalidations.instance)
+
+		require.NoError(t, invalidations.Publish())
+		require.True(t, notify.Triggered)
+	})
+	t.Run("Should invalidate on the notifications of the other instances, of write transactions and on reconnections", func(t *testing.T) {
+		invalidations := newPostgresCacheInvalidations(database)
+		invalidated := 0
+		invalidations.Subscribe(func() { invalidated++ })
+
+		invalidations.dispatch(&pq.Notification{Channel: CacheInvalidationChannel, Extra: invalidations.instance})
+		require.Zero(t, invalidated, "This instance is already invalidated")
+		invalidations.dispatch(&pq.Notification{Channel: CacheInvalidationChannel, Extra: "other"})
+		require.Equal(t, 1, invalidated)
+		invalidations.dispatch(&pq.Notification{Channel: CacheInvalidationChannel})
+		require.Equal(t, 2, invalidated)
+		invalidations.dispatch(nil)
+		require.Equal(t, 3, invalidated)
+	})
+}
@@ -4,6 +4,10 @@ package mocks
 
 import mock "github.com/stretchr/testify/mock"
 import model "github.com/EurosportDigital/global-transcoding-platform/model"
+import profile "github.com/EurosportDigital/global-transcoding-platform/lib/repository/profile"
+import time "time"
+import context "context"
+import repository "github.com/EurosportDigital/global-transcoding-platform/lib/repository"
 
 // Repository is an autogenerated mock type for the Repository type
 type Repository struct {
@@ -33,6 +37,29 @@ func (_m *Repository) All() ([]*model.Profile, error) {
 	return r0, r1
 }
 
+// AllContext provides a mock function with given fields: ctx
+func (_m *Repository) AllContext(ctx context.Context) ([]*model.Profile, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []*model.Profile
+	if rf, ok := ret.Get(0).(func(context.Context) []*model.Profile); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Profile)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Create provides a mock function with given fields: _a0
 func (_m *Repository) Create(_a0 *model.Profile) error {
 	ret := _m.Called(_a0)
@@ -47,6 +74,66 @@ func (_m *Repository) Create(_a0 *model.Profile) error {
 	return r0
 }
 
+// CreateContext provides a mock function with given fields: ctx, _a1
+func (_m *Repository) CreateContext(ctx context.Context, _a1 *model.Profile) error {
+	ret := _m.Called(ctx, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Profile) error); ok {
+		r0 = rf(ctx, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateMany provides a mock function with given fields: profiles, mode
+func (_m *Repository) CreateMany(profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error) {
+	ret := _m.Called(profiles, mode)
+
+	var r0 repository.BulkResults
+	if rf, ok := ret.Get(0).(func([]*model.Profile, repository.BulkMode) repository.BulkResults); ok {
+		r0 = rf(profiles, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(repository.BulkResults)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]*model.Profile, repository.BulkMode) error); ok {
+		r1 = rf(profiles, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateManyContext provides a mock function with given fields: ctx, profiles, mode
+func (_m *Repository) CreateManyContext(ctx context.Context, profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error) {
+	ret := _m.Called(ctx, profiles, mode)
+
+	var r0 repository.BulkResults
+	if rf, ok := ret.Get(0).(func(context.Context, []*model.Profile, repository.BulkMode) repository.BulkResults); ok {
+		r0 = rf(ctx, profiles, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(repository.BulkResults)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []*model.Profile, repository.BulkMode) error); ok {
+		r1 = rf(ctx, profiles, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Delete provides a mock function with given fields: id
 func (_m *Repository) Delete(id int) error {
 	ret := _m.Called(id)
@@ -61,6 +148,66 @@ func (_m *Repository) Delete(id int) error {
 	return r0
 }
 
+// DeleteContext provides a mock function with given fields: ctx, id
+func (_m *Repository) DeleteContext(ctx context.Context, id int) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteMany provides a mock function with given fields: ids, mode
+func (_m *Repository) DeleteMany(ids []int, mode repository.BulkMode) (repository.BulkResults, error) {
+	ret := _m.Called(ids, mode)
+
+	var r0 repository.BulkResults
+	if rf, ok := ret.Get(0).(func([]int, repository.BulkMode) repository.BulkResults); ok {
+		r0 = rf(ids, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(repository.BulkResults)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]int, repository.BulkMode) error); ok {
+		r1 = rf(ids, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteManyContext provides a mock function with given fields: ctx, ids, mode
+func (_m *Repository) DeleteManyContext(ctx context.Context, ids []int, mode repository.BulkMode) (repository.BulkResults, error) {
+	ret := _m.Called(ctx, ids, mode)
+
+	var r0 repository.BulkResults
+	if rf, ok := ret.Get(0).(func(context.Context, []int, repository.BulkMode) repository.BulkResults); ok {
+		r0 = rf(ctx, ids, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(repository.BulkResults)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []int, repository.BulkMode) error); ok {
+		r1 = rf(ctx, ids, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Get provides a mock function with given fields: id
 func (_m *Repository) Get(id int) (*model.Profile, error) {
 	ret := _m.Called(id)
@@ -107,6 +254,52 @@ func (_m *Repository) GetByName(name string) (*model.Profile, error) {
 	return r0, r1
 }
 
+// GetByNameContext provides a mock function with given fields: ctx, name
+func (_m *Repository) GetByNameContext(ctx context.Context, name string) (*model.Profile, error) {
+	ret := _m.Called(ctx, name)
+
+	var r0 *model.Profile
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.Profile); ok {
+		r0 = rf(ctx, name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Profile)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetContext provides a mock function with given fields: ctx, id
+func (_m *Repository) GetContext(ctx context.Context, id int) (*model.Profile, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *model.Profile
+	if rf, ok := ret.Get(0).(func(context.Context, int) *model.Profile); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Profile)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetMany provides a mock function with given fields: ids
 func (_m *Repository) GetMany(ids []int) ([]*model.Profile, error) {
 	ret := _m.Called(ids)
@@ -153,6 +346,230 @@ func (_m *Repository) GetManyByName(names []string) ([]*model.Profile, error) {
 	return r0, r1
 }
 
+// GetManyByNameContext provides a mock function with given fields: ctx, names
+func (_m *Repository) GetManyByNameContext(ctx context.Context, names []string) ([]*model.Profile, error) {
+	ret := _m.Called(ctx, names)
+
+	var r0 []*model.Profile
+	if rf, ok := ret.Get(0).(func(context.Context, []string) []*model.Profile); ok {
+		r0 = rf(ctx, names)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Profile)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, names)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetManyContext provides a mock function with given fields: ctx, ids
+func (_m *Repository) GetManyContext(ctx context.Context, ids []int) ([]*model.Profile, error) {
+	ret := _m.Called(ctx, ids)
+
+	var r0 []*model.Profile
+	if rf, ok := ret.Get(0).(func(context.Context, []int) []*model.Profile); ok {
+		r0 = rf(ctx, ids)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Profile)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []int) error); ok {
+		r1 = rf(ctx, ids)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRevision provides a mock function with given fields: name, revision
+func (_m *Repository) GetRevision(name string, revision int) (*model.ProfileRevision, error) {
+	ret := _m.Called(name, revision)
+
+	var r0 *model.ProfileRevision
+	if rf, ok := ret.Get(0).(func(string, int) *model.ProfileRevision); ok {
+		r0 = rf(name, revision)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.ProfileRevision)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		r1 = rf(name, revision)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRevisionByID provides a mock function with given fields: profileID, revision
+func (_m *Repository) GetRevisionByID(profileID int, revision int) (*model.ProfileRevision, error) {
+	ret := _m.Called(profileID, revision)
+
+	var r0 *model.ProfileRevision
+	if rf, ok := ret.Get(0).(func(int, int) *model.ProfileRevision); ok {
+		r0 = rf(profileID, revision)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.ProfileRevision)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(int, int) error); ok {
+		r1 = rf(profileID, revision)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRevisionByIDContext provides a mock function with given fields: ctx, profileID, revision
+func (_m *Repository) GetRevisionByIDContext(ctx context.Context, profileID int, revision int) (*model.ProfileRevision, error) {
+	ret := _m.Called(ctx, profileID, revision)
+
+	var r0 *model.ProfileRevision
+	if rf, ok := ret.Get(0).(func(context.Context, int, int) *model.ProfileRevision); ok {
+		r0 = rf(ctx, profileID, revision)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.ProfileRevision)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int, int) error); ok {
+		r1 = rf(ctx, profileID, revision)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRevisionContext provides a mock function with given fields: ctx, name, revision
+func (_m *Repository) GetRevisionContext(ctx context.Context, name string, revision int) (*model.ProfileRevision, error) {
+	ret := _m.Called(ctx, name, revision)
+
+	var r0 *model.ProfileRevision
+	if rf, ok := ret.Get(0).(func(context.Context, string, int) *model.ProfileRevision); ok {
+		r0 = rf(ctx, name, revision)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.ProfileRevision)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, int) error); ok {
+		r1 = rf(ctx, name, revision)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IncludeDeleted provides a mock function with given fields:
+func (_m *Repository) IncludeDeleted() profile.Repository {
+	ret := _m.Called()
+
+	var r0 profile.Repository
+	if rf, ok := ret.Get(0).(func() profile.Repository); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(profile.Repository)
+		}
+	}
+
+	return r0
+}
+
+// Purge provides a mock function with given fields: olderThan
+func (_m *Repository) Purge(olderThan time.Duration) (int, error) {
+	ret := _m.Called(olderThan)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(time.Duration) int); ok {
+		r0 = rf(olderThan)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(time.Duration) error); ok {
+		r1 = rf(olderThan)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PurgeContext provides a mock function with given fields: ctx, olderThan
+func (_m *Repository) PurgeContext(ctx context.Context, olderThan time.Duration) (int, error) {
+	ret := _m.Called(ctx, olderThan)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration) int); ok {
+		r0 = rf(ctx, olderThan)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Duration) error); ok {
+		r1 = rf(ctx, olderThan)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Restore provides a mock function with given fields: id
+func (_m *Repository) Restore(id int) error {
+	ret := _m.Called(id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int) error); ok {
+		r0 = rf(id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RestoreContext provides a mock function with given fields: ctx, id
+func (_m *Repository) RestoreContext(ctx context.Context, id int) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Update provides a mock function with given fields: _a0
 func (_m *Repository) Update(_a0 *model.Profile) error {
 	ret := _m.Called(_a0)
@@ -166,3 +583,95 @@ func (_m *Repository) Update(_a0 *model.Profile) error {
 
 	return r0
 }
+
+// UpdateContext provides a mock function with given fields: ctx, _a1
+func (_m *Repository) UpdateContext(ctx context.Context, _a1 *model.Profile) error {
+	ret := _m.Called(ctx, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Profile) error); ok {
+		r0 = rf(ctx, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateMany provides a mock function with given fields: profiles, mode
+func (_m *Repository) UpdateMany(profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error) {
+	ret := _m.Called(profiles, mode)
+
+	var r0 repository.BulkResults
+	if rf, ok := ret.Get(0).(func([]*model.Profile, repository.BulkMode) repository.BulkResults); ok {
+		r0 = rf(profiles, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(repository.BulkResults)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]*model.Profile, repository.BulkMode) error); ok {
+		r1 = rf(profiles, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateManyContext provides a mock function with given fields: ctx, profiles, mode
+func (_m *Repository) UpdateManyContext(ctx context.Context, profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error) {
+	ret := _m.Called(ctx, profiles, mode)
+
+	var r0 repository.BulkResults
+	if rf, ok := ret.Get(0).(func(context.Context, []*model.Profile, repository.BulkMode) repository.BulkResults); ok {
+		r0 = rf(ctx, profiles, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(repository.BulkResults)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []*model.Profile, repository.BulkMode) error); ok {
+		r1 = rf(ctx, profiles, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UsePrimary provides a mock function with given fields:
+func (_m *Repository) UsePrimary() profile.Repository {
+	ret := _m.Called()
+
+	var r0 profile.Repository
+	if rf, ok := ret.Get(0).(func() profile.Repository); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(profile.Repository)
+		}
+	}
+
+	return r0
+}
+
+// WithContext provides a mock function with given fields: ctx
+func (_m *Repository) WithContext(ctx context.Context) profile.Repository {
+	ret := _m.Called(ctx)
+
+	var r0 profile.Repository
+	if rf, ok := ret.Get(0).(func(context.Context) profile.Repository); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(profile.Repository)
+		}
+	}
+
+	return r0
+}
@@ -1,7 +1,13 @@
 package profile
 
 import (
+	"context"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/db"
 	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/audit"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/validation"
 	"github.com/EurosportDigital/global-transcoding-platform/model"
 	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
 	"github.com/jinzhu/gorm"
@@ -16,36 +22,136 @@ type Repository interface {
 	// GetMany retrieves all model.Profiles with the specified IDs.
 	GetMany(ids []int) ([]*model.Profile, error)
 
-	// GetByName retrieves the model.Profile with the specified name.
+	// GetByName retrieves the latest revision of the model.Profile with the specified name. Only one profile that is
+	// not deleted has a name, and it comes before the deleted ones, the latest first, when they are included.
 	GetByName(name string) (*model.Profile, error)
 
+	// GetRevision retrieves the specified revision of the model.Profile with the specified name, or of the latest
+	// deleted one when none that is not deleted has it, like IncludeDeleted().GetByName. A profile renamed since is
+	// not found by its former name, see GetRevisionByID. It returns repository.ErrEntityNotFound when there is none.
+	GetRevision(name string, revision int) (*model.ProfileRevision, error)
+
+	// GetRevisionByID retrieves the specified revision of the model.Profile with the specified ID, including when the
+	// profile is soft deleted or renamed, such as the revision a job is pinned to. It returns
+	// repository.ErrEntityNotFound when there is none.
+	GetRevisionByID(profileID int, revision int) (*model.ProfileRevision, error)
+
 	// GetManyByName retrieves all model.Profiles with the specified names.
 	GetManyByName(names []string) ([]*model.Profile, error)
 
-	// Create adds the specified model.Profile to the database.
+	// Create adds the specified model.Profile to the database, at revision 1. It returns a validation.Error, see
+	// validation.As, when the profile does not pass validation.ValidateProfile, and repository.ErrDuplicate when
+	// another profile that is not deleted has its name.
 	Create(profile *model.Profile) error
 
-	// Update updates an existing record in the database.
+	// Update updates an existing record in the database and records it as the next revision. Only the profile row is
+	// written: its encoder config is the one EncConfig.ID refers to when set, and neither the encoder config nor the
+	// encoder, which other profiles share, is updated or created. It returns
+	// repository.ErrEntityNotFound when there is none, a validation.Error when the updated profile does not pass
+	// validation.ValidateProfile, and repository.ErrDuplicate when it would take the name of another profile.
 	Update(profile *model.Profile) error
 
-	// Delete removes the model.Profile with the specified ID.
+	// Delete soft deletes the model.Profile with the specified ID: reads exclude it until it is restored.
 	Delete(id int) error
 
+	// Restore undoes the soft deletion of the model.Profile with the specified ID. It returns repository.ErrDuplicate
+	// when another profile took its name since.
+	Restore(id int) error
+
+	// CreateMany adds the specified model.Profiles like Create, with multi-row statements in one transaction, and
+	// returns the result of each, in order. The mode tells whether the other profiles are still added when some of
+	// them fail, see repository.BulkMode. The error is set when none of them could be added. A profile with the name
+	// of an earlier one fails with repository.ErrDuplicate.
+	CreateMany(profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error)
+
+	// UpdateMany updates the specified model.Profiles like Update in one transaction, and returns the result of each,
+	// see CreateMany.
+	UpdateMany(profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error)
+
+	// DeleteMany soft deletes the model.Profiles with the specified IDs like Delete, with one statement, and returns
+	// the result of each, see CreateMany.
+	DeleteMany(ids []int, mode repository.BulkMode) (repository.BulkResults, error)
+
+	// Purge permanently removes the profiles soft deleted more than olderThan ago, along with their revisions, and
+	// returns their number. The profiles the outputs of jobs are pinned to are kept until the jobs are purged.
+	Purge(olderThan time.Duration) (int, error)
+
 	// All retrieves all model.Profile within the database.
 	All() ([]*model.Profile, error)
+
+	// UsePrimary returns a view of the repository whose reads go to the primary database, for read-after-write consistency.
+	UsePrimary() Repository
+
+	// IncludeDeleted returns a view of the repository whose reads include soft deleted profiles.
+	IncludeDeleted() Repository
+
+	// WithContext returns a view of the repository bound to ctx. Its queries are cancelled when ctx is done, failing
+	// with the error of ctx, and its slow queries are logged with the logging context of ctx. Its creates, updates and
+	// deletes are recorded in the audit trail as made by the actor of ctx, see routing.WithActor.
+	WithContext(ctx context.Context) Repository
+
+	// The Context variants are the methods of WithContext(ctx).
+	GetContext(ctx context.Context, id int) (*model.Profile, error)
+	GetManyContext(ctx context.Context, ids []int) ([]*model.Profile, error)
+	GetByNameContext(ctx context.Context, name string) (*model.Profile, error)
+	GetRevisionContext(ctx context.Context, name string, revision int) (*model.ProfileRevision, error)
+	GetRevisionByIDContext(ctx context.Context, profileID int, revision int) (*model.ProfileRevision, error)
+	GetManyByNameContext(ctx context.Context, names []string) ([]*model.Profile, error)
+	CreateContext(ctx context.Context, profile *model.Profile) error
+	UpdateContext(ctx context.Context, profile *model.Profile) error
+	DeleteContext(ctx context.Context, id int) error
+	RestoreContext(ctx context.Context, id int) error
+	CreateManyContext(ctx context.Context, profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error)
+	UpdateManyContext(ctx context.Context, profiles []*model.Profile, mode repository.BulkMode) (repository.BulkResults, error)
+	DeleteManyContext(ctx context.Context, ids []int, mode repository.BulkMode) (repository.BulkResults, error)
+	PurgeContext(ctx context.Context, olderThan time.Duration) (int, error)
+	AllContext(ctx context.Context) ([]*model.Profile, error)
 }
 
 type gormRepository struct {
-	db *gorm.DB
+	resolver       db.Resolver
+	includeDeleted bool
+	ctx            context.Context
 }
 
 // New constructs a new instance of the profile repository.
-func New(db *gorm.DB) *gormRepository {
-	return &gormRepository{db}
+func New(database *gorm.DB) *gormRepository {
+	return NewWithResolver(db.NewCluster(database))
+}
+
+// NewWithResolver constructs a profile repository that reads from the resolver's replicas and writes to its primary.
+func NewWithResolver(resolver db.Resolver) *gormRepository {
+	return &gormRepository{resolver: resolver, ctx: context.Background()}
+}
+
+func (profileRepo *gormRepository) UsePrimary() Repository {
+	view := *profileRepo
+	view.resolver = db.PrimaryOnly(profileRepo.resolver)
+	return &view
+}
+
+func (profileRepo *gormRepository) IncludeDeleted() Repository {
+	view := *profileRepo
+	view.includeDeleted = true
+	return &view
+}
+
+func (profileRepo *gormRepository) WithContext(ctx context.Context) Repository {
+	view := *profileRepo
+	view.ctx = ctx
+	return &view
+}
+
+// replica returns the connection reads go to, unscoped when soft deleted profiles are included.
+func (profileRepo *gormRepository) replica() *gorm.DB {
+	if profileRepo.includeDeleted {
+		return profileRepo.resolver.Replica().Unscoped()
+	}
+	return profileRepo.resolver.Replica()
 }
 
 func (profileRepo *gormRepository) Get(id int) (*model.Profile, error) {
-	return profileRepo.getFirstProfile(id)
+	return profileRepo.getFirstProfile("", id)
 }
 
 func (profileRepo *gormRepository) GetMany(ids []int) ([]*model.Profile, error) {
@@ -53,23 +159,44 @@ func (profileRepo *gormRepository) GetMany(ids []int) ([]*model.Profile, error)
 }
 
 func (profileRepo *gormRepository) GetByName(name string) (*model.Profile, error) {
-	return profileRepo.getFirstProfile("name = ?", name)
+	return profileRepo.getFirstProfile(latestFirst, "name = ?", name)
 }
 
+// latestFirst orders the profiles sharing a name: the one that is not deleted, then the latest deleted ones.
+const latestFirst = "deleted_at IS NULL DESC, id DESC"
+
 func (profileRepo *gormRepository) GetManyByName(names []string) ([]*model.Profile, error) {
 	return profileRepo.getManyProfiles("name IN (?)", names)
 }
 
 func (profileRepo *gormRepository) Create(profile *model.Profile) error {
+	if err := validation.ValidateProfile(profile); err != nil {
+		return errors.WithMessage(err, "unable to create profile")
+	}
 	gormProfile := gormmodel.ToGormProfile(profile)
 	if config := gormProfile.EncConfig; config != nil && config.ID != 0 {
 		// The ID should only be specified if it's referring to an existing config.
 		// If we do not set the EncConfig to nil then it will update that row, which we do not want on a Create.
 		gormProfile.EncConfig = nil
 	}
-	result := profileRepo.db.Create(gormProfile)
-	if result.Error != nil {
-		return errors.Wrapf(result.Error, "unable to create profile %v", profile)
+	err := repository.TransactionContext(profileRepo.ctx, profileRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		if err := checkName(tx, 0, profile.Name); err != nil {
+			return err
+		}
+		if err := tx.Create(gormProfile).Error; err != nil {
+			return errors.Wrapf(err, "unable to create profile %v", profile)
+		}
+		created, err := getProfile(tx, gormProfile.ID)
+		if err != nil {
+			return err
+		}
+		if err := recordRevision(tx, created); err != nil {
+			return err
+		}
+		return profileRepo.audit(tx, model.AuditCreate, gormProfile.ID, nil, gormmodel.ToProfile(&created.Profile))
+	})
+	if err != nil {
+		return err
 	}
 	*profile = *gormmodel.ToProfile(gormProfile)
 	return nil
@@ -77,55 +204,174 @@ func (profileRepo *gormRepository) Create(profile *model.Profile) error {
 
 func (profileRepo *gormRepository) Update(profile *model.Profile) error {
 	gormProfile := gormmodel.ToGormProfile(profile)
-	result := profileRepo.db.Model(&gormProfile).Update(gormProfile)
-	if result.Error != nil {
-		return errors.Wrapf(result.Error, "unable to update profile %v", profile)
-	}
-
-	return nil
+	return repository.TransactionContext(profileRepo.ctx, profileRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		before, err := getProfile(repository.ForUpdate(tx, false), profile.ID)
+		if err != nil {
+			return err
+		}
+		if err := updateProfile(tx, gormProfile); err != nil {
+			return errors.Wrapf(err, "unable to update profile %v", profile)
+		}
+		err = tx.Model(&gormmodel.ProfileRecord{}).Where("id = ?", profile.ID).UpdateColumn("revision", gorm.Expr("revision + 1")).Error
+		if err != nil {
+			return errors.Wrapf(err, "unable to increment the revision of profile %v", profile.ID)
+		}
+		after, err := getProfile(tx, profile.ID)
+		if err != nil {
+			return err
+		}
+		// The update only sets the non-empty fields, so the validated profile is the updated row.
+		if err := validation.ValidateProfile(gormmodel.ToProfile(&after.Profile)); err != nil {
+			return errors.WithMessagef(err, "unable to update profile %v", profile.ID)
+		}
+		if err := checkName(tx, profile.ID, after.Name); err != nil {
+			return err
+		}
+		if err := recordRevision(tx, after); err != nil {
+			return err
+		}
+		return profileRepo.audit(tx, model.AuditUpdate, profile.ID, gormmodel.ToProfile(&before.Profile), gormmodel.ToProfile(&after.Profile))
+	})
 }
 
 func (profileRepo *gormRepository) Delete(id int) error {
-	result := profileRepo.db.Delete(&gormmodel.Profile{ID: id})
-	if result.Error != nil {
-		return errors.Wrapf(result.Error, "unable to delete profile %v", id)
+	return repository.TransactionContext(profileRepo.ctx, profileRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		before, err := getProfile(repository.ForUpdate(tx, false), id)
+		if err != nil {
+			return err
+		}
+		result := tx.Delete(&gormmodel.ProfileRecord{Profile: gormmodel.Profile{ID: id}})
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "unable to delete profile %v", id)
+		}
+		if result.RowsAffected == 0 {
+			return errors.Wrapf(repository.ErrEntityNotFound, "did not find profile %v", id)
+		}
+		return profileRepo.audit(tx, model.AuditDelete, id, gormmodel.ToProfile(&before.Profile), nil)
+	})
+}
+
+// audit records the change of the profile in the audit trail, and notifies the profile caches of every instance, see
+// db.NotifyProfileChange, within the transaction making the change.
+func (profileRepo *gormRepository) audit(tx *gorm.DB, action model.AuditAction, id int, before *model.Profile, after *model.Profile) error {
+	if err := audit.Record(profileRepo.ctx, tx, change(action, id, before, after)); err != nil {
+		return err
 	}
-	if result.RowsAffected == 0 {
-		return errors.Wrapf(repository.ErrEntityNotFound, "did not find profile %v", id)
+	return db.NotifyProfileChange(tx)
+}
+
+// auditMany records the changes of the profiles like audit, with one statement.
+func (profileRepo *gormRepository) auditMany(tx *gorm.DB, changes []*audit.Change) error {
+	if err := audit.RecordMany(profileRepo.ctx, tx, changes); err != nil {
+		return err
 	}
+	return db.NotifyProfileChange(tx)
+}
+
+func change(action model.AuditAction, id int, before *model.Profile, after *model.Profile) *audit.Change {
+	return &audit.Change{EntityType: audit.EntityProfile, EntityID: id, Action: action, Before: before, After: after}
+}
+
+// updateProfile updates the non-empty fields of the profile row only. gorm would otherwise save the encoder config
+// and encoder of gormProfile as well, writing back to the rows other profiles share, or adding empty ones when
+// EncConfig is not set.
+func updateProfile(tx *gorm.DB, gormProfile *gormmodel.Profile) error {
+	return tx.Set("gorm:save_associations", false).Model(gormProfile).Update(gormProfile).Error
+}
 
+// checkName returns repository.ErrDuplicate when a profile that is not deleted, other than the one with the
+// specified ID, has the specified name. The unique idx_profiles_name index backs it on Postgres.
+func checkName(tx *gorm.DB, id int, name string) error {
+	var others []int
+	if err := tx.Model(&gormmodel.ProfileRecord{}).Where("name = ? AND id <> ?", name, id).Limit(1).Pluck("id", &others).Error; err != nil {
+		return errors.Wrapf(err, "unable to check the name of profile %q", name)
+	}
+	if len(others) > 0 {
+		return errors.Wrapf(repository.ErrDuplicate, "profile %q already exists", name)
+	}
 	return nil
 }
 
+func getProfile(tx *gorm.DB, id int) (*gormmodel.ProfileRecord, error) {
+	var record gormmodel.ProfileRecord
+	if err := repository.EvaluateError(tx.Preload("EncConfig.Encoder").First(&record, id).Error); err != nil {
+		return nil, errors.Wrapf(err, "did not find profile %v", id)
+	}
+	return &record, nil
+}
+
+func (profileRepo *gormRepository) Restore(id int) error {
+	return repository.TransactionContext(profileRepo.ctx, profileRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		if err := repository.Restore(tx, &gormmodel.ProfileRecord{}, id); err != nil {
+			return err
+		}
+		restored, err := getProfile(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkName(tx, id, restored.Name); err != nil {
+			return err
+		}
+		return profileRepo.audit(tx, model.AuditRestore, id, nil, gormmodel.ToProfile(&restored.Profile))
+	})
+}
+
+func (profileRepo *gormRepository) Purge(olderThan time.Duration) (int, error) {
+	return repository.PurgeWith(profileRepo.ctx, profileRepo.resolver.Primary(), &gormmodel.ProfileRecord{}, time.Now().Add(-olderThan), repository.PurgeOptions{
+		Keep: "EXISTS (SELECT 1 FROM job_outputs WHERE job_outputs.profile_id = profiles.id AND job_outputs.profile_revision > 0)",
+		BeforeDelete: func(tx *gorm.DB, ids []int) error {
+			if err := tx.Where("profile_id IN (?)", ids).Delete(&gormmodel.ProfileRevision{}).Error; err != nil {
+				return errors.Wrapf(err, "unable to delete the revisions of profiles %v", ids)
+			}
+			return nil
+		},
+	})
+}
+
 func (profileRepo *gormRepository) All() ([]*model.Profile, error) {
-	var profiles []*model.Profile
-	err := profileRepo.db.Find(&profiles).Error
+	var records []*gormmodel.ProfileRecord
+	err := repository.Run(profileRepo.ctx, profileRepo.replica(), func(db *gorm.DB) error {
+		return db.Find(&records).Error
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to retrieve all profiles")
 	}
+
+	profiles := make([]*model.Profile, len(records))
+	for i := range records {
+		profiles[i] = gormmodel.ToProfile(&records[i].Profile)
+	}
 	return profiles, nil
 }
 
-func (profileRepo *gormRepository) getFirstProfile(where ...interface{}) (*model.Profile, error) {
-	var profile gormmodel.Profile
-	err := repository.EvaluateError(profileRepo.db.Preload("EncConfig.Encoder").First(&profile, where...).Error)
+// getFirstProfile reads the first profile matching where, in the specified order if any, by ID otherwise.
+func (profileRepo *gormRepository) getFirstProfile(order string, where ...interface{}) (*model.Profile, error) {
+	var record gormmodel.ProfileRecord
+	err := repository.EvaluateError(repository.Run(profileRepo.ctx, profileRepo.replica(), func(db *gorm.DB) error {
+		if order != "" {
+			db = db.Order(order)
+		}
+		return db.Preload("EncConfig.Encoder").First(&record, where...).Error
+	}))
 	if err != nil {
 		return nil, errors.Wrapf(err, "did not find profile where %v", where)
 	}
 
-	return gormmodel.ToProfile(&profile), nil
+	return gormmodel.ToProfile(&record.Profile), nil
 }
 
 func (profileRepo *gormRepository) getManyProfiles(where ...interface{}) ([]*model.Profile, error) {
-	var profiles []*gormmodel.Profile
-	err := repository.EvaluateError(profileRepo.db.Preload("EncConfig.Encoder").Find(&profiles, where...).Error)
+	var records []*gormmodel.ProfileRecord
+	err := repository.EvaluateError(repository.Run(profileRepo.ctx, profileRepo.replica(), func(db *gorm.DB) error {
+		return db.Preload("EncConfig.Encoder").Find(&records, where...).Error
+	}))
 	if err != nil {
 		return nil, errors.Wrapf(err, "could not find profiles where %v", where)
 	}
 
-	modelProfiles := make([]*model.Profile, len(profiles))
-	for i := range profiles {
-		modelProfiles[i] = gormmodel.ToProfile(profiles[i])
+	modelProfiles := make([]*model.Profile, len(records))
+	for i := range records {
+		modelProfiles[i] = gormmodel.ToProfile(&records[i].Profile)
 	}
 
 	return modelProfiles, nil
@@ -1,12 +1,20 @@
 package profile
 
 import (
+	"context"
+	"encoding/json"
 	stderrors "errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/audit"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/routing"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/validation"
 	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
 	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
 	mocket "github.com/selvatico/go-mocket"
@@ -22,7 +30,7 @@ type profileTestSuite struct {
 
 func TestProfileTestSuite(t *testing.T) {
 	mocket.Catcher.Register()
-	mocket.Catcher.Logging = false
+	mocket.Catcher.Logging = true
 
 	db, err := gorm.Open(mocket.DriverName, "")
 	require.NoError(t, err)
@@ -33,6 +41,23 @@ func TestProfileTestSuite(t *testing.T) {
 	suite.Run(t, pSuite)
 }
 
+// recordingResolver counts which connection the repository asked for.
+type recordingResolver struct {
+	database *gorm.DB
+	primary  int
+	replica  int
+}
+
+func (r *recordingResolver) Primary() *gorm.DB {
+	r.primary++
+	return r.database
+}
+
+func (r *recordingResolver) Replica() *gorm.DB {
+	r.replica++
+	return r.database
+}
+
 func (pts *profileTestSuite) TearDownSuite() {
 	pts.db.Close()
 }
@@ -41,8 +66,14 @@ func (pts *profileTestSuite) SetupTest() {
 	mocket.Catcher.Reset()
 }
 
+// mockProfile makes the reads of the profile with the specified ID find it.
+func mockProfile(id int) {
+	mocket.Catcher.NewMock().WithQuery(fmt.Sprintf(`FROM "profiles"  WHERE "profiles"."deleted_at" IS NULL AND (("profiles"."id" = %d))`, id)).
+		WithReply([]map[string]interface{}{{"id": id, "name": "h264-hls", "codec": "h264", "package_format": "hls", "encoder_config_id": 0}})
+}
+
 func (pts *profileTestSuite) TestGormProfileGet() {
-	getQuery := `SELECT * FROM "profiles"  WHERE ("profiles"."id" = 1) ORDER BY "profiles"."id" ASC LIMIT 1`
+	getQuery := `SELECT * FROM "profiles"  WHERE "profiles"."deleted_at" IS NULL AND (("profiles"."id" = 1)) ORDER BY "profiles"."id" ASC LIMIT 1`
 	pts.Run("Should return expected result", func() {
 		pts.SetupTest()
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
@@ -94,7 +125,7 @@ func (pts *profileTestSuite) TestGormProfileGetMany() {
 		expectedIDs := []int{1, 2}
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
-				Pattern:  `SELECT * FROM "profiles"  WHERE (id IN (1,2))`,
+				Pattern:  `SELECT * FROM "profiles"  WHERE "profiles"."deleted_at" IS NULL AND ((id IN (1,2)))`,
 				Args:     []interface{}{int64(expectedIDs[0]), int64(expectedIDs[1])},
 				Response: []map[string]interface{}{{"id": expectedIDs[0]}, {"id": expectedIDs[1]}},
 				Once:     true,
@@ -113,7 +144,7 @@ func (pts *profileTestSuite) TestGormProfileGetMany() {
 		expectedError := stderrors.New("my error")
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
-				Pattern: `SELECT * FROM "profiles"  WHERE (id IN (1))`,
+				Pattern: `SELECT * FROM "profiles"  WHERE "profiles"."deleted_at" IS NULL AND ((id IN (1)))`,
 				Args:    []interface{}{int64(1)},
 				Once:    true,
 				Error:   expectedError,
@@ -132,7 +163,7 @@ func (pts *profileTestSuite) TestGormProfileGetManyByName() {
 		expectedNames := []string{"name 1", "name 2"}
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
-				Pattern:  `SELECT * FROM "profiles"  WHERE (name IN (name 1,name 2))`,
+				Pattern:  `SELECT * FROM "profiles"  WHERE "profiles"."deleted_at" IS NULL AND ((name IN (name 1,name 2)))`,
 				Args:     []interface{}{expectedNames[0], expectedNames[1]},
 				Response: []map[string]interface{}{{"name": expectedNames[0]}, {"name": expectedNames[1]}},
 				Once:     true,
@@ -151,7 +182,7 @@ func (pts *profileTestSuite) TestGormProfileGetManyByName() {
 		expectedError := stderrors.New("my error")
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
-				Pattern: `SELECT * FROM "profiles"  WHERE (name IN (name))`,
+				Pattern: `SELECT * FROM "profiles"  WHERE "profiles"."deleted_at" IS NULL AND ((name IN (name)))`,
 				Args:    []interface{}{"name"},
 				Once:    true,
 				Error:   expectedError,
@@ -166,7 +197,7 @@ func (pts *profileTestSuite) TestGormProfileGetManyByName() {
 
 func (pts *profileTestSuite) TestGormProfileGetByName() {
 	expectedName := "my profile name"
-	getQuery := fmt.Sprintf(`SELECT * FROM "profiles"  WHERE (name = %[1]s) ORDER BY "profiles"."id" ASC LIMIT 1`, expectedName)
+	getQuery := fmt.Sprintf(`SELECT * FROM "profiles"  WHERE "profiles"."deleted_at" IS NULL AND ((name = %[1]s)) ORDER BY deleted_at IS NULL DESC, id DESC,"profiles"."id" ASC LIMIT 1`, expectedName)
 	pts.Run("Should return expected result", func() {
 		pts.SetupTest()
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
@@ -217,8 +248,8 @@ func (pts *profileTestSuite) TestGormProfileCreate() {
 		pts.SetupTest()
 		newProfile := &model.Profile{
 			Name:          "my name",
-			Codec:         "my codec",
-			PackageFormat: "format",
+			Codec:         "h264",
+			PackageFormat: "hls",
 			EncConfig: model.EncoderConfig{
 				Name:   "my encoder config",
 				Config: "what is a config",
@@ -263,6 +294,7 @@ func (pts *profileTestSuite) TestGormProfileCreate() {
 		}
 		mocket.Catcher.Attach([]*mocket.FakeResponse{encoderInsert, encoderConfigInsert, profileInsert})
 
+		mockProfile(1)
 		err := pts.profileRepo.Create(newProfile)
 		pts.Require().NoError(err)
 		pts.Require().True(profileInsert.Triggered, "profile insert reference must be triggered")
@@ -273,7 +305,7 @@ func (pts *profileTestSuite) TestGormProfileCreate() {
 	pts.Run("Should bubble up any unhandled error", func() {
 		pts.SetupTest()
 		expectedError := stderrors.New("my error")
-		newProfile := model.Profile{EncConfig: model.EncoderConfig{ID: 1}}
+		newProfile := model.Profile{Name: "my name", Codec: "h264", PackageFormat: "hls", EncConfig: model.EncoderConfig{ID: 1}}
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
 				Pattern: `INSERT INTO "profiles" ("name","codec","package_format","encoder_config_id") VALUES (?,?,?,?)`,
@@ -292,15 +324,28 @@ func (pts *profileTestSuite) TestGormProfileCreate() {
 		pts.Require().Error(err)
 		pts.Require().EqualError(errors.Cause(err), expectedError.Error())
 	})
+
+	pts.Run("Should reject an invalid profile before inserting it", func() {
+		pts.SetupTest()
+		profileInsert := &mocket.FakeResponse{Pattern: `INSERT INTO "profiles"`, Once: true}
+		mocket.Catcher.Attach([]*mocket.FakeResponse{profileInsert})
+
+		err := pts.profileRepo.Create(&model.Profile{Name: "my name", Codec: "my codec", PackageFormat: "hls"})
+		validationError, ok := validation.As(err)
+		pts.Require().True(ok)
+		pts.Require().Equal(validation.Unsupported, validationError.Field("Codec").Reason)
+		pts.Require().False(profileInsert.Triggered)
+	})
 }
 
 func (pts *profileTestSuite) TestGormProfileUpdate() {
 	pts.Run("Should update an existing profile in database", func() {
 		pts.SetupTest()
 		newProfile := &model.Profile{
+			ID:            1,
 			Name:          "my name",
-			Codec:         "my codec",
-			PackageFormat: "format",
+			Codec:         "h264",
+			PackageFormat: "hls",
 			EncConfig: model.EncoderConfig{
 				Name:   "my encoder config",
 				Config: "what is a config",
@@ -312,9 +357,13 @@ func (pts *profileTestSuite) TestGormProfileUpdate() {
 			},
 		}
 
+		mockProfile(1)
 		err := pts.profileRepo.Create(newProfile)
 		pts.Require().NoError(err)
 		newProfile.Name = "updated name"
+		mockProfile(newProfile.ID)
+		entry := mocket.Catcher.NewMock().WithQuery(`INSERT INTO "audit_entries"`)
+		notify := mocket.Catcher.NewMock().WithQuery(`SELECT pg_notify`).WithArgs(CacheInvalidationChannel)
 
 		profileUpdate := &mocket.FakeResponse{
 			Pattern: `UPDATE "profiles" SET "codec" = ?, "encoder_config_id" = ?, "id" = ?, "name" = ?, "package_format" = ?  WHERE "profiles"."id" = ?`,
@@ -334,14 +383,19 @@ func (pts *profileTestSuite) TestGormProfileUpdate() {
 		err = pts.profileRepo.Update(newProfile)
 		pts.Require().NoError(err)
 		pts.Require().True(profileUpdate.Triggered, "profile update reference must be triggered")
+		pts.Require().True(entry.Triggered, "the update must be audited")
+		pts.Require().True(notify.Triggered, "the profile caches must be notified")
 	})
 
 	pts.Run("Should bubble up any unhandled error", func() {
 		pts.SetupTest()
 		expectedError := stderrors.New("my error")
-		newProfile := model.Profile{EncConfig: model.EncoderConfig{}}
-		err := pts.profileRepo.Create(&newProfile)
+		created := &model.Profile{ID: 2, Name: "my name", Codec: "h264", PackageFormat: "hls"}
+		mockProfile(2)
+		err := pts.profileRepo.Create(created)
 		pts.Require().NoError(err)
+		newProfile := model.Profile{ID: 2, EncConfig: created.EncConfig}
+		mockProfile(newProfile.ID)
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
 				Pattern: `UPDATE "profiles" SET "encoder_config_id" = ?, "id" = ?  WHERE "profiles"."id" = ?`,
@@ -365,11 +419,9 @@ func (pts *profileTestSuite) TestGormProfileDelete() {
 	pts.Run("Should delete an existing profile in database", func() {
 		pts.SetupTest()
 
+		mockProfile(1)
 		profileDelete := &mocket.FakeResponse{
-			Pattern: `DELETE FROM "profiles"  WHERE "profiles"."id" = ?`,
-			Args: []interface{}{
-				int64(1),
-			},
+			Pattern:      `UPDATE "profiles" SET "deleted_at"=?  WHERE "profiles"."deleted_at" IS NULL AND "profiles"."id" = ?`,
 			RowsAffected: 1,
 			Once:         true,
 		}
@@ -384,11 +436,9 @@ func (pts *profileTestSuite) TestGormProfileDelete() {
 	pts.Run("Should return EntityNotFound error when profile is not found.", func() {
 		pts.SetupTest()
 
+		mockProfile(1)
 		profileDelete := &mocket.FakeResponse{
-			Pattern: `DELETE FROM "profiles"  WHERE "profiles"."id" = ?`,
-			Args: []interface{}{
-				int64(1),
-			},
+			Pattern:      `UPDATE "profiles" SET "deleted_at"=?  WHERE "profiles"."deleted_at" IS NULL AND "profiles"."id" = ?`,
 			RowsAffected: 0,
 			Once:         true,
 		}
@@ -404,17 +454,16 @@ func (pts *profileTestSuite) TestGormProfileDelete() {
 	pts.Run("Should bubble up any unhandled error", func() {
 		pts.SetupTest()
 		expectedError := stderrors.New("my error")
-		newProfile := model.Profile{ID: 10, EncConfig: model.EncoderConfig{ID: 1}}
+		newProfile := model.Profile{ID: 10, Name: "my name", Codec: "h264", PackageFormat: "hls", EncConfig: model.EncoderConfig{ID: 1}}
+		mockProfile(10)
 		err := pts.profileRepo.Create(&newProfile)
 		pts.Require().NoError(err)
+		mockProfile(1)
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
-				Pattern: `DELETE FROM "profiles"  WHERE "profiles"."id" = ?`,
-				Args: []interface{}{
-					int64(1),
-				},
-				Once:  true,
-				Error: expectedError,
+				Pattern: `UPDATE "profiles" SET "deleted_at"=?  WHERE "profiles"."deleted_at" IS NULL AND "profiles"."id" = ?`,
+				Once:    true,
+				Error:   expectedError,
 			},
 		})
 
@@ -465,3 +514,252 @@ func (pts *profileTestSuite) TestGormProfileAll() {
 		pts.Require().EqualError(errors.Cause(err), expectedError.Error())
 	})
 }
+
+func (pts *profileTestSuite) TestReadReplicaRouting() {
+	pts.Run("Should read from a replica and write to the primary", func() {
+		pts.SetupTest()
+		resolver := &recordingResolver{database: pts.db}
+		repo := NewWithResolver(resolver)
+
+		_, _ = repo.Get(1)
+		_, _ = repo.All()
+		_, _ = repo.GetByName("name")
+		pts.Require().Equal(3, resolver.replica)
+		pts.Require().Equal(0, resolver.primary)
+
+		_ = repo.Create(&model.Profile{Name: "name", Codec: "h264", PackageFormat: "hls"})
+		_ = repo.Update(&model.Profile{ID: 1})
+		_ = repo.Delete(1)
+		pts.Require().Equal(3, resolver.replica)
+		pts.Require().Equal(3, resolver.primary)
+	})
+	pts.Run("Should read from the primary when forced", func() {
+		pts.SetupTest()
+		resolver := &recordingResolver{database: pts.db}
+
+		_, _ = NewWithResolver(resolver).UsePrimary().Get(1)
+		pts.Require().Equal(0, resolver.replica)
+		pts.Require().Equal(1, resolver.primary)
+	})
+}
+
+func TestProfileRepositoryOnSQLite(t *testing.T) {
+	database := repositorytest.OpenSQLite(t)
+	profileRepo := New(database)
+
+	first := &model.Profile{
+		Name:          "h264-hls",
+		Codec:         "h264",
+		PackageFormat: "hls",
+		EncConfig: model.EncoderConfig{
+			Name:    "default",
+			Config:  "{}",
+			Encoder: model.Encoder{Name: "bitmovin", ApiEndpoint: "https://api.bitmovin.com", InfoUrl: "https://bitmovin.com"},
+		},
+	}
+	require.NoError(t, profileRepo.Create(first))
+	require.NotZero(t, first.ID)
+	require.NotZero(t, first.EncConfig.ID)
+	second := &model.Profile{Name: "h265-dash", Codec: "h265", PackageFormat: "dash", EncConfig: first.EncConfig}
+	require.NoError(t, profileRepo.Create(second))
+
+	t.Run("Should get a profile with its encoder config and encoder", func(t *testing.T) {
+		profile, err := profileRepo.Get(first.ID)
+		require.NoError(t, err)
+		require.Equal(t, first, profile)
+	})
+	t.Run("Should get profiles by name", func(t *testing.T) {
+		profile, err := profileRepo.GetByName("h265-dash")
+		require.NoError(t, err)
+		require.Equal(t, second.ID, profile.ID)
+		require.Equal(t, first.EncConfig, profile.EncConfig, "The existing encoder config is shared")
+
+		profiles, err := profileRepo.GetManyByName([]string{"h264-hls", "h265-dash", "unknown"})
+		require.NoError(t, err)
+		require.Len(t, profiles, 2)
+
+		_, err = profileRepo.GetByName("unknown")
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err))
+	})
+	t.Run("Should update a profile", func(t *testing.T) {
+		second.Codec = "av1"
+		require.NoError(t, profileRepo.Update(second))
+
+		profile, err := profileRepo.Get(second.ID)
+		require.NoError(t, err)
+		require.Equal(t, "av1", profile.Codec)
+	})
+	t.Run("Should keep every revision of an updated profile", func(t *testing.T) {
+		revision, err := profileRepo.GetRevision("h265-dash", 1)
+		require.NoError(t, err)
+		require.Equal(t, "h265", revision.Codec)
+		require.Equal(t, first.EncConfig, revision.EncConfig)
+		require.False(t, revision.CreatedAt.IsZero())
+
+		revision, err = profileRepo.GetRevision("h265-dash", 2)
+		require.NoError(t, err)
+		require.Equal(t, second.ID, revision.ID)
+		require.Equal(t, "av1", revision.Codec)
+
+		_, err = profileRepo.GetRevision("h265-dash", 3)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err))
+	})
+	t.Run("Should roll back an invalid update", func(t *testing.T) {
+		err := profileRepo.Update(&model.Profile{ID: second.ID, PackageFormat: "mp4"})
+		validationError, ok := validation.As(err)
+		require.True(t, ok)
+		require.Equal(t, []*validation.FieldError{validationError.Field("PackageFormat")}, validationError.Fields)
+
+		profile, err := profileRepo.Get(second.ID)
+		require.NoError(t, err)
+		require.Equal(t, "dash", profile.PackageFormat)
+		_, err = profileRepo.GetRevision("h265-dash", 3)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err), "No revision is recorded")
+	})
+	t.Run("Should not write the shared encoder config back", func(t *testing.T) {
+		update := *second
+		update.EncConfig = first.EncConfig
+		update.EncConfig.Name, update.EncConfig.Encoder.Name = "renamed", "renamed"
+		require.NoError(t, profileRepo.Update(&update))
+		require.NoError(t, profileRepo.Update(&model.Profile{ID: second.ID, Name: second.Name}))
+
+		profiles, err := profileRepo.GetMany([]int{first.ID, second.ID})
+		require.NoError(t, err)
+		require.Len(t, profiles, 2)
+		require.Equal(t, first.EncConfig, profiles[0].EncConfig)
+		require.Equal(t, first.EncConfig, profiles[1].EncConfig, "Both profiles keep the unchanged encoder config")
+		var configs, encoders int
+		require.NoError(t, database.Table("encoder_configs").Count(&configs).Error)
+		require.NoError(t, database.Table("encoders").Count(&encoders).Error)
+		require.Equal(t, []int{1, 1}, []int{configs, encoders}, "No empty encoder config is added")
+	})
+	t.Run("Should delete a profile", func(t *testing.T) {
+		require.NoError(t, profileRepo.Delete(second.ID))
+
+		profiles, err := profileRepo.All()
+		require.NoError(t, err)
+		require.Len(t, profiles, 1)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(profileRepo.Delete(second.ID)))
+	})
+	t.Run("Should only read deleted profiles when asked", func(t *testing.T) {
+		_, err := profileRepo.Get(second.ID)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err))
+
+		profile, err := profileRepo.IncludeDeleted().Get(second.ID)
+		require.NoError(t, err)
+		require.Equal(t, "av1", profile.Codec)
+		profiles, err := profileRepo.IncludeDeleted().All()
+		require.NoError(t, err)
+		require.Len(t, profiles, 2)
+
+		revision, err := profileRepo.GetRevision("h265-dash", 2)
+		require.NoError(t, err)
+		require.Equal(t, "av1", revision.Codec, "The revisions of a deleted profile remain")
+	})
+	t.Run("Should restore a deleted profile", func(t *testing.T) {
+		require.NoError(t, profileRepo.Restore(second.ID))
+
+		profile, err := profileRepo.GetByName("h265-dash")
+		require.NoError(t, err)
+		require.Equal(t, second.ID, profile.ID)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(profileRepo.Restore(second.ID)))
+	})
+	t.Run("Should purge profiles deleted long enough ago", func(t *testing.T) {
+		require.NoError(t, profileRepo.Delete(second.ID))
+
+		purged, err := profileRepo.Purge(time.Hour)
+		require.NoError(t, err)
+		require.Zero(t, purged)
+		purged, err = profileRepo.Purge(-time.Second)
+		require.NoError(t, err)
+		require.Equal(t, 1, purged)
+
+		_, err = profileRepo.IncludeDeleted().Get(second.ID)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err))
+	})
+	t.Run("Should audit who changed a codec", func(t *testing.T) {
+		ctx := routing.WithActor(context.Background(), "alice@example.com")
+		first.Codec = "h265"
+		require.NoError(t, profileRepo.WithContext(ctx).Update(first))
+
+		entries, err := audit.New(database).Query(&audit.Filter{EntityType: audit.EntityProfile, Actor: "alice@example.com"}, 0)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, first.ID, entries[0].EntityID)
+		require.Equal(t, map[string]model.FieldChange{
+			"Codec": {Before: json.RawMessage(`"h264"`), After: json.RawMessage(`"h265"`)},
+		}, entries[0].Changes)
+	})
+	t.Run("Should not update a missing profile", func(t *testing.T) {
+		err := profileRepo.Update(&model.Profile{ID: second.ID + 1, Name: "missing"})
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err))
+	})
+
+	third := &model.Profile{Name: "vp9-hls", Codec: "vp9", PackageFormat: "hls", EncConfig: first.EncConfig}
+	require.NoError(t, profileRepo.Create(third))
+	t.Run("Should reject the name of another profile", func(t *testing.T) {
+		err := profileRepo.Create(&model.Profile{Name: "h264-hls", Codec: "h265", PackageFormat: "dash", EncConfig: first.EncConfig})
+		require.Equal(t, repository.ErrDuplicate, errors.Cause(err))
+		err = profileRepo.Update(&model.Profile{ID: third.ID, Name: "h264-hls"})
+		require.Equal(t, repository.ErrDuplicate, errors.Cause(err))
+
+		profile, err := profileRepo.Get(third.ID)
+		require.NoError(t, err)
+		require.Equal(t, "vp9-hls", profile.Name)
+	})
+	t.Run("Should get the revisions of a renamed profile by ID", func(t *testing.T) {
+		require.NoError(t, profileRepo.Update(&model.Profile{ID: third.ID, Name: "vp9-dash", PackageFormat: "dash"}))
+
+		revision, err := profileRepo.GetRevisionByID(third.ID, 1)
+		require.NoError(t, err)
+		require.Equal(t, "vp9-hls", revision.Name)
+		revision, err = profileRepo.GetRevisionByID(third.ID, 2)
+		require.NoError(t, err)
+		require.Equal(t, "vp9-dash", revision.Name)
+
+		_, err = profileRepo.GetRevision("vp9-hls", 1)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err), "The profile is found by its current name only")
+		_, err = profileRepo.GetRevisionByID(third.ID, 3)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err))
+	})
+
+	fourth := &model.Profile{Name: "vp9-dash", Codec: "vp9", PackageFormat: "dash", EncConfig: first.EncConfig}
+	t.Run("Should not restore a profile whose name was taken since", func(t *testing.T) {
+		require.NoError(t, profileRepo.Delete(third.ID))
+		require.NoError(t, profileRepo.Create(fourth))
+		require.Equal(t, repository.ErrDuplicate, errors.Cause(profileRepo.Restore(third.ID)))
+
+		require.NoError(t, profileRepo.Delete(fourth.ID))
+		require.NoError(t, profileRepo.Restore(third.ID))
+	})
+	t.Run("Should get the profile that is not deleted first by name", func(t *testing.T) {
+		profile, err := profileRepo.IncludeDeleted().GetByName("vp9-dash")
+		require.NoError(t, err)
+		require.Equal(t, third.ID, profile.ID)
+
+		revision, err := profileRepo.GetRevision("vp9-dash", 1)
+		require.NoError(t, err)
+		require.Equal(t, "vp9-hls", revision.Name, "The revision is the one of the same profile")
+	})
+	t.Run("Should keep the profiles jobs are pinned to when purging", func(t *testing.T) {
+		require.NoError(t, profileRepo.Delete(third.ID))
+		output := &gormmodel.JobOutput{JobID: 1, ProfileID: third.ID, ProfileRevision: 2}
+		require.NoError(t, database.Create(output).Error)
+
+		purged, err := profileRepo.Purge(-time.Second)
+		require.NoError(t, err)
+		require.Equal(t, 1, purged)
+		_, err = profileRepo.IncludeDeleted().Get(fourth.ID)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err))
+		_, err = profileRepo.GetRevisionByID(fourth.ID, 1)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err), "The revisions are purged along with the profile")
+		revision, err := profileRepo.GetRevisionByID(third.ID, 2)
+		require.NoError(t, err)
+		require.Equal(t, "vp9-dash", revision.Name)
+
+		require.NoError(t, database.Delete(output).Error)
+		purged, err = profileRepo.Purge(-time.Second)
+		require.NoError(t, err)
+		require.Equal(t, 1, purged)
+	})
+}
@@ -0,0 +1,61 @@
+package profile
+
+import (
+	"sort"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// recordRevision snapshots the profile, along with its encoder config and encoder, as its current revision.
+func recordRevision(tx *gorm.DB, record *gormmodel.ProfileRecord) error {
+	revision := gormmodel.ToGormProfileRevision(gormmodel.ToProfile(&record.Profile), record.Revision)
+	if err := tx.Create(revision).Error; err != nil {
+		return errors.Wrapf(err, "unable to record revision %v of profile %v", record.Revision, record.ID)
+	}
+	return nil
+}
+
+// recordRevisions snapshots the profiles as their current revision like recordRevision, with one statement.
+func recordRevisions(tx *gorm.DB, records map[int]*gormmodel.ProfileRecord) error {
+	ids := make([]int, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	revisions := make([]interface{}, len(ids))
+	for i, id := range ids {
+		revisions[i] = gormmodel.ToGormProfileRevision(gormmodel.ToProfile(&records[id].Profile), records[id].Revision)
+	}
+	if err := repository.InsertMany(tx, revisions...); err != nil {
+		return errors.Wrapf(err, "unable to record the revisions of profiles %v", ids)
+	}
+	return nil
+}
+
+func (profileRepo *gormRepository) GetRevision(name string, revision int) (*model.ProfileRevision, error) {
+	var record gormmodel.ProfileRevision
+	err := repository.EvaluateError(repository.Run(profileRepo.ctx, profileRepo.resolver.Replica(), func(db *gorm.DB) error {
+		// The profile GetByName would return when including the deleted profiles.
+		return db.Where("profile_id = (SELECT id FROM profiles WHERE name = ? ORDER BY "+latestFirst+" LIMIT 1) AND revision = ?", name, revision).
+			First(&record).Error
+	}))
+	if err != nil {
+		return nil, errors.Wrapf(err, "did not find revision %v of profile %v", revision, name)
+	}
+	return gormmodel.ToProfileRevision(&record), nil
+}
+
+func (profileRepo *gormRepository) GetRevisionByID(profileID int, revision int) (*model.ProfileRevision, error) {
+	var record gormmodel.ProfileRevision
+	err := repository.EvaluateError(repository.Run(profileRepo.ctx, profileRepo.resolver.Replica(), func(db *gorm.DB) error {
+		return db.Where("profile_id = ? AND revision = ?", profileID, revision).First(&record).Error
+	}))
+	if err != nil {
+		return nil, errors.Wrapf(err, "did not find revision %v of profile %v", revision, profileID)
+	}
+	return gormmodel.ToProfileRevision(&record), nil
+}
@@ -0,0 +1,90 @@
+// Package repositorytest runs the repositories against an embedded SQLite database, so that their behavior
+// can be tested without a Postgres instance or assertions on the generated SQL.
+package repositorytest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/jinzhu/gorm"
+	"github.com/mattn/go-sqlite3"
+)
+
+const driverName = "sqlite3_repositorytest"
+
+var registerDriver sync.Once
+
+// OpenSQLite opens an SQLite database holding every gormmodel table, in a temporary file so
+// that it outlives the connections database/sql discards, such as those of cancelled transactions.
+// The database is closed when the test completes.
+func OpenSQLite(t testing.TB) *gorm.DB {
+	registerDriver.Do(func() {
+		sql.Register(driverName, &sqlite3.SQLiteDriver{
+			// The bundled SQLite is only built with JSON support under the json1 build tag,
+			// so the one JSON function the repositories rely on is provided here.
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				return conn.RegisterFunc("json_extract", jsonExtract, true)
+			},
+		})
+	})
+
+	dir, err := ioutil.TempDir("", "repositorytest")
+	if err != nil {
+		t.Fatalf("creating SQLite directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sqlDB, err := sql.Open(driverName, filepath.Join(dir, "repository.db"))
+	if err != nil {
+		t.Fatalf("opening SQLite database: %v", err)
+	}
+	// A single connection, as the tests run one statement at a time and SQLite locks the whole database on writes.
+	sqlDB.SetMaxOpenConns(1)
+	database, err := gorm.Open("sqlite3", sqlDB)
+	if err != nil {
+		t.Fatalf("opening SQLite database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if err := database.AutoMigrate(gormmodel.Models()...).Error; err != nil {
+		t.Fatalf("creating SQLite tables: %v", err)
+	}
+	return database
+}
+
+// jsonExtract implements json_extract for the '$.field' paths, returning the field as text.
+func jsonExtract(document interface{}, path string) (string, error) {
+	var raw []byte
+	switch value := document.(type) {
+	case nil:
+		return "", nil
+	case []byte:
+		raw = value
+	case string:
+		raw = []byte(value)
+	default:
+		return "", fmt.Errorf("json_extract: unsupported document %T", document)
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return "", fmt.Errorf("json_extract: %v", err)
+	}
+	field, ok := object[strings.TrimPrefix(path, "$.")]
+	if !ok {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(field, &text); err != nil {
+		// Not a string, return the JSON value as is.
+		return string(field), nil
+	}
+	return text, nil
+}
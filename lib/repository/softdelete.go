@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/jinzhu/gorm"
+)
+
+// PurgeBatchSize is the number of rows Purge deletes per statement, so that no statement holds its locks for long.
+var PurgeBatchSize = 500
+
+// Restore clears the deleted_at column of the soft deleted row of model's table with the specified ID.
+// It returns ErrEntityNotFound when there is no such row or it is not soft deleted.
+func Restore(db *gorm.DB, model interface{}, id int) error {
+	table := db.NewScope(model).TableName()
+	result := db.Unscoped().Model(model).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+	if result.Error != nil {
+		return errors.Wrapf(result.Error, "unable to restore %s %v", table, id)
+	}
+	if result.RowsAffected == 0 {
+		return errors.Wrapf(ErrEntityNotFound, "did not find deleted %s %v", table, id)
+	}
+
+	return nil
+}
+
+// Purge permanently deletes the rows of model's table that were soft deleted before cutoff, PurgeBatchSize rows at a time,
+// and returns the number of rows deleted. Each batch is bound to ctx, see Run. Rows deleted by earlier batches stay
+// deleted when a later batch fails or ctx is done.
+func Purge(ctx context.Context, db *gorm.DB, model interface{}, cutoff time.Time) (int, error) {
+	return PurgeWith(ctx, db, model, cutoff, PurgeOptions{})
+}
+
+// PurgeOptions tailor PurgeWith to the tables other tables refer to.
+type PurgeOptions struct {
+	// Keep is an SQL condition on the rows of the table that keeps the rows it matches, such as the rows that others
+	// still refer to.
+	Keep string
+	// BeforeDelete runs in the transaction of every batch, with the IDs of its rows, before they are deleted, such
+	// as to delete the rows referring to them.
+	BeforeDelete func(tx *gorm.DB, ids []int) error
+}
+
+// PurgeWith purges the rows of model's table like Purge, within the limits of options.
+func PurgeWith(ctx context.Context, db *gorm.DB, model interface{}, cutoff time.Time, options PurgeOptions) (int, error) {
+	table := db.NewScope(model).TableName()
+	batch := fmt.Sprintf("SELECT id FROM %s WHERE deleted_at < ?", table)
+	if options.Keep != "" {
+		batch += fmt.Sprintf(" AND NOT (%s)", options.Keep)
+	}
+	batch += " ORDER BY id LIMIT ?"
+	purged := 0
+	for {
+		var deleted int64
+		err := Run(ctx, db, func(db *gorm.DB) error {
+			return TransactionContext(ctx, db, func(tx *gorm.DB) error {
+				var ids []int
+				if err := tx.Raw(batch, cutoff.UTC(), PurgeBatchSize).Pluck("id", &ids).Error; err != nil {
+					return err
+				}
+				if len(ids) == 0 {
+					return nil
+				}
+				if options.BeforeDelete != nil {
+					if err := options.BeforeDelete(tx, ids); err != nil {
+						return err
+					}
+				}
+				result := tx.Unscoped().Where("id IN (?)", ids).Delete(model)
+				deleted = result.RowsAffected
+				return result.Error
+			})
+		})
+		if err != nil {
+			return purged, errors.Wrapf(err, "unable to purge %s deleted before %v", table, cutoff)
+		}
+		purged += int(deleted)
+		if deleted < int64(PurgeBatchSize) {
+			return purged, nil
+		}
+	}
+}
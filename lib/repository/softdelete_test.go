@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSoftDelete(t *testing.T) {
+	db := repositorytest.OpenSQLite(t)
+	var targets []*gormmodel.TargetRecord
+	for _, path := range []string{"s3://bucket/a", "s3://bucket/b", "s3://bucket/c", "s3://bucket/d"} {
+		target := &gormmodel.TargetRecord{Target: gormmodel.Target{TargetType: "s3", Path: path}}
+		require.NoError(t, db.Create(target).Error)
+		targets = append(targets, target)
+	}
+	count := func(unscoped bool) int {
+		var total int
+		query := db.Model(&gormmodel.TargetRecord{})
+		if unscoped {
+			query = query.Unscoped()
+		}
+		require.NoError(t, query.Count(&total).Error)
+		return total
+	}
+
+	t.Run("Should restore a soft deleted row only", func(t *testing.T) {
+		require.NoError(t, db.Delete(targets[0]).Error)
+		require.Equal(t, 3, count(false))
+
+		require.NoError(t, Restore(db, &gormmodel.TargetRecord{}, targets[0].ID))
+		require.Equal(t, 4, count(false))
+		require.Equal(t, ErrEntityNotFound, errors.Cause(Restore(db, &gormmodel.TargetRecord{}, targets[0].ID)))
+	})
+	t.Run("Should purge the rows deleted before the cutoff in batches", func(t *testing.T) {
+		defer func(size int) { PurgeBatchSize = size }(PurgeBatchSize)
+		PurgeBatchSize = 2
+		for _, target := range targets[:3] {
+			require.NoError(t, db.Delete(target).Error)
+		}
+
+		purged, err := Purge(context.Background(), db, &gormmodel.TargetRecord{}, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		require.Zero(t, purged)
+
+		purged, err = Purge(context.Background(), db, &gormmodel.TargetRecord{}, time.Now().Add(time.Second))
+		require.NoError(t, err)
+		require.Equal(t, 3, purged)
+		require.Equal(t, 1, count(true))
+	})
+}
@@ -0,0 +1,196 @@
+package target
+
+import (
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/audit"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/validation"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+func (targetRepo *gormRepository) CreateMany(targets []*model.Target, mode repository.BulkMode) (repository.BulkResults, error) {
+	operation := repository.NewBulkOperation(mode, len(targets))
+	records := make([]*gormmodel.TargetRecord, len(targets))
+	for i, target := range targets {
+		if err := validation.ValidateTarget(target); err != nil {
+			operation.Fail(i, errors.WithMessage(err, "unable to create target"))
+			continue
+		}
+		gormTarget, err := targetRepo.seal(target)
+		if err != nil {
+			operation.Fail(i, err)
+			continue
+		}
+		records[i] = &gormmodel.TargetRecord{Target: *gormTarget}
+	}
+
+	created := make([]model.Target, len(targets))
+	results, err := operation.Run(targetRepo.ctx, targetRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		items := operation.Succeeded()
+		inserted := make([]interface{}, len(items))
+		for j, i := range items {
+			inserted[j] = records[i]
+		}
+		if err := operation.Insert(tx, items, inserted); err != nil {
+			return err
+		}
+		var changes []*audit.Change
+		for _, i := range operation.Succeeded() {
+			created[i] = *targets[i]
+			created[i].ID = records[i].ID
+			operation.SetID(i, created[i].ID)
+			changes = append(changes, change(model.AuditCreate, created[i].ID, nil, &created[i]))
+		}
+		return audit.RecordMany(targetRepo.ctx, tx, changes)
+	})
+	if err != nil {
+		return results, err
+	}
+	for i, result := range results {
+		if result.Err == nil {
+			*targets[i] = created[i]
+		}
+	}
+	return results, nil
+}
+
+func (targetRepo *gormRepository) UpdateMany(targets []*model.Target, mode repository.BulkMode) (repository.BulkResults, error) {
+	operation := repository.NewBulkOperation(mode, len(targets))
+	ids := make([]int, len(targets))
+	for i, target := range targets {
+		ids[i] = target.ID
+	}
+	operation.FailDuplicates(ids)
+	gormTargets := make([]*gormmodel.Target, len(targets))
+	for _, i := range operation.Succeeded() {
+		gormTarget, err := targetRepo.seal(targets[i])
+		if err != nil {
+			operation.Fail(i, err)
+			continue
+		}
+		gormTargets[i] = gormTarget
+	}
+
+	return operation.Run(targetRepo.ctx, targetRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		before, err := targetRepo.lockTargets(tx, operation, ids)
+		if err != nil {
+			return err
+		}
+		// The update only sets the non-empty fields, so the targets are validated as merged with their locked rows
+		// before any of them is written.
+		after := make(map[int]*model.Target, len(targets))
+		for _, i := range operation.Succeeded() {
+			updated := mergeTarget(before[ids[i]], targets[i])
+			if err := validation.ValidateTarget(updated); err != nil {
+				if err := operation.Fail(i, errors.WithMessagef(err, "unable to update target %v", ids[i])); err != nil {
+					return err
+				}
+				continue
+			}
+			after[ids[i]] = updated
+		}
+		// Every target sets its own non-empty fields, so each one takes an UPDATE of its own rather than sharing one
+		// UPDATE ... FROM (VALUES ...) statement, which would have to set every column of every row.
+		for _, i := range operation.Succeeded() {
+			err := operation.Item(tx, i, func(tx *gorm.DB) error {
+				if err := tx.Model(gormTargets[i]).Update(gormTargets[i]).Error; err != nil {
+					return errors.Wrapf(err, "unable to update target %v", ids[i])
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		var changes []*audit.Change
+		for _, i := range operation.Succeeded() {
+			operation.SetID(i, ids[i])
+			changes = append(changes, change(model.AuditUpdate, ids[i], before[ids[i]], after[ids[i]]))
+		}
+		return audit.RecordMany(targetRepo.ctx, tx, changes)
+	})
+}
+
+func (targetRepo *gormRepository) DeleteMany(ids []int, mode repository.BulkMode) (repository.BulkResults, error) {
+	operation := repository.NewBulkOperation(mode, len(ids))
+	operation.FailDuplicates(ids)
+
+	return operation.Run(targetRepo.ctx, targetRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		before, err := targetRepo.lockTargets(tx, operation, ids)
+		if err != nil {
+			return err
+		}
+		items := operation.Succeeded()
+		if len(items) == 0 {
+			return nil
+		}
+		deleted := make([]int, len(items))
+		for j, i := range items {
+			deleted[j] = ids[i]
+		}
+		result := tx.Where("id IN (?)", deleted).Delete(&gormmodel.TargetRecord{})
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "unable to delete targets %v", deleted)
+		}
+		if result.RowsAffected != int64(len(deleted)) {
+			return errors.Wrapf(repository.ErrEntityNotFound, "deleted %d of targets %v", result.RowsAffected, deleted)
+		}
+		changes := make([]*audit.Change, len(items))
+		for j, i := range items {
+			operation.SetID(i, ids[i])
+			changes[j] = change(model.AuditDelete, ids[i], before[ids[i]], nil)
+		}
+		return audit.RecordMany(targetRepo.ctx, tx, changes)
+	})
+}
+
+// mergeTarget returns target as updated with the non-empty fields of update.
+func mergeTarget(target *model.Target, update *model.Target) *model.Target {
+	merged := *target
+	if update.TargetType != "" {
+		merged.TargetType = update.TargetType
+	}
+	if update.Path != "" {
+		merged.Path = update.Path
+	}
+	if update.AuthKey != "" {
+		merged.AuthKey = update.AuthKey
+	}
+	return &merged
+}
+
+// lockTargets reads and locks the targets of the items of operation that did not fail yet, ids[i] being the ID of
+// item i, with one statement. The items whose target does not exist fail with repository.ErrEntityNotFound.
+// It returns the error that stops the operation.
+func (targetRepo *gormRepository) lockTargets(tx *gorm.DB, operation *repository.BulkOperation, ids []int) (map[int]*model.Target, error) {
+	items := operation.Succeeded()
+	if len(items) == 0 {
+		return nil, nil
+	}
+	wanted := make([]int, len(items))
+	for j, i := range items {
+		wanted[j] = ids[i]
+	}
+	var records []*gormmodel.TargetRecord
+	if err := repository.ForUpdate(tx, false).Find(&records, "id IN (?)", wanted).Error; err != nil {
+		return nil, errors.Wrapf(err, "unable to get targets %v", wanted)
+	}
+	targets, err := targetRepo.openAll(records)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[int]*model.Target, len(targets))
+	for _, target := range targets {
+		found[target.ID] = target
+	}
+	for _, i := range items {
+		if _, ok := found[ids[i]]; !ok {
+			if err := operation.Fail(i, errors.Wrapf(repository.ErrEntityNotFound, "unable to get target %v", ids[i])); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,99 @@
+package target
+
+import (
+	"testing"
+
+	"github.com/EurosportDigital/global-transcoding-platform/db"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/audit"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/validation"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetBulkOnSQLite(t *testing.T) {
+	database := repositorytest.OpenSQLite(t)
+	keys := newTestKeys(t)
+	targetRepo := NewWithKeyProvider(db.NewCluster(database), keys)
+	all := func() []*model.Target {
+		targets, err := targetRepo.All()
+		require.NoError(t, err)
+		return targets
+	}
+
+	first := &model.Target{TargetType: "s3", Path: "s3://bucket/first", AuthKey: "key"}
+	second := &model.Target{TargetType: "akamai", Path: "https://akamai.example.com/second"}
+	invalid := &model.Target{TargetType: "s3", Path: "https://bucket/invalid"}
+
+	t.Run("Should create none of the targets when one is invalid", func(t *testing.T) {
+		results, err := targetRepo.CreateMany([]*model.Target{first, invalid, second}, repository.AllOrNothing)
+		_, ok := validation.As(err)
+		require.True(t, ok)
+		require.Equal(t, repository.ErrRolledBack, results[0].Err)
+		require.Equal(t, []int{0, 1, 2}, results.Failed())
+		require.Zero(t, first.ID)
+		require.Empty(t, all())
+	})
+	t.Run("Should create the valid targets", func(t *testing.T) {
+		results, err := targetRepo.CreateMany([]*model.Target{first, invalid, second}, repository.PartialSuccess)
+		require.NoError(t, err)
+		require.Equal(t, []int{1}, results.Failed())
+		_, ok := validation.As(results[1].Err)
+		require.True(t, ok)
+		require.NotZero(t, first.ID)
+		require.Equal(t, repository.BulkResult{ID: first.ID}, results[0])
+		require.Equal(t, repository.BulkResult{ID: second.ID}, results[2])
+		require.Zero(t, invalid.ID)
+		require.Equal(t, []*model.Target{first, second}, all())
+
+		var authKey string
+		require.NoError(t, database.Table("targets").Select("auth_key").Where("id = ?", first.ID).Row().Scan(&authKey))
+		requireSealed(t, keys, "key", authKey)
+	})
+	t.Run("Should update the targets that exist", func(t *testing.T) {
+		first.AuthKey = "rotated"
+		second.Path = "https://akamai.example.com/moved"
+		results, err := targetRepo.UpdateMany([]*model.Target{first, {ID: second.ID + 1, Path: "s3://bucket/missing"}, second}, repository.PartialSuccess)
+		require.NoError(t, err)
+		require.Equal(t, []int{1}, results.Failed())
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(results[1].Err))
+		require.Equal(t, []*model.Target{first, second}, all())
+	})
+	t.Run("Should roll back the updates when one is invalid", func(t *testing.T) {
+		updates := []*model.Target{{ID: first.ID, Path: "s3://bucket/renamed"}, {ID: second.ID, TargetType: "s3"}}
+		results, err := targetRepo.UpdateMany(updates, repository.AllOrNothing)
+		_, ok := validation.As(err)
+		require.True(t, ok)
+		require.Equal(t, repository.ErrRolledBack, results[0].Err)
+		require.Equal(t, []*model.Target{first, second}, all())
+
+		results, err = targetRepo.UpdateMany(updates, repository.PartialSuccess)
+		require.NoError(t, err)
+		require.Equal(t, []int{1}, results.Failed())
+		first.Path = "s3://bucket/renamed"
+		require.Equal(t, []*model.Target{first, second}, all(), "The invalid update is rolled back alone")
+	})
+	t.Run("Should delete the targets", func(t *testing.T) {
+		results, err := targetRepo.DeleteMany([]int{first.ID, second.ID + 1}, repository.AllOrNothing)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err))
+		require.Equal(t, repository.ErrRolledBack, results[0].Err)
+		require.Len(t, all(), 2)
+
+		results, err = targetRepo.DeleteMany([]int{first.ID, second.ID, first.ID}, repository.PartialSuccess)
+		require.NoError(t, err)
+		require.Equal(t, []int{2}, results.Failed())
+		require.Empty(t, all())
+	})
+	t.Run("Should audit every change without the AuthKey values", func(t *testing.T) {
+		history, err := audit.New(database).History(audit.EntityTarget, first.ID)
+		require.NoError(t, err)
+		actions := make([]model.AuditAction, len(history))
+		for i, entry := range history {
+			actions[i] = entry.Action
+		}
+		require.Equal(t, []model.AuditAction{model.AuditCreate, model.AuditUpdate, model.AuditUpdate, model.AuditDelete}, actions)
+		require.NotContains(t, string(history[1].Changes["AuthKey"].After), "rotated")
+	})
+}
@@ -0,0 +1,53 @@
+package target
+
+import (
+	"context"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+)
+
+func (targetRepo *gormRepository) GetContext(ctx context.Context, id int) (*model.Target, error) {
+	return targetRepo.WithContext(ctx).Get(id)
+}
+
+func (targetRepo *gormRepository) GetManyContext(ctx context.Context, ids []int) ([]*model.Target, error) {
+	return targetRepo.WithContext(ctx).GetMany(ids)
+}
+
+func (targetRepo *gormRepository) CreateContext(ctx context.Context, target *model.Target) error {
+	return targetRepo.WithContext(ctx).Create(target)
+}
+
+func (targetRepo *gormRepository) UpdateContext(ctx context.Context, target *model.Target) error {
+	return targetRepo.WithContext(ctx).Update(target)
+}
+
+func (targetRepo *gormRepository) DeleteContext(ctx context.Context, id int) error {
+	return targetRepo.WithContext(ctx).Delete(id)
+}
+
+func (targetRepo *gormRepository) RestoreContext(ctx context.Context, id int) error {
+	return targetRepo.WithContext(ctx).Restore(id)
+}
+
+func (targetRepo *gormRepository) CreateManyContext(ctx context.Context, targets []*model.Target, mode repository.BulkMode) (repository.BulkResults, error) {
+	return targetRepo.WithContext(ctx).CreateMany(targets, mode)
+}
+
+func (targetRepo *gormRepository) UpdateManyContext(ctx context.Context, targets []*model.Target, mode repository.BulkMode) (repository.BulkResults, error) {
+	return targetRepo.WithContext(ctx).UpdateMany(targets, mode)
+}
+
+func (targetRepo *gormRepository) DeleteManyContext(ctx context.Context, ids []int, mode repository.BulkMode) (repository.BulkResults, error) {
+	return targetRepo.WithContext(ctx).DeleteMany(ids, mode)
+}
+
+func (targetRepo *gormRepository) PurgeContext(ctx context.Context, olderThan time.Duration) (int, error) {
+	return targetRepo.WithContext(ctx).Purge(olderThan)
+}
+
+func (targetRepo *gormRepository) AllContext(ctx context.Context) ([]*model.Target, error) {
+	return targetRepo.WithContext(ctx).All()
+}
@@ -4,6 +4,10 @@ package mocks
 
 import mock "github.com/stretchr/testify/mock"
 import model "github.com/EurosportDigital/global-transcoding-platform/model"
+import target "github.com/EurosportDigital/global-transcoding-platform/lib/repository/target"
+import time "time"
+import context "context"
+import repository "github.com/EurosportDigital/global-transcoding-platform/lib/repository"
 
 // Repository is an autogenerated mock type for the Repository type
 type Repository struct {
@@ -33,6 +37,29 @@ func (_m *Repository) All() ([]*model.Target, error) {
 	return r0, r1
 }
 
+// AllContext provides a mock function with given fields: ctx
+func (_m *Repository) AllContext(ctx context.Context) ([]*model.Target, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []*model.Target
+	if rf, ok := ret.Get(0).(func(context.Context) []*model.Target); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Target)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Create provides a mock function with given fields: _a0
 func (_m *Repository) Create(_a0 *model.Target) error {
 	ret := _m.Called(_a0)
@@ -47,6 +74,66 @@ func (_m *Repository) Create(_a0 *model.Target) error {
 	return r0
 }
 
+// CreateContext provides a mock function with given fields: ctx, _a1
+func (_m *Repository) CreateContext(ctx context.Context, _a1 *model.Target) error {
+	ret := _m.Called(ctx, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Target) error); ok {
+		r0 = rf(ctx, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateMany provides a mock function with given fields: targets, mode
+func (_m *Repository) CreateMany(targets []*model.Target, mode repository.BulkMode) (repository.BulkResults, error) {
+	ret := _m.Called(targets, mode)
+
+	var r0 repository.BulkResults
+	if rf, ok := ret.Get(0).(func([]*model.Target, repository.BulkMode) repository.BulkResults); ok {
+		r0 = rf(targets, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(repository.BulkResults)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]*model.Target, repository.BulkMode) error); ok {
+		r1 = rf(targets, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateManyContext provides a mock function with given fields: ctx, targets, mode
+func (_m *Repository) CreateManyContext(ctx context.Context, targets []*model.Target, mode repository.BulkMode) (repository.BulkResults, error) {
+	ret := _m.Called(ctx, targets, mode)
+
+	var r0 repository.BulkResults
+	if rf, ok := ret.Get(0).(func(context.Context, []*model.Target, repository.BulkMode) repository.BulkResults); ok {
+		r0 = rf(ctx, targets, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(repository.BulkResults)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []*model.Target, repository.BulkMode) error); ok {
+		r1 = rf(ctx, targets, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Delete provides a mock function with given fields: id
 func (_m *Repository) Delete(id int) error {
 	ret := _m.Called(id)
@@ -61,6 +148,66 @@ func (_m *Repository) Delete(id int) error {
 	return r0
 }
 
+// DeleteContext provides a mock function with given fields: ctx, id
+func (_m *Repository) DeleteContext(ctx context.Context, id int) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteMany provides a mock function with given fields: ids, mode
+func (_m *Repository) DeleteMany(ids []int, mode repository.BulkMode) (repository.BulkResults, error) {
+	ret := _m.Called(ids, mode)
+
+	var r0 repository.BulkResults
+	if rf, ok := ret.Get(0).(func([]int, repository.BulkMode) repository.BulkResults); ok {
+		r0 = rf(ids, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(repository.BulkResults)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]int, repository.BulkMode) error); ok {
+		r1 = rf(ids, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteManyContext provides a mock function with given fields: ctx, ids, mode
+func (_m *Repository) DeleteManyContext(ctx context.Context, ids []int, mode repository.BulkMode) (repository.BulkResults, error) {
+	ret := _m.Called(ctx, ids, mode)
+
+	var r0 repository.BulkResults
+	if rf, ok := ret.Get(0).(func(context.Context, []int, repository.BulkMode) repository.BulkResults); ok {
+		r0 = rf(ctx, ids, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(repository.BulkResults)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []int, repository.BulkMode) error); ok {
+		r1 = rf(ctx, ids, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Get provides a mock function with given fields: id
 func (_m *Repository) Get(id int) (*model.Target, error) {
 	ret := _m.Called(id)
@@ -84,6 +231,29 @@ func (_m *Repository) Get(id int) (*model.Target, error) {
 	return r0, r1
 }
 
+// GetContext provides a mock function with given fields: ctx, id
+func (_m *Repository) GetContext(ctx context.Context, id int) (*model.Target, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *model.Target
+	if rf, ok := ret.Get(0).(func(context.Context, int) *model.Target); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Target)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetMany provides a mock function with given fields: ids
 func (_m *Repository) GetMany(ids []int) ([]*model.Target, error) {
 	ret := _m.Called(ids)
@@ -107,6 +277,115 @@ func (_m *Repository) GetMany(ids []int) ([]*model.Target, error) {
 	return r0, r1
 }
 
+// GetManyContext provides a mock function with given fields: ctx, ids
+func (_m *Repository) GetManyContext(ctx context.Context, ids []int) ([]*model.Target, error) {
+	ret := _m.Called(ctx, ids)
+
+	var r0 []*model.Target
+	if rf, ok := ret.Get(0).(func(context.Context, []int) []*model.Target); ok {
+		r0 = rf(ctx, ids)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Target)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []int) error); ok {
+		r1 = rf(ctx, ids)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IncludeDeleted provides a mock function with given fields:
+func (_m *Repository) IncludeDeleted() target.Repository {
+	ret := _m.Called()
+
+	var r0 target.Repository
+	if rf, ok := ret.Get(0).(func() target.Repository); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(target.Repository)
+		}
+	}
+
+	return r0
+}
+
+// Purge provides a mock function with given fields: olderThan
+func (_m *Repository) Purge(olderThan time.Duration) (int, error) {
+	ret := _m.Called(olderThan)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(time.Duration) int); ok {
+		r0 = rf(olderThan)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(time.Duration) error); ok {
+		r1 = rf(olderThan)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PurgeContext provides a mock function with given fields: ctx, olderThan
+func (_m *Repository) PurgeContext(ctx context.Context, olderThan time.Duration) (int, error) {
+	ret := _m.Called(ctx, olderThan)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration) int); ok {
+		r0 = rf(ctx, olderThan)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Duration) error); ok {
+		r1 = rf(ctx, olderThan)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Restore provides a mock function with given fields: id
+func (_m *Repository) Restore(id int) error {
+	ret := _m.Called(id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int) error); ok {
+		r0 = rf(id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RestoreContext provides a mock function with given fields: ctx, id
+func (_m *Repository) RestoreContext(ctx context.Context, id int) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Update provides a mock function with given fields: _a0
 func (_m *Repository) Update(_a0 *model.Target) error {
 	ret := _m.Called(_a0)
@@ -120,3 +399,95 @@ func (_m *Repository) Update(_a0 *model.Target) error {
 
 	return r0
 }
+
+// UpdateContext provides a mock function with given fields: ctx, _a1
+func (_m *Repository) UpdateContext(ctx context.Context, _a1 *model.Target) error {
+	ret := _m.Called(ctx, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Target) error); ok {
+		r0 = rf(ctx, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateMany provides a mock function with given fields: targets, mode
+func (_m *Repository) UpdateMany(targets []*model.Target, mode repository.BulkMode) (repository.BulkResults, error) {
+	ret := _m.Called(targets, mode)
+
+	var r0 repository.BulkResults
+	if rf, ok := ret.Get(0).(func([]*model.Target, repository.BulkMode) repository.BulkResults); ok {
+		r0 = rf(targets, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(repository.BulkResults)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]*model.Target, repository.BulkMode) error); ok {
+		r1 = rf(targets, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateManyContext provides a mock function with given fields: ctx, targets, mode
+func (_m *Repository) UpdateManyContext(ctx context.Context, targets []*model.Target, mode repository.BulkMode) (repository.BulkResults, error) {
+	ret := _m.Called(ctx, targets, mode)
+
+	var r0 repository.BulkResults
+	if rf, ok := ret.Get(0).(func(context.Context, []*model.Target, repository.BulkMode) repository.BulkResults); ok {
+		r0 = rf(ctx, targets, mode)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(repository.BulkResults)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []*model.Target, repository.BulkMode) error); ok {
+		r1 = rf(ctx, targets, mode)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UsePrimary provides a mock function with given fields:
+func (_m *Repository) UsePrimary() target.Repository {
+	ret := _m.Called()
+
+	var r0 target.Repository
+	if rf, ok := ret.Get(0).(func() target.Repository); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(target.Repository)
+		}
+	}
+
+	return r0
+}
+
+// WithContext provides a mock function with given fields: ctx
+func (_m *Repository) WithContext(ctx context.Context) target.Repository {
+	ret := _m.Called(ctx)
+
+	var r0 target.Repository
+	if rf, ok := ret.Get(0).(func(context.Context) target.Repository); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(target.Repository)
+		}
+	}
+
+	return r0
+}
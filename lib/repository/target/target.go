@@ -1,7 +1,14 @@
 package target
 
 import (
+	"context"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/db"
 	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/audit"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/secrets"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/validation"
 	"github.com/EurosportDigital/global-transcoding-platform/model"
 	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
 	"github.com/jinzhu/gorm"
@@ -9,6 +16,8 @@ import (
 )
 
 // Repository provides CRUD operations on model.Target types.
+// The AuthKey of the targets is envelope encrypted in the database with the repository's secrets.KeyProvider, and
+// decrypted when read. AuthKey values stored before encryption are read as is and sealed by their next update.
 type Repository interface {
 	// Get retrieves the model with the specified ID.
 	Get(id int) (*model.Target, error)
@@ -16,94 +25,287 @@ type Repository interface {
 	// GetMany retrieves all objects with the specified IDs.
 	GetMany(ids []int) ([]*model.Target, error)
 
-	// Create adds the specified model.Target to the database.
+	// Create adds the specified model.Target to the database. It returns a validation.Error, see validation.As, when
+	// the target does not pass validation.ValidateTarget.
 	Create(target *model.Target) error
 
-	// Update updates an existing record in the database.
+	// Update updates an existing record in the database. It returns repository.ErrEntityNotFound when there is none,
+	// and a validation.Error when the updated target does not pass validation.ValidateTarget.
 	Update(target *model.Target) error
 
-	// Delete removes the model.Target with the specified ID.
+	// Delete soft deletes the model.Target with the specified ID: reads exclude it until it is restored.
 	Delete(id int) error
 
+	// Restore undoes the soft deletion of the model.Target with the specified ID.
+	Restore(id int) error
+
+	// CreateMany adds the specified model.Targets like Create, with multi-row statements in one transaction, and
+	// returns the result of each, in order. The mode tells whether the other targets are still added when some of
+	// them fail, see repository.BulkMode. The error is set when none of them could be added.
+	CreateMany(targets []*model.Target, mode repository.BulkMode) (repository.BulkResults, error)
+
+	// UpdateMany updates the specified model.Targets like Update in one transaction, and returns the result of each,
+	// see CreateMany.
+	UpdateMany(targets []*model.Target, mode repository.BulkMode) (repository.BulkResults, error)
+
+	// DeleteMany soft deletes the model.Targets with the specified IDs like Delete, with one statement, and returns
+	// the result of each, see CreateMany.
+	DeleteMany(ids []int, mode repository.BulkMode) (repository.BulkResults, error)
+
+	// Purge permanently removes the targets soft deleted more than olderThan ago and returns their number.
+	Purge(olderThan time.Duration) (int, error)
+
 	// All retrieves all model.Targets within the database.
 	All() ([]*model.Target, error)
+
+	// UsePrimary returns a view of the repository whose reads go to the primary database, for read-after-write consistency.
+	UsePrimary() Repository
+
+	// IncludeDeleted returns a view of the repository whose reads include soft deleted targets.
+	IncludeDeleted() Repository
+
+	// WithContext returns a view of the repository bound to ctx. Its queries are cancelled when ctx is done, failing
+	// with the error of ctx, and its slow queries are logged with the logging context of ctx. Its creates, updates and
+	// deletes are recorded in the audit trail as made by the actor of ctx, see routing.WithActor. The AuthKey values
+	// are left out of the trail.
+	WithContext(ctx context.Context) Repository
+
+	// The Context variants are the methods of WithContext(ctx).
+	GetContext(ctx context.Context, id int) (*model.Target, error)
+	GetManyContext(ctx context.Context, ids []int) ([]*model.Target, error)
+	CreateContext(ctx context.Context, target *model.Target) error
+	UpdateContext(ctx context.Context, target *model.Target) error
+	DeleteContext(ctx context.Context, id int) error
+	RestoreContext(ctx context.Context, id int) error
+	CreateManyContext(ctx context.Context, targets []*model.Target, mode repository.BulkMode) (repository.BulkResults, error)
+	UpdateManyContext(ctx context.Context, targets []*model.Target, mode repository.BulkMode) (repository.BulkResults, error)
+	DeleteManyContext(ctx context.Context, ids []int, mode repository.BulkMode) (repository.BulkResults, error)
+	PurgeContext(ctx context.Context, olderThan time.Duration) (int, error)
+	AllContext(ctx context.Context) ([]*model.Target, error)
 }
 
 type gormRepository struct {
-	db *gorm.DB
+	resolver       db.Resolver
+	includeDeleted bool
+	ctx            context.Context
+	keys           secrets.KeyProvider
+}
+
+// New constructs a new instance of the target repository, whose AuthKey values are encrypted with secrets.Keys. Like
+// NewWithKeyProvider, it panics when secrets.Keys is not set yet.
+func New(database *gorm.DB) *gormRepository {
+	return NewWithResolver(db.NewCluster(database))
 }
 
-// New constructs a new instance of the target repository.
-func New(db *gorm.DB) *gormRepository {
-	return &gormRepository{db}
+// NewWithResolver constructs a target repository that reads from the resolver's replicas and writes to its primary,
+// and encrypts AuthKey values with secrets.Keys like New.
+func NewWithResolver(resolver db.Resolver) *gormRepository {
+	return NewWithKeyProvider(resolver, secrets.Keys)
+}
+
+// NewWithKeyProvider constructs a target repository like NewWithResolver, whose AuthKey values are encrypted with
+// keys. It panics when keys is nil, rather than letting every later write and read of an AuthKey fail.
+func NewWithKeyProvider(resolver db.Resolver, keys secrets.KeyProvider) *gormRepository {
+	if keys == nil {
+		panic(errors.WithMessage(secrets.ErrNoKeyProvider, "constructing a target repository, secrets.Keys must be set at startup"))
+	}
+	return &gormRepository{resolver: resolver, ctx: context.Background(), keys: keys}
+}
+
+// seal returns the gormmodel.Target of target, with its AuthKey encrypted.
+func (targetRepo *gormRepository) seal(target *model.Target) (*gormmodel.Target, error) {
+	gormTarget := gormmodel.ToGormTarget(target)
+	authKey, err := secrets.Seal(targetRepo.keys, target.AuthKey)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "unable to encrypt the auth key of target %v", target.ID)
+	}
+	gormTarget.AuthKey = authKey
+	return gormTarget, nil
+}
+
+// open returns the model.Target of gormTarget, with its AuthKey decrypted.
+func (targetRepo *gormRepository) open(gormTarget *gormmodel.Target) (*model.Target, error) {
+	target := gormmodel.ToTarget(gormTarget)
+	authKey, err := secrets.Open(targetRepo.keys, gormTarget.AuthKey)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "unable to decrypt the auth key of target %v", gormTarget.ID)
+	}
+	target.AuthKey = authKey
+	return target, nil
+}
+
+// openAll opens every record, see open.
+func (targetRepo *gormRepository) openAll(records []*gormmodel.TargetRecord) ([]*model.Target, error) {
+	targets := make([]*model.Target, len(records))
+	for i := range records {
+		target, err := targetRepo.open(&records[i].Target)
+		if err != nil {
+			return nil, err
+		}
+		targets[i] = target
+	}
+	return targets, nil
+}
+
+func (targetRepo *gormRepository) UsePrimary() Repository {
+	view := *targetRepo
+	view.resolver = db.PrimaryOnly(targetRepo.resolver)
+	return &view
+}
+
+func (targetRepo *gormRepository) IncludeDeleted() Repository {
+	view := *targetRepo
+	view.includeDeleted = true
+	return &view
+}
+
+func (targetRepo *gormRepository) WithContext(ctx context.Context) Repository {
+	view := *targetRepo
+	view.ctx = ctx
+	return &view
+}
+
+// replica returns the connection reads go to, unscoped when soft deleted targets are included.
+func (targetRepo *gormRepository) replica() *gorm.DB {
+	if targetRepo.includeDeleted {
+		return targetRepo.resolver.Replica().Unscoped()
+	}
+	return targetRepo.resolver.Replica()
 }
 
 func (targetRepo *gormRepository) Get(id int) (*model.Target, error) {
-	target := gormmodel.Target{}
-	err := repository.EvaluateError(targetRepo.db.First(&target, id).Error)
+	record := gormmodel.TargetRecord{}
+	err := repository.EvaluateError(repository.Run(targetRepo.ctx, targetRepo.replica(), func(db *gorm.DB) error {
+		return db.First(&record, id).Error
+	}))
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to get target %v", id)
 	}
 
-	return gormmodel.ToTarget(&target), nil
+	return targetRepo.open(&record.Target)
 }
 
 func (targetRepo *gormRepository) GetMany(ids []int) ([]*model.Target, error) {
-	var gormTargets []*gormmodel.Target
-	err := repository.EvaluateError(targetRepo.db.Find(&gormTargets, "id IN (?)", ids).Error)
+	var gormTargets []*gormmodel.TargetRecord
+	err := repository.EvaluateError(repository.Run(targetRepo.ctx, targetRepo.replica(), func(db *gorm.DB) error {
+		return db.Find(&gormTargets, "id IN (?)", ids).Error
+	}))
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to get targets %v", ids)
 	}
 
-	targets := make([]*model.Target, len(gormTargets))
-	for i := range gormTargets {
-		targets[i] = gormmodel.ToTarget(gormTargets[i])
-	}
-	return targets, nil
+	return targetRepo.openAll(gormTargets)
 }
 
 func (targetRepo *gormRepository) Create(target *model.Target) error {
-	gormTarget := gormmodel.ToGormTarget(target)
-	result := targetRepo.db.Create(gormTarget)
-	if result.Error != nil {
-		return errors.Wrapf(result.Error, "unable to create target %v", target)
+	if err := validation.ValidateTarget(target); err != nil {
+		return errors.WithMessage(err, "unable to create target")
+	}
+	gormTarget, err := targetRepo.seal(target)
+	if err != nil {
+		return err
 	}
-	*target = *gormmodel.ToTarget(gormTarget)
+	created := *target
+	err = repository.TransactionContext(targetRepo.ctx, targetRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		if err := tx.Create(gormTarget).Error; err != nil {
+			return errors.Wrapf(err, "unable to create target %q", target.Path)
+		}
+		created.ID = gormTarget.ID
+		return targetRepo.audit(tx, model.AuditCreate, gormTarget.ID, nil, &created)
+	})
+	if err != nil {
+		return err
+	}
+	*target = created
 	return nil
 }
 
 func (targetRepo *gormRepository) Update(target *model.Target) error {
-	gormTarget := gormmodel.ToGormTarget(target)
-	result := targetRepo.db.Model(&gormTarget).Update(gormTarget)
-	if result.Error != nil {
-		return errors.Wrapf(result.Error, "unable to update target %v", target)
+	gormTarget, err := targetRepo.seal(target)
+	if err != nil {
+		return err
 	}
-
-	return nil
+	return repository.TransactionContext(targetRepo.ctx, targetRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		before, err := targetRepo.getTarget(repository.ForUpdate(tx, false), target.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&gormTarget).Update(gormTarget).Error; err != nil {
+			return errors.Wrapf(err, "unable to update target %v", target.ID)
+		}
+		after, err := targetRepo.getTarget(tx, target.ID)
+		if err != nil {
+			return err
+		}
+		// The update only sets the non-empty fields, so the validated target is the updated row.
+		if err := validation.ValidateTarget(after); err != nil {
+			return errors.WithMessagef(err, "unable to update target %v", target.ID)
+		}
+		return targetRepo.audit(tx, model.AuditUpdate, target.ID, before, after)
+	})
 }
 
 func (targetRepo *gormRepository) Delete(id int) error {
-	result := targetRepo.db.Delete(&gormmodel.Target{ID: id})
-	if result.Error != nil {
-		return errors.Wrapf(result.Error, "unable to delete target %v", id)
+	return repository.TransactionContext(targetRepo.ctx, targetRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		before, err := targetRepo.getTarget(repository.ForUpdate(tx, false), id)
+		if err != nil {
+			return err
+		}
+		result := tx.Delete(&gormmodel.TargetRecord{Target: gormmodel.Target{ID: id}})
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "unable to delete target %v", id)
+		}
+		if result.RowsAffected == 0 {
+			return errors.Wrapf(repository.ErrEntityNotFound, "did not find target %v", id)
+		}
+		return targetRepo.audit(tx, model.AuditDelete, id, before, nil)
+	})
+}
+
+// audit records the change of the target in the audit trail, within the transaction making the change.
+func (targetRepo *gormRepository) audit(tx *gorm.DB, action model.AuditAction, id int, before *model.Target, after *model.Target) error {
+	return audit.Record(targetRepo.ctx, tx, change(action, id, before, after))
+}
+
+func change(action model.AuditAction, id int, before *model.Target, after *model.Target) *audit.Change {
+	return &audit.Change{
+		EntityType: audit.EntityTarget, EntityID: id, Action: action, Before: before, After: after, Secrets: []string{"AuthKey"},
 	}
-	if result.RowsAffected == 0 {
-		return errors.Wrapf(repository.ErrEntityNotFound, "did not find target %v", id)
+}
+
+func (targetRepo *gormRepository) getTarget(tx *gorm.DB, id int) (*model.Target, error) {
+	record := gormmodel.TargetRecord{}
+	if err := repository.EvaluateError(tx.First(&record, id).Error); err != nil {
+		return nil, errors.Wrapf(err, "unable to get target %v", id)
 	}
+	return targetRepo.open(&record.Target)
+}
 
-	return nil
+func (targetRepo *gormRepository) Restore(id int) error {
+	return repository.TransactionContext(targetRepo.ctx, targetRepo.resolver.Primary(), func(tx *gorm.DB) error {
+		if err := repository.Restore(tx, &gormmodel.TargetRecord{}, id); err != nil {
+			return err
+		}
+		restored, err := targetRepo.getTarget(tx, id)
+		if err != nil {
+			return err
+		}
+		return targetRepo.audit(tx, model.AuditRestore, id, nil, restored)
+	})
+}
+
+func (targetRepo *gormRepository) Purge(olderThan time.Duration) (int, error) {
+	return repository.Purge(targetRepo.ctx, targetRepo.resolver.Primary(), &gormmodel.TargetRecord{}, time.Now().Add(-olderThan))
 }
 
 func (targetRepo *gormRepository) All() ([]*model.Target, error) {
-	var gormTargets []*gormmodel.Target
-	err := targetRepo.db.Find(&gormTargets).Error
+	var gormTargets []*gormmodel.TargetRecord
+	err := repository.Run(targetRepo.ctx, targetRepo.replica(), func(db *gorm.DB) error {
+		return db.Find(&gormTargets).Error
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to retrieve all targets")
 	}
 
-	targets := make([]*model.Target, len(gormTargets))
-	for i := range gormTargets {
-		targets[i] = gormmodel.ToTarget(gormTargets[i])
-	}
-	return targets, nil
+	return targetRepo.openAll(gormTargets)
 }
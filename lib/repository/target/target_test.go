@@ -1,10 +1,22 @@
 package target
 
 import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
 	stderrors "errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/EurosportDigital/global-transcoding-platform/db"
 	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/audit"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/routing"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/secrets"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/validation"
 	"github.com/EurosportDigital/global-transcoding-platform/model"
 	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
@@ -16,6 +28,7 @@ import (
 type targetTestSuite struct {
 	suite.Suite
 	db         *gorm.DB
+	keys       secrets.KeyProvider
 	targetRepo Repository
 }
 
@@ -23,15 +36,62 @@ func TestTargetTestSuite(t *testing.T) {
 	mocket.Catcher.Register()
 	mocket.Catcher.Logging = false
 
-	db, err := gorm.Open(mocket.DriverName, "")
+	database, err := gorm.Open(mocket.DriverName, "")
 	require.NoError(t, err)
+	keys := newTestKeys(t)
 	pSuite := &targetTestSuite{
-		db:         db,
-		targetRepo: New(db),
+		db:         database,
+		keys:       keys,
+		targetRepo: NewWithKeyProvider(db.NewCluster(database), keys),
 	}
 	suite.Run(t, pSuite)
 }
 
+func newTestKeys(t *testing.T) secrets.KeyProvider {
+	keys, err := secrets.NewLocalKeyProvider([]byte(strings.Repeat("k", 32)))
+	require.NoError(t, err)
+	return keys
+}
+
+// captureArgs makes the response record the arguments of the query it answers.
+func captureArgs(response *mocket.FakeResponse, args *[]interface{}) *mocket.FakeResponse {
+	response.Callback = func(_ string, values []driver.NamedValue) {
+		*args = make([]interface{}, len(values))
+		for i, value := range values {
+			(*args)[i] = value.Value
+		}
+	}
+	return response
+}
+
+// requireSealed checks that value is authKey sealed with keys.
+func requireSealed(t *testing.T, keys secrets.KeyProvider, authKey string, value interface{}) {
+	sealed, ok := value.(string)
+	require.True(t, ok, "the auth key is stored as a string")
+	require.True(t, secrets.IsSealed(sealed), "the auth key is stored sealed")
+	require.NotContains(t, sealed, authKey)
+	opened, err := secrets.Open(keys, sealed)
+	require.NoError(t, err)
+	require.Equal(t, authKey, opened)
+}
+
+// recordingResolver counts which connection the repository asked for.
+type recordingResolver struct {
+	database *gorm.DB
+	primary  int
+	replica  int
+}
+
+func (r *recordingResolver) Primary() *gorm.DB {
+	r.primary++
+	return r.database
+}
+
+func (r *recordingResolver) Replica() *gorm.DB {
+	r.replica++
+	return r.database
+}
+
 func (pts *targetTestSuite) TearDownSuite() {
 	pts.db.Close()
 }
@@ -40,8 +100,14 @@ func (pts *targetTestSuite) SetupTest() {
 	mocket.Catcher.Reset()
 }
 
+// mockTarget makes the reads of the target with the specified ID find it.
+func mockTarget(id int) {
+	mocket.Catcher.NewMock().WithQuery(fmt.Sprintf(`FROM "targets"  WHERE "targets"."deleted_at" IS NULL AND (("targets"."id" = %d))`, id)).
+		WithReply([]map[string]interface{}{{"id": id, "target_type": "s3", "path": "s3://bucket/prefix", "auth_key": "key"}})
+}
+
 func (pts *targetTestSuite) TestGormTargetGet() {
-	getQuery := `SELECT * FROM "targets"  WHERE ("targets"."id" = 1) ORDER BY "targets"."id" ASC LIMIT 1`
+	getQuery := `SELECT * FROM "targets"  WHERE "targets"."deleted_at" IS NULL AND (("targets"."id" = 1)) ORDER BY "targets"."id" ASC LIMIT 1`
 	pts.Run("Should return expected result", func() {
 		pts.SetupTest()
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
@@ -93,7 +159,7 @@ func (pts *targetTestSuite) TestGormTargetGetMany() {
 		expectedIDs := []int{1, 2}
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
-				Pattern:  `SELECT * FROM "targets"  WHERE (id IN (1,2))`,
+				Pattern:  `SELECT * FROM "targets"  WHERE "targets"."deleted_at" IS NULL AND ((id IN (1,2)))`,
 				Args:     []interface{}{int64(expectedIDs[0]), int64(expectedIDs[1])},
 				Response: []map[string]interface{}{{"id": expectedIDs[0]}, {"id": expectedIDs[1]}},
 				Once:     true,
@@ -112,7 +178,7 @@ func (pts *targetTestSuite) TestGormTargetGetMany() {
 		expectedError := stderrors.New("my error")
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
-				Pattern: `SELECT * FROM "targets"  WHERE (id IN (1))`,
+				Pattern: `SELECT * FROM "targets"  WHERE "targets"."deleted_at" IS NULL AND ((id IN (1)))`,
 				Args:    []interface{}{int64(1)},
 				Once:    true,
 				Error:   expectedError,
@@ -131,31 +197,52 @@ func (pts *targetTestSuite) TestGormTargetCreate() {
 		newTarget := &model.Target{
 			ID:         10,
 			AuthKey:    "authkey",
-			Path:       "path",
-			TargetType: "type",
+			Path:       "s3://bucket/path",
+			TargetType: "s3",
 		}
 
-		targetInsert := &mocket.FakeResponse{
+		var args []interface{}
+		targetInsert := captureArgs(&mocket.FakeResponse{
 			Pattern: `INSERT INTO "targets" ("id","target_type","path","auth_key") VALUES (?,?,?,?)`,
-			Args: []interface{}{
-				int64(newTarget.ID),
-				newTarget.TargetType,
-				newTarget.Path,
-				newTarget.AuthKey,
-			},
-			Once: true,
-		}
+			Once:    true,
+		}, &args)
 		mocket.Catcher.Attach([]*mocket.FakeResponse{targetInsert})
 
 		err := pts.targetRepo.Create(newTarget)
 		pts.Require().NoError(err)
 		pts.Require().True(targetInsert.Triggered, "target insert reference must be triggered")
+		pts.Require().Equal([]interface{}{int64(newTarget.ID), newTarget.TargetType, newTarget.Path}, args[:3])
+		requireSealed(pts.T(), pts.keys, "authkey", args[3])
+		pts.Require().Equal("authkey", newTarget.AuthKey, "the created target keeps its plaintext AuthKey")
+	})
+
+	pts.Run("Should not construct a repository without a KeyProvider", func() {
+		pts.SetupTest()
+		defer func(keys secrets.KeyProvider) { secrets.Keys = keys }(secrets.Keys)
+		secrets.Keys = nil
+
+		pts.Require().Panics(func() { NewWithResolver(db.NewCluster(pts.db)) })
+		pts.Require().Panics(func() { NewWithKeyProvider(db.NewCluster(pts.db), nil) })
+
+		secrets.Keys = pts.keys
+		pts.Require().Equal(pts.keys, NewWithResolver(db.NewCluster(pts.db)).keys, "secrets.Keys is taken once set")
+	})
+
+	pts.Run("Should keep the AuthKey out of errors", func() {
+		pts.SetupTest()
+		mocket.Catcher.Attach([]*mocket.FakeResponse{
+			{Pattern: `INSERT INTO "targets"`, Once: true, Error: stderrors.New("my error")},
+		})
+
+		err := pts.targetRepo.Create(&model.Target{TargetType: "s3", Path: "s3://bucket/path", AuthKey: "authkey"})
+		pts.Require().Error(err)
+		pts.Require().NotContains(fmt.Sprintf("%+v", err), "authkey")
 	})
 
 	pts.Run("Should bubble up any unhandled error", func() {
 		pts.SetupTest()
 		expectedError := stderrors.New("my error")
-		newTarget := model.Target{}
+		newTarget := model.Target{TargetType: "s3", Path: "s3://bucket/path"}
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
 				Pattern: `INSERT INTO "targets" ("target_type","path","auth_key") VALUES (?,?,?)`,
@@ -173,6 +260,18 @@ func (pts *targetTestSuite) TestGormTargetCreate() {
 		pts.Require().Error(err)
 		pts.Require().EqualError(errors.Cause(err), expectedError.Error())
 	})
+
+	pts.Run("Should reject an invalid target before inserting it", func() {
+		pts.SetupTest()
+		targetInsert := &mocket.FakeResponse{Pattern: `INSERT INTO "targets"`, Once: true}
+		mocket.Catcher.Attach([]*mocket.FakeResponse{targetInsert})
+
+		err := pts.targetRepo.Create(&model.Target{TargetType: "s3", Path: "https://bucket/path"})
+		validationError, ok := validation.As(err)
+		pts.Require().True(ok)
+		pts.Require().Equal(validation.Malformed, validationError.Field("Path").Reason)
+		pts.Require().False(targetInsert.Triggered)
+	})
 }
 
 func (pts *targetTestSuite) TestGormTargetUpdate() {
@@ -181,39 +280,39 @@ func (pts *targetTestSuite) TestGormTargetUpdate() {
 		newTarget := &model.Target{
 			ID:         10,
 			AuthKey:    "my auth key",
-			Path:       "my path",
-			TargetType: "my type",
+			Path:       "s3://bucket/my-path",
+			TargetType: "s3",
 		}
 
 		err := pts.targetRepo.Create(newTarget)
 		pts.Require().NoError(err)
 		newTarget.AuthKey = "updated auth key"
+		mockTarget(newTarget.ID)
+		entry := mocket.Catcher.NewMock().WithQuery(`INSERT INTO "audit_entries"`)
 
-		targetUpdate := &mocket.FakeResponse{
+		var args []interface{}
+		targetUpdate := captureArgs(&mocket.FakeResponse{
 			Pattern: `UPDATE "targets" SET "auth_key" = ?, "id" = ?, "path" = ?, "target_type" = ?  WHERE "targets"."id" = ?`,
-			Args: []interface{}{
-				newTarget.AuthKey,
-				int64(newTarget.ID),
-				newTarget.Path,
-				newTarget.TargetType,
-				int64(newTarget.ID),
-			},
-			Once: true,
-		}
+			Once:    true,
+		}, &args)
 
 		mocket.Catcher.Attach([]*mocket.FakeResponse{targetUpdate})
 
 		err = pts.targetRepo.Update(newTarget)
 		pts.Require().NoError(err)
 		pts.Require().True(targetUpdate.Triggered, "target update reference must be triggered")
+		requireSealed(pts.T(), pts.keys, "updated auth key", args[0])
+		pts.Require().Equal([]interface{}{int64(newTarget.ID), newTarget.Path, newTarget.TargetType, int64(newTarget.ID)}, args[1:])
+		pts.Require().True(entry.Triggered, "the update must be audited")
 	})
 
 	pts.Run("Should bubble up any unhandled error", func() {
 		pts.SetupTest()
 		expectedError := stderrors.New("my error")
 		newTarget := model.Target{ID: 10}
-		err := pts.targetRepo.Create(&newTarget)
+		err := pts.targetRepo.Create(&model.Target{ID: 10, TargetType: "s3", Path: "s3://bucket/path"})
 		pts.Require().NoError(err)
+		mockTarget(newTarget.ID)
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
 				Pattern: `UPDATE "targets" SET "id" = ?  WHERE "targets"."id" = ?`,
@@ -236,11 +335,9 @@ func (pts *targetTestSuite) TestGormTargetDelete() {
 	pts.Run("Should delete an existing target in database", func() {
 		pts.SetupTest()
 
+		mockTarget(1)
 		targetDelete := &mocket.FakeResponse{
-			Pattern: `DELETE FROM "targets"  WHERE "targets"."id" = ?`,
-			Args: []interface{}{
-				int64(1),
-			},
+			Pattern:      `UPDATE "targets" SET "deleted_at"=?  WHERE "targets"."deleted_at" IS NULL AND "targets"."id" = ?`,
 			RowsAffected: 1,
 			Once:         true,
 		}
@@ -255,11 +352,9 @@ func (pts *targetTestSuite) TestGormTargetDelete() {
 	pts.Run("Should return EntityNotFound error when target is not found.", func() {
 		pts.SetupTest()
 
+		mockTarget(1)
 		targetDelete := &mocket.FakeResponse{
-			Pattern: `DELETE FROM "targets"  WHERE "targets"."id" = ?`,
-			Args: []interface{}{
-				int64(1),
-			},
+			Pattern:      `UPDATE "targets" SET "deleted_at"=?  WHERE "targets"."deleted_at" IS NULL AND "targets"."id" = ?`,
 			RowsAffected: 0,
 			Once:         true,
 		}
@@ -275,17 +370,14 @@ func (pts *targetTestSuite) TestGormTargetDelete() {
 	pts.Run("Should bubble up any unhandled error", func() {
 		pts.SetupTest()
 		expectedError := stderrors.New("my error")
-		newTarget := model.Target{ID: 10}
-		err := pts.targetRepo.Create(&newTarget)
+		err := pts.targetRepo.Create(&model.Target{ID: 10, TargetType: "s3", Path: "s3://bucket/path"})
 		pts.Require().NoError(err)
+		mockTarget(1)
 		mocket.Catcher.Attach([]*mocket.FakeResponse{
 			{
-				Pattern: `DELETE FROM "targets"  WHERE "targets"."id" = ?`,
-				Args: []interface{}{
-					int64(1),
-				},
-				Once:  true,
-				Error: expectedError,
+				Pattern: `UPDATE "targets" SET "deleted_at"=?  WHERE "targets"."deleted_at" IS NULL AND "targets"."id" = ?`,
+				Once:    true,
+				Error:   expectedError,
 			},
 		})
 
@@ -336,3 +428,156 @@ func (pts *targetTestSuite) TestGormTargetAll() {
 		pts.Require().EqualError(errors.Cause(err), expectedError.Error())
 	})
 }
+
+func (pts *targetTestSuite) TestReadReplicaRouting() {
+	pts.Run("Should read from a replica and write to the primary", func() {
+		pts.SetupTest()
+		resolver := &recordingResolver{database: pts.db}
+		repo := NewWithKeyProvider(resolver, pts.keys)
+
+		_, _ = repo.Get(1)
+		_, _ = repo.All()
+		pts.Require().Equal(2, resolver.replica)
+		pts.Require().Equal(0, resolver.primary)
+
+		_ = repo.Create(&model.Target{TargetType: "s3", Path: "s3://bucket/path"})
+		_ = repo.Update(&model.Target{ID: 1})
+		_ = repo.Delete(1)
+		pts.Require().Equal(2, resolver.replica)
+		pts.Require().Equal(3, resolver.primary)
+	})
+	pts.Run("Should read from the primary when forced", func() {
+		pts.SetupTest()
+		resolver := &recordingResolver{database: pts.db}
+
+		_, _ = NewWithKeyProvider(resolver, pts.keys).UsePrimary().Get(1)
+		pts.Require().Equal(0, resolver.replica)
+		pts.Require().Equal(1, resolver.primary)
+	})
+}
+
+func TestTargetRepositoryOnSQLite(t *testing.T) {
+	database := repositorytest.OpenSQLite(t)
+	keys := newTestKeys(t)
+	targetRepo := NewWithKeyProvider(db.NewCluster(database), keys)
+
+	first := &model.Target{TargetType: "s3", Path: "s3://bucket/first", AuthKey: "key"}
+	require.NoError(t, targetRepo.Create(first))
+	require.NotZero(t, first.ID)
+	second := &model.Target{TargetType: "akamai", Path: "https://akamai.example.com/second"}
+	require.NoError(t, targetRepo.Create(second))
+
+	t.Run("Should get targets", func(t *testing.T) {
+		target, err := targetRepo.Get(first.ID)
+		require.NoError(t, err)
+		require.Equal(t, first, target)
+
+		targets, err := targetRepo.GetMany([]int{first.ID, second.ID})
+		require.NoError(t, err)
+		require.Equal(t, []*model.Target{first, second}, targets)
+
+		_, err = targetRepo.Get(second.ID + 1)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err))
+	})
+	t.Run("Should store the AuthKey sealed", func(t *testing.T) {
+		var authKey string
+		require.NoError(t, database.Table("targets").Select("auth_key").Where("id = ?", first.ID).Row().Scan(&authKey))
+		requireSealed(t, keys, "key", authKey)
+
+		other, err := secrets.NewLocalKeyProvider([]byte(strings.Repeat("o", 32)))
+		require.NoError(t, err)
+		_, err = NewWithKeyProvider(db.NewCluster(database), other).Get(first.ID)
+		require.Error(t, err, "Another key cannot open the AuthKey")
+	})
+	t.Run("Should read the AuthKey stored before encryption as is", func(t *testing.T) {
+		legacy := &model.Target{TargetType: "s3", Path: "s3://bucket/legacy", AuthKey: "plaintext"}
+		require.NoError(t, database.Exec(`INSERT INTO targets (target_type, path, auth_key) VALUES (?, ?, ?)`, legacy.TargetType, legacy.Path, legacy.AuthKey).Error)
+		require.NoError(t, database.Table("targets").Select("id").Where("path = ?", legacy.Path).Row().Scan(&legacy.ID))
+
+		target, err := targetRepo.Get(legacy.ID)
+		require.NoError(t, err)
+		require.Equal(t, legacy, target)
+		require.NoError(t, database.Exec(`DELETE FROM targets WHERE id = ?`, legacy.ID).Error)
+	})
+	t.Run("Should update a target", func(t *testing.T) {
+		second.Path = "https://akamai.example.com/moved"
+		require.NoError(t, targetRepo.Update(second))
+
+		target, err := targetRepo.Get(second.ID)
+		require.NoError(t, err)
+		require.Equal(t, second, target)
+	})
+	t.Run("Should roll back an invalid update", func(t *testing.T) {
+		err := targetRepo.Update(&model.Target{ID: second.ID, TargetType: "s3"})
+		validationError, ok := validation.As(err)
+		require.True(t, ok)
+		require.Equal(t, []*validation.FieldError{validationError.Field("Path")}, validationError.Fields)
+
+		target, err := targetRepo.Get(second.ID)
+		require.NoError(t, err)
+		require.Equal(t, second, target)
+	})
+	t.Run("Should delete a target", func(t *testing.T) {
+		require.NoError(t, targetRepo.Delete(first.ID))
+
+		targets, err := targetRepo.All()
+		require.NoError(t, err)
+		require.Equal(t, []*model.Target{second}, targets)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(targetRepo.Delete(first.ID)))
+	})
+	t.Run("Should only read deleted targets when asked", func(t *testing.T) {
+		_, err := targetRepo.Get(first.ID)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(err))
+
+		targets, err := targetRepo.IncludeDeleted().GetMany([]int{first.ID, second.ID})
+		require.NoError(t, err)
+		require.Equal(t, []*model.Target{first, second}, targets)
+	})
+	t.Run("Should restore a deleted target", func(t *testing.T) {
+		require.NoError(t, targetRepo.Restore(first.ID))
+
+		target, err := targetRepo.Get(first.ID)
+		require.NoError(t, err)
+		require.Equal(t, first, target)
+		require.Equal(t, repository.ErrEntityNotFound, errors.Cause(targetRepo.Restore(first.ID)))
+	})
+	t.Run("Should purge targets deleted long enough ago", func(t *testing.T) {
+		require.NoError(t, targetRepo.Delete(first.ID))
+
+		purged, err := targetRepo.Purge(time.Hour)
+		require.NoError(t, err)
+		require.Zero(t, purged)
+		purged, err = targetRepo.Purge(-time.Second)
+		require.NoError(t, err)
+		require.Equal(t, 1, purged)
+
+		targets, err := targetRepo.IncludeDeleted().All()
+		require.NoError(t, err)
+		require.Equal(t, []*model.Target{second}, targets)
+	})
+	t.Run("Should audit the changes without the AuthKey values", func(t *testing.T) {
+		ctx := routing.WithActor(context.Background(), "alice@example.com")
+		second.AuthKey = "rotated"
+		require.NoError(t, targetRepo.WithContext(ctx).Update(second))
+
+		history, err := audit.New(database).History(audit.EntityTarget, second.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 3, "The creation and both updates")
+		require.Equal(t, model.AuditCreate, history[0].Action)
+		require.Equal(t, audit.UnknownActor, history[0].Actor)
+		require.Equal(t, "alice@example.com", history[2].Actor)
+		require.Equal(t, model.AuditUpdate, history[2].Action)
+		require.Equal(t, map[string]model.FieldChange{
+			"AuthKey": {Before: json.RawMessage(`"[redacted]"`), After: json.RawMessage(`"[redacted]"`)},
+		}, history[2].Changes)
+
+		id := first.ID
+		entries, err := audit.New(database).Query(&audit.Filter{EntityType: audit.EntityTarget, EntityID: &id}, 0)
+		require.NoError(t, err)
+		actions := make([]model.AuditAction, len(entries))
+		for i, entry := range entries {
+			actions[i] = entry.Action
+		}
+		require.Equal(t, []model.AuditAction{model.AuditDelete, model.AuditRestore, model.AuditDelete, model.AuditCreate}, actions)
+	})
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	gtpdb "github.com/EurosportDigital/global-transcoding-platform/db"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/routing"
+	"github.com/jinzhu/gorm"
+)
+
+// Transaction runs fn in a transaction of db, committed when fn returns nil and rolled back otherwise.
+// When db already is a transaction, such as the one unitofwork.WithTx hands out, fn joins it and leaves the commit
+// or rollback to whoever began it.
+func Transaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	return TransactionContext(context.Background(), db, fn)
+}
+
+// TransactionContext runs fn in a transaction of db like Transaction, begun with ctx. Once ctx is done, before fn
+// starts or while it runs, it fails with the error of ctx: the Postgres driver cancels the running statement and the
+// transaction is rolled back. The slow queries of fn are logged with the logging context of ctx.
+func TransactionContext(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error) (err error) {
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	db = gtpdb.WithLoggingContext(db, routing.GetLoggingContext(ctx))
+	if inTransaction(db) {
+		return contextError(ctx, fn(db))
+	}
+
+	tx := db.BeginTx(ctx, &sql.TxOptions{})
+	if tx.Error != nil {
+		return contextError(ctx, errors.Wrap(tx.Error, "beginning a transaction"))
+	}
+	panicked := true
+	defer func() {
+		if panicked || err != nil {
+			tx.Rollback()
+		}
+	}()
+	err = fn(tx)
+	if err == nil {
+		err = tx.Commit().Error
+	}
+	panicked = false
+	return contextError(ctx, err)
+}
+
+// Run runs fn with db bound to ctx. When ctx has a deadline, fn runs in a transaction begun with ctx as
+// TransactionContext does, whose statements time out at the deadline, see Dialect.StatementTimeout. Otherwise fn
+// runs without a transaction, only failing with the error of ctx if it is done before fn starts or once fn
+// returns, which spares plain reads a round-trip. When db already is a transaction, fn joins it.
+func Run(ctx context.Context, db *gorm.DB, fn func(db *gorm.DB) error) error {
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || inTransaction(db) {
+		return contextError(ctx, fn(gtpdb.WithLoggingContext(db, routing.GetLoggingContext(ctx))))
+	}
+	return TransactionContext(ctx, db, func(tx *gorm.DB) error {
+		if query := DialectOf(tx).StatementTimeout(time.Until(deadline)); query != "" {
+			rows, err := tx.Raw(query).Rows()
+			if err != nil {
+				return errors.Wrap(err, "setting the statement timeout")
+			}
+			rows.Close()
+		}
+		return fn(tx)
+	})
+}
+
+func inTransaction(db *gorm.DB) bool {
+	_, ok := db.CommonDB().(*sql.Tx)
+	return ok
+}
+
+// contextError returns the error of ctx in place of err once ctx is done, as the statements of a cancelled
+// transaction only fail with sql.ErrTxDone or a driver specific error.
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	return errors.WithMessage(ctx.Err(), err.Error())
+}
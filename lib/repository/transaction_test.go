@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"testing"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/model/gormmodel"
+	"github.com/jinzhu/gorm"
+	mocket "github.com/selvatico/go-mocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransaction(t *testing.T) {
+	db := repositorytest.OpenSQLite(t)
+	count := func() int {
+		var total int
+		require.NoError(t, db.Model(&gormmodel.TargetRecord{}).Count(&total).Error)
+		return total
+	}
+	create := func(tx *gorm.DB, path string) error {
+		return tx.Create(&gormmodel.TargetRecord{Target: gormmodel.Target{TargetType: "s3", Path: path}}).Error
+	}
+
+	t.Run("Should commit when the function succeeds", func(t *testing.T) {
+		require.NoError(t, Transaction(db, func(tx *gorm.DB) error { return create(tx, "s3://bucket/a") }))
+		require.Equal(t, 1, count())
+	})
+	t.Run("Should join the transaction it is given and roll back with it", func(t *testing.T) {
+		failure := errors.New("failed")
+		err := Transaction(db, func(tx *gorm.DB) error {
+			if err := Transaction(tx, func(inner *gorm.DB) error { return create(inner, "s3://bucket/b") }); err != nil {
+				return err
+			}
+			return failure
+		})
+		require.Equal(t, failure, err)
+		require.Equal(t, 1, count(), "The inner write is rolled back along with the outer transaction")
+	})
+}
+
+func TestTransactionContext(t *testing.T) {
+	db := repositorytest.OpenSQLite(t)
+	count := func() int {
+		var total int
+		require.NoError(t, db.Model(&gormmodel.TargetRecord{}).Count(&total).Error)
+		return total
+	}
+	create := func(tx *gorm.DB, path string) error {
+		return tx.Create(&gormmodel.TargetRecord{Target: gormmodel.Target{TargetType: "s3", Path: path}}).Error
+	}
+
+	t.Run("Should not start once the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		called := false
+		err := TransactionContext(ctx, db, func(tx *gorm.DB) error {
+			called = true
+			return nil
+		})
+		require.Equal(t, context.Canceled, errors.Cause(err))
+		require.False(t, called)
+	})
+	t.Run("Should roll back and fail with the error of the context when it is done meanwhile", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		err := TransactionContext(ctx, db, func(tx *gorm.DB) error {
+			if err := create(tx, "s3://bucket/a"); err != nil {
+				return err
+			}
+			cancel()
+			// The transaction is rolled back in the background once the context is done.
+			require.Eventually(t, func() bool { return create(tx, "s3://bucket/b") != nil }, time.Second, time.Millisecond)
+			return nil
+		})
+		require.Equal(t, context.Canceled, errors.Cause(err))
+		require.Zero(t, count())
+	})
+	t.Run("Should only run in a transaction when the context has a deadline", func(t *testing.T) {
+		require.NoError(t, Run(context.Background(), db, func(db *gorm.DB) error {
+			require.False(t, inTransaction(db))
+			return nil
+		}))
+		cancellable, cancelRead := context.WithCancel(context.Background())
+		require.NoError(t, Run(cancellable, db, func(db *gorm.DB) error {
+			require.False(t, inTransaction(db))
+			return nil
+		}))
+		cancelRead()
+		require.Equal(t, context.Canceled, errors.Cause(Run(cancellable, db, func(db *gorm.DB) error {
+			require.Fail(t, "A done context should not run")
+			return nil
+		})))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		require.NoError(t, Run(ctx, db, func(db *gorm.DB) error {
+			require.True(t, inTransaction(db))
+			return create(db, "s3://bucket/c")
+		}))
+		require.Equal(t, 1, count())
+	})
+}
+
+func TestRunOnPostgres(t *testing.T) {
+	mocket.Catcher.Register()
+	mocket.Catcher.Reset()
+	postgres, err := gorm.Open(mocket.DriverName, "")
+	require.NoError(t, err)
+	defer postgres.Close()
+	timeout := mocket.Catcher.NewMock().WithQuery(`SELECT set_config('statement_timeout'`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	require.NoError(t, Run(ctx, postgres, func(db *gorm.DB) error { return nil }))
+	require.True(t, timeout.Triggered, "The statements time out at the deadline of the context")
+}
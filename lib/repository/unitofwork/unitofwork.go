@@ -0,0 +1,59 @@
+// Package unitofwork runs calls to several repositories in one database transaction.
+// It lives apart from package repository, which the repositories themselves import.
+package unitofwork
+
+import (
+	"context"
+
+	"github.com/EurosportDigital/global-transcoding-platform/db"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/audit"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/job"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/profile"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/target"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/secrets"
+	"github.com/jinzhu/gorm"
+)
+
+// Repos are the repositories bound to the transaction of a WithTx call. Their reads see the writes made so far in the
+// transaction, and the transactions they would otherwise run on their own join it.
+type Repos struct {
+	Jobs     job.JobRepository
+	Profiles profile.Repository
+	Targets  target.Repository
+	Audit    audit.Repository
+}
+
+// WithTx runs fn in a transaction of the primary database of resolver begun with ctx, committed when fn returns nil
+// and rolled back when it returns an error or panics, or once ctx is done. When the primary already is a transaction,
+// fn joins it instead. The mutations made through the Repos are audited as made by the actor of ctx.
+//
+// A failed statement aborts the whole transaction on Postgres, so fn should return the errors of the Repos rather
+// than carry on with other calls.
+//
+// The Targets seal and open AuthKey values with secrets.Keys; use WithTxKeyProvider to pass another KeyProvider.
+func WithTx(ctx context.Context, resolver db.Resolver, fn func(repos *Repos) error) error {
+	return WithTxKeyProvider(ctx, resolver, secrets.Keys, fn)
+}
+
+// WithTxKeyProvider runs fn like WithTx, with Targets that seal and open AuthKey values with keys. It fails without
+// beginning a transaction when keys is nil.
+func WithTxKeyProvider(ctx context.Context, resolver db.Resolver, keys secrets.KeyProvider, fn func(repos *Repos) error) error {
+	if keys == nil {
+		return errors.Wrap(secrets.ErrNoKeyProvider, "running unit of work")
+	}
+	err := repository.TransactionContext(ctx, resolver.Primary(), func(tx *gorm.DB) error {
+		bound := db.NewCluster(tx)
+		return fn(&Repos{
+			Jobs:     job.NewWithResolver(bound).WithContext(ctx),
+			Profiles: profile.NewWithResolver(bound).WithContext(ctx),
+			Targets:  target.NewWithKeyProvider(bound, keys).WithContext(ctx),
+			Audit:    audit.NewWithResolver(bound),
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "running unit of work")
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package unitofwork
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/EurosportDigital/global-transcoding-platform/db"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/audit"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/repositorytest"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/repository/target"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/routing"
+	"github.com/EurosportDigital/global-transcoding-platform/lib/secrets"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTxOnSQLite(t *testing.T) {
+	database := repositorytest.OpenSQLite(t)
+	resolver := db.NewCluster(database)
+	ctx := routing.WithActor(context.Background(), "onboarding")
+	keys, err := secrets.NewLocalKeyProvider([]byte(strings.Repeat("u", 32)))
+	require.NoError(t, err)
+
+	t.Run("Should commit the writes of every repository together", func(t *testing.T) {
+		var created *model.Job
+		err := WithTxKeyProvider(ctx, resolver, keys, func(repos *Repos) error {
+			newTarget := &model.Target{TargetType: "s3", Path: "s3://bucket/partner"}
+			if err := repos.Targets.Create(newTarget); err != nil {
+				return err
+			}
+			newProfile := &model.Profile{Name: "h264-hls", Codec: "h264", PackageFormat: "hls", EncConfig: model.EncoderConfig{Name: "default"}}
+			if err := repos.Profiles.Create(newProfile); err != nil {
+				return err
+			}
+			if _, err := repos.Targets.Get(newTarget.ID); err != nil {
+				return err
+			}
+			created = &model.Job{
+				Status:     model.JobStatus{Status: model.StatusReady},
+				SourcePath: "s3://bucket/source.mp4",
+				Outputs:    []*model.Output{{ProfileID: newProfile.ID, TargetID: newTarget.ID}},
+			}
+			return repos.Jobs.Create(created)
+		})
+		require.NoError(t, err)
+
+		targets, err := target.NewWithKeyProvider(resolver, keys).All()
+		require.NoError(t, err)
+		require.Len(t, targets, 1)
+		entries, err := audit.New(database).Query(&audit.Filter{Actor: "onboarding"}, 0)
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		require.Equal(t, audit.EntityJob, entries[0].EntityType)
+		require.Equal(t, created.ID, entries[0].EntityID)
+	})
+	t.Run("Should roll every write back when the function fails", func(t *testing.T) {
+		failure := errors.New("profile missing")
+		err := WithTxKeyProvider(ctx, resolver, keys, func(repos *Repos) error {
+			if err := repos.Targets.Create(&model.Target{TargetType: "s3", Path: "s3://bucket/orphan"}); err != nil {
+				return err
+			}
+			return failure
+		})
+		require.Equal(t, failure, errors.Cause(err))
+
+		targets, err := target.NewWithKeyProvider(resolver, keys).All()
+		require.NoError(t, err)
+		require.Len(t, targets, 1, "The target created in the failed unit of work is rolled back")
+		entries, err := audit.New(database).Query(&audit.Filter{Actor: "onboarding"}, 0)
+		require.NoError(t, err)
+		require.Len(t, entries, 3, "So is its audit entry")
+	})
+	t.Run("Should roll back when the function panics", func(t *testing.T) {
+		require.Panics(t, func() {
+			_ = WithTxKeyProvider(ctx, resolver, keys, func(repos *Repos) error {
+				if err := repos.Targets.Create(&model.Target{TargetType: "s3", Path: "s3://bucket/panic"}); err != nil {
+					return err
+				}
+				panic("unexpected")
+			})
+		})
+
+		targets, err := target.NewWithKeyProvider(resolver, keys).All()
+		require.NoError(t, err)
+		require.Len(t, targets, 1)
+	})
+	t.Run("Should not start once the context is done", func(t *testing.T) {
+		done, cancel := context.WithCancel(ctx)
+		cancel()
+		called := false
+		err := WithTxKeyProvider(done, resolver, keys, func(repos *Repos) error {
+			called = true
+			return nil
+		})
+		require.Equal(t, context.Canceled, errors.Cause(err))
+		require.False(t, called)
+	})
+	t.Run("Should seal AuthKey values with the KeyProvider passed in", func(t *testing.T) {
+		defer func(global secrets.KeyProvider) { secrets.Keys = global }(secrets.Keys)
+		secrets.Keys = nil
+		sealed := &model.Target{TargetType: "s3", Path: "s3://bucket/sealed", AuthKey: "authkey"}
+		err := WithTxKeyProvider(ctx, resolver, keys, func(repos *Repos) error {
+			return repos.Targets.Create(sealed)
+		})
+		require.NoError(t, err)
+
+		got, err := target.NewWithKeyProvider(resolver, keys).Get(sealed.ID)
+		require.NoError(t, err)
+		require.Equal(t, "authkey", got.AuthKey)
+		other, err := secrets.NewLocalKeyProvider([]byte(strings.Repeat("o", 32)))
+		require.NoError(t, err)
+		_, err = target.NewWithKeyProvider(resolver, other).Get(sealed.ID)
+		require.Error(t, err, "Only the KeyProvider of the unit of work opens the AuthKey")
+	})
+	t.Run("Should not start without a KeyProvider", func(t *testing.T) {
+		defer func(global secrets.KeyProvider) { secrets.Keys = global }(secrets.Keys)
+		secrets.Keys = nil
+		called := false
+		err := WithTx(ctx, resolver, func(repos *Repos) error {
+			called = true
+			return nil
+		})
+		require.Equal(t, secrets.ErrNoKeyProvider, errors.Cause(err))
+		require.False(t, called)
+	})
+}
@@ -9,6 +9,7 @@ type LoggingContext = map[string]interface{}
 
 const (
 	keyLoggingContext contextKey = iota
+	keyActor
 )
 
 // WithLoggingContext adds the logging context to the context.
@@ -23,3 +24,14 @@ func GetLoggingContext(ctx context.Context) LoggingContext {
 	}
 	return make(LoggingContext)
 }
+
+// WithActor adds the identity of whoever the request is made on behalf of, as recorded in the audit trail, to the context.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, keyActor, actor)
+}
+
+// GetActor retrieves the actor from the context, otherwise returns an empty string.
+func GetActor(ctx context.Context) string {
+	actor, _ := ctx.Value(keyActor).(string)
+	return actor
+}
@@ -31,3 +31,13 @@ func TestGetLoggingContext(t *testing.T) {
 		require.Empty(t, loggingContext)
 	})
 }
+
+func TestGetActor(t *testing.T) {
+	t.Run("Should retrieve the actor when populated", func(t *testing.T) {
+		ctx := WithActor(context.Background(), "alice@example.com")
+		require.Equal(t, "alice@example.com", GetActor(ctx))
+	})
+	t.Run("Should give back an empty actor if not found", func(t *testing.T) {
+		require.Empty(t, GetActor(context.Background()))
+	})
+}
@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+)
+
+// LocalKeyProvider wraps data keys with one AES-256 key encryption key held in memory, read from the environment or
+// a file. It suits development and tests, and deployments whose key is mounted from a secret store.
+type LocalKeyProvider struct {
+	id  string
+	key []byte
+}
+
+// NewLocalKeyProvider returns a LocalKeyProvider wrapping data keys with key, which must be 32 bytes long.
+// Its KeyID is derived from the key, so that the secrets sealed with another key are told apart.
+func NewLocalKeyProvider(key []byte) (*LocalKeyProvider, error) {
+	if len(key) != dataKeySize {
+		return nil, errors.Errorf("the key encryption key is %d bytes long, expected %d", len(key), dataKeySize)
+	}
+	digest := sha256.Sum256(key)
+	return &LocalKeyProvider{id: "local-" + hex.EncodeToString(digest[:8]), key: key}, nil
+}
+
+// LocalKeyProviderFromEnv returns a LocalKeyProvider with the base64 encoded key held by the environment variable.
+func LocalKeyProviderFromEnv(variable string) (*LocalKeyProvider, error) {
+	encoded, ok := os.LookupEnv(variable)
+	if !ok {
+		return nil, errors.Errorf("the environment variable %s is not set", variable)
+	}
+	provider, err := newLocalKeyProviderFromBase64(encoded)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "reading the key of %s", variable)
+	}
+	return provider, nil
+}
+
+// LocalKeyProviderFromFile returns a LocalKeyProvider with the base64 encoded key held by the file.
+func LocalKeyProviderFromFile(path string) (*LocalKeyProvider, error) {
+	encoded, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading the key file %s", path)
+	}
+	provider, err := newLocalKeyProviderFromBase64(string(encoded))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "reading the key of %s", path)
+	}
+	return provider, nil
+}
+
+func newLocalKeyProviderFromBase64(encoded string) (*LocalKeyProvider, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		// The decoding error holds part of the key.
+		return nil, errors.New("the key is not base64 encoded")
+	}
+	return NewLocalKeyProvider(key)
+}
+
+func (provider *LocalKeyProvider) KeyID() string {
+	return provider.id
+}
+
+func (provider *LocalKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	return encrypt(provider.key, dataKey)
+}
+
+func (provider *LocalKeyProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != provider.id {
+		return nil, errors.Errorf("unknown key %s, this provider holds %s", keyID, provider.id)
+	}
+	return decrypt(provider.key, wrapped)
+}
+
+// String leaves the key out of the output of the fmt package.
+func (provider *LocalKeyProvider) String() string {
+	return "LocalKeyProvider(" + provider.id + ")"
+}
+
+// GoString leaves the key out of the %#v verb.
+func (provider *LocalKeyProvider) GoString() string {
+	return provider.String()
+}
@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalKeyProvider(t *testing.T) {
+	key := []byte(strings.Repeat("k", 32))
+	encoded := base64.StdEncoding.EncodeToString(key)
+
+	t.Run("Should require a 32 bytes key", func(t *testing.T) {
+		_, err := NewLocalKeyProvider([]byte("short"))
+		require.Error(t, err)
+	})
+	t.Run("Should read the key from the environment", func(t *testing.T) {
+		require.NoError(t, os.Setenv("GTP_TEST_SECRETS_KEY", encoded))
+		defer os.Unsetenv("GTP_TEST_SECRETS_KEY")
+		keys, err := LocalKeyProviderFromEnv("GTP_TEST_SECRETS_KEY")
+		require.NoError(t, err)
+		expected, err := NewLocalKeyProvider(key)
+		require.NoError(t, err)
+		require.Equal(t, expected.KeyID(), keys.KeyID())
+
+		_, err = LocalKeyProviderFromEnv("GTP_TEST_SECRETS_MISSING")
+		require.Error(t, err)
+	})
+	t.Run("Should read the key from a file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "secrets")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "key")
+		require.NoError(t, ioutil.WriteFile(path, []byte(encoded+"\n"), 0600))
+
+		keys, err := LocalKeyProviderFromFile(path)
+		require.NoError(t, err)
+		sealed, err := Seal(keys, "my auth key")
+		require.NoError(t, err)
+		opened, err := Open(keys, sealed)
+		require.NoError(t, err)
+		require.Equal(t, "my auth key", opened)
+
+		require.NoError(t, ioutil.WriteFile(path, []byte("not base64!"), 0600))
+		_, err = LocalKeyProviderFromFile(path)
+		require.Error(t, err)
+	})
+	t.Run("Should not print the key", func(t *testing.T) {
+		keys, err := NewLocalKeyProvider(key)
+		require.NoError(t, err)
+		for _, verb := range []string{"%v", "%+v", "%#v"} {
+			require.NotContains(t, fmt.Sprintf(verb, keys), string(key), verb)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+// Package secrets envelope encrypts the secrets stored in the database, such as the AuthKey of the targets.
+// Every secret is encrypted with its own data key, and the data key is stored along with it, wrapped by a
+// KeyProvider with a key encryption key that never leaves the provider.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	stderrors "errors"
+	"io"
+	"strings"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+)
+
+// Keys is the KeyProvider used by the repositories that are not given one. It must be set at startup before any
+// secret is stored or read.
+var Keys KeyProvider
+
+var (
+	// ErrNoKeyProvider is returned when a secret is sealed or opened without a KeyProvider.
+	ErrNoKeyProvider = stderrors.New("No Key Provider")
+
+	// ErrMalformedSecret is returned when a sealed secret cannot be parsed.
+	ErrMalformedSecret = stderrors.New("Malformed Secret")
+)
+
+// KeyProvider wraps and unwraps data keys with the key encryption keys it holds.
+type KeyProvider interface {
+	// KeyID identifies the key encryption key new data keys are wrapped with. It must not contain colons.
+	KeyID() string
+
+	// WrapKey encrypts dataKey with the key encryption key identified by KeyID.
+	WrapKey(dataKey []byte) ([]byte, error)
+
+	// UnwrapKey decrypts a data key wrapped with the key encryption key identified by keyID.
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// sealedPrefix starts every sealed secret, followed by the key ID, the wrapped data key and the ciphertext.
+const sealedPrefix = "enc:v1:"
+
+const dataKeySize = 32
+
+// Seal encrypts plaintext with a new data key wrapped by keys. The empty string is kept as is.
+func Seal(keys KeyProvider, plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	if keys == nil {
+		return "", errors.WithStack(ErrNoKeyProvider)
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", errors.Wrap(err, "generating a data key")
+	}
+	ciphertext, err := encrypt(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := keys.WrapKey(dataKey)
+	if err != nil {
+		return "", errors.Wrapf(err, "wrapping a data key with key %s", keys.KeyID())
+	}
+	return sealedPrefix + strings.Join([]string{
+		keys.KeyID(), base64.RawStdEncoding.EncodeToString(wrapped), base64.RawStdEncoding.EncodeToString(ciphertext),
+	}, ":"), nil
+}
+
+// Open decrypts a secret sealed by Seal. Values that are not sealed, stored before secrets were encrypted, are
+// returned as is.
+func Open(keys KeyProvider, sealed string) (string, error) {
+	if !IsSealed(sealed) {
+		return sealed, nil
+	}
+	if keys == nil {
+		return "", errors.WithStack(ErrNoKeyProvider)
+	}
+	parts := strings.Split(strings.TrimPrefix(sealed, sealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.WithStack(ErrMalformedSecret)
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.WithStack(ErrMalformedSecret)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.WithStack(ErrMalformedSecret)
+	}
+	dataKey, err := keys.UnwrapKey(parts[0], wrapped)
+	if err != nil {
+		return "", errors.Wrapf(err, "unwrapping a data key with key %s", parts[0])
+	}
+	plaintext, err := decrypt(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsSealed tells whether value was sealed by Seal.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// encrypt seals plaintext with AES-GCM under key, and returns the nonce followed by the ciphertext.
+func encrypt(key []byte, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "generating a nonce")
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// decrypt opens the output of encrypt.
+func decrypt(key []byte, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.WithStack(ErrMalformedSecret)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		// The error of the cipher tells nothing more, and must not hint at the plaintext.
+		return nil, errors.WithStack(ErrMalformedSecret)
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating the cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating the cipher")
+	}
+	return aead, nil
+}
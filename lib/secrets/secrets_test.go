@@ -0,0 +1,75 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKeys(t *testing.T, fill byte) *LocalKeyProvider {
+	keys, err := NewLocalKeyProvider([]byte(strings.Repeat(string(fill), 32)))
+	require.NoError(t, err)
+	return keys
+}
+
+func TestSealAndOpen(t *testing.T) {
+	keys := newTestKeys(t, 'k')
+
+	t.Run("Should open what it sealed", func(t *testing.T) {
+		sealed, err := Seal(keys, "my auth key")
+		require.NoError(t, err)
+		require.True(t, IsSealed(sealed))
+		require.NotContains(t, sealed, "my auth key")
+		require.Contains(t, sealed, keys.KeyID())
+
+		opened, err := Open(keys, sealed)
+		require.NoError(t, err)
+		require.Equal(t, "my auth key", opened)
+	})
+	t.Run("Should use a new data key every time", func(t *testing.T) {
+		first, err := Seal(keys, "my auth key")
+		require.NoError(t, err)
+		second, err := Seal(keys, "my auth key")
+		require.NoError(t, err)
+		require.NotEqual(t, first, second)
+	})
+	t.Run("Should keep empty and legacy values as is", func(t *testing.T) {
+		sealed, err := Seal(nil, "")
+		require.NoError(t, err)
+		require.Equal(t, "", sealed)
+
+		opened, err := Open(nil, "plaintext key")
+		require.NoError(t, err)
+		require.Equal(t, "plaintext key", opened)
+	})
+	t.Run("Should require a KeyProvider", func(t *testing.T) {
+		_, err := Seal(nil, "my auth key")
+		require.Equal(t, ErrNoKeyProvider, errors.Cause(err))
+
+		sealed, err := Seal(keys, "my auth key")
+		require.NoError(t, err)
+		_, err = Open(nil, sealed)
+		require.Equal(t, ErrNoKeyProvider, errors.Cause(err))
+	})
+	t.Run("Should not open with another key", func(t *testing.T) {
+		sealed, err := Seal(keys, "my auth key")
+		require.NoError(t, err)
+		_, err = Open(newTestKeys(t, 'o'), sealed)
+		require.Error(t, err)
+	})
+	t.Run("Should reject tampered secrets", func(t *testing.T) {
+		sealed, err := Seal(keys, "my auth key")
+		require.NoError(t, err)
+		tampered := sealed[:len(sealed)-2] + "AA"
+		if tampered == sealed {
+			tampered = sealed[:len(sealed)-2] + "BB"
+		}
+		_, err = Open(keys, tampered)
+		require.Equal(t, ErrMalformedSecret, errors.Cause(err))
+
+		_, err = Open(keys, sealedPrefix+"missing parts")
+		require.Equal(t, ErrMalformedSecret, errors.Cause(err))
+	})
+}
@@ -0,0 +1,39 @@
+package validation
+
+import "github.com/EurosportDigital/global-transcoding-platform/model"
+
+var (
+	codecs         = newRegistry("h264", "h265", "vp9", "av1")
+	packageFormats = newRegistry("hls", "dash")
+)
+
+// RegisterCodec makes ValidateProfile accept the codec.
+func RegisterCodec(codec string) {
+	codecs.add(codec)
+}
+
+// RegisterPackageFormat makes ValidateProfile accept the package format.
+func RegisterPackageFormat(format string) {
+	packageFormats.add(format)
+}
+
+// ValidateProfile checks that the profile has a name, and a registered codec and package format.
+func ValidateProfile(profile *model.Profile) error {
+	invalid := &fieldErrors{entity: "profile"}
+	if profile.Name == "" {
+		invalid.add("Name", Required, "a profile needs a name")
+	}
+	switch {
+	case profile.Codec == "":
+		invalid.add("Codec", Required, "expected one of %s", codecs.list())
+	case !codecs.has(profile.Codec):
+		invalid.add("Codec", Unsupported, "unknown codec %q, expected one of %s", profile.Codec, codecs.list())
+	}
+	switch {
+	case profile.PackageFormat == "":
+		invalid.add("PackageFormat", Required, "expected one of %s", packageFormats.list())
+	case !packageFormats.has(profile.PackageFormat):
+		invalid.add("PackageFormat", Unsupported, "unknown package format %q, expected one of %s", profile.PackageFormat, packageFormats.list())
+	}
+	return invalid.err()
+}
@@ -0,0 +1,32 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateProfile(t *testing.T) {
+	t.Run("Should accept registered codecs and package formats", func(t *testing.T) {
+		require.NoError(t, ValidateProfile(&model.Profile{Name: "h264-hls", Codec: "h264", PackageFormat: "hls"}))
+		require.NoError(t, ValidateProfile(&model.Profile{Name: "av1-dash", Codec: "av1", PackageFormat: "dash"}))
+	})
+	t.Run("Should report every invalid field", func(t *testing.T) {
+		validationError, ok := As(ValidateProfile(&model.Profile{Codec: "mpeg2"}))
+		require.True(t, ok)
+		require.Len(t, validationError.Fields, 3)
+		require.Equal(t, Required, validationError.Field("Name").Reason)
+		require.Equal(t, Unsupported, validationError.Field("Codec").Reason)
+		require.Contains(t, validationError.Field("Codec").Message, "av1, h264, h265, vp9")
+		require.Equal(t, Required, validationError.Field("PackageFormat").Reason)
+	})
+	t.Run("Should accept registered values", func(t *testing.T) {
+		profile := &model.Profile{Name: "prores-smooth", Codec: "prores", PackageFormat: "smooth"}
+		require.Error(t, ValidateProfile(profile))
+
+		RegisterCodec("prores")
+		RegisterPackageFormat("smooth")
+		require.NoError(t, ValidateProfile(profile))
+	})
+}
@@ -0,0 +1,118 @@
+package validation
+
+import (
+	"net/url"
+	"regexp"
+	"sync"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+)
+
+// TargetHandler checks the paths of the targets of one type.
+type TargetHandler interface {
+	// ValidatePath returns why path is malformed, nil when it is valid.
+	ValidatePath(path string) error
+}
+
+// TargetHandlerFunc adapts a function to TargetHandler.
+type TargetHandlerFunc func(path string) error
+
+func (handler TargetHandlerFunc) ValidatePath(path string) error {
+	return handler(path)
+}
+
+var (
+	handlersMutex  sync.RWMutex
+	targetHandlers = map[string]TargetHandler{
+		"s3":     TargetHandlerFunc(ValidateS3Path),
+		"akamai": TargetHandlerFunc(ValidateHTTPSURL),
+	}
+	targetTypes = newRegistry("s3", "akamai")
+)
+
+// RegisterTargetHandler makes ValidateTarget accept the targets of targetType whose path handler accepts.
+func RegisterTargetHandler(targetType string, handler TargetHandler) {
+	handlersMutex.Lock()
+	defer handlersMutex.Unlock()
+	targetHandlers[targetType] = handler
+	targetTypes.add(targetType)
+}
+
+func targetHandler(targetType string) (TargetHandler, bool) {
+	handlersMutex.RLock()
+	defer handlersMutex.RUnlock()
+	handler, ok := targetHandlers[targetType]
+	return handler, ok
+}
+
+// ValidateTarget checks that the target type has a registered handler, and that the handler accepts the path.
+// The AuthKey is not checked.
+func ValidateTarget(target *model.Target) error {
+	invalid := &fieldErrors{entity: "target"}
+	handler, ok := targetHandler(target.TargetType)
+	switch {
+	case target.TargetType == "":
+		invalid.add("TargetType", Required, "expected one of %s", targetTypes.list())
+	case !ok:
+		invalid.add("TargetType", Unsupported, "no handler for target type %q, expected one of %s", target.TargetType, targetTypes.list())
+	}
+	switch {
+	case target.Path == "":
+		invalid.add("Path", Required, "a target needs a path")
+	case ok:
+		if err := handler.ValidatePath(target.Path); err != nil {
+			invalid.add("Path", Malformed, "%s", err.Error())
+		}
+	}
+	return invalid.err()
+}
+
+// bucketName matches the names S3 accepts for new buckets.
+var bucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// ValidateS3Path accepts s3://bucket and s3://bucket/prefix paths.
+func ValidateS3Path(path string) error {
+	parsed, err := parseURL(path)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "s3" {
+		return errors.New("expected s3://bucket/prefix")
+	}
+	if !bucketName.MatchString(parsed.Host) {
+		return errors.New("expected a bucket name of 3 to 63 lowercase letters, digits, dots and hyphens")
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return errors.New("expected no query nor fragment")
+	}
+	return nil
+}
+
+// ValidateHTTPSURL accepts absolute https URLs without credentials, which belong in the AuthKey.
+func ValidateHTTPSURL(path string) error {
+	parsed, err := parseURL(path)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "https" || parsed.Hostname() == "" {
+		return errors.New("expected https://host/path")
+	}
+	if parsed.Fragment != "" {
+		return errors.New("expected no fragment")
+	}
+	return nil
+}
+
+// parseURL parses path and rejects the URLs carrying credentials.
+func parseURL(path string) (*url.URL, error) {
+	parsed, err := url.Parse(path)
+	if err != nil {
+		// The error of url.Parse quotes the path, which may hold credentials.
+		return nil, errors.New("not a URL")
+	}
+	if parsed.User != nil {
+		return nil, errors.New("expected no credentials in the URL, they belong in the AuthKey")
+	}
+	return parsed, nil
+}
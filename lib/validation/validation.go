@@ -0,0 +1,122 @@
+// Package validation checks profiles and targets before they are stored, so that a bad row is rejected when it is
+// written rather than when an encoder rejects a job built from it.
+package validation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+)
+
+// Reason tells why a field is invalid.
+type Reason string
+
+const (
+	// Required means the field is empty.
+	Required Reason = "required"
+	// Unsupported means the value is well formed but not one of the registered values.
+	Unsupported Reason = "unsupported"
+	// Malformed means the value does not have the expected format.
+	Malformed Reason = "malformed"
+)
+
+// FieldError describes one invalid field. The value is left out, it may hold credentials.
+type FieldError struct {
+	Field   string
+	Reason  Reason
+	Message string
+}
+
+func (fieldError *FieldError) Error() string {
+	return fmt.Sprintf("%s is %s: %s", fieldError.Field, fieldError.Reason, fieldError.Message)
+}
+
+// Error is returned when an entity is invalid, with every invalid field. The repositories wrap it, use As to get it
+// back.
+type Error struct {
+	Entity string
+	Fields []*FieldError
+}
+
+func (validationError *Error) Error() string {
+	messages := make([]string, len(validationError.Fields))
+	for i, field := range validationError.Fields {
+		messages[i] = field.Error()
+	}
+	return fmt.Sprintf("invalid %s: %s", validationError.Entity, strings.Join(messages, "; "))
+}
+
+// Field returns the error of the field, nil when it is valid.
+func (validationError *Error) Field(name string) *FieldError {
+	for _, field := range validationError.Fields {
+		if field.Field == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// As returns the Error err was caused by, if any.
+func As(err error) (*Error, bool) {
+	validationError, ok := errors.Cause(err).(*Error)
+	return validationError, ok
+}
+
+// fieldErrors collects the invalid fields of an entity.
+type fieldErrors struct {
+	entity string
+	fields []*FieldError
+}
+
+func (collected *fieldErrors) add(field string, reason Reason, format string, args ...interface{}) {
+	collected.fields = append(collected.fields, &FieldError{Field: field, Reason: reason, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns the Error of the collected fields, nil when there are none.
+func (collected *fieldErrors) err() error {
+	if len(collected.fields) == 0 {
+		return nil
+	}
+	return errors.WithStack(&Error{Entity: collected.entity, Fields: collected.fields})
+}
+
+// registry is a set of names, safe for concurrent use.
+type registry struct {
+	mutex sync.RWMutex
+	names map[string]bool
+}
+
+func newRegistry(names ...string) *registry {
+	registry := &registry{names: map[string]bool{}}
+	for _, name := range names {
+		registry.names[name] = true
+	}
+	return registry
+}
+
+func (registry *registry) add(name string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.names[name] = true
+}
+
+func (registry *registry) has(name string) bool {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	return registry.names[name]
+}
+
+// list returns the sorted names, for error messages.
+func (registry *registry) list() string {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	names := make([]string, 0, len(registry.names))
+	for name := range registry.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
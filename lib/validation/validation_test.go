@@ -0,0 +1,27 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/EurosportDigital/global-transcoding-platform/lib/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestError(t *testing.T) {
+	invalid := &fieldErrors{entity: "profile"}
+	require.NoError(t, invalid.err())
+
+	invalid.add("Codec", Unsupported, "unknown codec %q", "mpeg2")
+	invalid.add("Name", Required, "a profile needs a name")
+	err := errors.Wrap(invalid.err(), "unable to create profile")
+
+	validationError, ok := As(err)
+	require.True(t, ok)
+	require.Equal(t, "profile", validationError.Entity)
+	require.Equal(t, &FieldError{Field: "Codec", Reason: Unsupported, Message: `unknown codec "mpeg2"`}, validationError.Field("Codec"))
+	require.Nil(t, validationError.Field("PackageFormat"))
+	require.EqualError(t, err, `unable to create profile: invalid profile: Codec is unsupported: unknown codec "mpeg2"; Name is required: a profile needs a name`)
+
+	_, ok = As(errors.New("my error"))
+	require.False(t, ok)
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditAction is the kind of mutation an AuditEntry records.
+type AuditAction string
+
+const (
+	AuditCreate AuditAction = "create"
+	AuditUpdate AuditAction = "update"
+	AuditDelete AuditAction = "delete"
+	// AuditRestore records the undoing of a soft delete, with the restored entity as After.
+	AuditRestore AuditAction = "restore"
+)
+
+// FieldChange holds the JSON values of an entity field before and after a mutation.
+// Before is nil for a field the entity did not have, such as every field of a created entity, and After for a deleted entity.
+type FieldChange struct {
+	Before json.RawMessage
+	After  json.RawMessage
+}
+
+// AuditEntry records who mutated an entity, when, and the fields that changed, by field name.
+type AuditEntry struct {
+	ID         int
+	Actor      string
+	At         time.Time
+	EntityType string
+	EntityID   int
+	Action     AuditAction
+	Changes    map[string]FieldChange
+}
@@ -0,0 +1,57 @@
+package gormmodel
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+)
+
+type FieldChange struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// AuditChanges maps the changed fields of an entity to their values, it is stored as a JSON object.
+type AuditChanges map[string]*FieldChange
+
+func (c AuditChanges) Value() (driver.Value, error) { return json.Marshal(c) }
+func (c *AuditChanges) Scan(v interface{}) error {
+	switch b := v.(type) {
+	case []byte:
+		return json.Unmarshal(b, c)
+	case string:
+		return json.Unmarshal([]byte(b), c)
+	case nil:
+		return nil
+	}
+	return errors.New("bad audit changes")
+}
+
+type AuditEntry struct {
+	ID         int
+	Actor      string `gorm:"index:idx_audit_entries_actor"`
+	EntityType string `gorm:"index:idx_audit_entries_entity"`
+	EntityID   int    `gorm:"index:idx_audit_entries_entity"`
+	Action     model.AuditAction
+	Changes    AuditChanges `gorm:"type:json"`
+	CreatedAt  time.Time    `gorm:"index:idx_audit_entries_created_at"`
+}
+
+func ToGormAuditEntry(e *model.AuditEntry) *AuditEntry {
+	changes := make(AuditChanges, len(e.Changes))
+	for field, change := range e.Changes {
+		changes[field] = &FieldChange{Before: change.Before, After: change.After}
+	}
+	return &AuditEntry{ID: e.ID, Actor: e.Actor, EntityType: e.EntityType, EntityID: e.EntityID, Action: e.Action, Changes: changes, CreatedAt: e.At}
+}
+
+func ToAuditEntry(e *AuditEntry) *model.AuditEntry {
+	changes := make(map[string]model.FieldChange, len(e.Changes))
+	for field, change := range e.Changes {
+		changes[field] = model.FieldChange{Before: change.Before, After: change.After}
+	}
+	return &model.AuditEntry{ID: e.ID, Actor: e.Actor, At: e.CreatedAt, EntityType: e.EntityType, EntityID: e.EntityID, Action: e.Action, Changes: changes}
+}
@@ -0,0 +1,15 @@
+package gormmodel
+
+// JobOutput is a row of job_outputs, which indexes the profile and target of every output of a job.
+type JobOutput struct {
+	ID        int
+	JobID     int `gorm:"index:idx_job_outputs_job_id"`
+	ProfileID int `gorm:"index:idx_job_outputs_profile_id"`
+	TargetID  int `gorm:"index:idx_job_outputs_target_id"`
+	// ProfileRevision is the revision of the profile the output is built with, 0 when the profile did not exist.
+	ProfileRevision int
+}
+
+func (JobOutput) TableName() string {
+	return "job_outputs"
+}
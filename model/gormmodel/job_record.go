@@ -0,0 +1,23 @@
+package gormmodel
+
+import "time"
+
+// JobRecord is a row of the jobs table: the Job columns along with the ones later migrations added to it.
+type JobRecord struct {
+	Job
+	// Version is incremented by every update of the job.
+	Version int
+	// LeaseOwner is the worker processing the job until LeaseExpiresAt, empty when the job is not leased.
+	LeaseOwner     string
+	LeaseExpiresAt *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	// ProfileRevisions pins the profiles of the outputs to the revision they were at when the job got them.
+	ProfileRevisions ProfileRevisions `gorm:"type:json"`
+	// DeletedAt is set when the job is soft deleted, gorm then excludes it from queries unless unscoped.
+	DeletedAt *time.Time `gorm:"index:idx_jobs_deleted_at"`
+}
+
+func (JobRecord) TableName() string {
+	return "jobs"
+}
@@ -0,0 +1,24 @@
+package gormmodel
+
+import (
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+)
+
+type JobStatusHistory struct {
+	ID         int
+	JobID      int `gorm:"index:idx_job_status_history_job_id"`
+	FromStatus model.Status
+	ToStatus   model.Status
+	Reason     string
+	CreatedAt  time.Time
+}
+
+func (JobStatusHistory) TableName() string {
+	return "job_status_history"
+}
+
+func ToJobStatusChange(h *JobStatusHistory) *model.JobStatusChange {
+	return &model.JobStatusChange{JobID: h.JobID, From: h.FromStatus, To: h.ToStatus, Reason: h.Reason, At: h.CreatedAt}
+}
@@ -0,0 +1,6 @@
+package gormmodel
+
+// Models lists every persisted type, ordered so that referenced tables come first.
+func Models() []interface{} {
+	return []interface{}{&Encoder{}, &EncoderConfig{}, &ProfileRecord{}, &ProfileRevision{}, &TargetRecord{}, &JobRecord{}, &JobStatusHistory{}, &JobOutput{}, &AuditEntry{}}
+}
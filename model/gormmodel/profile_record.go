@@ -0,0 +1,16 @@
+package gormmodel
+
+import "time"
+
+// ProfileRecord is a row of the profiles table: the Profile columns along with the ones later migrations added to it.
+type ProfileRecord struct {
+	Profile
+	// Revision is the latest revision of the profile, incremented by every update, see ProfileRevision.
+	Revision int `gorm:"not null;default:1"`
+	// DeletedAt is set when the profile is soft deleted, gorm then excludes it from queries unless unscoped.
+	DeletedAt *time.Time `gorm:"index:idx_profiles_deleted_at"`
+}
+
+func (ProfileRecord) TableName() string {
+	return "profiles"
+}
@@ -0,0 +1,63 @@
+package gormmodel
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+)
+
+// ProfileSnapshot is a profile along with its encoder config and encoder, stored as JSON.
+type ProfileSnapshot model.Profile
+
+func (s ProfileSnapshot) Value() (driver.Value, error) { return json.Marshal(s) }
+func (s *ProfileSnapshot) Scan(v interface{}) error {
+	switch b := v.(type) {
+	case []byte:
+		return json.Unmarshal(b, s)
+	case string:
+		return json.Unmarshal([]byte(b), s)
+	case nil:
+		return nil
+	}
+	return errors.New("bad profile snapshot")
+}
+
+// ProfileRevision is a row of profile_revisions, the immutable snapshot of a profile at one revision.
+type ProfileRevision struct {
+	ID        int
+	ProfileID int             `gorm:"unique_index:idx_profile_revisions_profile_id_revision"`
+	Revision  int             `gorm:"unique_index:idx_profile_revisions_profile_id_revision"`
+	Snapshot  ProfileSnapshot `gorm:"type:json"`
+	CreatedAt time.Time
+}
+
+func (ProfileRevision) TableName() string {
+	return "profile_revisions"
+}
+
+func ToGormProfileRevision(profile *model.Profile, revision int) *ProfileRevision {
+	return &ProfileRevision{ProfileID: profile.ID, Revision: revision, Snapshot: ProfileSnapshot(*profile)}
+}
+
+func ToProfileRevision(r *ProfileRevision) *model.ProfileRevision {
+	return &model.ProfileRevision{Profile: model.Profile(r.Snapshot), Revision: r.Revision, CreatedAt: r.CreatedAt}
+}
+
+// ProfileRevisions maps the IDs of the profiles a job outputs to the revision it was created with.
+type ProfileRevisions map[int]int
+
+func (r ProfileRevisions) Value() (driver.Value, error) { return json.Marshal(r) }
+func (r *ProfileRevisions) Scan(v interface{}) error {
+	switch b := v.(type) {
+	case []byte:
+		return json.Unmarshal(b, r)
+	case string:
+		return json.Unmarshal([]byte(b), r)
+	case nil:
+		return nil
+	}
+	return errors.New("bad profile revisions")
+}
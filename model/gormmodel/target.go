@@ -0,0 +1,21 @@
+package gormmodel
+
+import (
+	"fmt"
+
+	"github.com/EurosportDigital/global-transcoding-platform/model"
+)
+
+// String prints the target without its AuthKey, sealed or not, see model.Target.String.
+func (target Target) String() string {
+	return ToTarget(&target).String()
+}
+
+// GoString prints the target without its AuthKey for the %#v verb.
+func (target Target) GoString() string {
+	authKey := ""
+	if target.AuthKey != "" {
+		authKey = model.RedactedSecret
+	}
+	return fmt.Sprintf("gormmodel.Target{ID:%d, TargetType:%q, Path:%q, AuthKey:%q}", target.ID, target.TargetType, target.Path, authKey)
+}
@@ -0,0 +1,14 @@
+package gormmodel
+
+import "time"
+
+// TargetRecord is a row of the targets table: the Target columns along with the ones later migrations added to it.
+type TargetRecord struct {
+	Target
+	// DeletedAt is set when the target is soft deleted, gorm then excludes it from queries unless unscoped.
+	DeletedAt *time.Time `gorm:"index:idx_targets_deleted_at"`
+}
+
+func (TargetRecord) TableName() string {
+	return "targets"
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// ProfileRevision is a profile as it was at one of its revisions. Every update of a profile creates a new revision,
+// starting at 1, and the revisions never change once created, so that jobs can be built again with the exact
+// profile they were created with.
+type ProfileRevision struct {
+	Profile
+	Revision  int
+	CreatedAt time.Time
+}
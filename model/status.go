@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// StatusCancelled is the status of a job that was stopped before it completed.
+const StatusCancelled Status = "cancelled"
+
+// StatusTransitions declares the statuses a job may move to from each status.
+// Failed and cancelled jobs are retried by moving them back to ready.
+var StatusTransitions = map[Status][]Status{
+	StatusReady:      {StatusProcessing, StatusCancelled},
+	StatusProcessing: {StatusCompleted, StatusFailed, StatusCancelled},
+	StatusFailed:     {StatusReady},
+	StatusCancelled:  {StatusReady},
+}
+
+// CanTransitionTo reports whether a job may move from status s to next.
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, allowed := range StatusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// JobStatusChange records a job moving from one status to another. From is empty for the status a job was created with.
+type JobStatusChange struct {
+	JobID  int
+	From   Status
+	To     Status
+	Reason string
+	At     time.Time
+}
@@ -0,0 +1,25 @@
+package model
+
+import "fmt"
+
+// RedactedSecret replaces the secrets, such as the AuthKey of a Target, in the output of the fmt package.
+const RedactedSecret = "[redacted]"
+
+// String prints the target without its AuthKey, so that formatting it with %v or %+v in logs and errors does not
+// leak the key.
+func (target Target) String() string {
+	return fmt.Sprintf("{ID:%d TargetType:%s Path:%s AuthKey:%s}", target.ID, target.TargetType, target.Path, redact(target.AuthKey))
+}
+
+// GoString prints the target without its AuthKey for the %#v verb.
+func (target Target) GoString() string {
+	return fmt.Sprintf("model.Target{ID:%d, TargetType:%q, Path:%q, AuthKey:%q}", target.ID, target.TargetType, target.Path, redact(target.AuthKey))
+}
+
+// redact returns RedactedSecret unless secret is empty, so that the output still tells whether there is one.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return RedactedSecret
+}